@@ -0,0 +1,34 @@
+-- +migrate Up
+create table accounts (
+    id serial not null primary key,
+    version int not null,
+    created_at timestamp without time zone not null,
+    changed_at timestamp without time zone not null,
+
+    name text not null,
+    website text not null,
+
+    billing_street text not null,
+    billing_postal_code text not null,
+    billing_state text not null,
+    billing_city text not null,
+    billing_country text not null,
+
+    physical_street text not null,
+    physical_postal_code text not null,
+    physical_state text not null,
+    physical_city text not null,
+    physical_country text not null
+);
+
+alter table people add column account_id int default null;
+alter table people add foreign key (account_id) references accounts(id) on update cascade on delete set null;
+create index people_account_id_idx on people (account_id);
+
+alter table phone_numbers add column account_id int default null;
+alter table phone_numbers add foreign key (account_id) references accounts(id) on update cascade on delete cascade;
+
+-- +migrate Down
+alter table phone_numbers drop column if exists account_id;
+alter table people drop column if exists account_id;
+drop table if exists accounts CASCADE;
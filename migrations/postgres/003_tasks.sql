@@ -0,0 +1,27 @@
+-- +migrate Up
+create table tasks (
+    id serial not null primary key,
+    version int not null,
+    created_at timestamp without time zone not null,
+    changed_at timestamp without time zone not null,
+
+    title text not null,
+    description text not null,
+
+    person_id int not null,
+    account_id int default null,
+    assignee_id int default null,
+
+    due_date timestamp without time zone default null,
+    priority int not null,
+    status text not null,
+
+    foreign key (person_id) references people(id) on update cascade on delete cascade,
+    foreign key (account_id) references accounts(id) on update cascade on delete set null,
+    foreign key (assignee_id) references users(id) on update cascade on delete set null
+);
+
+create index tasks_assignee_id_status_idx on tasks (assignee_id, status);
+
+-- +migrate Down
+drop table if exists tasks CASCADE;
@@ -0,0 +1,23 @@
+-- +migrate Up
+create table activities (
+    id serial not null primary key,
+    version int not null,
+    created_at timestamp without time zone not null,
+    changed_at timestamp without time zone not null,
+
+    kind text not null,
+    subject text not null,
+    body text not null,
+    occurred_at timestamp without time zone not null,
+
+    person_id int not null,
+    author_id int default null,
+
+    foreign key (person_id) references people(id) on update cascade on delete cascade,
+    foreign key (author_id) references users(id) on update cascade on delete set null
+);
+
+create index activities_person_id_occurred_at_idx on activities (person_id, occurred_at);
+
+-- +migrate Down
+drop table if exists activities CASCADE;
@@ -0,0 +1,47 @@
+-- +migrate Up
+create table events (
+    id serial not null primary key,
+    version int not null,
+    created_at timestamp without time zone not null,
+    changed_at timestamp without time zone not null,
+
+    title text not null,
+    description text not null,
+    location text not null,
+
+    start_at timestamp without time zone not null,
+    end_at timestamp without time zone not null
+);
+
+create table event_people (
+    event_id int not null,
+    person_id int not null,
+
+    primary key (event_id, person_id),
+    foreign key (event_id) references events(id) on update cascade on delete cascade,
+    foreign key (person_id) references people(id) on update cascade on delete cascade
+);
+
+create table event_users (
+    event_id int not null,
+    user_id int not null,
+
+    primary key (event_id, user_id),
+    foreign key (event_id) references events(id) on update cascade on delete cascade,
+    foreign key (user_id) references users(id) on update cascade on delete cascade
+);
+
+create index event_users_user_id_idx on event_users (user_id);
+
+create table calendar_tokens (
+    token text not null primary key,
+    user_id int not null unique,
+
+    foreign key (user_id) references users(id) on update cascade on delete cascade
+);
+
+-- +migrate Down
+drop table if exists calendar_tokens CASCADE;
+drop table if exists event_users CASCADE;
+drop table if exists event_people CASCADE;
+drop table if exists events CASCADE;
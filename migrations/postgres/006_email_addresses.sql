@@ -0,0 +1,29 @@
+-- +migrate Up
+create table email_addresses (
+    id serial not null primary key,
+
+    address text not null,
+    type text not null,
+    "primary" boolean not null default false,
+    person_id int not null,
+
+    foreign key (person_id) references people(id) on update cascade on delete cascade
+);
+
+create index email_addresses_person_id_idx on email_addresses (person_id);
+
+insert into email_addresses (address, type, "primary", person_id)
+    select email_address, 'work', true, id from people where email_address <> '';
+
+alter table people drop column email_address;
+
+-- +migrate Down
+alter table people add column email_address text not null default '';
+
+update people set email_address = email_addresses.address
+    from email_addresses
+    where email_addresses.person_id = people.id and email_addresses."primary";
+
+alter table people alter column email_address drop default;
+
+drop table email_addresses;
@@ -0,0 +1,16 @@
+-- +migrate Up
+create table revisions (
+    entity text not null,
+    entity_id int not null,
+    version int not null,
+
+    data text not null,
+    changed_by int default null,
+    changed_at timestamp without time zone not null,
+
+    primary key (entity, entity_id, version),
+    foreign key (changed_by) references users(id) on update cascade on delete set null
+);
+
+-- +migrate Down
+drop table revisions;
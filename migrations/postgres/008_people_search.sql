@@ -0,0 +1,85 @@
+-- +migrate Up
+create table people_search (
+    person_id int not null primary key,
+    document tsvector not null,
+
+    foreign key (person_id) references people(id) on update cascade on delete cascade
+);
+
+create index people_search_document_idx on people_search using gin (document);
+
+-- people_search_refresh recomputes the search document for a person from the
+-- person record, the email addresses and the phone numbers. Phone numbers are
+-- also indexed in the national format with all formatting removed, e.g.
+-- "+49 (221) 123-45" is also indexed as "022112345".
+-- +migrate StatementBegin
+create function people_search_refresh(pid int) returns void as $$
+begin
+    delete from people_search where person_id = pid;
+
+    insert into people_search (person_id, document)
+    select p.id,
+        setweight(to_tsvector('simple', p.name), 'A') ||
+        setweight(to_tsvector('simple', p.title || ' ' || p.department), 'B') ||
+        setweight(to_tsvector('simple', coalesce(
+            (select string_agg(e.address, ' ') from email_addresses e where e.person_id = p.id), '')), 'B') ||
+        setweight(to_tsvector('simple', coalesce(
+            (select string_agg(n.number || ' ' || regexp_replace(regexp_replace(regexp_replace(
+                    regexp_replace(n.number, '[^0-9+]', '', 'g'), '^\+', '00'), '\+', '', 'g'), '^0049', '0'), ' ')
+                from phone_numbers n where n.person_id = p.id), '')), 'B') ||
+        setweight(to_tsvector('simple', p.street || ' ' || p.postal_code || ' ' || p.state || ' ' ||
+            p.city || ' ' || p.country), 'C') ||
+        setweight(to_tsvector('simple', p.comment), 'D')
+    from people p where p.id = pid;
+end;
+$$ language plpgsql;
+-- +migrate StatementEnd
+
+-- +migrate StatementBegin
+create function people_search_trigger() returns trigger as $$
+begin
+    perform people_search_refresh(NEW.id);
+    return null;
+end;
+$$ language plpgsql;
+-- +migrate StatementEnd
+
+-- +migrate StatementBegin
+create function people_search_child_trigger() returns trigger as $$
+begin
+    if TG_OP = 'UPDATE' or TG_OP = 'DELETE' then
+        if OLD.person_id is not null then
+            perform people_search_refresh(OLD.person_id);
+        end if;
+    end if;
+
+    if TG_OP = 'UPDATE' or TG_OP = 'INSERT' then
+        if NEW.person_id is not null then
+            perform people_search_refresh(NEW.person_id);
+        end if;
+    end if;
+
+    return null;
+end;
+$$ language plpgsql;
+-- +migrate StatementEnd
+
+create trigger people_search_update after insert or update on people
+    for each row execute procedure people_search_trigger();
+
+create trigger people_search_update after insert or update or delete on email_addresses
+    for each row execute procedure people_search_child_trigger();
+
+create trigger people_search_update after insert or update or delete on phone_numbers
+    for each row execute procedure people_search_child_trigger();
+
+select people_search_refresh(id) from people;
+
+-- +migrate Down
+drop trigger people_search_update on phone_numbers;
+drop trigger people_search_update on email_addresses;
+drop trigger people_search_update on people;
+drop function people_search_child_trigger();
+drop function people_search_trigger();
+drop function people_search_refresh(int);
+drop table people_search;
@@ -0,0 +1,14 @@
+-- +migrate Up
+
+-- normalize_phone_number removes all formatting from a phone number and
+-- replaces the international prefix for Germany with a zero, in the same way
+-- as normalizePhoneNumber() in the package ghenga/db.
+-- +migrate StatementBegin
+create function normalize_phone_number(num text) returns text as $$
+    select regexp_replace(regexp_replace(regexp_replace(
+        regexp_replace(num, '[^0-9+]', '', 'g'), '^\+', '00'), '\+', '', 'g'), '^0049', '0');
+$$ language sql immutable;
+-- +migrate StatementEnd
+
+-- +migrate Down
+drop function normalize_phone_number(text);
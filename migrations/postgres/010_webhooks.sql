@@ -0,0 +1,37 @@
+-- +migrate Up
+create table webhooks (
+    id serial not null primary key,
+    version int not null,
+    created_at timestamp without time zone not null,
+    changed_at timestamp without time zone not null,
+
+    url text not null,
+    events text not null,
+    secret text not null,
+    active boolean not null
+);
+
+create table webhook_deliveries (
+    id serial not null primary key,
+    webhook_id int not null,
+    event text not null,
+    entity_id int not null,
+    payload text not null,
+
+    status text not null,
+    attempts int not null,
+    response_status int default null,
+    error text not null,
+
+    created_at timestamp without time zone not null,
+    delivered_at timestamp without time zone default null,
+
+    foreign key (webhook_id) references webhooks(id) on update cascade on delete cascade
+);
+
+create index webhook_deliveries_webhook_id_idx on webhook_deliveries (webhook_id);
+create index webhook_deliveries_status_idx on webhook_deliveries (status);
+
+-- +migrate Down
+drop table webhook_deliveries;
+drop table webhooks;
@@ -0,0 +1,23 @@
+-- +migrate Up
+create table audit_log (
+    id serial not null primary key,
+    created_at timestamp without time zone not null,
+
+    user_id int default null,
+    login text not null,
+    action text not null,
+    entity text not null,
+    entity_id int default null,
+    before_version int default null,
+    after_version int default null,
+
+    remote_addr text not null,
+    user_agent text not null
+);
+
+create index audit_log_created_at_idx on audit_log (created_at);
+create index audit_log_login_idx on audit_log (login);
+create index audit_log_entity_idx on audit_log (entity, entity_id);
+
+-- +migrate Down
+drop table audit_log;
@@ -0,0 +1,28 @@
+-- +migrate Up
+alter table people
+    add column created_by int default null references users(id) on update cascade on delete set null,
+    add column changed_by int default null references users(id) on update cascade on delete set null;
+
+alter table users
+    add column created_by int default null references users(id) on update cascade on delete set null,
+    add column changed_by int default null references users(id) on update cascade on delete set null;
+
+-- the authors of existing records are taken from the revision history
+update people set
+    created_by = (select changed_by from revisions r
+        where r.entity = 'person' and r.entity_id = people.id order by r.version asc limit 1),
+    changed_by = (select changed_by from revisions r
+        where r.entity = 'person' and r.entity_id = people.id order by r.version desc limit 1);
+
+update users set
+    created_by = (select changed_by from revisions r
+        where r.entity = 'user' and r.entity_id = users.id order by r.version asc limit 1),
+    changed_by = (select changed_by from revisions r
+        where r.entity = 'user' and r.entity_id = users.id order by r.version desc limit 1);
+
+create index people_created_by_idx on people (created_by);
+create index people_changed_by_idx on people (changed_by);
+
+-- +migrate Down
+alter table people drop column created_by, drop column changed_by;
+alter table users drop column created_by, drop column changed_by;
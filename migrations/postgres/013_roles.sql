@@ -0,0 +1,44 @@
+-- +migrate Up
+create table roles (
+    id serial not null primary key,
+    version int not null,
+    created_at timestamp without time zone not null,
+    changed_at timestamp without time zone not null,
+
+    name text not null unique,
+    description text not null,
+    permissions text not null
+);
+
+create table user_roles (
+    user_id int not null,
+    role_id int not null,
+
+    primary key (user_id, role_id),
+    foreign key (user_id) references users(id) on update cascade on delete cascade,
+    foreign key (role_id) references roles(id) on update cascade on delete cascade
+);
+
+insert into roles (version, created_at, changed_at, name, description, permissions) values
+    (1, now(), now(), 'viewer', 'Read people', 'person:read'),
+    (1, now(), now(), 'sales', 'Read and edit people', 'person:read,person:write'),
+    (1, now(), now(), 'manager', 'Read, edit and delete people, export data',
+        'person:read,person:write,person:delete,export'),
+    (1, now(), now(), 'admin', 'Full access', 'person:read,person:write,person:delete,user:manage,export');
+
+-- admins keep full access, all other users keep access to all people
+insert into user_roles (user_id, role_id)
+    select u.id, r.id from users u, roles r where u.admin and r.name = 'admin';
+insert into user_roles (user_id, role_id)
+    select u.id, r.id from users u, roles r where not u.admin and r.name = 'manager';
+
+alter table users drop column admin;
+
+-- +migrate Down
+alter table users add column admin boolean not null default false;
+
+update users set admin = true where id in
+    (select ur.user_id from user_roles ur join roles r on r.id = ur.role_id where r.name = 'admin');
+
+drop table user_roles;
+drop table roles;
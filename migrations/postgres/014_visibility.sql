@@ -0,0 +1,38 @@
+-- +migrate Up
+create table teams (
+    id serial not null primary key,
+    version int not null,
+    created_at timestamp without time zone not null,
+    changed_at timestamp without time zone not null,
+
+    name text not null unique,
+    description text not null
+);
+
+create table team_members (
+    team_id int not null,
+    user_id int not null,
+
+    primary key (team_id, user_id),
+    foreign key (team_id) references teams(id) on update cascade on delete cascade,
+    foreign key (user_id) references users(id) on update cascade on delete cascade
+);
+
+create index team_members_user_id_idx on team_members (user_id);
+
+alter table people
+    add column owner_id int default null references users(id) on update cascade on delete set null,
+    add column visibility text not null default 'everyone'
+        check (visibility in ('private', 'team', 'everyone'));
+
+-- existing people are owned by the user who created them and stay visible to
+-- everyone
+update people set owner_id = created_by;
+
+create index people_owner_id_idx on people (owner_id);
+
+-- +migrate Down
+alter table people drop column owner_id, drop column visibility;
+
+drop table team_members;
+drop table teams;
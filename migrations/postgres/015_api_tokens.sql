@@ -0,0 +1,20 @@
+-- +migrate Up
+create table api_tokens (
+    id serial not null primary key,
+    user_id int not null,
+    name text not null,
+
+    -- only the SHA-256 hash of the token is stored
+    token_hash text not null unique,
+    read_only boolean not null,
+
+    created_at timestamp without time zone not null,
+    expires_at timestamp without time zone default null,
+    last_used_at timestamp without time zone default null,
+
+    unique (user_id, name),
+    foreign key (user_id) references users(id) on update cascade on delete cascade
+);
+
+-- +migrate Down
+drop table api_tokens;
@@ -0,0 +1,11 @@
+-- +migrate Up
+create table oidc_logins (
+    state text not null primary key,
+    nonce text not null,
+    verifier text not null,
+    redirect text not null,
+    valid_until timestamp without time zone not null
+);
+
+-- +migrate Down
+drop table oidc_logins;
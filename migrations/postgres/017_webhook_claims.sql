@@ -0,0 +1,7 @@
+-- +migrate Up
+alter table webhook_deliveries
+    add column claimed_by text not null default '',
+    add column claimed_until timestamp without time zone default null;
+
+-- +migrate Down
+alter table webhook_deliveries drop column claimed_by, drop column claimed_until;
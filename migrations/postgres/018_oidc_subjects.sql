@@ -0,0 +1,13 @@
+-- +migrate Up
+create table oidc_subjects (
+    issuer text not null,
+    subject text not null,
+    user_id int not null unique,
+    created_at timestamp without time zone not null,
+
+    primary key (issuer, subject),
+    foreign key (user_id) references users(id) on update cascade on delete cascade
+);
+
+-- +migrate Down
+drop table oidc_subjects;
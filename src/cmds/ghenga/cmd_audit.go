@@ -0,0 +1,100 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"ghenga/db"
+	"os"
+	"strconv"
+	"text/tabwriter"
+	"time"
+)
+
+type cmdAudit struct {
+	User   string `short:"u" long:"user"   description:"only show entries of this user (login)"`
+	Entity string `short:"e" long:"entity" description:"only show entries for this entity, e.g. person"`
+	Since  string `short:"s" long:"since"  description:"only show entries at or after this time (YYYY-MM-DD or RFC 3339)"`
+	Until  string `          long:"until"  description:"only show entries before this time (YYYY-MM-DD or RFC 3339)"`
+	Limit  int    `short:"n" long:"limit"  default:"100" description:"show at most this many entries"`
+}
+
+func init() {
+	_, err := parser.AddCommand("audit",
+		"show audit log",
+		"The audit command prints the newest entries of the audit log",
+		&cmdAudit{})
+	if err != nil {
+		panic(err)
+	}
+}
+
+// parseAuditTime parses a date or a timestamp in the local time zone.
+func parseAuditTime(s string) (string, error) {
+	t, err := time.ParseInLocation("2006-01-02", s, time.Local)
+	if err != nil {
+		t, err = time.Parse(time.RFC3339, s)
+	}
+	if err != nil {
+		return "", fmt.Errorf("invalid time %q", s)
+	}
+
+	return t.Format(time.RFC3339Nano), nil
+}
+
+func formatNullInt64(v sql.NullInt64) string {
+	if !v.Valid {
+		return "-"
+	}
+
+	return strconv.FormatInt(v.Int64, 10)
+}
+
+func (opts *cmdAudit) Execute(args []string) (err error) {
+	if opts.Limit <= 0 {
+		return fmt.Errorf("limit must be positive")
+	}
+
+	filter := make(map[string]string)
+	if opts.User != "" {
+		filter["user"] = opts.User
+	}
+	if opts.Entity != "" {
+		filter["entity"] = opts.Entity
+	}
+
+	for name, s := range map[string]string{"since": opts.Since, "until": opts.Until} {
+		if s == "" {
+			continue
+		}
+
+		if filter[name], err = parseAuditTime(s); err != nil {
+			return err
+		}
+	}
+
+	dbm, e := OpenDB()
+	if e != nil {
+		return e
+	}
+	defer CleanupErr(&err, dbm.Close)
+
+	entries, _, err := dbm.ListAuditEntriesPage(db.ListOptions{
+		Limit:  opts.Limit,
+		Sort:   db.ParseSort("-id"),
+		Filter: filter,
+	})
+	if err != nil {
+		return err
+	}
+
+	wr := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(wr, "TIME\tUSER\tACTION\tENTITY\tID\tBEFORE\tAFTER\tREMOTE ADDR\tUSER AGENT")
+	for _, e := range entries {
+		fmt.Fprintf(wr, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			e.CreatedAt.Format(time.RFC3339), e.Login, e.Action, e.Entity,
+			formatNullInt64(e.EntityID), formatNullInt64(e.BeforeVersion), formatNullInt64(e.AfterVersion),
+			e.RemoteAddr, e.UserAgent)
+	}
+
+	return wr.Flush()
+}
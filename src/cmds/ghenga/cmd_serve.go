@@ -1,7 +1,9 @@
 package main
 
 import (
+	"errors"
 	"fmt"
+	"ghenga/db"
 	"ghenga/server"
 	"log"
 	"net/http"
@@ -11,12 +13,32 @@ import (
 	"golang.org/x/net/context"
 
 	"github.com/gorilla/handlers"
+	"github.com/lib/pq"
 )
 
 type cmdServe struct {
 	Port   uint   `short:"p" long:"port"   default:"8080"   description:"set the port for the HTTP server"`
 	Addr   string `short:"b" long:"bind"   default:""       description:"bind to this address"`
 	Public string `          long:"public" default:"public" description:"directory for serving static files"`
+
+	OIDCIssuer        string   `long:"oidc-issuer"         env:"GHENGA_OIDC_ISSUER"        description:"enable login with this OpenID Connect provider"`
+	OIDCClientID      string   `long:"oidc-client-id"      env:"GHENGA_OIDC_CLIENT_ID"     description:"client ID registered with the provider"`
+	OIDCClientSecret  string   `long:"oidc-client-secret"  env:"GHENGA_OIDC_CLIENT_SECRET" description:"client secret registered with the provider"`
+	OIDCRedirectURL   string   `long:"oidc-redirect-url"   env:"GHENGA_OIDC_REDIRECT_URL"  description:"absolute URL of /api/login/oidc/callback"`
+	OIDCLoginClaim    string   `long:"oidc-login-claim"    default:"preferred_username"    description:"claim of the ID token which contains the login"`
+	OIDCLinkUsers     bool     `long:"oidc-link-users"                                     description:"bind existing users with the same login on their first login"`
+	OIDCAutoProvision bool     `long:"oidc-auto-provision"                                 description:"create users which log in for the first time"`
+	OIDCDefaultRoles  []string `long:"oidc-default-role"   default:"sales"                 description:"role for users created on login (can be repeated)"`
+	OIDCAdminGroup    string   `long:"oidc-admin-group"                                    description:"grant the role admin to members of this group"`
+	OIDCGroupsClaim   string   `long:"oidc-groups-claim"   default:"groups"                description:"claim of the ID token which contains the groups"`
+
+	Auth               string   `long:"auth"                 default:"db" choice:"db" choice:"ldap" description:"check passwords against the database or an LDAP server"`
+	LDAPURL            string   `long:"ldap-url"             env:"GHENGA_LDAP_URL"                  description:"URL of the LDAP server, e.g. ldaps://ldap.example.com"`
+	LDAPUserDN         string   `long:"ldap-user-dn"         env:"GHENGA_LDAP_USER_DN"              description:"DN of a user, %s is replaced with the login"`
+	LDAPUserGroup      string   `long:"ldap-user-group"                                             description:"allow only members of the group with this DN to log in"`
+	LDAPAdminGroup     string   `long:"ldap-admin-group"                                            description:"grant the role admin to members of the group with this DN"`
+	LDAPGroupAttribute string   `long:"ldap-group-attribute" default:"member"                       description:"attribute of a group which contains the DNs of the members"`
+	LDAPDefaultRoles   []string `long:"ldap-default-role"    default:"sales"                        description:"role for users created on login (can be repeated)"`
 }
 
 func init() {
@@ -31,6 +53,52 @@ func init() {
 
 const sessionDuration = 12 * time.Hour
 
+// oidcConfig returns the configuration for the login with an OpenID Connect
+// provider, it is nil if no provider is configured.
+func (opts *cmdServe) oidcConfig() (*server.OIDCConfig, error) {
+	if opts.OIDCIssuer == "" {
+		return nil, nil
+	}
+
+	if opts.OIDCClientID == "" || opts.OIDCRedirectURL == "" {
+		return nil, errors.New("--oidc-client-id and --oidc-redirect-url are required for OpenID Connect")
+	}
+
+	return &server.OIDCConfig{
+		Issuer:        opts.OIDCIssuer,
+		ClientID:      opts.OIDCClientID,
+		ClientSecret:  opts.OIDCClientSecret,
+		RedirectURL:   opts.OIDCRedirectURL,
+		LoginClaim:    opts.OIDCLoginClaim,
+		LinkUsers:     opts.OIDCLinkUsers,
+		AutoProvision: opts.OIDCAutoProvision,
+		DefaultRoles:  opts.OIDCDefaultRoles,
+		AdminGroup:    opts.OIDCAdminGroup,
+		GroupsClaim:   opts.OIDCGroupsClaim,
+	}, nil
+}
+
+// authenticator returns the Authenticator which checks passwords at login, it
+// is nil for the database.
+func (opts *cmdServe) authenticator() (server.Authenticator, error) {
+	if opts.Auth != "ldap" {
+		return nil, nil
+	}
+
+	if opts.LDAPURL == "" || opts.LDAPUserDN == "" {
+		return nil, errors.New("--ldap-url and --ldap-user-dn are required for LDAP")
+	}
+
+	return &server.LDAPAuthenticator{
+		URL:            opts.LDAPURL,
+		UserDN:         opts.LDAPUserDN,
+		UserGroup:      opts.LDAPUserGroup,
+		AdminGroup:     opts.LDAPAdminGroup,
+		GroupAttribute: opts.LDAPGroupAttribute,
+		DefaultRoles:   opts.LDAPDefaultRoles,
+	}, nil
+}
+
 func expireSessions(ctx context.Context, env *server.Env, d time.Duration) {
 	t := time.NewTicker(d)
 	defer t.Stop()
@@ -48,6 +116,10 @@ func expireSessions(ctx context.Context, env *server.Env, d time.Duration) {
 			if n > 0 {
 				log.Printf("expired %v sessions", n)
 			}
+
+			if _, err = env.DB.ExpireOIDCLogins(); err != nil {
+				log.Printf("ExpireOIDCLogins returned error %v", err)
+			}
 		case <-ctx.Done():
 			return
 		}
@@ -56,9 +128,72 @@ func expireSessions(ctx context.Context, env *server.Env, d time.Duration) {
 
 const sessionExpireInterval = 5 * time.Minute
 
+const (
+	listenerMinReconnect = 10 * time.Second
+	listenerMaxReconnect = time.Minute
+	listenerPingInterval = 90 * time.Second
+)
+
+// listenChanges receives the changes made by other instances using the same
+// database and reports them to the observers of env.DB.
+func listenChanges(ctx context.Context, env *server.Env, dataSource string) {
+	l := pq.NewListener(dataSource, listenerMinReconnect, listenerMaxReconnect,
+		func(ev pq.ListenerEventType, err error) {
+			if err != nil {
+				log.Printf("change listener: %v", err)
+			}
+		})
+	defer func() {
+		if err := l.Close(); err != nil {
+			log.Printf("closing change listener failed: %v", err)
+		}
+	}()
+
+	if err := l.Listen(db.ChangeChannel); err != nil {
+		log.Printf("unable to listen for changes: %v", err)
+		return
+	}
+
+	log.Printf("listening for changes of other instances")
+
+	for {
+		select {
+		case n := <-l.Notify:
+			// n is nil after the connection was re-established,
+			// notifications sent in between are lost
+			if n == nil {
+				log.Printf("change listener reconnected, changes may have been missed")
+				continue
+			}
+
+			if err := env.DB.HandleNotification(n.Extra); err != nil {
+				log.Printf("invalid change notification %q: %v", n.Extra, err)
+			}
+		case <-time.After(listenerPingInterval):
+			go func() {
+				if err := l.Ping(); err != nil {
+					log.Printf("change listener ping failed: %v", err)
+				}
+			}()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
 func (opts *cmdServe) Execute(args []string) (err error) {
 	lgr := log.New(os.Stderr, "", log.LstdFlags)
 
+	oidc, err := opts.oidcConfig()
+	if err != nil {
+		return err
+	}
+
+	auth, err := opts.authenticator()
+	if err != nil {
+		return err
+	}
+
 	dbmap, e := OpenDB()
 	if e != nil {
 		return e
@@ -75,6 +210,8 @@ func (opts *cmdServe) Execute(args []string) (err error) {
 		Cfg: server.Config{
 			Debug:           globalOpts.Debug,
 			SessionDuration: sessionDuration,
+			OIDC:            oidc,
+			Authenticator:   auth,
 		},
 	}
 
@@ -84,6 +221,7 @@ func (opts *cmdServe) Execute(args []string) (err error) {
 	}
 
 	go expireSessions(ctx, env, sessionExpireInterval)
+	go listenChanges(ctx, env, globalOpts.DB)
 
 	router := server.NewRouter(ctx, env)
 
@@ -5,9 +5,12 @@
 package client
 
 import (
+	"bytes"
 	"encoding/json"
 	"io"
+	"io/ioutil"
 	"net/http"
+	"sync"
 	"time"
 
 	"github.com/fd0/probe"
@@ -29,6 +32,16 @@ type Client struct {
 
 	// http request/response tracing
 	trace io.Writer
+
+	// records received from the server with an ETag, by URL
+	cacheMu sync.Mutex
+	cache   map[string]cachedRecord
+}
+
+// cachedRecord is a record received from the server together with its ETag.
+type cachedRecord struct {
+	etag string
+	body []byte
 }
 
 // New returns a new Client. In the parameter `url` it expects the base URL for
@@ -37,6 +50,7 @@ func New(url string) *Client {
 	return &Client{
 		BaseURL: url,
 		C:       http.DefaultClient,
+		cache:   make(map[string]cachedRecord),
 	}
 }
 
@@ -59,15 +73,81 @@ func (c *Client) TraceOff() {
 
 // do executes the http request req. If an authentication token is available,
 // it will be set in the request.
+//
+// Records received with an ETag are remembered. When the same URL is
+// requested again, the ETag is sent in the If-None-Match header, and the
+// remembered record is returned if the server responds with 304 (Not
+// Modified). For PUT, PATCH and DELETE, the ETag is sent in the If-Match
+// header, so that the server rejects the request with 412 (Precondition
+// Failed) if the record was modified in the meantime.
 func (c *Client) do(req *http.Request) (*http.Response, error) {
 	if c.Token != "" {
 		req.Header.Set("X-Auth-Token", c.Token)
 	}
 
+	key := req.URL.String()
+	cached, ok := c.cachedRecord(key)
+	if ok {
+		switch req.Method {
+		case "GET":
+			req.Header.Set("If-None-Match", cached.etag)
+		case "PUT", "PATCH", "DELETE":
+			req.Header.Set("If-Match", cached.etag)
+		}
+	}
+
 	dumpHTTPRequest(c.trace, req)
 	res, err := c.C.Do(req)
 	dumpHTTPResponse(c.trace, res)
-	return res, err
+	if err != nil {
+		return res, err
+	}
+
+	switch {
+	case res.StatusCode == http.StatusNotModified && ok:
+		_ = res.Body.Close()
+		res.StatusCode = http.StatusOK
+		res.Status = "200 OK"
+		res.Body = ioutil.NopCloser(bytes.NewReader(cached.body))
+	case req.Method == "DELETE" && res.StatusCode == http.StatusOK:
+		c.forget(key)
+	case req.Method != "POST" && res.StatusCode == http.StatusOK && res.Header.Get("ETag") != "":
+		body, err := ioutil.ReadAll(res.Body)
+		_ = res.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		c.remember(key, cachedRecord{etag: res.Header.Get("ETag"), body: body})
+		res.Body = ioutil.NopCloser(bytes.NewReader(body))
+	}
+
+	return res, nil
+}
+
+func (c *Client) cachedRecord(url string) (cachedRecord, bool) {
+	c.cacheMu.Lock()
+	defer c.cacheMu.Unlock()
+
+	r, ok := c.cache[url]
+	return r, ok
+}
+
+func (c *Client) remember(url string, r cachedRecord) {
+	c.cacheMu.Lock()
+	defer c.cacheMu.Unlock()
+
+	if c.cache == nil {
+		c.cache = make(map[string]cachedRecord)
+	}
+	c.cache[url] = r
+}
+
+func (c *Client) forget(url string) {
+	c.cacheMu.Lock()
+	defer c.cacheMu.Unlock()
+
+	delete(c.cache, url)
 }
 
 // Login tries to log into the API with the given credentials. On success, the
@@ -146,6 +226,27 @@ func (c *Client) doJSON(req *http.Request, responseStatus int, data interface{})
 	return probe.Trace(res.Body.Close())
 }
 
+// doJSONList works like doJSON for a request which returns a part of a list,
+// it expects the status code 200. The cursor for the next part is returned,
+// it is empty if there are no more items.
+func (c *Client) doJSONList(req *http.Request, data interface{}) (string, error) {
+	res, err := c.do(req)
+	if err != nil {
+		return "", probe.Trace(err)
+	}
+
+	if res.StatusCode != http.StatusOK {
+		return "", probe.Trace(ParseError(res))
+	}
+
+	dec := json.NewDecoder(res.Body)
+	if err := dec.Decode(data); err != nil {
+		return "", probe.Trace(err)
+	}
+
+	return res.Header.Get("X-Next-Cursor"), probe.Trace(res.Body.Close())
+}
+
 // Check queries the API server whether the token is still valid.
 func (c *Client) Check() error {
 	req, err := http.NewRequest("GET", c.BaseURL+"/api/login/info", nil)
@@ -0,0 +1,71 @@
+package client
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/fd0/probe"
+)
+
+// BatchOperation is a single operation within a batch. Op is one of
+// "create", "update" or "delete", Entity is "person" or "user". Data is
+// encoded as JSON and sent as the body for create and update. If Version is
+// not zero, the operation fails if the record has been modified.
+type BatchOperation struct {
+	Op      string      `json:"op"`
+	Entity  string      `json:"entity"`
+	ID      int64       `json:"id,omitempty"`
+	Version int64       `json:"version,omitempty"`
+	Data    interface{} `json:"data,omitempty"`
+}
+
+// BatchResult is the result of a single operation, it contains the status
+// code and the body which the server would have returned for the single
+// request.
+type BatchResult struct {
+	Status int             `json:"status"`
+	Body   json.RawMessage `json:"body"`
+}
+
+// BatchResponse is returned for a batch. Committed is true if the changes
+// have been saved.
+type BatchResponse struct {
+	Committed bool          `json:"committed"`
+	Results   []BatchResult `json:"results"`
+}
+
+// Batch runs the operations on the server within a single transaction. If an
+// operation fails, no changes are saved unless continueOnError is set, in
+// which case only the failed operations are skipped. The result of each
+// operation is returned.
+func (c *Client) Batch(ops []BatchOperation, continueOnError bool) (BatchResponse, error) {
+	data, err := json.Marshal(struct {
+		ContinueOnError bool             `json:"continue_on_error"`
+		Operations      []BatchOperation `json:"operations"`
+	}{continueOnError, ops})
+	if err != nil {
+		return BatchResponse{}, probe.Trace(err)
+	}
+
+	req, err := http.NewRequest("POST", c.BaseURL+"/api/batch", bytes.NewReader(data))
+	if err != nil {
+		return BatchResponse{}, probe.Trace(err)
+	}
+
+	var res BatchResponse
+	err = c.doJSON(req, http.StatusOK, &res)
+	if err != nil {
+		return BatchResponse{}, probe.Trace(err)
+	}
+
+	// records changed by the batch must be requested again
+	for _, op := range ops {
+		if op.ID != 0 {
+			c.forget(fmt.Sprintf("%s/api/%s/%d", c.BaseURL, op.Entity, op.ID))
+		}
+	}
+
+	return res, nil
+}
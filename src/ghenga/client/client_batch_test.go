@@ -0,0 +1,69 @@
+package client
+
+import (
+	"encoding/json"
+	"ghenga/db"
+	"ghenga/server"
+	"testing"
+)
+
+func TestClientBatch(t *testing.T) {
+	srv, cleanup := server.TestServer(t)
+	defer cleanup()
+
+	c := TestClient(t, srv.URL, "admin", "geheim")
+
+	u, err := c.CreateUser(db.User{Login: "batch", Password: "x"})
+	if err != nil {
+		t.Fatalf("creating user failed: %v", err)
+	}
+
+	// remember the user with its ETag
+	if _, err = c.FindUser(int(u.ID)); err != nil {
+		t.Fatal(err)
+	}
+
+	u.Login = "batch2"
+	ops := []BatchOperation{
+		{Op: "create", Entity: "person", Data: db.PersonJSON{Name: "Batch Client"}},
+		{Op: "update", Entity: "user", ID: u.ID, Version: u.Version, Data: u},
+	}
+
+	res, err := c.Batch(ops, false)
+	if err != nil {
+		t.Fatalf("batch failed: %v", err)
+	}
+
+	if !res.Committed || len(res.Results) != 2 {
+		t.Fatalf("unexpected response: %v", res)
+	}
+
+	for i, r := range res.Results {
+		if r.Status >= 400 {
+			t.Errorf("operation %d failed with status %d: %s", i, r.Status, r.Body)
+		}
+	}
+
+	var p db.Person
+	if err = json.Unmarshal(res.Results[0].Body, &p); err != nil {
+		t.Fatal(err)
+	}
+
+	if p.ID == 0 || p.Name != "Batch Client" {
+		t.Fatalf("wrong person returned: %v", p)
+	}
+
+	// the user changed by the batch is requested again
+	u, err = c.FindUser(int(u.ID))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if u.Login != "batch2" {
+		t.Fatalf("client returned outdated user: %v", u)
+	}
+
+	if err = c.DeleteUser(int(u.ID)); err != nil {
+		t.Fatalf("deleting user failed: %v", err)
+	}
+}
@@ -6,24 +6,38 @@ import (
 	"fmt"
 	"ghenga/db"
 	"net/http"
+	"net/url"
 
 	"github.com/fd0/probe"
 )
 
-// ListUsers returns the list of all users.
+// ListUsers returns the list of all users. The list is requested in parts
+// until the server does not return a cursor for the next part.
 func (c *Client) ListUsers() ([]db.User, error) {
-	req, err := http.NewRequest("GET", c.BaseURL+"/api/user", nil)
-	if err != nil {
-		return nil, probe.Trace(err)
-	}
-
 	var list []db.User
-	err = c.doJSON(req, http.StatusOK, &list)
-	if err != nil {
-		return nil, probe.Trace(err)
-	}
+	cursor := ""
+	for {
+		u := c.BaseURL + "/api/user"
+		if cursor != "" {
+			u += "?cursor=" + url.QueryEscape(cursor)
+		}
+
+		req, err := http.NewRequest("GET", u, nil)
+		if err != nil {
+			return nil, probe.Trace(err)
+		}
 
-	return list, nil
+		var page []db.User
+		cursor, err = c.doJSONList(req, &page)
+		if err != nil {
+			return nil, probe.Trace(err)
+		}
+
+		list = append(list, page...)
+		if cursor == "" {
+			return list, nil
+		}
+	}
 }
 
 // FindUser returns the record of a single user, identified by the user ID.
@@ -62,3 +76,38 @@ func (c *Client) CreateUser(u db.User) (db.User, error) {
 
 	return resultingUser, nil
 }
+
+// UpdateUser saves the changed user account. If the user was requested
+// before, the request fails if the user was modified on the server in the
+// meantime.
+func (c *Client) UpdateUser(u db.User) (db.User, error) {
+	data, err := json.Marshal(u)
+	if err != nil {
+		return db.User{}, probe.Trace(err, u)
+	}
+
+	req, err := http.NewRequest("PUT", fmt.Sprintf("%s/api/user/%d", c.BaseURL, u.ID), bytes.NewReader(data))
+	if err != nil {
+		return db.User{}, probe.Trace(err)
+	}
+
+	var resultingUser db.User
+	err = c.doJSON(req, http.StatusOK, &resultingUser)
+	if err != nil {
+		return db.User{}, probe.Trace(err, u)
+	}
+
+	return resultingUser, nil
+}
+
+// DeleteUser removes a user account. If the user was requested before, the
+// request fails if the user was modified on the server in the meantime.
+func (c *Client) DeleteUser(id int) error {
+	req, err := http.NewRequest("DELETE", fmt.Sprintf("%s/api/user/%d", c.BaseURL, id), nil)
+	if err != nil {
+		return probe.Trace(err)
+	}
+
+	var result struct{}
+	return probe.Trace(c.doJSON(req, http.StatusOK, &result), id)
+}
@@ -3,7 +3,10 @@ package client
 import (
 	"ghenga/db"
 	"ghenga/server"
+	"net/http"
 	"testing"
+
+	"github.com/fd0/probe"
 )
 
 func TestClientUsersCRUD(t *testing.T) {
@@ -32,3 +35,59 @@ func TestClientUsersCRUD(t *testing.T) {
 
 	t.Logf("created user %v", u)
 }
+
+func TestClientUsersConcurrentUpdate(t *testing.T) {
+	srv, cleanup := server.TestServer(t)
+	defer cleanup()
+
+	c1 := TestClient(t, srv.URL, "admin", "geheim")
+	c2 := TestClient(t, srv.URL, "admin", "geheim")
+
+	u, err := c1.CreateUser(db.User{Login: "concurrent", Password: "x"})
+	if err != nil {
+		t.Fatalf("creating user failed: %v", err)
+	}
+
+	u1, err := c1.FindUser(int(u.ID))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the second request is answered from the cache
+	if _, err = c1.FindUser(int(u.ID)); err != nil {
+		t.Fatal(err)
+	}
+
+	u2, err := c2.FindUser(int(u.ID))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	u2.Admin = true
+	if _, err = c2.UpdateUser(u2); err != nil {
+		t.Fatalf("updating user failed: %v", err)
+	}
+
+	u1.Login = "concurrent2"
+	_, err = c1.UpdateUser(u1)
+	if e, ok := err.(probe.Error); !ok || e.Cause.(Error).Status != http.StatusPreconditionFailed {
+		t.Fatalf("updating outdated user did not fail with status 412: %v", err)
+	}
+
+	if err = c1.DeleteUser(int(u.ID)); err == nil {
+		t.Fatalf("deleting outdated user succeeded")
+	}
+
+	u1, err = c1.FindUser(int(u.ID))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !u1.Admin {
+		t.Fatalf("client returned outdated user: %v", u1)
+	}
+
+	if err = c1.DeleteUser(int(u.ID)); err != nil {
+		t.Fatalf("deleting user failed: %v", err)
+	}
+}
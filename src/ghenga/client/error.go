@@ -21,15 +21,20 @@ func ParseError(r *http.Response) Error {
 	if err != nil {
 		return Error{
 			Message: "response body contained invalid JSON: " + err.Error(),
+			Status:  r.StatusCode,
 		}
 	}
 
+	e.Status = r.StatusCode
 	return e
 }
 
 // Error is an error as returned by the ghenga API.
 type Error struct {
 	Message string `json:"message"`
+
+	// Status is the HTTP status code of the response.
+	Status int `json:"-"`
 }
 
 func (e Error) String() string {
@@ -0,0 +1,235 @@
+package db
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/modl"
+)
+
+// Account is a company, a union or another organisation in the database.
+type Account struct {
+	ID           int64
+	Name         string
+	Website      string
+	PhoneNumbers PhoneNumbers `db:"-"`
+
+	// Billing address
+	BillingStreet     string
+	BillingPostalCode string
+	BillingState      string
+	BillingCity       string
+	BillingCountry    string
+
+	// Physical address
+	PhysicalStreet     string
+	PhysicalPostalCode string
+	PhysicalState      string
+	PhysicalCity       string
+	PhysicalCountry    string
+
+	ChangedAt time.Time
+	CreatedAt time.Time
+	Version   int64
+}
+
+// AccountJSON is the JSON representation of an Account as returned or
+// consumed by the API.
+type AccountJSON struct {
+	ID           int64             `json:"id,omitempty"`
+	Name         string            `json:"name,omitempty"`
+	Website      string            `json:"website,omitempty"`
+	PhoneNumbers []PhoneNumberJSON `json:"phone_numbers"`
+
+	BillingAddress  AddressJSON `json:"billing_address,omitempty"`
+	PhysicalAddress AddressJSON `json:"physical_address,omitempty"`
+
+	ChangedAt string `json:"changed_at,omitempty"`
+	CreatedAt string `json:"created_at,omitempty"`
+
+	Version int64 `json:"version"`
+}
+
+// NewAccount returns a new account record.
+func NewAccount(name string) *Account {
+	ts := time.Now()
+	return &Account{
+		Name:      name,
+		CreatedAt: ts,
+		ChangedAt: ts,
+	}
+}
+
+// MarshalJSON returns the JSON representation of a.
+func (a Account) MarshalJSON() ([]byte, error) {
+	ja := AccountJSON{
+		ID:           a.ID,
+		Name:         a.Name,
+		Website:      a.Website,
+		PhoneNumbers: a.PhoneNumbers.JSON(),
+
+		BillingAddress: AddressJSON{
+			Street:     a.BillingStreet,
+			PostalCode: a.BillingPostalCode,
+			State:      a.BillingState,
+			City:       a.BillingCity,
+			Country:    a.BillingCountry,
+		},
+
+		PhysicalAddress: AddressJSON{
+			Street:     a.PhysicalStreet,
+			PostalCode: a.PhysicalPostalCode,
+			State:      a.PhysicalState,
+			City:       a.PhysicalCity,
+			Country:    a.PhysicalCountry,
+		},
+
+		ChangedAt: a.ChangedAt.Format(timeLayout),
+		CreatedAt: a.CreatedAt.Format(timeLayout),
+		Version:   a.Version,
+	}
+
+	return json.Marshal(ja)
+}
+
+// UnmarshalJSON returns an account from JSON.
+func (a *Account) UnmarshalJSON(data []byte) error {
+	var ja AccountJSON
+
+	err := json.Unmarshal(data, &ja)
+	if err != nil {
+		return err
+	}
+
+	createdAt, err := time.Parse(timeLayout, ja.CreatedAt)
+	if err != nil {
+		return err
+	}
+
+	changedAt, err := time.Parse(timeLayout, ja.ChangedAt)
+	if err != nil {
+		return err
+	}
+
+	*a = Account{
+		ID:        ja.ID,
+		CreatedAt: createdAt,
+		ChangedAt: changedAt,
+	}
+	a.Update(ja)
+
+	return nil
+}
+
+// Validate checks if a is valid and returns an error if not.
+func (a *Account) Validate() error {
+	if a.Name == "" {
+		return errors.New("name is empty")
+	}
+
+	if a.CreatedAt.IsZero() || a.ChangedAt.IsZero() {
+		return errors.New("invalid timestamps")
+	}
+
+	return nil
+}
+
+// PostInsert is run after an account is saved into the database. It is used
+// to handle phone numbers associated with an account.
+func (a *Account) PostInsert(db modl.SqlExecutor) error {
+	return insertPhoneNumbers(db, phoneOwnerAccount, a.ID, a.PhoneNumbers)
+}
+
+// PostGet loads the phone numbers associated with the account.
+func (a *Account) PostGet(db modl.SqlExecutor) error {
+	return selectPhoneNumbers(db, phoneOwnerAccount, a.ID, &a.PhoneNumbers)
+}
+
+// PostUpdate is run after an account has been updated. It handles updating
+// the phone numbers for an account.
+func (a *Account) PostUpdate(db modl.SqlExecutor) error {
+	return updatePhoneNumbers(db, phoneOwnerAccount, a.ID, a.PhoneNumbers)
+}
+
+// Update updates a with the fields from other.
+func (a *Account) Update(other AccountJSON) {
+	a.Name = other.Name
+	a.Website = other.Website
+	a.PhoneNumbers = phoneNumbersFromJSON(other.PhoneNumbers)
+
+	a.BillingStreet = other.BillingAddress.Street
+	a.BillingPostalCode = other.BillingAddress.PostalCode
+	a.BillingState = other.BillingAddress.State
+	a.BillingCity = other.BillingAddress.City
+	a.BillingCountry = other.BillingAddress.Country
+
+	a.PhysicalStreet = other.PhysicalAddress.Street
+	a.PhysicalPostalCode = other.PhysicalAddress.PostalCode
+	a.PhysicalState = other.PhysicalAddress.State
+	a.PhysicalCity = other.PhysicalAddress.City
+	a.PhysicalCountry = other.PhysicalAddress.Country
+
+	a.Version = other.Version
+}
+
+func (a Account) String() string {
+	return fmt.Sprintf("<Account[%v] (%v)>", a.ID, a.Name)
+}
+
+// FindAccount returns the account with the given id.
+func (db *DB) FindAccount(id int64) (*Account, error) {
+	var a Account
+
+	err := db.ex.SelectOne(&a, "SELECT * FROM accounts WHERE id = $1", id)
+	if err != nil {
+		return nil, err
+	}
+
+	return &a, nil
+}
+
+// UpdateAccount modifies an existing account. The account and the phone
+// numbers are saved in one transaction.
+func (db *DB) UpdateAccount(a *Account) error {
+	return db.atomic(func(tx *Tx) error {
+		_, err := tx.ex.Update(a)
+		return err
+	})
+}
+
+// InsertAccount creates a new account. The account and the phone numbers are
+// saved in one transaction.
+func (db *DB) InsertAccount(a *Account) error {
+	return db.atomic(func(tx *Tx) error {
+		return tx.ex.Insert(a)
+	})
+}
+
+// ListAccounts returns the list of accounts.
+func (db *DB) ListAccounts() ([]*Account, error) {
+	var accounts []*Account
+	err := db.ex.Select(&accounts, "select * from accounts")
+	return accounts, err
+}
+
+// DeleteAccount removes an account. People associated with the account are
+// kept, their account is reset.
+func (db *DB) DeleteAccount(id int64) error {
+	res, err := db.ex.Exec("delete from accounts where id = $1", id)
+	if err != nil {
+		return err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if n != 1 {
+		return errors.New("account not found")
+	}
+
+	return nil
+}
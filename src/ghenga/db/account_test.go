@@ -0,0 +1,170 @@
+package db
+
+import (
+	"bytes"
+	"database/sql"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+var testAccounts = []struct {
+	name string
+	a    Account
+}{
+	{
+		name: "testaccount1",
+		a: Account{
+			Name:    "Beispiel GmbH",
+			Website: "https://www.example.com",
+			PhoneNumbers: []PhoneNumber{
+				{Type: "switchboard", Number: "+49 221 1231234"},
+				{Type: "fax", Number: "+49 221 1231235"},
+			},
+
+			BillingStreet:     "Teststraße 24",
+			BillingPostalCode: "03030",
+			BillingCity:       "Berlin",
+			BillingCountry:    "Germany",
+
+			PhysicalStreet:     "Teststraße 24b",
+			PhysicalPostalCode: "03030",
+			PhysicalCity:       "Berlin",
+			PhysicalCountry:    "Germany",
+
+			ChangedAt: parseTime("2016-04-24T10:30:07+00:00"),
+			CreatedAt: parseTime("2016-04-24T10:30:07+00:00"),
+			Version:   2,
+		},
+	},
+}
+
+func TestAccountInsertSelect(t *testing.T) {
+	for _, test := range testAccounts {
+		a := test.a
+		err := testDB.InsertAccount(&a)
+		if err != nil {
+			t.Errorf("saving %v failed: %v", test.name, err)
+			continue
+		}
+
+		a2, err := testDB.FindAccount(a.ID)
+		if err != nil {
+			t.Errorf("loading %v failed: %v", a.ID, err)
+			continue
+		}
+
+		if a2.Version != test.a.Version+1 {
+			t.Errorf("%v: wrong version loaded from db, want %v, got %v",
+				test.name, test.a.Version+1, a2.Version)
+		}
+
+		if !a2.PhoneNumbers.Equals(test.a.PhoneNumbers) {
+			t.Errorf("%v: wrong phone numbers loaded from db, want %v, got %v",
+				test.name, test.a.PhoneNumbers, a2.PhoneNumbers)
+		}
+	}
+}
+
+func TestAccountMarshal(t *testing.T) {
+	for i, test := range testAccounts {
+		buf := marshal(t, test.a)
+
+		golden := filepath.Join("testdata", "TestAccountMarshal_"+test.name+".golden")
+		if *update {
+			err := ioutil.WriteFile(golden, buf, 0644)
+			if err != nil {
+				t.Fatalf("test %d: update golden file %v failed: %v", i, golden, err)
+			}
+		}
+
+		expected, err := ioutil.ReadFile(golden)
+		if err != nil {
+			t.Errorf("test %d: unable to read golden file %v", i, golden)
+			continue
+		}
+		if !bytes.Equal(buf, expected) {
+			t.Errorf("test %d (%v) wrong JSON returned:\nwant:\n%s\ngot:\n%s", i, test.name, expected, buf)
+		}
+	}
+}
+
+func TestAccountUnmarshal(t *testing.T) {
+	for i, test := range testAccounts {
+		golden := filepath.Join("testdata", "TestAccountMarshal_"+test.name+".golden")
+		buf, err := ioutil.ReadFile(golden)
+		if err != nil {
+			t.Errorf("test %d: unable to read golden file %v", i, golden)
+			continue
+		}
+
+		var a Account
+		unmarshal(t, buf, &a)
+
+		buf2 := marshal(t, a)
+
+		if !bytes.Equal(buf, buf2) {
+			t.Errorf("test %d (%v) wrong JSON returned:\nwant:\n%s\ngot:\n%s", i, test.name, buf, buf2)
+		}
+	}
+}
+
+func TestAccountValidate(t *testing.T) {
+	for i, test := range testAccounts {
+		if err := test.a.Validate(); err != nil {
+			t.Errorf("test %v (%v) failed: testAccount is invalid: %v", test.name, i, err)
+		}
+	}
+
+	a := Account{}
+	if err := a.Validate(); err == nil {
+		t.Errorf("empty account is valid")
+	}
+}
+
+func TestAccountUpdate(t *testing.T) {
+	a := NewAccount("Testers Inc.")
+	if err := testDB.InsertAccount(a); err != nil {
+		t.Fatal(err)
+	}
+
+	a.Website = "https://testers.example.com"
+	if err := testDB.UpdateAccount(a); err != nil {
+		t.Fatalf("unable to update account: %v", err)
+	}
+
+	a.Name = "Testers Ltd."
+	a.Version = 1
+	if err := testDB.UpdateAccount(a); err == nil {
+		t.Fatalf("update did not fail despite wrong version field")
+	}
+}
+
+func TestAccountPeople(t *testing.T) {
+	a := NewAccount("People Inc.")
+	if err := testDB.InsertAccount(a); err != nil {
+		t.Fatal(err)
+	}
+
+	p := findPerson(t, testDB, 3)
+	p.AccountID = sql.NullInt64{Int64: a.ID, Valid: true}
+	updatePerson(t, testDB, p)
+
+	people, err := testDB.ListAccountPeople(a.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(people) != 1 || people[0].ID != p.ID {
+		t.Fatalf("ListAccountPeople returned wrong list, want [%v], got %v", p, people)
+	}
+
+	if err = testDB.DeleteAccount(a.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	p = findPerson(t, testDB, p.ID)
+	if p.AccountID.Valid {
+		t.Fatalf("account ID for person %v not reset after account was removed", p)
+	}
+}
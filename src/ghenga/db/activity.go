@@ -0,0 +1,235 @@
+package db
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Valid values for the kind of an activity.
+const (
+	ActivityCall    = "call"
+	ActivityMeeting = "meeting"
+	ActivityEmail   = "email"
+	ActivityNote    = "note"
+)
+
+// Activity is an interaction with a person, e.g. a phone call or a meeting.
+type Activity struct {
+	ID      int64
+	Kind    string
+	Subject string
+	Body    string
+
+	OccurredAt time.Time
+	PersonID   int64
+	AuthorID   sql.NullInt64
+
+	ChangedAt time.Time
+	CreatedAt time.Time
+	Version   int64
+}
+
+// ActivityJSON is the JSON representation of an Activity as returned or
+// consumed by the API.
+type ActivityJSON struct {
+	ID      int64  `json:"id,omitempty"`
+	Kind    string `json:"kind,omitempty"`
+	Subject string `json:"subject,omitempty"`
+	Body    string `json:"body,omitempty"`
+
+	OccurredAt string `json:"occurred_at,omitempty"`
+	PersonID   int64  `json:"person_id"`
+	AuthorID   *int64 `json:"author_id,omitempty"`
+
+	ChangedAt string `json:"changed_at,omitempty"`
+	CreatedAt string `json:"created_at,omitempty"`
+
+	Version int64 `json:"version"`
+}
+
+// NewActivity returns a new activity record for the person, which occurred
+// just now.
+func NewActivity(kind, subject string, personID int64) *Activity {
+	ts := time.Now()
+	return &Activity{
+		Kind:       kind,
+		Subject:    subject,
+		PersonID:   personID,
+		OccurredAt: ts,
+		CreatedAt:  ts,
+		ChangedAt:  ts,
+	}
+}
+
+// MarshalJSON returns the JSON representation of a.
+func (a Activity) MarshalJSON() ([]byte, error) {
+	ja := ActivityJSON{
+		ID:      a.ID,
+		Kind:    a.Kind,
+		Subject: a.Subject,
+		Body:    a.Body,
+
+		OccurredAt: a.OccurredAt.Format(timeLayout),
+		PersonID:   a.PersonID,
+		AuthorID:   nullInt64JSON(a.AuthorID),
+
+		ChangedAt: a.ChangedAt.Format(timeLayout),
+		CreatedAt: a.CreatedAt.Format(timeLayout),
+		Version:   a.Version,
+	}
+
+	return json.Marshal(ja)
+}
+
+// UnmarshalJSON returns an activity from JSON.
+func (a *Activity) UnmarshalJSON(data []byte) error {
+	var ja ActivityJSON
+
+	err := json.Unmarshal(data, &ja)
+	if err != nil {
+		return err
+	}
+
+	createdAt, err := time.Parse(timeLayout, ja.CreatedAt)
+	if err != nil {
+		return err
+	}
+
+	changedAt, err := time.Parse(timeLayout, ja.ChangedAt)
+	if err != nil {
+		return err
+	}
+
+	*a = Activity{
+		ID:        ja.ID,
+		PersonID:  ja.PersonID,
+		AuthorID:  jsonNullInt64(ja.AuthorID),
+		CreatedAt: createdAt,
+		ChangedAt: changedAt,
+	}
+
+	return a.Update(ja)
+}
+
+// Validate checks if a is valid and returns an error if not.
+func (a *Activity) Validate() error {
+	switch a.Kind {
+	case ActivityCall, ActivityMeeting, ActivityEmail, ActivityNote:
+	default:
+		return fmt.Errorf("invalid kind %q", a.Kind)
+	}
+
+	if a.Subject == "" {
+		return errors.New("subject is empty")
+	}
+
+	if a.PersonID == 0 {
+		return errors.New("person is not set")
+	}
+
+	if a.OccurredAt.IsZero() {
+		return errors.New("invalid occurred_at timestamp")
+	}
+
+	if a.CreatedAt.IsZero() || a.ChangedAt.IsZero() {
+		return errors.New("invalid timestamps")
+	}
+
+	return nil
+}
+
+// Update updates a with the fields from other. The person and the author of
+// an activity cannot be changed. When other does not specify the time the
+// activity occurred at, it is left unchanged.
+func (a *Activity) Update(other ActivityJSON) error {
+	if other.OccurredAt != "" {
+		occurredAt, err := time.Parse(timeLayout, other.OccurredAt)
+		if err != nil {
+			return err
+		}
+
+		a.OccurredAt = occurredAt
+	}
+
+	a.Kind = other.Kind
+	a.Subject = other.Subject
+	a.Body = other.Body
+
+	a.Version = other.Version
+
+	return nil
+}
+
+func (a Activity) String() string {
+	return fmt.Sprintf("<Activity[%v] %v (%v)>", a.ID, a.Kind, a.Subject)
+}
+
+// FindActivity returns the activity with the given id.
+func (db *DB) FindActivity(id int64) (*Activity, error) {
+	var a Activity
+
+	err := db.ex.SelectOne(&a, "SELECT * FROM activities WHERE id = $1", id)
+	if err != nil {
+		return nil, err
+	}
+
+	return &a, nil
+}
+
+// UpdateActivity modifies an existing activity.
+func (db *DB) UpdateActivity(a *Activity) error {
+	_, err := db.ex.Update(a)
+	return err
+}
+
+// InsertActivity creates a new activity.
+func (db *DB) InsertActivity(a *Activity) error {
+	return db.ex.Insert(a)
+}
+
+// ListPersonActivities returns the timeline of activities for the person. The
+// activities are ordered by the time they occurred, the newest first unless
+// ascending is set. At most limit activities are returned, starting at
+// offset. In addition, the total number of activities for the person is
+// returned.
+func (db *DB) ListPersonActivities(personID int64, ascending bool, limit, offset int) (activities []*Activity, total int64, err error) {
+	err = db.ex.SelectOne(&total, "SELECT count(*) FROM activities WHERE person_id = $1", personID)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	order := "DESC"
+	if ascending {
+		order = "ASC"
+	}
+
+	query := fmt.Sprintf("SELECT * FROM activities WHERE person_id = $1 ORDER BY occurred_at %s, id %s LIMIT $2 OFFSET $3", order, order)
+	err = db.ex.Select(&activities, query, personID, limit, offset)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return activities, total, nil
+}
+
+// DeleteActivity removes an activity.
+func (db *DB) DeleteActivity(id int64) error {
+	res, err := db.ex.Exec("delete from activities where id = $1", id)
+	if err != nil {
+		return err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if n != 1 {
+		return errors.New("activity not found")
+	}
+
+	return nil
+}
@@ -0,0 +1,124 @@
+package db
+
+import (
+	"bytes"
+	"database/sql"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+var testActivities = []struct {
+	name string
+	a    Activity
+}{
+	{
+		name: "testactivity1",
+		a: Activity{
+			Kind:       ActivityCall,
+			Subject:    "Asked about the offer",
+			Body:       "Will call back next week.",
+			OccurredAt: parseTime("2016-04-23T15:00:00+00:00"),
+			PersonID:   3,
+			AuthorID:   sql.NullInt64{Int64: 1, Valid: true},
+			ChangedAt:  parseTime("2016-04-24T10:30:07+00:00"),
+			CreatedAt:  parseTime("2016-04-24T10:30:07+00:00"),
+			Version:    1,
+		},
+	},
+}
+
+func TestActivityMarshal(t *testing.T) {
+	for i, test := range testActivities {
+		buf := marshal(t, test.a)
+
+		golden := filepath.Join("testdata", "TestActivityMarshal_"+test.name+".golden")
+		if *update {
+			err := ioutil.WriteFile(golden, buf, 0644)
+			if err != nil {
+				t.Fatalf("test %d: update golden file %v failed: %v", i, golden, err)
+			}
+		}
+
+		expected, err := ioutil.ReadFile(golden)
+		if err != nil {
+			t.Errorf("test %d: unable to read golden file %v", i, golden)
+			continue
+		}
+		if !bytes.Equal(buf, expected) {
+			t.Errorf("test %d (%v) wrong JSON returned:\nwant:\n%s\ngot:\n%s", i, test.name, expected, buf)
+		}
+	}
+}
+
+func TestActivityUnmarshal(t *testing.T) {
+	for i, test := range testActivities {
+		golden := filepath.Join("testdata", "TestActivityMarshal_"+test.name+".golden")
+		buf, err := ioutil.ReadFile(golden)
+		if err != nil {
+			t.Errorf("test %d: unable to read golden file %v", i, golden)
+			continue
+		}
+
+		var a Activity
+		unmarshal(t, buf, &a)
+
+		buf2 := marshal(t, a)
+
+		if !bytes.Equal(buf, buf2) {
+			t.Errorf("test %d (%v) wrong JSON returned:\nwant:\n%s\ngot:\n%s", i, test.name, buf, buf2)
+		}
+	}
+}
+
+func TestActivityValidate(t *testing.T) {
+	for i, test := range testActivities {
+		if err := test.a.Validate(); err != nil {
+			t.Errorf("test %v (%v) failed: testActivity is invalid: %v", test.name, i, err)
+		}
+	}
+
+	a := NewActivity("visit", "foo", 1)
+	if err := a.Validate(); err == nil {
+		t.Errorf("activity with invalid kind is valid")
+	}
+}
+
+func TestPersonActivities(t *testing.T) {
+	p := NewPerson("Activity Person")
+	if err := testDB.InsertPerson(p); err != nil {
+		t.Fatal(err)
+	}
+
+	start := parseTime("2016-04-01T10:00:00+00:00")
+	for i := 0; i < 5; i++ {
+		a := NewActivity(ActivityNote, "note", p.ID)
+		a.OccurredAt = start.Add(time.Duration(i) * time.Hour)
+		if err := testDB.InsertActivity(a); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	list, total, err := testDB.ListPersonActivities(p.ID, false, 2, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if total != 5 {
+		t.Errorf("wrong total number of activities, want 5, got %v", total)
+	}
+
+	if len(list) != 2 || !list[0].OccurredAt.After(list[1].OccurredAt) {
+		t.Errorf("wrong list of activities returned, want the newest two, got %v", list)
+	}
+
+	list, _, err = testDB.ListPersonActivities(p.ID, true, 10, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(list) != 2 || !list[0].OccurredAt.Equal(start.Add(3*time.Hour)) {
+		t.Errorf("wrong list of activities returned, want the oldest but three, got %v", list)
+	}
+}
@@ -0,0 +1,170 @@
+package db
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// APITokenPrefix is the prefix of all personal API tokens. It distinguishes
+// them from session tokens.
+const APITokenPrefix = "ghenga_"
+
+// APIToken is a named, long-lived token a user creates for scripts and
+// integrations. Only the hash of the token is saved, the token itself is
+// only available right after it has been created.
+type APIToken struct {
+	ID        int64
+	UserID    int64
+	Name      string
+	TokenHash string
+	ReadOnly  bool
+
+	// Token is the token in plain text, it is only set by NewAPIToken.
+	Token string `db:"-"`
+
+	CreatedAt  time.Time
+	ExpiresAt  pq.NullTime
+	LastUsedAt pq.NullTime
+}
+
+// APITokenJSON is the JSON representation of an APIToken.
+type APITokenJSON struct {
+	ID       int64  `json:"id,omitempty"`
+	Name     string `json:"name"`
+	Token    string `json:"token,omitempty"`
+	ReadOnly bool   `json:"read_only"`
+
+	CreatedAt  string  `json:"created_at,omitempty"`
+	ExpiresAt  *string `json:"expires_at"`
+	LastUsedAt *string `json:"last_used_at"`
+}
+
+// MarshalJSON returns the JSON representation of t. The token itself is only
+// included if it is known, i.e. right after the token has been created.
+func (t APIToken) MarshalJSON() ([]byte, error) {
+	return json.Marshal(APITokenJSON{
+		ID:         t.ID,
+		Name:       t.Name,
+		Token:      t.Token,
+		ReadOnly:   t.ReadOnly,
+		CreatedAt:  t.CreatedAt.Format(timeLayout),
+		ExpiresAt:  nullTimeJSON(t.ExpiresAt),
+		LastUsedAt: nullTimeJSON(t.LastUsedAt),
+	})
+}
+
+// NewAPIToken returns a new random token for the user. The token is
+// available in the field Token until the struct is discarded.
+func NewAPIToken(userID int64, name string, readOnly bool, expiresAt pq.NullTime) (*APIToken, error) {
+	buf := make([]byte, tokenLength)
+	_, err := io.ReadFull(rand.Reader, buf)
+	if err != nil {
+		return nil, err
+	}
+
+	token := APITokenPrefix + hex.EncodeToString(buf)
+
+	t := &APIToken{
+		UserID:    userID,
+		Name:      name,
+		TokenHash: hashAPIToken(token),
+		ReadOnly:  readOnly,
+		Token:     token,
+		CreatedAt: time.Now(),
+		ExpiresAt: expiresAt,
+	}
+
+	return t, nil
+}
+
+// hashAPIToken returns the hash of the token which is saved in the database.
+// The tokens are long random strings, so a plain SHA-256 is sufficient.
+func hashAPIToken(token string) string {
+	h := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(h[:])
+}
+
+// IsAPIToken returns true if token looks like a personal API token.
+func IsAPIToken(token string) bool {
+	return strings.HasPrefix(token, APITokenPrefix)
+}
+
+// Validate checks if t is valid and returns an error if not.
+func (t *APIToken) Validate() error {
+	if t.Name == "" {
+		return errors.New("name is empty")
+	}
+
+	if t.ExpiresAt.Valid && !t.ExpiresAt.Time.After(t.CreatedAt) {
+		return errors.New("expiry is in the past")
+	}
+
+	return nil
+}
+
+// Expired returns true if t has an expiry date before now.
+func (t *APIToken) Expired(now time.Time) bool {
+	return t.ExpiresAt.Valid && t.ExpiresAt.Time.Before(now)
+}
+
+func (t APIToken) String() string {
+	return fmt.Sprintf("<APIToken[%v] %v, user %v>", t.ID, t.Name, t.UserID)
+}
+
+// InsertAPIToken saves a new token.
+func (db *DB) InsertAPIToken(t *APIToken) error {
+	return db.ex.Insert(t)
+}
+
+// ListAPITokens returns the tokens of the user, ordered by name.
+func (db *DB) ListAPITokens(userID int64) ([]*APIToken, error) {
+	tokens := []*APIToken{}
+	err := db.ex.Select(&tokens, "SELECT * FROM api_tokens WHERE user_id = $1 ORDER BY name", userID)
+	return tokens, err
+}
+
+// FindAPIToken searches the token in the database. It does not check whether
+// the token is expired.
+func (db *DB) FindAPIToken(token string) (*APIToken, error) {
+	var t APIToken
+	err := db.ex.SelectOne(&t, "SELECT * FROM api_tokens WHERE token_hash = $1", hashAPIToken(token))
+	if err != nil {
+		return nil, err
+	}
+
+	return &t, nil
+}
+
+// TouchAPIToken records that the token with the id has been used.
+func (db *DB) TouchAPIToken(id int64) error {
+	_, err := db.ex.Exec("UPDATE api_tokens SET last_used_at = $1 WHERE id = $2", time.Now(), id)
+	return err
+}
+
+// DeleteAPIToken revokes the token with the id of the user.
+func (db *DB) DeleteAPIToken(userID, id int64) error {
+	res, err := db.ex.Exec("DELETE FROM api_tokens WHERE id = $1 AND user_id = $2", id, userID)
+	if err != nil {
+		return err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if n != 1 {
+		return errors.New("token not found")
+	}
+
+	return nil
+}
@@ -0,0 +1,129 @@
+package db
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+func TestAPIToken(t *testing.T) {
+	tok, err := NewAPIToken(1, "sync", true, pq.NullTime{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !IsAPIToken(tok.Token) || len(tok.Token) != len(APITokenPrefix)+2*tokenLength {
+		t.Fatalf("invalid token %q", tok.Token)
+	}
+
+	if tok.TokenHash != hashAPIToken(tok.Token) || strings.Contains(tok.TokenHash, tok.Token) {
+		t.Fatalf("invalid hash %q for token %q", tok.TokenHash, tok.Token)
+	}
+
+	if err = tok.Validate(); err != nil {
+		t.Fatalf("token is invalid: %v", err)
+	}
+
+	buf, err := json.Marshal(tok)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(string(buf), tok.Token) {
+		t.Errorf("new token not included in JSON: %s", buf)
+	}
+
+	tok.Token = ""
+	buf, err = json.Marshal(tok)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if strings.Contains(string(buf), `"token"`) || strings.Contains(string(buf), tok.TokenHash) {
+		t.Errorf("token or hash included in JSON: %s", buf)
+	}
+
+	if IsAPIToken(strings.Repeat("a", 2*tokenLength)) {
+		t.Errorf("session token detected as API token")
+	}
+}
+
+func TestAPITokenExpiry(t *testing.T) {
+	now := time.Now()
+
+	tok, err := NewAPIToken(1, "sync", false, pq.NullTime{Time: now.Add(-time.Hour), Valid: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = tok.Validate(); err == nil {
+		t.Errorf("token with expiry in the past is valid")
+	}
+
+	if !tok.Expired(now) {
+		t.Errorf("token is not expired")
+	}
+
+	tok.ExpiresAt = pq.NullTime{}
+	if tok.Expired(now) {
+		t.Errorf("token without expiry is expired")
+	}
+
+	tok.Name = ""
+	if err = tok.Validate(); err == nil {
+		t.Errorf("token without name is valid")
+	}
+}
+
+func TestAPITokenSave(t *testing.T) {
+	u, err := testDB.FindUserName("user")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tok, err := NewAPIToken(u.ID, "test", false, pq.NullTime{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = testDB.InsertAPIToken(tok); err != nil {
+		t.Fatal(err)
+	}
+
+	found, err := testDB.FindAPIToken(tok.Token)
+	if err != nil {
+		t.Fatalf("unable to find token: %v", err)
+	}
+
+	if found.ID != tok.ID || found.Token != "" || found.LastUsedAt.Valid {
+		t.Fatalf("wrong token found: %v", found)
+	}
+
+	if err = testDB.TouchAPIToken(tok.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	tokens, err := testDB.ListAPITokens(u.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(tokens) != 1 || !tokens[0].LastUsedAt.Valid {
+		t.Fatalf("wrong tokens listed: %v", tokens)
+	}
+
+	if err = testDB.DeleteAPIToken(u.ID+1, tok.ID); err == nil {
+		t.Errorf("token of another user was removed")
+	}
+
+	if err = testDB.DeleteAPIToken(u.ID, tok.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = testDB.FindAPIToken(tok.Token); err == nil {
+		t.Errorf("revoked token still found")
+	}
+}
@@ -0,0 +1,153 @@
+package db
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// Actions recorded in the audit log in addition to ActionCreate,
+// ActionUpdate and ActionDelete.
+const (
+	AuditLogin       = "login"
+	AuditLoginFailed = "login_failed"
+	AuditLogout      = "logout"
+)
+
+// AuditSession is the entity of audit entries for logins and logouts.
+const AuditSession = "session"
+
+// AuditEntry records a change made through the API or a login. UserID is the
+// ID of the user who made the change, for failed logins it is NULL and Login
+// is the name which was tried. BeforeVersion is the version of the record the
+// client based the change on, AfterVersion the version after the change.
+type AuditEntry struct {
+	ID        int64
+	CreatedAt time.Time
+
+	UserID        sql.NullInt64
+	Login         string
+	Action        string
+	Entity        string
+	EntityID      sql.NullInt64
+	BeforeVersion sql.NullInt64
+	AfterVersion  sql.NullInt64
+
+	RemoteAddr string
+	UserAgent  string
+}
+
+// AuditEntryJSON is the JSON representation of an AuditEntry.
+type AuditEntryJSON struct {
+	ID        int64  `json:"id"`
+	CreatedAt string `json:"created_at"`
+
+	UserID        *int64 `json:"user_id"`
+	Login         string `json:"login"`
+	Action        string `json:"action"`
+	Entity        string `json:"entity"`
+	EntityID      *int64 `json:"entity_id"`
+	BeforeVersion *int64 `json:"before_version"`
+	AfterVersion  *int64 `json:"after_version"`
+
+	RemoteAddr string `json:"remote_addr"`
+	UserAgent  string `json:"user_agent"`
+}
+
+// MarshalJSON returns the JSON representation of e.
+func (e AuditEntry) MarshalJSON() ([]byte, error) {
+	return json.Marshal(AuditEntryJSON{
+		ID:            e.ID,
+		CreatedAt:     e.CreatedAt.Format(timeLayout),
+		UserID:        nullInt64JSON(e.UserID),
+		Login:         e.Login,
+		Action:        e.Action,
+		Entity:        e.Entity,
+		EntityID:      nullInt64JSON(e.EntityID),
+		BeforeVersion: nullInt64JSON(e.BeforeVersion),
+		AfterVersion:  nullInt64JSON(e.AfterVersion),
+		RemoteAddr:    e.RemoteAddr,
+		UserAgent:     e.UserAgent,
+	})
+}
+
+// UnmarshalJSON returns an audit entry from JSON.
+func (e *AuditEntry) UnmarshalJSON(data []byte) error {
+	var je AuditEntryJSON
+	if err := json.Unmarshal(data, &je); err != nil {
+		return err
+	}
+
+	createdAt, err := time.Parse(timeLayout, je.CreatedAt)
+	if err != nil {
+		return err
+	}
+
+	*e = AuditEntry{
+		ID:            je.ID,
+		CreatedAt:     createdAt,
+		UserID:        jsonNullInt64(je.UserID),
+		Login:         je.Login,
+		Action:        je.Action,
+		Entity:        je.Entity,
+		EntityID:      jsonNullInt64(je.EntityID),
+		BeforeVersion: jsonNullInt64(je.BeforeVersion),
+		AfterVersion:  jsonNullInt64(je.AfterVersion),
+		RemoteAddr:    je.RemoteAddr,
+		UserAgent:     je.UserAgent,
+	}
+
+	return nil
+}
+
+func (e AuditEntry) String() string {
+	return fmt.Sprintf("<AuditEntry[%v] %v %v %v %v>", e.ID, e.Login, e.Action, e.Entity, nullInt64JSON(e.EntityID))
+}
+
+// InsertAuditEntry saves a new entry in the audit log.
+func (db *DB) InsertAuditEntry(e *AuditEntry) error {
+	return db.ex.Insert(e)
+}
+
+// auditList describes how the table audit_log can be listed. Entries can be
+// filtered by the user's login with `user` and by the time range with `since`
+// and `until`.
+var auditList = listTable{
+	name: "audit_log",
+	id:   func(item interface{}) int64 { return item.(*AuditEntry).ID },
+	sort: map[string]listColumn{
+		"id":         {func(item interface{}) string { return strconv.FormatInt(item.(*AuditEntry).ID, 10) }, parseInt},
+		"created_at": {func(item interface{}) string { return formatTime(item.(*AuditEntry).CreatedAt) }, parseTimestamp},
+	},
+	filter: map[string]listColumn{
+		"user_id":   {parse: parseInt},
+		"action":    {parse: parseString},
+		"entity":    {parse: parseString},
+		"entity_id": {parse: parseInt},
+	},
+	conditions: map[string]listCondition{
+		"user":  {"login", "=", parseString},
+		"since": {"created_at", ">=", parseTimestamp},
+		"until": {"created_at", "<", parseTimestamp},
+	},
+}
+
+// ListAuditEntriesPage returns a part of the audit log according to opts.
+// Invalid options are reported as a ListError.
+func (db *DB) ListAuditEntriesPage(opts ListOptions) ([]*AuditEntry, Page, error) {
+	entries := []*AuditEntry{}
+	page, err := db.list(auditList, opts, &entries,
+		func() int { return len(entries) },
+		func(i int) interface{} { return entries[i] })
+	if err != nil {
+		return nil, Page{}, err
+	}
+
+	if len(entries) > opts.Limit {
+		entries = entries[:opts.Limit]
+	}
+
+	return entries, page, nil
+}
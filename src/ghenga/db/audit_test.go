@@ -0,0 +1,57 @@
+package db
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+)
+
+func TestAuditLog(t *testing.T) {
+	start := time.Now().Add(-time.Second)
+
+	entries := []*AuditEntry{
+		{Login: "audit", Action: AuditLoginFailed, Entity: AuditSession},
+		{Login: "audit", UserID: sql.NullInt64{Int64: 1, Valid: true}, Action: ActionUpdate, Entity: RevisionPerson,
+			EntityID: sql.NullInt64{Int64: 23, Valid: true}, BeforeVersion: sql.NullInt64{Int64: 1, Valid: true},
+			AfterVersion: sql.NullInt64{Int64: 2, Valid: true}, RemoteAddr: "127.0.0.1", UserAgent: "test"},
+	}
+
+	for _, e := range entries {
+		e.CreatedAt = time.Now()
+		if err := testDB.InsertAuditEntry(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	list, page, err := testDB.ListAuditEntriesPage(ListOptions{
+		Limit: 10,
+		Filter: map[string]string{
+			"user":   "audit",
+			"entity": RevisionPerson,
+			"since":  start.Format(time.RFC3339Nano),
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if page.Total != 1 || len(list) != 1 || list[0].ID != entries[1].ID {
+		t.Fatalf("wrong entries returned: %v", list)
+	}
+
+	if e := list[0]; e.AfterVersion.Int64 != 2 || e.RemoteAddr != "127.0.0.1" || e.UserAgent != "test" {
+		t.Fatalf("entry was not saved correctly: %+v", e)
+	}
+
+	list, _, err = testDB.ListAuditEntriesPage(ListOptions{
+		Limit:  10,
+		Filter: map[string]string{"user": "audit", "until": start.Format(time.RFC3339Nano)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(list) != 0 {
+		t.Fatalf("entries before the start returned: %v", list)
+	}
+}
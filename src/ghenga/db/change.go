@@ -0,0 +1,244 @@
+package db
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// Actions for a Change.
+const (
+	ActionCreate = "create"
+	ActionUpdate = "update"
+	ActionDelete = "delete"
+)
+
+// Change describes a person or a user which was created, updated or deleted.
+// For a deleted record, Version is the last version. Remote is set for
+// changes made by another ghenga instance using the same database.
+type Change struct {
+	Action    string
+	Entity    string
+	ID        int64
+	Version   int64
+	ChangedBy sql.NullInt64
+	ChangedAt time.Time
+	Remote    bool
+
+	// OwnerID and Visibility are set for deleted people, so that the change
+	// is only reported to the users who could see the person, see CanSee.
+	// They are not part of the JSON representation.
+	OwnerID    sql.NullInt64
+	Visibility string
+}
+
+// ChangeJSON is the JSON representation of a Change.
+type ChangeJSON struct {
+	Action    string `json:"action"`
+	Entity    string `json:"entity"`
+	ID        int64  `json:"id"`
+	Version   int64  `json:"version"`
+	ChangedBy *int64 `json:"changed_by"`
+	ChangedAt string `json:"changed_at"`
+}
+
+// MarshalJSON returns the JSON representation of c.
+func (c Change) MarshalJSON() ([]byte, error) {
+	return json.Marshal(ChangeJSON{
+		Action:    c.Action,
+		Entity:    c.Entity,
+		ID:        c.ID,
+		Version:   c.Version,
+		ChangedBy: nullInt64JSON(c.ChangedBy),
+		ChangedAt: c.ChangedAt.Format(timeLayout),
+	})
+}
+
+// UnmarshalJSON returns a change from JSON.
+func (c *Change) UnmarshalJSON(data []byte) error {
+	var jc ChangeJSON
+	if err := json.Unmarshal(data, &jc); err != nil {
+		return err
+	}
+
+	changedAt, err := time.Parse(timeLayout, jc.ChangedAt)
+	if err != nil {
+		return err
+	}
+
+	*c = Change{
+		Action:    jc.Action,
+		Entity:    jc.Entity,
+		ID:        jc.ID,
+		Version:   jc.Version,
+		ChangedBy: jsonNullInt64(jc.ChangedBy),
+		ChangedAt: changedAt,
+	}
+
+	return nil
+}
+
+// Event returns the name of the change, e.g. "person.update".
+func (c Change) Event() string {
+	return c.Entity + "." + c.Action
+}
+
+func (c Change) String() string {
+	return fmt.Sprintf("<Change %v %v version %v>", c.Event(), c.ID, c.Version)
+}
+
+// observers is the list of functions which are called for changes.
+type observers struct {
+	m   sync.Mutex
+	id  int
+	fns map[int]func(Change)
+}
+
+func (o *observers) add(fn func(Change)) (remove func()) {
+	o.m.Lock()
+	defer o.m.Unlock()
+
+	if o.fns == nil {
+		o.fns = make(map[int]func(Change))
+	}
+
+	o.id++
+	id := o.id
+	o.fns[id] = fn
+
+	return func() {
+		o.m.Lock()
+		defer o.m.Unlock()
+		delete(o.fns, id)
+	}
+}
+
+func (o *observers) notify(changes []Change) {
+	o.m.Lock()
+	fns := make([]func(Change), 0, len(o.fns))
+	for _, fn := range o.fns {
+		fns = append(fns, fn)
+	}
+	o.m.Unlock()
+
+	for _, c := range changes {
+		for _, fn := range fns {
+			fn(c)
+		}
+	}
+}
+
+// OnChange registers fn to be called for each person or user which is
+// created, updated or deleted. Within a transaction, fn is called after the
+// transaction has been committed, changes which are rolled back are not
+// reported. Changes made by other instances are reported once they are
+// received with HandleNotification. fn is called synchronously and must not
+// block. The returned function removes fn again.
+func (db *DB) OnChange(fn func(Change)) (remove func()) {
+	return db.observers.add(fn)
+}
+
+// ChangeChannel is the PostgreSQL channel on which committed changes are
+// published with NOTIFY, so that other ghenga instances using the same
+// database are notified.
+const ChangeChannel = "ghenga_changes"
+
+// changeNotification is the payload of a notification on ChangeChannel.
+type changeNotification struct {
+	Instance   string `json:"instance"`
+	Change     Change `json:"change"`
+	OwnerID    *int64 `json:"owner_id,omitempty"`
+	Visibility string `json:"visibility,omitempty"`
+}
+
+// newInstanceID returns a random ID for a DB, it is used to recognize the
+// notifications sent by the DB itself.
+func newInstanceID() (string, error) {
+	buf := make([]byte, 8)
+	if _, err := io.ReadFull(rand.Reader, buf); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(buf), nil
+}
+
+// changed records a change, see record.
+func (db *DB) changed(action, entity string, id, version int64, changedBy sql.NullInt64) error {
+	return db.record(Change{
+		Action:    action,
+		Entity:    entity,
+		ID:        id,
+		Version:   version,
+		ChangedBy: changedBy,
+		ChangedAt: time.Now(),
+	})
+}
+
+// personDeleted records the deletion of the person p, the owner and the
+// visibility are saved in the change.
+func (db *DB) personDeleted(p deletedPerson, changedBy sql.NullInt64) error {
+	return db.record(Change{
+		Action:     ActionDelete,
+		Entity:     RevisionPerson,
+		ID:         p.ID,
+		Version:    p.Version,
+		ChangedBy:  changedBy,
+		ChangedAt:  time.Now(),
+		OwnerID:    p.OwnerID,
+		Visibility: p.Visibility,
+	})
+}
+
+// record records the change c. Within a transaction, the change is reported
+// when the transaction is committed. The change is also published on
+// ChangeChannel, PostgreSQL delivers the notification only when the
+// transaction is committed.
+func (db *DB) record(c Change) error {
+	buf, err := json.Marshal(changeNotification{
+		Instance:   db.instance,
+		Change:     c,
+		OwnerID:    nullInt64JSON(c.OwnerID),
+		Visibility: c.Visibility,
+	})
+	if err != nil {
+		return err
+	}
+
+	if _, err = db.ex.Exec("SELECT pg_notify($1, $2)", ChangeChannel, string(buf)); err != nil {
+		return err
+	}
+
+	if db.changes != nil {
+		*db.changes = append(*db.changes, c)
+		return nil
+	}
+
+	db.observers.notify([]Change{c})
+	return nil
+}
+
+// HandleNotification reports a change received on ChangeChannel to the
+// observers registered with OnChange. Changes made by db itself have already
+// been reported and are ignored.
+func (db *DB) HandleNotification(payload string) error {
+	var n changeNotification
+	if err := json.Unmarshal([]byte(payload), &n); err != nil {
+		return err
+	}
+
+	if n.Instance == db.instance {
+		return nil
+	}
+
+	c := n.Change
+	c.Remote = true
+	c.OwnerID = jsonNullInt64(n.OwnerID)
+	c.Visibility = n.Visibility
+	db.observers.notify([]Change{c})
+	return nil
+}
@@ -0,0 +1,124 @@
+package db
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/lib/pq"
+	"golang.org/x/net/context"
+)
+
+func TestOnChange(t *testing.T) {
+	var changes []Change
+	remove := testDB.OnChange(func(c Change) {
+		changes = append(changes, c)
+	})
+	defer remove()
+
+	p := NewPerson("Change Test")
+	errTest := errors.New("test error")
+	err := testDB.Tx(context.Background(), func(tx *Tx) error {
+		if err := tx.InsertPerson(p); err != nil {
+			return err
+		}
+
+		if len(changes) != 0 {
+			t.Errorf("observer was called before commit: %v", changes)
+		}
+
+		return errTest
+	})
+	if err != errTest {
+		t.Fatalf("wrong error returned: %v", err)
+	}
+
+	if len(changes) != 0 {
+		t.Fatalf("observer was called for rolled back changes: %v", changes)
+	}
+
+	changedBy := sql.NullInt64{Int64: 1, Valid: true}
+	p = NewPerson("Change Test")
+	p.ChangedBy = changedBy
+	if err = testDB.InsertPerson(p); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = testDB.DeletePerson(p.ID, changedBy); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(changes) != 2 {
+		t.Fatalf("want 2 changes, got %v", changes)
+	}
+
+	for i, action := range []string{ActionCreate, ActionDelete} {
+		c := changes[i]
+		if c.Action != action || c.Entity != RevisionPerson || c.ID != p.ID || c.Version != 1 || c.ChangedBy != changedBy {
+			t.Errorf("change %d is wrong: %+v", i, c)
+		}
+	}
+}
+
+func TestHandleNotification(t *testing.T) {
+	db := &DB{observers: &observers{}, instance: "a"}
+
+	var changes []Change
+	db.OnChange(func(c Change) {
+		changes = append(changes, c)
+	})
+
+	c := Change{Action: ActionUpdate, Entity: RevisionUser, ID: 5, Version: 2, ChangedAt: time.Now()}
+	for _, instance := range []string{"a", "b"} {
+		buf, err := json.Marshal(changeNotification{Instance: instance, Change: c})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err = db.HandleNotification(string(buf)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if len(changes) != 1 {
+		t.Fatalf("want one change from the other instance, got %v", changes)
+	}
+
+	if changes[0].ID != c.ID || changes[0].Version != c.Version || changes[0].Event() != "user.update" || !changes[0].Remote {
+		t.Fatalf("wrong change reported: %v", changes[0])
+	}
+
+	if err := db.HandleNotification("foo"); err == nil {
+		t.Fatalf("expected error for invalid payload")
+	}
+}
+
+func TestChangeNotify(t *testing.T) {
+	l := pq.NewListener(TestDataSource(), time.Second, time.Minute, nil)
+	defer l.Close()
+
+	if err := l.Listen(ChangeChannel); err != nil {
+		t.Fatal(err)
+	}
+
+	p := NewPerson("Change Notify Test")
+	if err := testDB.InsertPerson(p); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case n := <-l.Notify:
+		var cn changeNotification
+		if err := json.Unmarshal([]byte(n.Extra), &cn); err != nil {
+			t.Fatal(err)
+		}
+
+		if cn.Instance != testDB.instance || cn.Change.ID != p.ID || cn.Change.Action != ActionCreate {
+			t.Fatalf("wrong notification received: %s", n.Extra)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timeout waiting for notification")
+	}
+}
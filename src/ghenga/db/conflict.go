@@ -0,0 +1,150 @@
+package db
+
+import (
+	"encoding/json"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// FieldConflict describes a field which was changed both by a client and on
+// the server, to different values. Fields of nested objects are named with a
+// dot, e.g. "address.city". The values are null if the field is not set, Base
+// is null if the version the client started from is unknown.
+type FieldConflict struct {
+	Field  string          `json:"field"`
+	Base   json.RawMessage `json:"base"`
+	Client json.RawMessage `json:"client"`
+	Server json.RawMessage `json:"server"`
+}
+
+// mergeIgnoreFields are fields which are managed by the server, for them the
+// value of the server is always used.
+var mergeIgnoreFields = map[string]bool{
+	"id":         true,
+	"version":    true,
+	"changed_at": true,
+	"created_at": true,
+	"changed_by": true,
+	"created_by": true,
+}
+
+// unflattenJSON is the inverse of flattenJSON, it returns the nested JSON
+// object for the fields.
+func unflattenJSON(fields map[string]interface{}) map[string]interface{} {
+	obj := make(map[string]interface{})
+	for name, v := range fields {
+		parts := strings.Split(name, ".")
+		cur := obj
+		for _, p := range parts[:len(parts)-1] {
+			sub, ok := cur[p].(map[string]interface{})
+			if !ok {
+				sub = make(map[string]interface{})
+				cur[p] = sub
+			}
+			cur = sub
+		}
+
+		cur[parts[len(parts)-1]] = v
+	}
+
+	return obj
+}
+
+// MergeJSON does a three-way merge of JSON objects. base is the version the
+// client started from, client the version the client submitted, and server
+// the current version on the server. All fields changed only by the client
+// are taken from client, all other fields from server. Lists are treated as
+// single values.
+//
+// If a field was changed by both the client and on the server to different
+// values, the conflicts are returned and the merged object is nil. If base is
+// nil, all fields which differ between client and server are conflicts.
+func MergeJSON(base, client, server []byte) (merged []byte, conflicts []FieldConflict, err error) {
+	var baseFields map[string]interface{}
+	if base != nil {
+		if baseFields, err = decodeFields(string(base)); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	clientFields, err := decodeFields(string(client))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	serverFields, err := decodeFields(string(server))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	names := make(map[string]struct{})
+	for _, fields := range []map[string]interface{}{baseFields, clientFields, serverFields} {
+		for k := range fields {
+			names[k] = struct{}{}
+		}
+	}
+
+	var sorted []string
+	for k := range names {
+		sorted = append(sorted, k)
+	}
+	sort.Strings(sorted)
+
+	equal := func(a, b map[string]interface{}, k string) bool {
+		va, aOK := a[k]
+		vb, bOK := b[k]
+		return aOK == bOK && reflect.DeepEqual(va, vb)
+	}
+
+	result := make(map[string]interface{})
+	conflicts = []FieldConflict{}
+	for _, k := range sorted {
+		if mergeIgnoreFields[k] {
+			if v, ok := serverFields[k]; ok {
+				result[k] = v
+			}
+			continue
+		}
+
+		fields := serverFields
+		if base == nil || !equal(baseFields, clientFields, k) {
+			// the client changed the field
+			fields = clientFields
+
+			if !equal(clientFields, serverFields, k) && (base == nil || !equal(baseFields, serverFields, k)) {
+				c := FieldConflict{Field: k}
+				b, bOK := baseFields[k]
+				if c.Base, err = rawJSON(b, bOK && base != nil); err != nil {
+					return nil, nil, err
+				}
+				v, ok := clientFields[k]
+				if c.Client, err = rawJSON(v, ok); err != nil {
+					return nil, nil, err
+				}
+				v, ok = serverFields[k]
+				if c.Server, err = rawJSON(v, ok); err != nil {
+					return nil, nil, err
+				}
+
+				conflicts = append(conflicts, c)
+				continue
+			}
+		}
+
+		if v, ok := fields[k]; ok {
+			result[k] = v
+		}
+	}
+
+	if len(conflicts) > 0 {
+		return nil, conflicts, nil
+	}
+
+	merged, err = json.Marshal(unflattenJSON(result))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return merged, conflicts, nil
+}
@@ -0,0 +1,105 @@
+package db
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+var mergeJSONTests = []struct {
+	base, client, server string
+	merged               string
+	conflicts            []string
+}{
+	// non-overlapping changes
+	{
+		`{"id": 1, "version": 1, "name": "a", "title": "b", "address": {"city": "c", "street": "d"}}`,
+		`{"id": 1, "version": 1, "name": "x", "title": "b", "address": {"city": "c", "street": "d"}}`,
+		`{"id": 1, "version": 2, "name": "a", "title": "y", "address": {"city": "z", "street": "d"}}`,
+		`{"id": 1, "version": 2, "name": "x", "title": "y", "address": {"city": "z", "street": "d"}}`,
+		nil,
+	},
+	// the same change on both sides, removed and added fields
+	{
+		`{"name": "a", "title": "b", "phone_numbers": [1, 2]}`,
+		`{"name": "x", "phone_numbers": [1, 2], "comment": "foo"}`,
+		`{"name": "x", "title": "b", "phone_numbers": [1, 2, 3]}`,
+		`{"name": "x", "phone_numbers": [1, 2, 3], "comment": "foo"}`,
+		nil,
+	},
+	// conflicting changes
+	{
+		`{"name": "a", "title": "b", "address": {"city": "c"}}`,
+		`{"name": "x", "title": "b", "address": {"city": "y"}}`,
+		`{"name": "z", "title": "w", "address": {"city": "y"}}`,
+		``,
+		[]string{"name"},
+	},
+	// unknown base
+	{
+		``,
+		`{"version": 1, "name": "x", "title": "b", "comment": "c"}`,
+		`{"version": 2, "name": "z", "title": "b"}`,
+		``,
+		[]string{"comment", "name"},
+	},
+}
+
+func TestMergeJSON(t *testing.T) {
+	for i, test := range mergeJSONTests {
+		var base []byte
+		if test.base != "" {
+			base = []byte(test.base)
+		}
+
+		merged, conflicts, err := MergeJSON(base, []byte(test.client), []byte(test.server))
+		if err != nil {
+			t.Errorf("test %d: MergeJSON returned error: %v", i, err)
+			continue
+		}
+
+		var fields []string
+		for _, c := range conflicts {
+			fields = append(fields, c.Field)
+		}
+
+		if !reflect.DeepEqual(fields, test.conflicts) {
+			t.Errorf("test %d: wrong conflicts, want %v, got %v", i, test.conflicts, fields)
+			continue
+		}
+
+		if test.merged == "" {
+			if merged != nil {
+				t.Errorf("test %d: merged object returned despite conflicts: %s", i, merged)
+			}
+			continue
+		}
+
+		var got, want interface{}
+		if err = json.Unmarshal(merged, &got); err != nil {
+			t.Fatal(err)
+		}
+		if err = json.Unmarshal([]byte(test.merged), &want); err != nil {
+			t.Fatal(err)
+		}
+
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("test %d: wrong merge result:\nwant: %s\n got: %s", i, test.merged, merged)
+		}
+	}
+}
+
+func TestMergeJSONConflictValues(t *testing.T) {
+	_, conflicts, err := MergeJSON([]byte(`{"name": "a"}`), []byte(`{"name": "b"}`), []byte(`{}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []FieldConflict{
+		{Field: "name", Base: json.RawMessage(`"a"`), Client: json.RawMessage(`"b"`), Server: json.RawMessage(`null`)},
+	}
+
+	if !reflect.DeepEqual(conflicts, want) {
+		t.Fatalf("wrong conflicts, want %v, got %v", want, conflicts)
+	}
+}
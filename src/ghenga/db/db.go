@@ -3,6 +3,7 @@ package db
 
 import (
 	"database/sql"
+	"errors"
 	"fmt"
 	"log"
 	"os"
@@ -14,6 +15,7 @@ import (
 	"github.com/jmoiron/modl"
 	"github.com/jmoiron/sqlx"
 	"github.com/rubenv/modl-migrate"
+	"golang.org/x/net/context"
 )
 
 const dialect = "postgres"
@@ -46,6 +48,150 @@ func findMigrationsDir() (dir string, err error) {
 // DB is a storage database for ghenga.
 type DB struct {
 	dbmap *modl.DbMap
+
+	// ex runs the queries, it is either dbmap or the transaction tx.
+	ex modl.SqlExecutor
+	tx *modl.Transaction
+
+	// observers are notified about changes, within a transaction the
+	// changes are collected until it is committed.
+	observers *observers
+	changes   *[]Change
+
+	// instance identifies the notifications on ChangeChannel sent by this
+	// DB.
+	instance string
+
+	// viewer is the user for whom people are selected, if set only the
+	// people visible to the user are returned. See VisibleTo.
+	viewer *User
+}
+
+// Tx is a database transaction. All methods of DB can be called on a Tx,
+// they are run within the transaction.
+type Tx struct {
+	*DB
+}
+
+// Tx runs fn within a transaction. The transaction is committed if fn returns
+// nil, otherwise it is rolled back and the error is returned. It is also
+// rolled back if fn panics or ctx is cancelled before the transaction is
+// committed. When called on a Tx, fn is run within the existing transaction.
+func (db *DB) Tx(ctx context.Context, fn func(tx *Tx) error) (err error) {
+	if db.tx != nil {
+		return fn(&Tx{db})
+	}
+
+	t, err := db.dbmap.Begin()
+	if err != nil {
+		return err
+	}
+
+	committed := false
+	defer func() {
+		if !committed {
+			_ = t.Rollback()
+		}
+	}()
+
+	var changes []Change
+	txdb := &DB{dbmap: db.dbmap, ex: t, tx: t, observers: db.observers, changes: &changes, instance: db.instance, viewer: db.viewer}
+	if err = fn(&Tx{txdb}); err != nil {
+		return err
+	}
+
+	if err = ctx.Err(); err != nil {
+		return err
+	}
+
+	committed = true
+	if err = t.Commit(); err != nil {
+		return err
+	}
+
+	db.observers.notify(changes)
+	return nil
+}
+
+// atomic runs fn within a transaction, so that changes to several tables
+// are either all applied or not at all.
+func (db *DB) atomic(fn func(tx *Tx) error) error {
+	return db.Tx(context.Background(), fn)
+}
+
+// Savepoint runs fn within a savepoint of the transaction. If fn returns an
+// error, all changes made by fn are rolled back and the error is returned,
+// but the transaction can still be used and committed.
+func (tx *Tx) Savepoint(fn func() error) (err error) {
+	if _, err = tx.ex.Exec("SAVEPOINT ghenga_savepoint"); err != nil {
+		return err
+	}
+
+	n := len(*tx.changes)
+
+	released := false
+	defer func() {
+		if !released {
+			_, _ = tx.ex.Exec("ROLLBACK TO SAVEPOINT ghenga_savepoint")
+			*tx.changes = (*tx.changes)[:n]
+		}
+	}()
+
+	if err = fn(); err != nil {
+		return err
+	}
+
+	released = true
+	_, err = tx.ex.Exec("RELEASE SAVEPOINT ghenga_savepoint")
+	return err
+}
+
+// ErrVersionMismatch is returned when a record is updated or deleted, but the
+// version does not match the version in the database, because the record was
+// changed in the meantime.
+var ErrVersionMismatch = errors.New("version does not match")
+
+// versionError converts an optimistic locking error returned by modl into
+// ErrVersionMismatch.
+func versionError(err error) error {
+	if _, ok := err.(modl.OptimisticLockError); ok {
+		return ErrVersionMismatch
+	}
+
+	return err
+}
+
+// deletedVersion checks the result of a DELETE statement which only removes
+// the record with the given ID if the version matches. If nothing was removed,
+// ErrVersionMismatch is returned if the record still exists.
+func (db *DB) deletedVersion(res sql.Result, table, name string, id int64) error {
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if n == 1 {
+		return nil
+	}
+
+	return db.notDeleted(table, name, id)
+}
+
+// notDeleted returns the error for a record which was not removed by a DELETE
+// statement which checks the version: ErrVersionMismatch if the record still
+// exists, an error that the record was not found otherwise.
+func (db *DB) notDeleted(table, name string, id int64) error {
+	var count int64
+	err := db.ex.SelectOne(&count, "SELECT count(*) FROM "+table+" WHERE id = $1", id)
+	if err != nil {
+		return err
+	}
+
+	if count > 0 {
+		return ErrVersionMismatch
+	}
+
+	return fmt.Errorf("%s not found", name)
 }
 
 // configDBMap creates a new mapping on the given database and creates the
@@ -54,8 +200,21 @@ func configDBMap(db *sql.DB) (*modl.DbMap, error) {
 	dbmap := modl.NewDbMap(db, modl.PostgresDialect{})
 	dbmap.AddTableWithName(Person{}, "people").SetKeys(true, "id")
 	dbmap.AddTableWithName(PhoneNumber{}, "phone_numbers").SetKeys(true, "id")
+	dbmap.AddTableWithName(EmailAddress{}, "email_addresses").SetKeys(true, "id")
+	dbmap.AddTableWithName(Account{}, "accounts").SetKeys(true, "id")
+	dbmap.AddTableWithName(Task{}, "tasks").SetKeys(true, "id")
+	dbmap.AddTableWithName(Activity{}, "activities").SetKeys(true, "id")
+	dbmap.AddTableWithName(Event{}, "events").SetKeys(true, "id")
+	dbmap.AddTableWithName(CalendarToken{}, "calendar_tokens").SetKeys(false, "token")
 	dbmap.AddTableWithName(User{}, "users").SetKeys(true, "id")
+	dbmap.AddTableWithName(Role{}, "roles").SetKeys(true, "id")
+	dbmap.AddTableWithName(Team{}, "teams").SetKeys(true, "id")
 	dbmap.AddTableWithName(Session{}, "sessions").SetKeys(false, "token")
+	dbmap.AddTableWithName(APIToken{}, "api_tokens").SetKeys(true, "id")
+	dbmap.AddTableWithName(OIDCLogin{}, "oidc_logins").SetKeys(false, "state")
+	dbmap.AddTableWithName(Webhook{}, "webhooks").SetKeys(true, "id")
+	dbmap.AddTableWithName(WebhookDelivery{}, "webhook_deliveries").SetKeys(true, "id")
+	dbmap.AddTableWithName(AuditEntry{}, "audit_log").SetKeys(true, "id")
 
 	return dbmap, nil
 }
@@ -87,7 +246,12 @@ func Init(dataSource string) (*DB, error) {
 		return nil, err
 	}
 
-	return &DB{dbmap}, nil
+	instance, err := newInstanceID()
+	if err != nil {
+		return nil, err
+	}
+
+	return &DB{dbmap: dbmap, ex: dbmap, observers: &observers{}, instance: instance}, nil
 }
 
 // migrateDB applies migrations according to the files in the subdir
@@ -1,8 +1,11 @@
 package db
 
 import (
+	"errors"
 	"os"
 	"testing"
+
+	"golang.org/x/net/context"
 )
 
 var testDB *DB
@@ -14,3 +17,121 @@ func TestMain(m *testing.M) {
 	cleanup()
 	os.Exit(res)
 }
+
+func TestTxCommit(t *testing.T) {
+	p := NewPerson("Tx Commit")
+	task := NewTask("call back", 0)
+
+	err := testDB.Tx(context.Background(), func(tx *Tx) error {
+		if err := tx.InsertPerson(p); err != nil {
+			return err
+		}
+
+		task.PersonID = p.ID
+		return tx.InsertTask(task)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	findPerson(t, testDB, p.ID)
+	if _, err = testDB.FindTask(task.ID); err != nil {
+		t.Fatalf("task was not saved: %v", err)
+	}
+}
+
+func TestTxRollback(t *testing.T) {
+	p := NewPerson("Tx Rollback")
+	p.PhoneNumbers = PhoneNumbers{{Type: "work", Number: "0221 12345"}}
+
+	errTest := errors.New("test error")
+	err := testDB.Tx(context.Background(), func(tx *Tx) error {
+		if err := tx.InsertPerson(p); err != nil {
+			return err
+		}
+
+		// nested calls use the same transaction
+		return tx.Tx(context.Background(), func(tx *Tx) error {
+			if _, err := tx.FindPerson(p.ID); err != nil {
+				return err
+			}
+
+			return errTest
+		})
+	})
+	if err != errTest {
+		t.Fatalf("wrong error returned: %v", err)
+	}
+
+	if _, err = testDB.FindPerson(p.ID); err == nil {
+		t.Fatalf("person was saved despite rollback")
+	}
+
+	var n int64
+	if err = testDB.ex.SelectOne(&n, "SELECT count(*) FROM phone_numbers WHERE person_id = $1", p.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	if n != 0 {
+		t.Fatalf("phone numbers were saved despite rollback")
+	}
+}
+
+func TestTxCancel(t *testing.T) {
+	p := NewPerson("Tx Cancel")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	err := testDB.Tx(ctx, func(tx *Tx) error {
+		if err := tx.InsertPerson(p); err != nil {
+			return err
+		}
+
+		cancel()
+		return nil
+	})
+	if err != context.Canceled {
+		t.Fatalf("wrong error returned: %v", err)
+	}
+
+	if _, err = testDB.FindPerson(p.ID); err == nil {
+		t.Fatalf("person was saved despite cancelled context")
+	}
+}
+
+func TestTxSavepoint(t *testing.T) {
+	keep := NewPerson("Tx Savepoint Keep")
+	discard := NewPerson("Tx Savepoint Discard")
+
+	errTest := errors.New("test error")
+	err := testDB.Tx(context.Background(), func(tx *Tx) error {
+		err := tx.Savepoint(func() error {
+			if err := tx.InsertPerson(discard); err != nil {
+				return err
+			}
+
+			return errTest
+		})
+		if err != errTest {
+			t.Errorf("wrong error returned: %v", err)
+		}
+
+		// the transaction can still be used after a failed statement
+		err = tx.Savepoint(func() error {
+			_, err := tx.ex.Exec("SELECT * FROM no_such_table")
+			return err
+		})
+		if err == nil {
+			t.Errorf("expected error for invalid statement")
+		}
+
+		return tx.InsertPerson(keep)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	findPerson(t, testDB, keep.ID)
+	if _, err = testDB.FindPerson(discard.ID); err == nil {
+		t.Fatalf("person was saved despite rollback to savepoint")
+	}
+}
@@ -0,0 +1,479 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// colognePhoneticReplacer maps German special characters to the base letters
+// before the phonetic code is computed.
+var colognePhoneticReplacer = strings.NewReplacer(
+	"Ä", "A", "Ö", "O", "Ü", "U", "ß", "S",
+	"À", "A", "Á", "A", "Â", "A", "É", "E", "È", "E", "Ê", "E",
+	"Ç", "C", "Ñ", "N",
+)
+
+func runeIn(r rune, set string) bool {
+	return r != 0 && strings.ContainsRune(set, r)
+}
+
+// colognePhonetic returns the phonetic code of the word s according to the
+// "Kölner Phonetik", which is suited for German names. Similar sounding names
+// like "Meyer" and "Maier" result in the same code.
+func colognePhonetic(s string) string {
+	s = colognePhoneticReplacer.Replace(strings.ToUpper(s))
+
+	var letters []rune
+	for _, r := range s {
+		if r >= 'A' && r <= 'Z' {
+			letters = append(letters, r)
+		}
+	}
+
+	var codes []byte
+	for i, r := range letters {
+		var prev, next rune
+		if i > 0 {
+			prev = letters[i-1]
+		}
+		if i < len(letters)-1 {
+			next = letters[i+1]
+		}
+
+		var code string
+		switch r {
+		case 'A', 'E', 'I', 'J', 'O', 'U', 'Y':
+			code = "0"
+		case 'H':
+			code = ""
+		case 'B':
+			code = "1"
+		case 'P':
+			if next == 'H' {
+				code = "3"
+			} else {
+				code = "1"
+			}
+		case 'D', 'T':
+			if runeIn(next, "CSZ") {
+				code = "8"
+			} else {
+				code = "2"
+			}
+		case 'F', 'V', 'W':
+			code = "3"
+		case 'G', 'K', 'Q':
+			code = "4"
+		case 'C':
+			switch {
+			case i == 0 && runeIn(next, "AHKLOQRUX"):
+				code = "4"
+			case i > 0 && !runeIn(prev, "SZ") && runeIn(next, "AHKOQUX"):
+				code = "4"
+			default:
+				code = "8"
+			}
+		case 'X':
+			if runeIn(prev, "CKQ") {
+				code = "8"
+			} else {
+				code = "48"
+			}
+		case 'L':
+			code = "5"
+		case 'M', 'N':
+			code = "6"
+		case 'R':
+			code = "7"
+		case 'S', 'Z':
+			code = "8"
+		}
+
+		codes = append(codes, code...)
+	}
+
+	// collapse repeated codes and remove all zeroes except at the beginning
+	var res []byte
+	for i, c := range codes {
+		if i > 0 && codes[i-1] == c {
+			continue
+		}
+
+		if c == '0' && i > 0 {
+			continue
+		}
+
+		res = append(res, c)
+	}
+
+	return string(res)
+}
+
+// nameWords splits a name into lower case words, punctuation is removed.
+func nameWords(name string) []string {
+	return strings.FieldsFunc(strings.ToLower(name), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// normalizeName returns the name in lower case with the words sorted, so that
+// "Drees, Mario" and "mario drees" are considered equal.
+func normalizeName(name string) string {
+	words := nameWords(name)
+	sort.Strings(words)
+	return strings.Join(words, " ")
+}
+
+// phoneticName returns the phonetic codes for all words of the name, sorted.
+func phoneticName(name string) string {
+	var codes []string
+	for _, word := range nameWords(name) {
+		if code := colognePhonetic(word); code != "" {
+			codes = append(codes, code)
+		}
+	}
+
+	sort.Strings(codes)
+	return strings.Join(codes, " ")
+}
+
+// defaultCountryCode is the country code assumed for phone numbers without
+// an international prefix.
+const defaultCountryCode = "49"
+
+// normalizePhoneNumber returns the phone number with all formatting removed
+// and the international prefix for the default country replaced by a zero,
+// so that "+49 (221) 123-45" and "0221 12345" are considered equal.
+func normalizePhoneNumber(num string) string {
+	num = strings.TrimSpace(num)
+
+	var digits []rune
+	for i, r := range num {
+		switch {
+		case r >= '0' && r <= '9':
+			digits = append(digits, r)
+		case r == '+' && i == 0:
+			digits = append(digits, '0', '0')
+		}
+	}
+
+	s := string(digits)
+	if strings.HasPrefix(s, "00"+defaultCountryCode) {
+		s = "0" + strings.TrimPrefix(s, "00"+defaultCountryCode)
+	}
+
+	return s
+}
+
+// Reasons why two people are considered duplicates.
+const (
+	DuplicateName         = "name"
+	DuplicatePhoneticName = "phonetic_name"
+	DuplicateEmail        = "email"
+	DuplicatePhone        = "phone"
+)
+
+// duplicateScores are the scores added for each reason, the sum is capped at 1.
+var duplicateScores = map[string]float64{
+	DuplicateName:         0.6,
+	DuplicatePhoneticName: 0.4,
+	DuplicateEmail:        0.5,
+	DuplicatePhone:        0.3,
+}
+
+// DuplicatePair is a pair of people which are probably duplicates. The score
+// is between 0 and 1, higher values mean that the records are more likely
+// to describe the same person.
+type DuplicatePair struct {
+	PersonIDs [2]int64 `json:"person_ids"`
+	Score     float64  `json:"score"`
+	Reasons   []string `json:"reasons"`
+}
+
+func (d DuplicatePair) String() string {
+	return fmt.Sprintf("<DuplicatePair %v, %v (%.2f)>", d.PersonIDs[0], d.PersonIDs[1], d.Score)
+}
+
+// duplicateKeys returns the keys for the person, people with equal keys are
+// duplicate candidates.
+func duplicateKeys(p *Person) map[string][]string {
+	keys := make(map[string][]string)
+
+	if name := normalizeName(p.Name); name != "" {
+		keys[DuplicateName] = append(keys[DuplicateName], name)
+	}
+
+	if code := phoneticName(p.Name); code != "" {
+		keys[DuplicatePhoneticName] = append(keys[DuplicatePhoneticName], code)
+	}
+
+	for _, addr := range p.EmailAddresses {
+		if a := strings.ToLower(strings.TrimSpace(addr.Address)); a != "" {
+			keys[DuplicateEmail] = append(keys[DuplicateEmail], a)
+		}
+	}
+
+	for _, num := range p.PhoneNumbers {
+		// very short numbers like extensions are not meaningful
+		if n := normalizePhoneNumber(num.Number); len(n) >= 6 {
+			keys[DuplicatePhone] = append(keys[DuplicatePhone], n)
+		}
+	}
+
+	return keys
+}
+
+// findDuplicates returns the pairs of people which have a score of at least
+// minScore, the highest scores first.
+func findDuplicates(people []*Person, minScore float64) []DuplicatePair {
+	type pairKey [2]int64
+
+	// index people by their keys, so that not all pairs must be compared
+	index := make(map[string]map[string][]int64)
+	for _, p := range people {
+		for reason, keys := range duplicateKeys(p) {
+			if index[reason] == nil {
+				index[reason] = make(map[string][]int64)
+			}
+
+			seen := make(map[string]bool)
+			for _, k := range keys {
+				if seen[k] {
+					continue
+				}
+				seen[k] = true
+				index[reason][k] = append(index[reason][k], p.ID)
+			}
+		}
+	}
+
+	reasons := make(map[pairKey]map[string]bool)
+	for reason, keys := range index {
+		for _, ids := range keys {
+			for i := 0; i < len(ids); i++ {
+				for j := i + 1; j < len(ids); j++ {
+					pk := pairKey{ids[i], ids[j]}
+					if pk[0] > pk[1] {
+						pk[0], pk[1] = pk[1], pk[0]
+					}
+
+					if reasons[pk] == nil {
+						reasons[pk] = make(map[string]bool)
+					}
+					reasons[pk][reason] = true
+				}
+			}
+		}
+	}
+
+	pairs := []DuplicatePair{}
+	for pk, rs := range reasons {
+		// the phonetic match is implied by an equal name
+		if rs[DuplicateName] {
+			delete(rs, DuplicatePhoneticName)
+		}
+
+		d := DuplicatePair{PersonIDs: pk}
+		for reason := range rs {
+			d.Score += duplicateScores[reason]
+			d.Reasons = append(d.Reasons, reason)
+		}
+		sort.Strings(d.Reasons)
+
+		if d.Score > 1 {
+			d.Score = 1
+		}
+
+		if d.Score >= minScore {
+			pairs = append(pairs, d)
+		}
+	}
+
+	sort.Slice(pairs, func(i, j int) bool {
+		if pairs[i].Score != pairs[j].Score {
+			return pairs[i].Score > pairs[j].Score
+		}
+
+		if pairs[i].PersonIDs[0] != pairs[j].PersonIDs[0] {
+			return pairs[i].PersonIDs[0] < pairs[j].PersonIDs[0]
+		}
+
+		return pairs[i].PersonIDs[1] < pairs[j].PersonIDs[1]
+	})
+
+	return pairs
+}
+
+// FindDuplicatePeople returns pairs of people which are probably duplicates,
+// with a score of at least minScore. The highest scores are returned first.
+func (db *DB) FindDuplicatePeople(minScore float64) ([]DuplicatePair, error) {
+	people, err := db.ListPeople()
+	if err != nil {
+		return nil, err
+	}
+
+	return findDuplicates(people, minScore), nil
+}
+
+// Fields of a person which can be preferred from the removed record when two
+// people are merged.
+const (
+	MergeName       = "name"
+	MergeTitle      = "title"
+	MergeDepartment = "department"
+	MergeAddress    = "address"
+	MergeComment    = "comment"
+	MergeAccount    = "account_id"
+)
+
+// mergeString returns the value for a merged field: other is used if it is
+// preferred or if value is empty.
+func mergeString(value, other string, prefer bool) string {
+	if other != "" && (prefer || value == "") {
+		return other
+	}
+
+	return value
+}
+
+// mergePeople merges the data of remove into keep. For each field, the value
+// of keep is used unless it is empty or the field is contained in prefer.
+// Comments are concatenated, phone numbers and email addresses are combined.
+// The phone numbers and email addresses of remove keep their IDs, so that
+// saving keep moves them over.
+func mergePeople(keep, remove *Person, prefer map[string]bool) {
+	keep.Name = mergeString(keep.Name, remove.Name, prefer[MergeName])
+	keep.Title = mergeString(keep.Title, remove.Title, prefer[MergeTitle])
+	keep.Department = mergeString(keep.Department, remove.Department, prefer[MergeDepartment])
+
+	hasAddress := func(p *Person) bool {
+		return p.Street != "" || p.PostalCode != "" || p.State != "" || p.City != "" || p.Country != ""
+	}
+
+	if hasAddress(remove) && (prefer[MergeAddress] || !hasAddress(keep)) {
+		keep.Street = remove.Street
+		keep.PostalCode = remove.PostalCode
+		keep.State = remove.State
+		keep.City = remove.City
+		keep.Country = remove.Country
+	}
+
+	switch {
+	case prefer[MergeComment]:
+		keep.Comment = mergeString(keep.Comment, remove.Comment, true)
+	case keep.Comment == "":
+		keep.Comment = remove.Comment
+	case remove.Comment != "" && remove.Comment != keep.Comment:
+		keep.Comment = keep.Comment + "\n\n" + remove.Comment
+	}
+
+	if remove.AccountID.Valid && (prefer[MergeAccount] || !keep.AccountID.Valid) {
+		keep.AccountID = remove.AccountID
+	}
+
+	numbers := make(map[string]bool)
+	for _, num := range keep.PhoneNumbers {
+		numbers[normalizePhoneNumber(num.Number)] = true
+	}
+
+	for _, num := range remove.PhoneNumbers {
+		n := normalizePhoneNumber(num.Number)
+		if numbers[n] {
+			continue
+		}
+		numbers[n] = true
+
+		keep.PhoneNumbers = append(keep.PhoneNumbers, num)
+	}
+
+	addrs := make(map[string]bool)
+	for _, addr := range keep.EmailAddresses {
+		addrs[strings.ToLower(addr.Address)] = true
+	}
+
+	for _, addr := range remove.EmailAddresses {
+		a := strings.ToLower(addr.Address)
+		if addrs[a] {
+			continue
+		}
+		addrs[a] = true
+
+		if len(keep.EmailAddresses) > 0 {
+			addr.Primary = false
+		}
+		keep.EmailAddresses = append(keep.EmailAddresses, addr)
+	}
+	keep.EmailAddresses.setDefaultPrimary()
+}
+
+// MergePeople merges the person removeID into the person keepID and returns
+// the merged person. Fields are merged as described for mergePeople, the
+// names of the fields in prefer are taken from the removed person. Tasks,
+// activities and event participations of the removed person are moved to the
+// kept person, then the removed person is deleted. All changes are done in
+// one transaction.
+func (db *DB) MergePeople(keepID, removeID int64, prefer []string, changedBy sql.NullInt64) (*Person, error) {
+	if keepID == removeID {
+		return nil, errors.New("cannot merge a person with itself")
+	}
+
+	var keep, remove Person
+	err := db.atomic(func(tx *Tx) error {
+		if err := tx.ex.SelectOne(&keep, "SELECT * FROM people WHERE id = $1 FOR UPDATE", keepID); err != nil {
+			return err
+		}
+
+		if err := tx.ex.SelectOne(&remove, "SELECT * FROM people WHERE id = $1 FOR UPDATE", removeID); err != nil {
+			return err
+		}
+
+		preferFields := make(map[string]bool)
+		for _, f := range prefer {
+			preferFields[f] = true
+		}
+
+		mergePeople(&keep, &remove, preferFields)
+		keep.ChangedAt = time.Now()
+		keep.ChangedBy = changedBy
+
+		if _, err := tx.ex.Update(&keep); err != nil {
+			return err
+		}
+
+		if err := tx.changed(ActionUpdate, RevisionPerson, keep.ID, keep.Version, changedBy); err != nil {
+			return err
+		}
+
+		for _, query := range []string{
+			"UPDATE tasks SET person_id = $1 WHERE person_id = $2",
+			"UPDATE activities SET person_id = $1 WHERE person_id = $2",
+			`UPDATE event_people SET person_id = $1 WHERE person_id = $2
+				AND event_id NOT IN (SELECT event_id FROM event_people WHERE person_id = $1)`,
+		} {
+			if _, err := tx.ex.Exec(query, keepID, removeID); err != nil {
+				return err
+			}
+		}
+
+		if _, err := tx.ex.Exec("DELETE FROM people WHERE id = $1", removeID); err != nil {
+			return err
+		}
+
+		return tx.personDeleted(deletedPerson{
+			ID:         remove.ID,
+			Version:    remove.Version,
+			OwnerID:    remove.OwnerID,
+			Visibility: remove.Visibility,
+		}, changedBy)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &keep, nil
+}
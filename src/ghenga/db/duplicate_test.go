@@ -0,0 +1,196 @@
+package db
+
+import (
+	"database/sql"
+	"reflect"
+	"testing"
+	"time"
+)
+
+var colognePhoneticTests = []struct {
+	word, code string
+}{
+	{"Wikipedia", "3412"},
+	{"Müller-Lüdenscheidt", "65752682"},
+	{"Breschnew", "17863"},
+	{"Meyer", "67"},
+	{"Maier", "67"},
+	{"Mayr", "67"},
+	{"Schmidt", "862"},
+	{"Schmitt", "862"},
+	{"Christoph", "47823"},
+	{"Xaver", "4837"},
+	{"", ""},
+}
+
+func TestColognePhonetic(t *testing.T) {
+	for i, test := range colognePhoneticTests {
+		code := colognePhonetic(test.word)
+		if code != test.code {
+			t.Errorf("test %d: colognePhonetic(%q) returned %q, want %q", i, test.word, code, test.code)
+		}
+	}
+}
+
+var normalizePhoneNumberTests = []struct {
+	number, normalized string
+}{
+	{"+49 (221) 123-45", "022112345"},
+	{"0221 12345", "022112345"},
+	{"0049 221 12345", "022112345"},
+	{"+1 555 1234", "0015551234"},
+	{"2134", "2134"},
+}
+
+func TestNormalizePhoneNumber(t *testing.T) {
+	for i, test := range normalizePhoneNumberTests {
+		n := normalizePhoneNumber(test.number)
+		if n != test.normalized {
+			t.Errorf("test %d: normalizePhoneNumber(%q) returned %q, want %q", i, test.number, n, test.normalized)
+		}
+	}
+}
+
+var duplicateTestPeople = []*Person{
+	{ID: 1, Name: "Mario Drees"},
+	{ID: 2, Name: "Drees, Mario"},
+	{ID: 3, Name: "Tamara Meyer", PhoneNumbers: PhoneNumbers{{Number: "+49 221 1231234"}}},
+	{ID: 4, Name: "Tamara Maier", PhoneNumbers: PhoneNumbers{{Number: "0221/1231234"}}},
+	{ID: 5, Name: "Bela Freigang", EmailAddresses: EmailAddresses{{Address: "Bela@Herweg.com"}}},
+	{ID: 6, Name: "B. Freigang", EmailAddresses: EmailAddresses{{Address: "bela@herweg.com"}}},
+	{ID: 7, Name: "Tamara Skibicki"},
+}
+
+func TestFindDuplicates(t *testing.T) {
+	pairs := findDuplicates(duplicateTestPeople, 0.5)
+
+	want := []DuplicatePair{
+		{PersonIDs: [2]int64{3, 4}, Score: 0.7, Reasons: []string{DuplicatePhone, DuplicatePhoneticName}},
+		{PersonIDs: [2]int64{1, 2}, Score: 0.6, Reasons: []string{DuplicateName}},
+		{PersonIDs: [2]int64{5, 6}, Score: 0.5, Reasons: []string{DuplicateEmail}},
+	}
+
+	if len(pairs) != len(want) {
+		t.Fatalf("wrong number of pairs returned, want %d, got %d: %v", len(want), len(pairs), pairs)
+	}
+
+	for i := range want {
+		if pairs[i].PersonIDs != want[i].PersonIDs || !reflect.DeepEqual(pairs[i].Reasons, want[i].Reasons) {
+			t.Errorf("pair %d: want %v %v, got %v %v", i, want[i], want[i].Reasons, pairs[i], pairs[i].Reasons)
+		}
+
+		if d := pairs[i].Score - want[i].Score; d > 0.001 || d < -0.001 {
+			t.Errorf("pair %d: wrong score, want %v, got %v", i, want[i].Score, pairs[i].Score)
+		}
+	}
+}
+
+func TestMergePeopleFields(t *testing.T) {
+	keep := &Person{
+		ID:      1,
+		Name:    "Mario Drees",
+		Comment: "first",
+		PhoneNumbers: PhoneNumbers{
+			{ID: 10, Type: "work", Number: "0221 12345"},
+		},
+		EmailAddresses: EmailAddresses{
+			{ID: 20, Type: "work", Address: "mario@example.com", Primary: true},
+		},
+	}
+
+	remove := &Person{
+		ID:         2,
+		Name:       "Drees, Mario",
+		Title:      "CEO",
+		City:       "Köln",
+		Comment:    "second",
+		AccountID:  sql.NullInt64{Int64: 5, Valid: true},
+		Department: "Sales",
+		PhoneNumbers: PhoneNumbers{
+			{ID: 11, Type: "work", Number: "+49 221 12345"},
+			{ID: 12, Type: "mobile", Number: "0157 1234567"},
+		},
+		EmailAddresses: EmailAddresses{
+			{ID: 21, Type: "private", Address: "mario@private.example.com", Primary: true},
+		},
+	}
+
+	mergePeople(keep, remove, map[string]bool{MergeDepartment: true})
+
+	if keep.Name != "Mario Drees" || keep.Title != "CEO" || keep.City != "Köln" || keep.Department != "Sales" {
+		t.Errorf("fields not merged correctly: %+v", keep)
+	}
+
+	if keep.Comment != "first\n\nsecond" {
+		t.Errorf("comments not merged correctly: %q", keep.Comment)
+	}
+
+	if !keep.AccountID.Valid || keep.AccountID.Int64 != 5 {
+		t.Errorf("account not merged: %v", keep.AccountID)
+	}
+
+	if len(keep.PhoneNumbers) != 2 || keep.PhoneNumbers[1].ID != 12 {
+		t.Errorf("phone numbers not combined correctly: %v", keep.PhoneNumbers)
+	}
+
+	if len(keep.EmailAddresses) != 2 || keep.EmailAddresses.Primary() != "mario@example.com" || keep.EmailAddresses[1].Primary {
+		t.Errorf("email addresses not combined correctly: %v", keep.EmailAddresses)
+	}
+}
+
+func TestMergePeople(t *testing.T) {
+	p1 := NewPerson("Merge Test")
+	p1.PhoneNumbers = PhoneNumbers{{Type: "work", Number: "0221 12345"}}
+	p1.CreatedAt = time.Now().Add(-time.Hour)
+	p1.ChangedAt = p1.CreatedAt
+	if err := testDB.InsertPerson(p1); err != nil {
+		t.Fatal(err)
+	}
+
+	p2 := NewPerson("Test, Merge")
+	p2.Title = "CTO"
+	p2.PhoneNumbers = PhoneNumbers{
+		{Type: "work", Number: "+49 221 12345"},
+		{Type: "mobile", Number: "0157 1234567"},
+	}
+	if err := testDB.InsertPerson(p2); err != nil {
+		t.Fatal(err)
+	}
+
+	task := NewTask("call back", p2.ID)
+	if err := testDB.InsertTask(task); err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now().Add(-time.Second)
+	p, err := testDB.MergePeople(p1.ID, p2.ID, nil, sql.NullInt64{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if p.Title != "CTO" || len(p.PhoneNumbers) != 2 {
+		t.Fatalf("person not merged correctly: %v", p)
+	}
+
+	if _, err = testDB.FindPerson(p2.ID); err == nil {
+		t.Fatalf("removed person still exists")
+	}
+
+	p = findPerson(t, testDB, p1.ID)
+	if len(p.PhoneNumbers) != 2 {
+		t.Fatalf("phone numbers not moved, got %v", p.PhoneNumbers)
+	}
+
+	if p.ChangedAt.Before(start) {
+		t.Errorf("modification time of the merged person not updated: %v", p.ChangedAt)
+	}
+
+	task, err = testDB.FindTask(task.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if task.PersonID != p1.ID {
+		t.Fatalf("task was not moved to the kept person, person ID is %v", task.PersonID)
+	}
+}
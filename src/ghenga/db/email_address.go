@@ -0,0 +1,200 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/jmoiron/modl"
+)
+
+// EmailAddress is an email address of a specified type. At most one of the
+// email addresses of a person is the primary address.
+type EmailAddress struct {
+	ID       int64
+	Address  string
+	Type     string
+	Primary  bool
+	PersonID int64
+}
+
+// EmailAddressJSON is the JSON representation of an email address.
+type EmailAddressJSON struct {
+	Type    string `json:"type"`
+	Address string `json:"address"`
+	Primary bool   `json:"primary"`
+}
+
+func (e EmailAddress) String() string {
+	if e.Primary {
+		return fmt.Sprintf("<EmailAddress [%v, primary] %v>", e.Type, e.Address)
+	}
+
+	return fmt.Sprintf("<EmailAddress [%v] %v>", e.Type, e.Address)
+}
+
+// EmailAddresses is a collection of email addresses.
+type EmailAddresses []EmailAddress
+
+// Equals returns true iff other contains exactly the same email addresses,
+// types and primary flags.
+func (e EmailAddresses) Equals(other EmailAddresses) bool {
+	found := make(map[EmailAddress]bool)
+
+	for _, addr := range e {
+		found[EmailAddress{Address: addr.Address, Type: addr.Type, Primary: addr.Primary}] = false
+	}
+
+	for _, addr := range other {
+		id := EmailAddress{Address: addr.Address, Type: addr.Type, Primary: addr.Primary}
+		if _, ok := found[id]; !ok {
+			return false
+		}
+		found[id] = true
+	}
+
+	for _, v := range found {
+		if !v {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Primary returns the primary email address. If none of the addresses is
+// marked as primary, the first one is returned. If there are no email
+// addresses at all, the empty string is returned.
+func (e EmailAddresses) Primary() string {
+	for _, addr := range e {
+		if addr.Primary {
+			return addr.Address
+		}
+	}
+
+	if len(e) > 0 {
+		return e[0].Address
+	}
+
+	return ""
+}
+
+// setDefaultPrimary marks the first address as primary if none of the
+// addresses is marked as primary.
+func (e EmailAddresses) setDefaultPrimary() {
+	for _, addr := range e {
+		if addr.Primary {
+			return
+		}
+	}
+
+	if len(e) > 0 {
+		e[0].Primary = true
+	}
+}
+
+// Validate checks that all addresses look like email addresses and that at
+// most one address is marked as primary.
+func (e EmailAddresses) Validate() error {
+	primary := 0
+	for _, addr := range e {
+		if !strings.Contains(addr.Address, "@") {
+			return fmt.Errorf("invalid email address %q", addr.Address)
+		}
+
+		if addr.Primary {
+			primary++
+		}
+	}
+
+	if primary > 1 {
+		return errors.New("more than one primary email address")
+	}
+
+	return nil
+}
+
+// JSON returns the JSON representation of the email addresses. It never
+// returns nil, so that an empty list is encoded as [].
+func (e EmailAddresses) JSON() []EmailAddressJSON {
+	list := []EmailAddressJSON{}
+	for _, addr := range e {
+		list = append(list, EmailAddressJSON{
+			Type:    addr.Type,
+			Address: addr.Address,
+			Primary: addr.Primary,
+		})
+	}
+
+	return list
+}
+
+// emailAddressesFromJSON converts the JSON representation to a list of email
+// addresses.
+func emailAddressesFromJSON(list []EmailAddressJSON) EmailAddresses {
+	var addrs EmailAddresses
+	for _, addr := range list {
+		addrs = append(addrs, EmailAddress{
+			Type:    addr.Type,
+			Address: addr.Address,
+			Primary: addr.Primary,
+		})
+	}
+
+	return addrs
+}
+
+// insertEmailAddresses saves the email addresses for the person.
+func insertEmailAddresses(db modl.SqlExecutor, personID int64, addrs EmailAddresses) error {
+	for _, addr := range addrs {
+		addr.PersonID = personID
+		err := db.Insert(&addr)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// selectEmailAddresses loads all email addresses for the person, the primary
+// address first.
+func selectEmailAddresses(db modl.SqlExecutor, personID int64, addrs *EmailAddresses) error {
+	return db.Select(addrs, `SELECT * FROM email_addresses WHERE person_id = $1 ORDER BY "primary" DESC, id`, personID)
+}
+
+// updateEmailAddresses updates the email addresses for the person. Email
+// addresses not contained in addrs are removed.
+func updateEmailAddresses(db modl.SqlExecutor, personID int64, addrs EmailAddresses) error {
+	var ids []int64
+	for _, addr := range addrs {
+		addr.PersonID = personID
+		var err error
+		if addr.ID != 0 {
+			_, err = db.Update(&addr)
+		} else {
+			err = db.Insert(&addr)
+		}
+
+		if err != nil {
+			return err
+		}
+
+		ids = append(ids, addr.ID)
+	}
+
+	if len(ids) > 0 {
+		// remove excess email addresses
+		query, args, err := in("DELETE FROM email_addresses WHERE person_id = ? AND id NOT IN (?)", personID, ids)
+		if err != nil {
+			return err
+		}
+
+		_, err = db.Exec(query, args...)
+		return err
+	}
+
+	// else remove all email addresses
+	_, err := db.Exec("DELETE FROM email_addresses WHERE person_id = $1", personID)
+	return err
+}
@@ -0,0 +1,381 @@
+package db
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/jmoiron/modl"
+)
+
+// Event is an appointment with participants, which may be people and users.
+type Event struct {
+	ID          int64
+	Title       string
+	Description string
+	Location    string
+
+	StartAt time.Time
+	EndAt   time.Time
+
+	PersonIDs []int64 `db:"-"`
+	UserIDs   []int64 `db:"-"`
+
+	ChangedAt time.Time
+	CreatedAt time.Time
+	Version   int64
+}
+
+// EventJSON is the JSON representation of an Event as returned or consumed by
+// the API.
+type EventJSON struct {
+	ID          int64  `json:"id,omitempty"`
+	Title       string `json:"title,omitempty"`
+	Description string `json:"description,omitempty"`
+	Location    string `json:"location,omitempty"`
+
+	Start string `json:"start,omitempty"`
+	End   string `json:"end,omitempty"`
+
+	Participants ParticipantsJSON `json:"participants"`
+
+	ChangedAt string `json:"changed_at,omitempty"`
+	CreatedAt string `json:"created_at,omitempty"`
+
+	Version int64 `json:"version"`
+}
+
+// ParticipantsJSON is the JSON representation of the participants of an
+// event.
+type ParticipantsJSON struct {
+	People []int64 `json:"people"`
+	Users  []int64 `json:"users"`
+}
+
+// NewEvent returns a new event record.
+func NewEvent(title string, start, end time.Time) *Event {
+	ts := time.Now()
+	return &Event{
+		Title:     title,
+		StartAt:   start,
+		EndAt:     end,
+		CreatedAt: ts,
+		ChangedAt: ts,
+	}
+}
+
+// MarshalJSON returns the JSON representation of e.
+func (e Event) MarshalJSON() ([]byte, error) {
+	je := EventJSON{
+		ID:          e.ID,
+		Title:       e.Title,
+		Description: e.Description,
+		Location:    e.Location,
+
+		Start: e.StartAt.Format(timeLayout),
+		End:   e.EndAt.Format(timeLayout),
+
+		Participants: ParticipantsJSON{
+			People: e.PersonIDs,
+			Users:  e.UserIDs,
+		},
+
+		ChangedAt: e.ChangedAt.Format(timeLayout),
+		CreatedAt: e.CreatedAt.Format(timeLayout),
+		Version:   e.Version,
+	}
+
+	if je.Participants.People == nil {
+		je.Participants.People = []int64{}
+	}
+
+	if je.Participants.Users == nil {
+		je.Participants.Users = []int64{}
+	}
+
+	return json.Marshal(je)
+}
+
+// UnmarshalJSON returns an event from JSON.
+func (e *Event) UnmarshalJSON(data []byte) error {
+	var je EventJSON
+
+	err := json.Unmarshal(data, &je)
+	if err != nil {
+		return err
+	}
+
+	createdAt, err := time.Parse(timeLayout, je.CreatedAt)
+	if err != nil {
+		return err
+	}
+
+	changedAt, err := time.Parse(timeLayout, je.ChangedAt)
+	if err != nil {
+		return err
+	}
+
+	*e = Event{
+		ID:        je.ID,
+		CreatedAt: createdAt,
+		ChangedAt: changedAt,
+	}
+
+	return e.Update(je)
+}
+
+// Validate checks if e is valid and returns an error if not.
+func (e *Event) Validate() error {
+	if e.Title == "" {
+		return errors.New("title is empty")
+	}
+
+	if e.StartAt.IsZero() || e.EndAt.IsZero() {
+		return errors.New("start or end is not set")
+	}
+
+	if e.EndAt.Before(e.StartAt) {
+		return errors.New("event ends before it starts")
+	}
+
+	if e.CreatedAt.IsZero() || e.ChangedAt.IsZero() {
+		return errors.New("invalid timestamps")
+	}
+
+	return nil
+}
+
+// Update updates e with the fields from other.
+func (e *Event) Update(other EventJSON) error {
+	start, err := time.Parse(timeLayout, other.Start)
+	if err != nil {
+		return err
+	}
+
+	end, err := time.Parse(timeLayout, other.End)
+	if err != nil {
+		return err
+	}
+
+	e.Title = other.Title
+	e.Description = other.Description
+	e.Location = other.Location
+
+	// timestamps are saved without time zone, so store them as UTC
+	e.StartAt = start.UTC()
+	e.EndAt = end.UTC()
+
+	e.PersonIDs = other.Participants.People
+	e.UserIDs = other.Participants.Users
+
+	e.Version = other.Version
+
+	return nil
+}
+
+func (e Event) String() string {
+	return fmt.Sprintf("<Event[%v] (%v, %v)>", e.ID, e.Title, e.StartAt)
+}
+
+// saveParticipants replaces the participants of the event in the database.
+func (e *Event) saveParticipants(db modl.SqlExecutor) error {
+	if _, err := db.Exec("DELETE FROM event_people WHERE event_id = $1", e.ID); err != nil {
+		return err
+	}
+
+	if _, err := db.Exec("DELETE FROM event_users WHERE event_id = $1", e.ID); err != nil {
+		return err
+	}
+
+	for _, id := range e.PersonIDs {
+		_, err := db.Exec("INSERT INTO event_people (event_id, person_id) VALUES ($1, $2)", e.ID, id)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, id := range e.UserIDs {
+		_, err := db.Exec("INSERT INTO event_users (event_id, user_id) VALUES ($1, $2)", e.ID, id)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// PostInsert is run after an event is saved into the database. It is used to
+// save the participants of the event.
+func (e *Event) PostInsert(db modl.SqlExecutor) error {
+	return e.saveParticipants(db)
+}
+
+// PostUpdate is run after an event has been updated. It replaces the
+// participants of the event.
+func (e *Event) PostUpdate(db modl.SqlExecutor) error {
+	return e.saveParticipants(db)
+}
+
+// PostGet loads the participants of the event.
+func (e *Event) PostGet(db modl.SqlExecutor) error {
+	err := db.Select(&e.PersonIDs, "SELECT person_id FROM event_people WHERE event_id = $1 ORDER BY person_id", e.ID)
+	if err != nil {
+		return err
+	}
+
+	return db.Select(&e.UserIDs, "SELECT user_id FROM event_users WHERE event_id = $1 ORDER BY user_id", e.ID)
+}
+
+// FindEvent returns the event with the given id.
+func (db *DB) FindEvent(id int64) (*Event, error) {
+	var e Event
+
+	err := db.ex.SelectOne(&e, "SELECT * FROM events WHERE id = $1", id)
+	if err != nil {
+		return nil, err
+	}
+
+	return &e, nil
+}
+
+// UpdateEvent modifies an existing event. The event and the participants are
+// saved in one transaction.
+func (db *DB) UpdateEvent(e *Event) error {
+	return db.atomic(func(tx *Tx) error {
+		_, err := tx.ex.Update(e)
+		return err
+	})
+}
+
+// InsertEvent creates a new event. The event and the participants are saved
+// in one transaction.
+func (db *DB) InsertEvent(e *Event) error {
+	return db.atomic(func(tx *Tx) error {
+		return tx.ex.Insert(e)
+	})
+}
+
+// ListEvents returns the list of events, ordered by their start.
+func (db *DB) ListEvents() ([]*Event, error) {
+	var events []*Event
+	err := db.ex.Select(&events, "select * from events order by start_at, id")
+	return events, err
+}
+
+// ListUserEvents returns the list of events the user participates in, ordered
+// by their start.
+func (db *DB) ListUserEvents(userID int64) ([]*Event, error) {
+	var events []*Event
+	err := db.ex.Select(&events, `SELECT events.* FROM events
+		JOIN event_users ON event_users.event_id = events.id
+		WHERE event_users.user_id = $1
+		ORDER BY events.start_at, events.id`, userID)
+	return events, err
+}
+
+// DeleteEvent removes an event.
+func (db *DB) DeleteEvent(id int64) error {
+	res, err := db.ex.Exec("delete from events where id = $1", id)
+	if err != nil {
+		return err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if n != 1 {
+		return errors.New("event not found")
+	}
+
+	return nil
+}
+
+// CalendarToken grants access to the calendar feed of a user.
+type CalendarToken struct {
+	Token  string
+	UserID int64
+}
+
+// newCalendarToken returns a new random calendar token for the user.
+func newCalendarToken(userID int64) (*CalendarToken, error) {
+	buf := make([]byte, tokenLength)
+	_, err := io.ReadFull(rand.Reader, buf)
+	if err != nil {
+		return nil, err
+	}
+
+	return &CalendarToken{
+		Token:  hex.EncodeToString(buf),
+		UserID: userID,
+	}, nil
+}
+
+// CalendarToken returns the calendar token for the user. If the user does not
+// have a token yet, a new one is created. When the token is created by
+// concurrent calls, all of them return the same token.
+func (db *DB) CalendarToken(userID int64) (*CalendarToken, error) {
+	var ct CalendarToken
+	err := db.ex.SelectOne(&ct, "SELECT * FROM calendar_tokens WHERE user_id = $1", userID)
+	if err == nil {
+		return &ct, nil
+	}
+
+	if err != sql.ErrNoRows {
+		return nil, err
+	}
+
+	created, err := newCalendarToken(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	// a token created concurrently is kept and read below
+	_, err = db.ex.Exec(`INSERT INTO calendar_tokens (token, user_id) VALUES ($1, $2)
+		ON CONFLICT (user_id) DO NOTHING`, created.Token, created.UserID)
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.ex.SelectOne(&ct, "SELECT * FROM calendar_tokens WHERE user_id = $1", userID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ct, nil
+}
+
+// ResetCalendarToken replaces the calendar token for the user with a new
+// one, so that the old token cannot be used any more. The token is replaced
+// in a single statement, so concurrent calls do not conflict.
+func (db *DB) ResetCalendarToken(userID int64) (*CalendarToken, error) {
+	ct, err := newCalendarToken(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = db.ex.Exec(`INSERT INTO calendar_tokens (token, user_id) VALUES ($1, $2)
+		ON CONFLICT (user_id) DO UPDATE SET token = EXCLUDED.token`, ct.Token, ct.UserID)
+	if err != nil {
+		return nil, err
+	}
+
+	return ct, nil
+}
+
+// FindCalendarToken searches the calendar token in the database.
+func (db *DB) FindCalendarToken(token string) (*CalendarToken, error) {
+	var ct CalendarToken
+	err := db.ex.SelectOne(&ct, "SELECT * FROM calendar_tokens WHERE token = $1", token)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ct, nil
+}
@@ -0,0 +1,211 @@
+package db
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+var testEvents = []struct {
+	name string
+	e    Event
+}{
+	{
+		name: "testevent1",
+		e: Event{
+			Title:       "Lunch",
+			Description: "Discuss the offer",
+			Location:    "Köln",
+			StartAt:     parseTime("2016-05-02T12:00:00+00:00"),
+			EndAt:       parseTime("2016-05-02T13:00:00+00:00"),
+			PersonIDs:   []int64{1, 2},
+			UserIDs:     []int64{1},
+			ChangedAt:   parseTime("2016-04-24T10:30:07+00:00"),
+			CreatedAt:   parseTime("2016-04-24T10:30:07+00:00"),
+			Version:     2,
+		},
+	},
+}
+
+func TestEventMarshal(t *testing.T) {
+	for i, test := range testEvents {
+		buf := marshal(t, test.e)
+
+		golden := filepath.Join("testdata", "TestEventMarshal_"+test.name+".golden")
+		if *update {
+			err := ioutil.WriteFile(golden, buf, 0644)
+			if err != nil {
+				t.Fatalf("test %d: update golden file %v failed: %v", i, golden, err)
+			}
+		}
+
+		expected, err := ioutil.ReadFile(golden)
+		if err != nil {
+			t.Errorf("test %d: unable to read golden file %v", i, golden)
+			continue
+		}
+		if !bytes.Equal(buf, expected) {
+			t.Errorf("test %d (%v) wrong JSON returned:\nwant:\n%s\ngot:\n%s", i, test.name, expected, buf)
+		}
+	}
+}
+
+func TestEventUnmarshal(t *testing.T) {
+	for i, test := range testEvents {
+		golden := filepath.Join("testdata", "TestEventMarshal_"+test.name+".golden")
+		buf, err := ioutil.ReadFile(golden)
+		if err != nil {
+			t.Errorf("test %d: unable to read golden file %v", i, golden)
+			continue
+		}
+
+		var e Event
+		unmarshal(t, buf, &e)
+
+		buf2 := marshal(t, e)
+
+		if !bytes.Equal(buf, buf2) {
+			t.Errorf("test %d (%v) wrong JSON returned:\nwant:\n%s\ngot:\n%s", i, test.name, buf, buf2)
+		}
+	}
+}
+
+func TestEventValidate(t *testing.T) {
+	for i, test := range testEvents {
+		if err := test.e.Validate(); err != nil {
+			t.Errorf("test %v (%v) failed: testEvent is invalid: %v", test.name, i, err)
+		}
+	}
+
+	now := time.Now()
+	e := NewEvent("backwards", now, now.Add(-time.Hour))
+	if err := e.Validate(); err == nil {
+		t.Errorf("event which ends before it starts is valid")
+	}
+}
+
+func TestEventParticipants(t *testing.T) {
+	u, err := testDB.FindUserName("admin")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now().UTC()
+	e := NewEvent("Meeting", now, now.Add(time.Hour))
+	e.PersonIDs = []int64{1, 2}
+	e.UserIDs = []int64{u.ID}
+
+	if err = testDB.InsertEvent(e); err != nil {
+		t.Fatal(err)
+	}
+
+	e2, err := testDB.FindEvent(e.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(e2.PersonIDs) != 2 || len(e2.UserIDs) != 1 {
+		t.Fatalf("wrong participants loaded, want %v/%v, got %v/%v",
+			e.PersonIDs, e.UserIDs, e2.PersonIDs, e2.UserIDs)
+	}
+
+	e2.PersonIDs = []int64{3}
+	if err = testDB.UpdateEvent(e2); err != nil {
+		t.Fatal(err)
+	}
+
+	events, err := testDB.ListUserEvents(u.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(events) != 1 || events[0].ID != e.ID || len(events[0].PersonIDs) != 1 {
+		t.Fatalf("wrong list of events for user returned: %v", events)
+	}
+}
+
+func TestCalendarToken(t *testing.T) {
+	u, err := testDB.FindUserName("user")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ct, err := testDB.CalendarToken(u.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ct2, err := testDB.CalendarToken(u.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if ct.Token != ct2.Token {
+		t.Fatalf("CalendarToken returned a different token for the same user")
+	}
+
+	ct3, err := testDB.ResetCalendarToken(u.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = testDB.FindCalendarToken(ct.Token); err == nil {
+		t.Fatalf("old calendar token still found after reset")
+	}
+
+	found, err := testDB.FindCalendarToken(ct3.Token)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if found.UserID != u.ID {
+		t.Fatalf("calendar token belongs to the wrong user, want %v, got %v", u.ID, found.UserID)
+	}
+}
+
+func TestCalendarTokenConcurrent(t *testing.T) {
+	u, err := NewUser("calendar-concurrent", "geheim")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = testDB.InsertUser(u); err != nil {
+		t.Fatal(err)
+	}
+
+	// the first calls for a user all create a token, only one is kept
+	tokens := make([]*CalendarToken, 5)
+	errs := make([]error, len(tokens))
+
+	var wg sync.WaitGroup
+	for i := range tokens {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			tokens[i], errs[i] = testDB.CalendarToken(u.ID)
+		}(i)
+	}
+	wg.Wait()
+
+	for i, err := range errs {
+		if err != nil {
+			t.Fatalf("call %d: %v", i, err)
+		}
+
+		if tokens[i].Token != tokens[0].Token {
+			t.Fatalf("call %d returned a different token", i)
+		}
+	}
+
+	found, err := testDB.FindCalendarToken(tokens[0].Token)
+	if err != nil {
+		t.Fatalf("returned token not saved: %v", err)
+	}
+
+	if found.UserID != u.ID {
+		t.Fatalf("calendar token belongs to the wrong user, want %v, got %v", u.ID, found.UserID)
+	}
+}
@@ -21,7 +21,15 @@ func NewFakePerson(lang string) (*Person, error) {
 		p.Title = "CEO"
 	}
 	p.Department = "Testers"
-	p.EmailAddress = f.Email()
+	p.EmailAddresses = EmailAddresses{
+		{Type: "work", Address: f.Email(), Primary: true},
+	}
+	if rand.Float32() <= 0.3 {
+		p.EmailAddresses = append(p.EmailAddresses, EmailAddress{
+			Type:    "private",
+			Address: f.FreeEmail(),
+		})
+	}
 
 	for _, d := range []struct {
 		probability float32
@@ -57,6 +65,34 @@ func NewFakePerson(lang string) (*Person, error) {
 	return p, nil
 }
 
+// NewFakeAccount returns an Account struct filled with fake data.
+func NewFakeAccount(lang string) (*Account, error) {
+	f, err := faker.New(lang)
+	if err != nil {
+		return nil, probe.Trace(err, lang)
+	}
+
+	a := NewAccount(f.CompanyName() + " " + f.CompanySuffix())
+	a.Website = f.URL()
+	a.PhoneNumbers = PhoneNumbers{
+		{Type: "switchboard", Number: f.PhoneNumber()},
+	}
+
+	a.BillingStreet = f.StreetAddress()
+	a.BillingPostalCode = f.PostCode()
+	a.BillingCity = f.City()
+	a.BillingCountry = f.Country()
+
+	if rand.Float32() <= 0.5 {
+		a.PhysicalStreet = f.StreetAddress()
+		a.PhysicalPostalCode = f.PostCode()
+		a.PhysicalCity = f.City()
+		a.PhysicalCountry = f.Country()
+	}
+
+	return a, nil
+}
+
 // NewFakeUser returns a User struct filled with fake data. The password is
 // always set to "geheim".
 func NewFakeUser(lang string) (*User, error) {
@@ -69,8 +105,9 @@ func NewFakeUser(lang string) (*User, error) {
 }
 
 // InsertFakeData will populate the db with fake (but realistic) data. Among
-// others, users named "admin" and "user" with the password "geheim" are
-// created.
+// others, users named "admin" (with the role admin) and "user" (with the role
+// manager) with the password "geheim" are created, the other users have the
+// role sales.
 func InsertFakeData(db *DB, people, user int) error {
 	for i := 0; i < people; i++ {
 		p, err := NewFakePerson("de")
@@ -85,15 +122,15 @@ func InsertFakeData(db *DB, people, user int) error {
 	}
 
 	for _, s := range []struct {
-		name  string
-		admin bool
-	}{{"admin", true}, {"user", false}} {
+		name string
+		role string
+	}{{"admin", RoleAdmin}, {"user", "manager"}} {
 		u, err := NewUser(s.name, "geheim")
 		if err != nil {
 			return probe.Trace(err, s.name)
 		}
 
-		u.Admin = s.admin
+		u.SetRoles([]string{s.role})
 		if err := db.InsertUser(u); err != nil {
 			return probe.Trace(err, u)
 		}
@@ -105,6 +142,7 @@ func InsertFakeData(db *DB, people, user int) error {
 			return probe.Trace(err)
 		}
 
+		u.SetRoles([]string{"sales"})
 		err = db.InsertUser(u)
 		if err != nil {
 			// ignore errors for fake data
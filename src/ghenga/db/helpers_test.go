@@ -12,3 +12,14 @@ func TestNewFakePerson(t *testing.T) {
 		t.Fatalf("NewFakePerson() not valid: %v", err)
 	}
 }
+
+func TestNewFakeAccount(t *testing.T) {
+	a, err := NewFakeAccount("de")
+	if err != nil {
+		t.Fatalf("NewFakeAccount(): %v", err)
+	}
+
+	if err = a.Validate(); err != nil {
+		t.Fatalf("NewFakeAccount() not valid: %v", err)
+	}
+}
@@ -0,0 +1,355 @@
+package db
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SortField is a column a list is sorted by.
+type SortField struct {
+	Column     string
+	Descending bool
+}
+
+// ParseSort parses a comma separated list of column names, e.g.
+// `name,-changed_at`. A column prefixed with a minus is sorted in descending
+// order.
+func ParseSort(s string) []SortField {
+	var fields []SortField
+	for _, col := range strings.Split(s, ",") {
+		col = strings.TrimSpace(col)
+		if col == "" {
+			continue
+		}
+
+		f := SortField{Column: col}
+		if strings.HasPrefix(col, "-") {
+			f.Column = col[1:]
+			f.Descending = true
+		}
+
+		fields = append(fields, f)
+	}
+
+	return fields
+}
+
+func (f SortField) String() string {
+	if f.Descending {
+		return "-" + f.Column
+	}
+
+	return f.Column
+}
+
+// ListOptions control which part of a list is returned. The list is sorted by
+// the columns in Sort, and finally by the ID. Filter maps column names to
+// values, only items for which all columns are equal to the values are
+// returned, names which are not a filter of the list are ignored. At most
+// Limit items are returned, starting after the item the Cursor points to.
+// Limit must be positive.
+type ListOptions struct {
+	Limit  int
+	Cursor string
+	Sort   []SortField
+	Filter map[string]string
+}
+
+// Page describes a part of a list. Total is the number of items in the list
+// (matching the filters), NextCursor is empty if there are no more items.
+type Page struct {
+	Total      int64
+	NextCursor string
+}
+
+// ListError is returned for invalid list options.
+type ListError struct {
+	Msg string
+}
+
+func (e ListError) Error() string {
+	return e.Msg
+}
+
+// listColumn is a column which can be used for sorting and filtering a list.
+// value returns the value of the column for an item as a string, parse
+// converts such a string back into a value suitable as a query parameter.
+type listColumn struct {
+	value func(item interface{}) string
+	parse func(string) (interface{}, error)
+}
+
+func parseString(s string) (interface{}, error) {
+	return s, nil
+}
+
+func parseInt(s string) (interface{}, error) {
+	return strconv.ParseInt(s, 10, 64)
+}
+
+func parseBool(s string) (interface{}, error) {
+	return strconv.ParseBool(s)
+}
+
+func parseTimestamp(s string) (interface{}, error) {
+	return time.Parse(time.RFC3339Nano, s)
+}
+
+func formatTime(t time.Time) string {
+	return t.Format(time.RFC3339Nano)
+}
+
+// listCondition is a filter which compares a column with the value using the
+// operator op, e.g. `created_at >= value`.
+type listCondition struct {
+	column string
+	op     string
+	parse  func(string) (interface{}, error)
+}
+
+// timeRangeConditions select the items created or changed within a time range.
+var timeRangeConditions = map[string]listCondition{
+	"created_since": {"created_at", ">=", parseTimestamp},
+	"created_until": {"created_at", "<", parseTimestamp},
+	"changed_since": {"changed_at", ">=", parseTimestamp},
+	"changed_until": {"changed_at", "<", parseTimestamp},
+}
+
+// listTable describes how a table can be listed. sort contains the columns
+// which can be used for sorting, filter the columns for filtering. Filters
+// which do not compare a column with the same name for equality are listed in
+// conditions. If visible is set, it returns the SQL condition which selects
+// the items visible to the user whose ID is in the parameter viewer.
+type listTable struct {
+	name       string
+	id         func(item interface{}) int64
+	sort       map[string]listColumn
+	filter     map[string]listColumn
+	conditions map[string]listCondition
+	visible    func(viewer string) string
+}
+
+// cursor is the position in a sorted list, it contains the sort order and the
+// values of the last item which was returned.
+type cursor struct {
+	Sort   string   `json:"s"`
+	Values []string `json:"v"`
+}
+
+// sortString returns the string representation of the sort order.
+func sortString(fields []SortField) string {
+	var s []string
+	for _, f := range fields {
+		s = append(s, f.String())
+	}
+
+	return strings.Join(s, ",")
+}
+
+func encodeCursor(c cursor) string {
+	buf, err := json.Marshal(c)
+	if err != nil {
+		panic(err)
+	}
+
+	return base64.RawURLEncoding.EncodeToString(buf)
+}
+
+func decodeCursor(s string) (c cursor, err error) {
+	buf, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return cursor{}, ListError{"invalid cursor"}
+	}
+
+	if err = json.Unmarshal(buf, &c); err != nil {
+		return cursor{}, ListError{"invalid cursor"}
+	}
+
+	return c, nil
+}
+
+// listQuery contains the SQL for listing a table.
+type listQuery struct {
+	count     string
+	countArgs []interface{}
+	query     string
+	args      []interface{}
+}
+
+// query builds the SQL for listing the table with the options. The query
+// selects one item more than the limit, so that the caller can find out
+// whether there are more items. If viewer is set, only the items visible to
+// the user are selected.
+func (t listTable) query(opts ListOptions, viewer *User) (listQuery, error) {
+	var (
+		conds []string
+		args  []interface{}
+	)
+
+	param := func(v interface{}) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	// sort the filters so that the generated SQL is deterministic
+	var filters []string
+	for name := range opts.Filter {
+		filters = append(filters, name)
+	}
+	sort.Strings(filters)
+
+	for _, name := range filters {
+		cond, ok := t.conditions[name]
+		if col, found := t.filter[name]; found {
+			cond, ok = listCondition{column: name, op: "=", parse: col.parse}, true
+		}
+
+		// other parameters, e.g. for cache busting, are ignored
+		if !ok {
+			continue
+		}
+
+		v, err := cond.parse(opts.Filter[name])
+		if err != nil {
+			return listQuery{}, ListError{fmt.Sprintf("invalid value for filter %q", name)}
+		}
+
+		conds = append(conds, cond.column+" "+cond.op+" "+param(v))
+	}
+
+	if viewer != nil && t.visible != nil {
+		conds = append(conds, t.visible(param(viewer.ID)))
+	}
+
+	where := func() string {
+		if len(conds) == 0 {
+			return ""
+		}
+		return " WHERE " + strings.Join(conds, " AND ")
+	}
+
+	q := listQuery{
+		count:     "SELECT count(*) FROM " + t.name + where(),
+		countArgs: append([]interface{}{}, args...),
+	}
+
+	var order []string
+	for _, f := range opts.Sort {
+		if _, ok := t.sort[f.Column]; !ok {
+			return listQuery{}, ListError{fmt.Sprintf("unable to sort by %q", f.Column)}
+		}
+
+		if f.Descending {
+			order = append(order, f.Column+" DESC")
+		} else {
+			order = append(order, f.Column+" ASC")
+		}
+	}
+	order = append(order, "id ASC")
+
+	if opts.Cursor != "" {
+		cond, err := t.cursorCondition(opts, param)
+		if err != nil {
+			return listQuery{}, err
+		}
+
+		conds = append(conds, cond)
+	}
+
+	q.query = "SELECT * FROM " + t.name + where() +
+		" ORDER BY " + strings.Join(order, ", ") +
+		" LIMIT " + param(opts.Limit+1)
+	q.args = args
+
+	return q, nil
+}
+
+// cursorCondition returns the SQL condition which selects all items after
+// the cursor.
+func (t listTable) cursorCondition(opts ListOptions, param func(interface{}) string) (string, error) {
+	c, err := decodeCursor(opts.Cursor)
+	if err != nil {
+		return "", err
+	}
+
+	if c.Sort != sortString(opts.Sort) || len(c.Values) != len(opts.Sort)+1 {
+		return "", ListError{"cursor does not match the sort order"}
+	}
+
+	fields := append(append([]SortField{}, opts.Sort...), SortField{Column: "id"})
+	var values []string
+	for i, f := range fields {
+		parse := parseInt
+		if f.Column != "id" {
+			parse = t.sort[f.Column].parse
+		}
+
+		v, err := parse(c.Values[i])
+		if err != nil {
+			return "", ListError{"invalid cursor"}
+		}
+
+		values = append(values, param(v))
+	}
+
+	// (a > x) OR (a = x AND b < y) OR (a = x AND b = y AND id > z)
+	var alternatives []string
+	for i, f := range fields {
+		var conds []string
+		for j := 0; j < i; j++ {
+			conds = append(conds, fields[j].Column+" = "+values[j])
+		}
+
+		op := " > "
+		if f.Descending {
+			op = " < "
+		}
+		conds = append(conds, f.Column+op+values[i])
+
+		alternatives = append(alternatives, "("+strings.Join(conds, " AND ")+")")
+	}
+
+	return "(" + strings.Join(alternatives, " OR ") + ")", nil
+}
+
+// cursor returns the cursor pointing to item.
+func (t listTable) cursor(opts ListOptions, item interface{}) string {
+	c := cursor{Sort: sortString(opts.Sort)}
+	for _, f := range opts.Sort {
+		c.Values = append(c.Values, t.sort[f.Column].value(item))
+	}
+	c.Values = append(c.Values, strconv.FormatInt(t.id(item), 10))
+
+	return encodeCursor(c)
+}
+
+// list selects a part of the table according to opts into items, which must
+// be a pointer to a slice. length returns the number of items selected and
+// item returns the item at an index. If there are more items, the slice
+// contains one item more than the limit, which the caller must remove.
+func (db *DB) list(t listTable, opts ListOptions, items interface{}, length func() int, item func(int) interface{}) (Page, error) {
+	q, err := t.query(opts, db.viewer)
+	if err != nil {
+		return Page{}, err
+	}
+
+	var page Page
+	if err = db.ex.SelectOne(&page.Total, q.count, q.countArgs...); err != nil {
+		return Page{}, err
+	}
+
+	if err = db.ex.Select(items, q.query, q.args...); err != nil {
+		return Page{}, err
+	}
+
+	if length() > opts.Limit {
+		page.NextCursor = t.cursor(opts, item(opts.Limit-1))
+	}
+
+	return page, nil
+}
@@ -0,0 +1,211 @@
+package db
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var parseSortTests = []struct {
+	s      string
+	fields []SortField
+}{
+	{"", nil},
+	{"name", []SortField{{Column: "name"}}},
+	{"name,-changed_at", []SortField{{Column: "name"}, {Column: "changed_at", Descending: true}}},
+	{" -city , ,country", []SortField{{Column: "city", Descending: true}, {Column: "country"}}},
+}
+
+func TestParseSort(t *testing.T) {
+	for i, test := range parseSortTests {
+		fields := ParseSort(test.s)
+		if !reflect.DeepEqual(fields, test.fields) {
+			t.Errorf("test %d: ParseSort(%q) returned wrong fields, want %v, got %v", i, test.s, test.fields, fields)
+		}
+	}
+}
+
+func TestListQuery(t *testing.T) {
+	opts := ListOptions{
+		Limit:  10,
+		Sort:   ParseSort("name,-changed_at"),
+		Filter: map[string]string{"country": "Deutschland", "city": "Köln"},
+	}
+
+	q, err := personList.query(opts, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := "SELECT * FROM people WHERE city = $1 AND country = $2 ORDER BY name ASC, changed_at DESC, id ASC LIMIT $3"
+	if q.query != want {
+		t.Errorf("wrong query:\nwant: %v\n got: %v", want, q.query)
+	}
+
+	want = "SELECT count(*) FROM people WHERE city = $1 AND country = $2"
+	if q.count != want {
+		t.Errorf("wrong count query:\nwant: %v\n got: %v", want, q.count)
+	}
+
+	if !reflect.DeepEqual(q.args, []interface{}{"Köln", "Deutschland", 11}) {
+		t.Errorf("wrong args: %v", q.args)
+	}
+
+	p := NewPerson("Tamara Skibicki")
+	p.ID = 23
+	opts.Cursor = personList.cursor(opts, p)
+
+	q, err = personList.query(opts, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want = "SELECT * FROM people WHERE city = $1 AND country = $2 AND " +
+		"((name > $3) OR (name = $3 AND changed_at < $4) OR (name = $3 AND changed_at = $4 AND id > $5)) " +
+		"ORDER BY name ASC, changed_at DESC, id ASC LIMIT $6"
+	if q.query != want {
+		t.Errorf("wrong query:\nwant: %v\n got: %v", want, q.query)
+	}
+
+	if len(q.args) != 6 || q.args[2] != "Tamara Skibicki" || q.args[4] != int64(23) {
+		t.Errorf("wrong args: %v", q.args)
+	}
+}
+
+var listQueryErrorTests = []ListOptions{
+	{Limit: 1, Sort: ParseSort("password_hash")},
+	{Limit: 1, Filter: map[string]string{"account_id": "foo"}},
+	{Limit: 1, Cursor: "foo"},
+	{Limit: 1, Cursor: encodeCursor(cursor{Sort: "name", Values: []string{"a", "1"}})},
+	{Limit: 1, Sort: ParseSort("changed_at"), Cursor: encodeCursor(cursor{Sort: "changed_at", Values: []string{"a", "1"}})},
+}
+
+func TestListQueryUnknownFilter(t *testing.T) {
+	opts := ListOptions{
+		Limit:  10,
+		Filter: map[string]string{"city": "Köln", "comment": "foo", "offset": "20", "_": "1461493807"},
+	}
+
+	q, err := personList.query(opts, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := "SELECT count(*) FROM people WHERE city = $1"
+	if q.count != want {
+		t.Errorf("wrong count query:\nwant: %v\n got: %v", want, q.count)
+	}
+}
+
+func TestListQueryVisible(t *testing.T) {
+	opts := ListOptions{
+		Limit:  10,
+		Filter: map[string]string{"city": "Köln"},
+	}
+
+	q, err := personList.query(opts, &User{ID: 5})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := "SELECT count(*) FROM people WHERE city = $1 AND " + visibleCondition("$2")
+	if q.count != want {
+		t.Errorf("wrong count query:\nwant: %v\n got: %v", want, q.count)
+	}
+
+	if !reflect.DeepEqual(q.args, []interface{}{"Köln", int64(5), 11}) {
+		t.Errorf("wrong args: %v", q.args)
+	}
+
+	q, err = userList.query(ListOptions{Limit: 10}, &User{ID: 5})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if strings.Contains(q.query, "visibility") {
+		t.Errorf("visibility condition added to the list of users: %v", q.query)
+	}
+}
+
+func TestListQueryErrors(t *testing.T) {
+	for i, opts := range listQueryErrorTests {
+		_, err := personList.query(opts, nil)
+		if _, ok := err.(ListError); !ok {
+			t.Errorf("test %d: expected ListError, got %v", i, err)
+		}
+	}
+}
+
+func TestListQueryConditions(t *testing.T) {
+	opts := ListOptions{
+		Limit: 10,
+		Filter: map[string]string{
+			"user":   "admin",
+			"since":  "2016-04-01T00:00:00Z",
+			"until":  "2016-05-01T00:00:00Z",
+			"entity": "person",
+		},
+	}
+
+	q, err := auditList.query(opts, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := "SELECT * FROM audit_log WHERE entity = $1 AND created_at >= $2 AND created_at < $3 AND login = $4 ORDER BY id ASC LIMIT $5"
+	if q.query != want {
+		t.Errorf("wrong query:\nwant: %v\n got: %v", want, q.query)
+	}
+
+	opts.Filter["until"] = "yesterday"
+	if _, err = auditList.query(opts, nil); err == nil {
+		t.Errorf("expected error for invalid time")
+	}
+}
+
+func TestListPeoplePage(t *testing.T) {
+	for _, name := range []string{"List Test C", "List Test A", "List Test B"} {
+		p := NewPerson(name)
+		p.City = "Listenhausen"
+		if err := testDB.InsertPerson(p); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	opts := ListOptions{
+		Limit:  2,
+		Sort:   ParseSort("-name"),
+		Filter: map[string]string{"city": "Listenhausen"},
+	}
+
+	var names []string
+	for i := 0; ; i++ {
+		people, page, err := testDB.ListPeoplePage(opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if page.Total != 3 {
+			t.Fatalf("wrong total, want 3, got %d", page.Total)
+		}
+
+		for _, p := range people {
+			names = append(names, p.Name)
+		}
+
+		if page.NextCursor == "" {
+			break
+		}
+
+		if i > 2 {
+			t.Fatalf("cursor does not advance")
+		}
+
+		opts.Cursor = page.NextCursor
+	}
+
+	want := []string{"List Test C", "List Test B", "List Test A"}
+	if !reflect.DeepEqual(names, want) {
+		t.Fatalf("wrong list of people, want %v, got %v", want, names)
+	}
+}
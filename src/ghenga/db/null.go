@@ -0,0 +1,53 @@
+package db
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// nullInt64JSON returns the JSON representation of a nullable integer, which
+// is nil when the value is NULL.
+func nullInt64JSON(v sql.NullInt64) *int64 {
+	if !v.Valid {
+		return nil
+	}
+
+	return &v.Int64
+}
+
+// jsonNullInt64 converts a JSON integer which may be null to a nullable
+// integer.
+func jsonNullInt64(v *int64) sql.NullInt64 {
+	if v == nil {
+		return sql.NullInt64{}
+	}
+
+	return sql.NullInt64{Int64: *v, Valid: true}
+}
+
+// nullTimeJSON returns the JSON representation of a nullable timestamp, which
+// is nil when the value is NULL.
+func nullTimeJSON(t pq.NullTime) *string {
+	if !t.Valid {
+		return nil
+	}
+
+	s := t.Time.Format(timeLayout)
+	return &s
+}
+
+// jsonNullTime parses a JSON timestamp which may be null.
+func jsonNullTime(s *string) (pq.NullTime, error) {
+	if s == nil || *s == "" {
+		return pq.NullTime{}, nil
+	}
+
+	t, err := time.Parse(timeLayout, *s)
+	if err != nil {
+		return pq.NullTime{}, err
+	}
+
+	return pq.NullTime{Time: t, Valid: true}, nil
+}
@@ -0,0 +1,117 @@
+package db
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"io"
+	"time"
+)
+
+// OIDCLogin is a login with an OpenID Connect provider which has been started
+// but not finished yet. It is found again by the state the provider passes
+// back to ghenga.
+type OIDCLogin struct {
+	State string
+	Nonce string
+
+	// Verifier is the PKCE code verifier which is sent when the
+	// authorization code is exchanged for the tokens.
+	Verifier string
+
+	// Redirect is the local path the browser is sent to after the login, it
+	// may be empty.
+	Redirect string
+
+	ValidUntil time.Time
+}
+
+// randomHex returns a random hex string of tokenLength bytes.
+func randomHex() (string, error) {
+	buf := make([]byte, tokenLength)
+	_, err := io.ReadFull(rand.Reader, buf)
+	if err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(buf), nil
+}
+
+// NewOIDCLogin returns a new login with random state, nonce and verifier.
+func NewOIDCLogin(redirect string, valid time.Duration) (*OIDCLogin, error) {
+	l := &OIDCLogin{
+		Redirect:   redirect,
+		ValidUntil: time.Now().Add(valid),
+	}
+
+	for _, s := range []*string{&l.State, &l.Nonce, &l.Verifier} {
+		v, err := randomHex()
+		if err != nil {
+			return nil, err
+		}
+		*s = v
+	}
+
+	return l, nil
+}
+
+// SaveOIDCLogin saves a new login.
+func (db *DB) SaveOIDCLogin(l *OIDCLogin) error {
+	return db.ex.Insert(l)
+}
+
+// TakeOIDCLogin returns the login for state and removes it, so that it can
+// only be used once. It does not check whether the login is still valid.
+func (db *DB) TakeOIDCLogin(state string) (*OIDCLogin, error) {
+	var l OIDCLogin
+	err := db.ex.SelectOne(&l, "DELETE FROM oidc_logins WHERE state = $1 RETURNING *", state)
+	if err != nil {
+		return nil, err
+	}
+
+	return &l, nil
+}
+
+// ExpireOIDCLogins removes logins which have not been finished in time.
+func (db *DB) ExpireOIDCLogins() (n int64, err error) {
+	res, err := db.ex.Exec("DELETE FROM oidc_logins WHERE valid_until < now()")
+	if err != nil {
+		return 0, err
+	}
+
+	return res.RowsAffected()
+}
+
+// FindOIDCUser returns the user who is bound to the subject at the OpenID
+// Connect provider issuer, see BindOIDCUser.
+func (db *DB) FindOIDCUser(issuer, subject string) (*User, error) {
+	var u User
+	err := db.ex.SelectOne(&u, `SELECT users.* FROM users
+		JOIN oidc_subjects ON oidc_subjects.user_id = users.id
+		WHERE oidc_subjects.issuer = $1 AND oidc_subjects.subject = $2`, issuer, subject)
+	if err != nil {
+		return nil, err
+	}
+
+	return &u, nil
+}
+
+// BindOIDCUser binds the user with the ID to the subject at the OpenID
+// Connect provider issuer. A user can only be bound to one subject and the
+// other way round.
+func (db *DB) BindOIDCUser(userID int64, issuer, subject string) error {
+	_, err := db.ex.Exec(`INSERT INTO oidc_subjects (issuer, subject, user_id, created_at)
+		VALUES ($1, $2, $3, $4)`, issuer, subject, userID, time.Now())
+	return err
+}
+
+// InsertOIDCUser creates the new user u and binds it to the subject at the
+// OpenID Connect provider issuer in one transaction.
+func (db *DB) InsertOIDCUser(u *User, issuer, subject string) error {
+	return db.atomic(func(tx *Tx) error {
+		if err := tx.InsertUser(u); err != nil {
+			return err
+		}
+
+		return tx.BindOIDCUser(u.ID, issuer, subject)
+	})
+}
@@ -1,9 +1,11 @@
 package db
 
 import (
+	"database/sql"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"strconv"
 	"time"
 
 	"github.com/jmoiron/modl"
@@ -12,12 +14,12 @@ import (
 
 // Person is a person in the database.
 type Person struct {
-	ID           int64
-	Name         string
-	Title        string
-	Department   string
-	EmailAddress string
-	PhoneNumbers PhoneNumbers `db:"-"`
+	ID             int64
+	Name           string
+	Title          string
+	Department     string
+	EmailAddresses EmailAddresses `db:"-"`
+	PhoneNumbers   PhoneNumbers   `db:"-"`
 
 	// Address
 	Street     string
@@ -28,6 +30,19 @@ type Person struct {
 
 	Comment string
 
+	AccountID sql.NullInt64
+
+	// OwnerID is the ID of the user who owns the person, Visibility
+	// determines who else may see the person.
+	OwnerID    sql.NullInt64
+	Visibility string
+
+	// CreatedBy is the ID of the user who created the person. ChangedBy is
+	// the ID of the user who makes a change, it is also recorded in the
+	// revision history when the person is saved.
+	CreatedBy sql.NullInt64
+	ChangedBy sql.NullInt64
+
 	ChangedAt time.Time
 	CreatedAt time.Time
 	Version   int64
@@ -36,19 +51,26 @@ type Person struct {
 // PersonJSON is the JSON representation of a Person as returned or consumed by
 // the API.
 type PersonJSON struct {
-	ID           int64             `json:"id,omitempty"`
-	Name         string            `json:"name,omitempty"`
-	Title        string            `json:"title,omitempty"`
-	Department   string            `json:"department,omitempty"`
-	EmailAddress string            `json:"email_address,omitempty"`
-	PhoneNumbers []PhoneNumberJSON `json:"phone_numbers"`
+	ID             int64              `json:"id,omitempty"`
+	Name           string             `json:"name,omitempty"`
+	Title          string             `json:"title,omitempty"`
+	Department     string             `json:"department,omitempty"`
+	EmailAddresses []EmailAddressJSON `json:"email_addresses"`
+	PhoneNumbers   []PhoneNumberJSON  `json:"phone_numbers"`
 
 	Address AddressJSON `json:"address,omitempty"`
 
 	Comment string `json:"comment,omitempty"`
 
+	AccountID *int64 `json:"account_id,omitempty"`
+
+	OwnerID    *int64 `json:"owner_id"`
+	Visibility string `json:"visibility,omitempty"`
+
 	ChangedAt string `json:"changed_at,omitempty"`
 	CreatedAt string `json:"created_at,omitempty"`
+	ChangedBy *int64 `json:"changed_by"`
+	CreatedBy *int64 `json:"created_by"`
 
 	Version int64 `json:"version"`
 }
@@ -66,9 +88,10 @@ type AddressJSON struct {
 func NewPerson(name string) *Person {
 	ts := time.Now()
 	return &Person{
-		Name:      name,
-		CreatedAt: ts,
-		ChangedAt: ts,
+		Name:       name,
+		Visibility: VisibilityEveryone,
+		CreatedAt:  ts,
+		ChangedAt:  ts,
 	}
 }
 
@@ -84,20 +107,15 @@ func (p Person) MarshalJSON() ([]byte, error) {
 
 		ChangedAt: p.ChangedAt.Format(timeLayout),
 		CreatedAt: p.CreatedAt.Format(timeLayout),
+		ChangedBy: nullInt64JSON(p.ChangedBy),
+		CreatedBy: nullInt64JSON(p.CreatedBy),
 		Version:   p.Version,
 	}
 
 	jp.Title = p.Title
 	jp.Department = p.Department
-	jp.EmailAddress = p.EmailAddress
-
-	jp.PhoneNumbers = []PhoneNumberJSON{}
-	for _, pn := range p.PhoneNumbers {
-		jp.PhoneNumbers = append(jp.PhoneNumbers, PhoneNumberJSON{
-			Type:   pn.Type,
-			Number: pn.Number,
-		})
-	}
+	jp.EmailAddresses = p.EmailAddresses.JSON()
+	jp.PhoneNumbers = p.PhoneNumbers.JSON()
 
 	jp.Address = AddressJSON{
 		Street:     p.Street,
@@ -108,6 +126,9 @@ func (p Person) MarshalJSON() ([]byte, error) {
 	}
 
 	jp.Comment = p.Comment
+	jp.AccountID = nullInt64JSON(p.AccountID)
+	jp.OwnerID = nullInt64JSON(p.OwnerID)
+	jp.Visibility = p.Visibility
 	return json.Marshal(jp)
 }
 
@@ -131,11 +152,10 @@ func (p *Person) UnmarshalJSON(data []byte) error {
 	}
 
 	*p = Person{
-		ID:           jp.ID,
-		Name:         jp.Name,
-		Title:        jp.Title,
-		Department:   jp.Department,
-		EmailAddress: jp.EmailAddress,
+		ID:         jp.ID,
+		Name:       jp.Name,
+		Title:      jp.Title,
+		Department: jp.Department,
 
 		Street:     jp.Address.Street,
 		PostalCode: jp.Address.PostalCode,
@@ -145,18 +165,20 @@ func (p *Person) UnmarshalJSON(data []byte) error {
 
 		Comment: jp.Comment,
 
+		AccountID: jsonNullInt64(jp.AccountID),
+
+		OwnerID:    jsonNullInt64(jp.OwnerID),
+		Visibility: jp.Visibility,
+
 		CreatedAt: createdAt,
 		ChangedAt: changedAt,
+		CreatedBy: jsonNullInt64(jp.CreatedBy),
+		ChangedBy: jsonNullInt64(jp.ChangedBy),
 		Version:   jp.Version,
 	}
 
-	for _, num := range jp.PhoneNumbers {
-		n := PhoneNumber{
-			Number: num.Number,
-			Type:   num.Type,
-		}
-		p.PhoneNumbers = append(p.PhoneNumbers, n)
-	}
+	p.EmailAddresses = emailAddressesFromJSON(jp.EmailAddresses)
+	p.PhoneNumbers = phoneNumbersFromJSON(jp.PhoneNumbers)
 
 	return nil
 }
@@ -167,6 +189,16 @@ func (p *Person) Validate() error {
 		return errors.New("name is empty")
 	}
 
+	if err := p.EmailAddresses.Validate(); err != nil {
+		return err
+	}
+
+	if p.Visibility != "" {
+		if err := validVisibility(p.Visibility); err != nil {
+			return err
+		}
+	}
+
 	if p.CreatedAt.IsZero() || p.ChangedAt.IsZero() {
 		return errors.New("invalid timestamps")
 	}
@@ -175,26 +207,28 @@ func (p *Person) Validate() error {
 }
 
 // PostInsert is run after a person is saved into the database. It is
-// used to handle phone numbers associated with a person.
+// used to handle phone numbers and email addresses associated with a person,
+// and records the first revision.
 func (p *Person) PostInsert(db modl.SqlExecutor) error {
-	if len(p.PhoneNumbers) == 0 {
-		return nil
+	if err := insertEmailAddresses(db, p.ID, p.EmailAddresses); err != nil {
+		return err
 	}
 
-	for _, num := range p.PhoneNumbers {
-		num.PersonID = p.ID
-		err := db.Insert(&num)
-		if err != nil {
-			return err
-		}
+	if err := insertPhoneNumbers(db, phoneOwnerPerson, p.ID, p.PhoneNumbers); err != nil {
+		return err
 	}
 
-	return nil
+	return saveRevision(db, RevisionPerson, p.ID, p.Version, p.ChangedBy, p)
 }
 
-// PostGet loads the phone numbers associated with the person.
+// PostGet loads the phone numbers and email addresses associated with the
+// person.
 func (p *Person) PostGet(db modl.SqlExecutor) error {
-	return db.Select(&p.PhoneNumbers, "SELECT * FROM phone_numbers WHERE person_id = $1", p.ID)
+	if err := selectEmailAddresses(db, p.ID, &p.EmailAddresses); err != nil {
+		return err
+	}
+
+	return selectPhoneNumbers(db, phoneOwnerPerson, p.ID, &p.PhoneNumbers)
 }
 
 // in is a small wrapper around the sqlx.In() function which handles rebinding
@@ -212,56 +246,30 @@ func in(query string, args ...interface{}) (string, []interface{}, error) {
 }
 
 // PostUpdate is run after a person has been updated. It handles updating the
-// phone numbers for a person.
+// phone numbers and email addresses for a person, and records the new
+// revision.
 func (p *Person) PostUpdate(db modl.SqlExecutor) error {
-	var ids []int64
-	for _, num := range p.PhoneNumbers {
-		num.PersonID = p.ID
-		var err error
-		if num.ID != 0 {
-			_, err = db.Update(&num)
-		} else {
-			err = db.Insert(&num)
-		}
-
-		if err != nil {
-			return err
-		}
-
-		ids = append(ids, num.ID)
+	if err := updateEmailAddresses(db, p.ID, p.EmailAddresses); err != nil {
+		return err
 	}
 
-	if len(ids) > 0 {
-		// remove excess phone numbers
-		query, args, err := in("DELETE FROM phone_numbers WHERE person_id = ? AND id NOT IN (?)", p.ID, ids)
-		if err != nil {
-			return err
-		}
-
-		_, err = db.Exec(query, args...)
+	if err := updatePhoneNumbers(db, phoneOwnerPerson, p.ID, p.PhoneNumbers); err != nil {
 		return err
 	}
 
-	// else remove all phone numbers
-	_, err := db.Exec("DELETE FROM phone_numbers WHERE person_id = $1", p.ID)
-	return err
+	return saveRevision(db, RevisionPerson, p.ID, p.Version, p.ChangedBy, p)
 }
 
-// Update updates p with the fields from other.
+// Update updates p with the fields from other. The owner and the visibility
+// are only changed if they are set in other.
 func (p *Person) Update(other PersonJSON) {
 	p.Name = other.Name
 	p.Title = other.Title
 	p.Department = other.Department
-	p.EmailAddress = other.EmailAddress
 
-	p.PhoneNumbers = nil
-
-	for _, num := range other.PhoneNumbers {
-		p.PhoneNumbers = append(p.PhoneNumbers, PhoneNumber{
-			Type:   num.Type,
-			Number: num.Number,
-		})
-	}
+	p.EmailAddresses = emailAddressesFromJSON(other.EmailAddresses)
+	p.EmailAddresses.setDefaultPrimary()
+	p.PhoneNumbers = phoneNumbersFromJSON(other.PhoneNumbers)
 
 	p.Street = other.Address.Street
 	p.PostalCode = other.Address.PostalCode
@@ -270,6 +278,15 @@ func (p *Person) Update(other PersonJSON) {
 	p.Country = other.Address.Country
 
 	p.Comment = other.Comment
+	p.AccountID = jsonNullInt64(other.AccountID)
+
+	if other.OwnerID != nil {
+		p.OwnerID = jsonNullInt64(other.OwnerID)
+	}
+
+	if other.Visibility != "" {
+		p.Visibility = other.Visibility
+	}
 
 	p.Version = other.Version
 }
@@ -289,7 +306,8 @@ func (p Person) String() string {
 func (db *DB) FindPerson(id int64) (*Person, error) {
 	var p Person
 
-	err := db.dbmap.SelectOne(&p, "SELECT * FROM people WHERE id = $1", id)
+	args := []interface{}{id}
+	err := db.ex.SelectOne(&p, "SELECT * FROM people WHERE id = $1 AND "+db.visiblePeople(&args), args...)
 	if err != nil {
 		return nil, err
 	}
@@ -297,35 +315,149 @@ func (db *DB) FindPerson(id int64) (*Person, error) {
 	return &p, nil
 }
 
-// UpdatePerson modifies an existing person.
+// UpdatePerson modifies an existing person. If the person was changed in the
+// meantime, ErrVersionMismatch is returned. All changes are saved in one
+// transaction.
 func (db *DB) UpdatePerson(p *Person) error {
-	_, err := db.dbmap.Update(p)
-	return err
+	return db.atomic(func(tx *Tx) error {
+		if _, err := tx.ex.Update(p); err != nil {
+			return versionError(err)
+		}
+
+		return tx.changed(ActionUpdate, RevisionPerson, p.ID, p.Version, p.ChangedBy)
+	})
 }
 
-// InsertPerson creates a new person.
+// InsertPerson creates a new person. The person, the phone numbers, the email
+// addresses and the revision are saved in one transaction. Unless CreatedBy is
+// set, the user in ChangedBy is recorded as the creator. Unless OwnerID is
+// set, the creator becomes the owner, the default visibility is
+// VisibilityEveryone.
 func (db *DB) InsertPerson(p *Person) error {
-	return db.dbmap.Insert(p)
+	if !p.CreatedBy.Valid {
+		p.CreatedBy = p.ChangedBy
+	}
+
+	if !p.OwnerID.Valid {
+		p.OwnerID = p.CreatedBy
+	}
+
+	if p.Visibility == "" {
+		p.Visibility = VisibilityEveryone
+	}
+
+	return db.atomic(func(tx *Tx) error {
+		if err := tx.ex.Insert(p); err != nil {
+			return err
+		}
+
+		return tx.changed(ActionCreate, RevisionPerson, p.ID, p.Version, p.ChangedBy)
+	})
 }
 
 // ListPeople returns the list of people.
 func (db *DB) ListPeople() ([]*Person, error) {
 	var people []*Person
-	err := db.dbmap.Select(&people, "select * from people")
+	var args []interface{}
+	err := db.ex.Select(&people, "select * from people where "+db.visiblePeople(&args), args...)
 	return people, err
 }
 
-// DeletePerson removes a person.
-func (db *DB) DeletePerson(id int64) error {
-	res := db.dbmap.Dbx.MustExec("delete from people where id = $1", id)
-	n, err := res.RowsAffected()
+// personList describes how the table people can be listed.
+var personList = listTable{
+	name: "people",
+	id:   func(item interface{}) int64 { return item.(*Person).ID },
+	sort: map[string]listColumn{
+		"id":         {func(item interface{}) string { return strconv.FormatInt(item.(*Person).ID, 10) }, parseInt},
+		"name":       {func(item interface{}) string { return item.(*Person).Name }, parseString},
+		"city":       {func(item interface{}) string { return item.(*Person).City }, parseString},
+		"country":    {func(item interface{}) string { return item.(*Person).Country }, parseString},
+		"created_at": {func(item interface{}) string { return formatTime(item.(*Person).CreatedAt) }, parseTimestamp},
+		"changed_at": {func(item interface{}) string { return formatTime(item.(*Person).ChangedAt) }, parseTimestamp},
+	},
+	filter: map[string]listColumn{
+		"city":        {parse: parseString},
+		"country":     {parse: parseString},
+		"state":       {parse: parseString},
+		"postal_code": {parse: parseString},
+		"department":  {parse: parseString},
+		"account_id":  {parse: parseInt},
+		"owner_id":    {parse: parseInt},
+		"visibility":  {parse: parseString},
+		"created_by":  {parse: parseInt},
+		"changed_by":  {parse: parseInt},
+	},
+	conditions: timeRangeConditions,
+	visible:    visibleCondition,
+}
+
+// ListPeoplePage returns a part of the list of people according to opts.
+// Invalid options are reported as a ListError.
+func (db *DB) ListPeoplePage(opts ListOptions) ([]*Person, Page, error) {
+	people := []*Person{}
+	page, err := db.list(personList, opts, &people,
+		func() int { return len(people) },
+		func(i int) interface{} { return people[i] })
 	if err != nil {
-		return err
+		return nil, Page{}, err
 	}
 
-	if n != 1 {
-		return errors.New("person not found")
+	if len(people) > opts.Limit {
+		people = people[:opts.Limit]
 	}
 
-	return nil
+	return people, page, nil
+}
+
+// ListAccountPeople returns the list of people associated with the account.
+func (db *DB) ListAccountPeople(accountID int64) ([]*Person, error) {
+	var people []*Person
+	args := []interface{}{accountID}
+	err := db.ex.Select(&people, "SELECT * FROM people WHERE account_id = $1 AND "+db.visiblePeople(&args), args...)
+	return people, err
+}
+
+// deletedPerson contains the columns of a deleted person which are saved in
+// the change.
+type deletedPerson struct {
+	ID         int64
+	Version    int64
+	OwnerID    sql.NullInt64
+	Visibility string
+}
+
+// DeletePerson removes a person. The user who removes the person is passed in
+// changedBy.
+func (db *DB) DeletePerson(id int64, changedBy sql.NullInt64) error {
+	return db.atomic(func(tx *Tx) error {
+		var p deletedPerson
+		err := tx.ex.SelectOne(&p, `DELETE FROM people WHERE id = $1
+			RETURNING id, version, owner_id, visibility`, id)
+		if err == sql.ErrNoRows {
+			return errors.New("person not found")
+		}
+		if err != nil {
+			return err
+		}
+
+		return tx.personDeleted(p, changedBy)
+	})
+}
+
+// DeletePersonVersion removes a person, but only if the version matches the
+// version in the database. Otherwise, ErrVersionMismatch is returned.
+func (db *DB) DeletePersonVersion(id, version int64, changedBy sql.NullInt64) error {
+	return db.atomic(func(tx *Tx) error {
+		var p deletedPerson
+		err := tx.ex.SelectOne(&p, `DELETE FROM people WHERE id = $1 AND version = $2
+			RETURNING id, version, owner_id, visibility`, id, version)
+		if err == sql.ErrNoRows {
+			return tx.notDeleted("people", "person", id)
+		}
+		if err != nil {
+			return err
+		}
+
+		return tx.personDeleted(p, changedBy)
+	})
 }
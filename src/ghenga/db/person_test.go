@@ -2,6 +2,7 @@ package db
 
 import (
 	"bytes"
+	"database/sql"
 	"encoding/json"
 	"flag"
 	"io/ioutil"
@@ -28,34 +29,43 @@ var testPersons = []struct {
 	{
 		name: "testperson1",
 		p: Person{
-			Name:         "Tamara Skibicki",
-			EmailAddress: "pit@ackermannsehls.org",
+			Name: "Tamara Skibicki",
+			EmailAddresses: []EmailAddress{
+				{Type: "work", Address: "pit@ackermannsehls.org", Primary: true},
+				{Type: "private", Address: "tamara@example.com"},
+			},
 			PhoneNumbers: []PhoneNumber{
 				{Type: "work", Number: "(03867) 3074101"},
 				{Type: "mobile", Number: "+49-077-1634655"},
 				{Type: "other", Number: "2134"},
 			},
-			Comment:   "fake profile",
-			ChangedAt: parseTime("2016-04-24T10:30:07+00:00"),
-			CreatedAt: parseTime("2016-04-24T10:30:07+00:00"),
-			Version:   23,
+			Comment:    "fake profile",
+			OwnerID:    sql.NullInt64{Int64: 2, Valid: true},
+			Visibility: VisibilityTeam,
+			ChangedAt:  parseTime("2016-04-24T10:30:07+00:00"),
+			CreatedAt:  parseTime("2016-04-24T10:30:07+00:00"),
+			Version:    23,
 		},
 	},
 	{
 		name: "testperson2",
 		p: Person{
-			Name:         "Mario Drees",
-			EmailAddress: "bela_freigang@herweg.com",
-			ChangedAt:    parseTime("2016-04-24T10:30:07+00:00"),
-			CreatedAt:    parseTime("2016-04-24T10:30:07+00:00"),
-			Version:      1,
+			Name: "Mario Drees",
+			EmailAddresses: []EmailAddress{
+				{Type: "work", Address: "bela_freigang@herweg.com", Primary: true},
+			},
+			ChangedAt: parseTime("2016-04-24T10:30:07+00:00"),
+			CreatedAt: parseTime("2016-04-24T10:30:07+00:00"),
+			Version:   1,
 		},
 	},
 	{
 		name: "testperson3",
 		p: Person{
-			Name:         "Mario Drees",
-			EmailAddress: "bela_freigang@herweg.com",
+			Name: "Mario Drees",
+			EmailAddresses: []EmailAddress{
+				{Type: "work", Address: "bela_freigang@herweg.com", Primary: true},
+			},
 			PhoneNumbers: []PhoneNumber{
 				{Type: "wörk", Number: "1234123 3074101"},
 			},
@@ -199,6 +209,31 @@ var testPersonValidate = []struct {
 			Name: "",
 		},
 	},
+	{
+		name:  "invalid-email",
+		valid: false,
+		p: Person{
+			Name:      "Mario Drees",
+			ChangedAt: parseTime("2016-04-24T10:30:07+00:00"),
+			CreatedAt: parseTime("2016-04-24T10:30:07+00:00"),
+			EmailAddresses: []EmailAddress{
+				{Type: "work", Address: "bela_freigang", Primary: true},
+			},
+		},
+	},
+	{
+		name:  "two-primary-emails",
+		valid: false,
+		p: Person{
+			Name:      "Mario Drees",
+			ChangedAt: parseTime("2016-04-24T10:30:07+00:00"),
+			CreatedAt: parseTime("2016-04-24T10:30:07+00:00"),
+			EmailAddresses: []EmailAddress{
+				{Type: "work", Address: "bela_freigang@herweg.com", Primary: true},
+				{Type: "private", Address: "bela@example.com", Primary: true},
+			},
+		},
+	},
 }
 
 func TestPersonValidate(t *testing.T) {
@@ -302,3 +337,82 @@ func TestPersonReplacePhoneNumbers(t *testing.T) {
 		t.Fatalf("changing phone numbers did not work, want:\n%v\n  got:\n%v", p.PhoneNumbers, p2.PhoneNumbers)
 	}
 }
+
+func TestPersonUpdateEmailAddresses(t *testing.T) {
+	p := findPerson(t, testDB, 14)
+	p.EmailAddresses = EmailAddresses{
+		{Type: "work", Address: "foo@example.com", Primary: true},
+		{Type: "private", Address: "bar@example.com"},
+	}
+
+	updatePerson(t, testDB, p)
+
+	p2 := findPerson(t, testDB, p.ID)
+	if !p.EmailAddresses.Equals(p2.EmailAddresses) {
+		t.Fatalf("changing email addresses did not work, want:\n%v\n  got:\n%v", p.EmailAddresses, p2.EmailAddresses)
+	}
+
+	if p2.EmailAddresses.Primary() != "foo@example.com" {
+		t.Fatalf("wrong primary email address, want foo@example.com, got %v", p2.EmailAddresses.Primary())
+	}
+
+	p2.EmailAddresses = EmailAddresses{}
+	updatePerson(t, testDB, p2)
+
+	p3 := findPerson(t, testDB, p.ID)
+	if len(p3.EmailAddresses) > 0 {
+		t.Fatalf("removing email addresses did not work, got:\n%v", p3.EmailAddresses)
+	}
+}
+
+func TestPersonUpdateDefaultPrimaryEmailAddress(t *testing.T) {
+	var p Person
+	p.Update(PersonJSON{
+		Name: "foo",
+		EmailAddresses: []EmailAddressJSON{
+			{Type: "work", Address: "foo@example.com"},
+			{Type: "private", Address: "bar@example.com"},
+		},
+	})
+
+	if !p.EmailAddresses[0].Primary || p.EmailAddresses[1].Primary {
+		t.Fatalf("first email address was not marked as primary: %v", p.EmailAddresses)
+	}
+}
+
+func TestPersonCreatedBy(t *testing.T) {
+	p := NewPerson("Created By Test")
+	p.ChangedBy = sql.NullInt64{Int64: 1, Valid: true}
+	if err := testDB.InsertPerson(p); err != nil {
+		t.Fatal(err)
+	}
+
+	p.ChangedBy = sql.NullInt64{Int64: 2, Valid: true}
+	if err := testDB.UpdatePerson(p); err != nil {
+		t.Fatal(err)
+	}
+
+	p2, err := testDB.FindPerson(p.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if p2.CreatedBy.Int64 != 1 || p2.ChangedBy.Int64 != 2 {
+		t.Fatalf("wrong users recorded: created by %v, changed by %v", p2.CreatedBy, p2.ChangedBy)
+	}
+
+	people, _, err := testDB.ListPeoplePage(ListOptions{
+		Limit: 10,
+		Filter: map[string]string{
+			"created_by":    "1",
+			"created_since": p.CreatedAt.Add(-time.Second).Format(time.RFC3339Nano),
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(people) != 1 || people[0].ID != p.ID {
+		t.Fatalf("wrong people returned: %v", people)
+	}
+}
@@ -1,13 +1,20 @@
 package db
 
-import "fmt"
+import (
+	"database/sql"
+	"fmt"
 
-// PhoneNumber is a phone number of a specified type.
+	"github.com/jmoiron/modl"
+)
+
+// PhoneNumber is a phone number of a specified type. It belongs either to a
+// person or to an account.
 type PhoneNumber struct {
-	ID       int64
-	Number   string
-	Type     string
-	PersonID int64
+	ID        int64
+	Number    string
+	Type      string
+	PersonID  sql.NullInt64
+	AccountID sql.NullInt64
 }
 
 // PhoneNumberJSON is the JSON representation of a phone number.
@@ -48,3 +55,105 @@ func (p PhoneNumbers) Equals(other PhoneNumbers) bool {
 
 	return true
 }
+
+// JSON returns the JSON representation of the phone numbers. It never returns
+// nil, so that an empty list is encoded as [].
+func (p PhoneNumbers) JSON() []PhoneNumberJSON {
+	list := []PhoneNumberJSON{}
+	for _, num := range p {
+		list = append(list, PhoneNumberJSON{
+			Type:   num.Type,
+			Number: num.Number,
+		})
+	}
+
+	return list
+}
+
+// phoneNumbersFromJSON converts the JSON representation to a list of phone
+// numbers.
+func phoneNumbersFromJSON(list []PhoneNumberJSON) PhoneNumbers {
+	var nums PhoneNumbers
+	for _, num := range list {
+		nums = append(nums, PhoneNumber{
+			Type:   num.Type,
+			Number: num.Number,
+		})
+	}
+
+	return nums
+}
+
+// Owner columns in the table phone_numbers.
+const (
+	phoneOwnerPerson  = "person_id"
+	phoneOwnerAccount = "account_id"
+)
+
+// setOwner sets the owner column of num to id.
+func (num *PhoneNumber) setOwner(owner string, id int64) {
+	switch owner {
+	case phoneOwnerPerson:
+		num.PersonID = sql.NullInt64{Int64: id, Valid: true}
+	case phoneOwnerAccount:
+		num.AccountID = sql.NullInt64{Int64: id, Valid: true}
+	default:
+		panic("invalid phone number owner " + owner)
+	}
+}
+
+// insertPhoneNumbers saves the phone numbers for the record with the given ID
+// in the owner column.
+func insertPhoneNumbers(db modl.SqlExecutor, owner string, id int64, nums PhoneNumbers) error {
+	for _, num := range nums {
+		num.setOwner(owner, id)
+		err := db.Insert(&num)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// selectPhoneNumbers loads all phone numbers for the record with the given ID
+// in the owner column.
+func selectPhoneNumbers(db modl.SqlExecutor, owner string, id int64, nums *PhoneNumbers) error {
+	return db.Select(nums, "SELECT * FROM phone_numbers WHERE "+owner+" = $1", id)
+}
+
+// updatePhoneNumbers updates the phone numbers for the record with the given
+// ID in the owner column. Phone numbers not contained in nums are removed.
+func updatePhoneNumbers(db modl.SqlExecutor, owner string, id int64, nums PhoneNumbers) error {
+	var ids []int64
+	for _, num := range nums {
+		num.setOwner(owner, id)
+		var err error
+		if num.ID != 0 {
+			_, err = db.Update(&num)
+		} else {
+			err = db.Insert(&num)
+		}
+
+		if err != nil {
+			return err
+		}
+
+		ids = append(ids, num.ID)
+	}
+
+	if len(ids) > 0 {
+		// remove excess phone numbers
+		query, args, err := in("DELETE FROM phone_numbers WHERE "+owner+" = ? AND id NOT IN (?)", id, ids)
+		if err != nil {
+			return err
+		}
+
+		_, err = db.Exec(query, args...)
+		return err
+	}
+
+	// else remove all phone numbers
+	_, err := db.Exec("DELETE FROM phone_numbers WHERE "+owner+" = $1", id)
+	return err
+}
@@ -0,0 +1,354 @@
+package db
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// QueryError is returned for a search query with a syntax error. Pos is the
+// position of the error in characters, starting at 1.
+type QueryError struct {
+	Pos int
+	Msg string
+}
+
+func (e QueryError) Error() string {
+	return fmt.Sprintf("syntax error at position %d: %s", e.Pos, e.Msg)
+}
+
+// queryColumns maps the fields which can be used in a search query to the
+// columns of the table people.
+var queryColumns = map[string][]string{
+	"name":        {"name"},
+	"title":       {"title"},
+	"department":  {"department"},
+	"street":      {"street"},
+	"postal_code": {"postal_code"},
+	"city":        {"city"},
+	"state":       {"state"},
+	"country":     {"country"},
+	"comment":     {"comment"},
+	"address":     {"street", "postal_code", "city", "state", "country"},
+}
+
+// Fields in a search query which are not columns of the table people.
+const (
+	queryFieldEmail = "email"
+	queryFieldPhone = "phone"
+)
+
+// queryTerm is a single term of a search query. Terms without a field are
+// matched against the full-text search document.
+type queryTerm struct {
+	Field  string
+	Value  string
+	Phrase bool
+	Negate bool
+}
+
+// Query is a parsed search query. The terms within a group are combined with
+// OR, all groups must match.
+type Query struct {
+	groups [][]queryTerm
+}
+
+// Empty returns true if the query does not contain any terms.
+func (q *Query) Empty() bool {
+	return len(q.groups) == 0
+}
+
+// queryScanner splits a query string into terms.
+type queryScanner struct {
+	s   string
+	pos int // byte offset in s
+}
+
+// charPos returns the position in characters of the byte offset, starting at 1.
+func (sc *queryScanner) charPos(offset int) int {
+	return utf8.RuneCountInString(sc.s[:offset]) + 1
+}
+
+func (sc *queryScanner) errorf(offset int, format string, args ...interface{}) error {
+	return QueryError{Pos: sc.charPos(offset), Msg: fmt.Sprintf(format, args...)}
+}
+
+func (sc *queryScanner) skipSpace() {
+	for sc.pos < len(sc.s) {
+		r, n := utf8.DecodeRuneInString(sc.s[sc.pos:])
+		if !unicode.IsSpace(r) {
+			return
+		}
+		sc.pos += n
+	}
+}
+
+// word reads all characters up to the next white space.
+func (sc *queryScanner) word() string {
+	start := sc.pos
+	for sc.pos < len(sc.s) {
+		r, n := utf8.DecodeRuneInString(sc.s[sc.pos:])
+		if unicode.IsSpace(r) {
+			break
+		}
+		sc.pos += n
+	}
+
+	return sc.s[start:sc.pos]
+}
+
+// phrase reads a phrase enclosed in double quotes, sc.pos must point to the
+// opening quote.
+func (sc *queryScanner) phrase() (string, error) {
+	start := sc.pos
+	end := strings.IndexByte(sc.s[start+1:], '"')
+	if end < 0 {
+		return "", sc.errorf(start, "unterminated quote")
+	}
+
+	sc.pos = start + 1 + end + 1
+	phrase := strings.TrimSpace(sc.s[start+1 : start+1+end])
+	if phrase == "" {
+		return "", sc.errorf(start, "empty phrase")
+	}
+
+	return phrase, nil
+}
+
+// isFieldName returns true if s only consists of the characters allowed in
+// field names.
+func isFieldName(s string) bool {
+	for _, r := range s {
+		if !(r >= 'a' && r <= 'z') && r != '_' {
+			return false
+		}
+	}
+
+	return s != ""
+}
+
+// term reads the next term. The returned bool is true if the term is the
+// keyword OR.
+func (sc *queryScanner) term() (queryTerm, bool, error) {
+	var t queryTerm
+	start := sc.pos
+
+	if sc.s[sc.pos] == '-' {
+		t.Negate = true
+		sc.pos++
+		if sc.pos == len(sc.s) || unicode.IsSpace(rune(sc.s[sc.pos])) {
+			return t, false, sc.errorf(start, "missing term after '-'")
+		}
+	}
+
+	// field name
+	fieldStart := sc.pos
+	if i := strings.IndexByte(sc.s[sc.pos:], ':'); i > 0 && isFieldName(sc.s[sc.pos:sc.pos+i]) {
+		t.Field = sc.s[sc.pos : sc.pos+i]
+		if _, ok := queryColumns[t.Field]; !ok && t.Field != queryFieldEmail && t.Field != queryFieldPhone {
+			return t, false, sc.errorf(fieldStart, "unknown field %q", t.Field)
+		}
+
+		sc.pos += i + 1
+		if sc.pos == len(sc.s) || unicode.IsSpace(rune(sc.s[sc.pos])) {
+			return t, false, sc.errorf(fieldStart, "missing value for field %q", t.Field)
+		}
+	}
+
+	if sc.s[sc.pos] == '"' {
+		quoteStart := sc.pos
+		phrase, err := sc.phrase()
+		if err != nil {
+			return t, false, err
+		}
+
+		t.Value = phrase
+		t.Phrase = true
+
+		if t.Field == queryFieldPhone && normalizePhoneNumber(t.Value) == "" {
+			return t, false, sc.errorf(quoteStart, "invalid phone number %q", t.Value)
+		}
+
+		return t, false, nil
+	}
+
+	valueStart := sc.pos
+	t.Value = sc.word()
+	if strings.ContainsRune(t.Value, '"') {
+		return t, false, sc.errorf(valueStart+strings.IndexByte(t.Value, '"'), "unexpected quote")
+	}
+
+	if t.Value == "OR" && t.Field == "" && !t.Negate {
+		return t, true, nil
+	}
+
+	if t.Field == queryFieldPhone && normalizePhoneNumber(t.Value) == "" {
+		return t, false, sc.errorf(valueStart, "invalid phone number %q", t.Value)
+	}
+
+	return t, false, nil
+}
+
+// ParseQuery parses a search query. The query consists of terms separated by
+// white space, all terms must match. A term is either a word, a phrase in
+// double quotes or a field name followed by a colon and a word or phrase,
+// e.g. `city:Köln` or `title:"Head of Sales"`. A term prefixed with a minus
+// must not match. Two terms separated by the keyword OR match if one of them
+// matches, OR binds more strongly than the implicit AND.
+//
+// Syntax errors are returned as a QueryError.
+func ParseQuery(s string) (*Query, error) {
+	q := &Query{}
+	sc := &queryScanner{s: s}
+
+	orPending := false
+	orPos := 0
+	for {
+		sc.skipSpace()
+		if sc.pos == len(sc.s) {
+			break
+		}
+
+		start := sc.pos
+		t, isOr, err := sc.term()
+		if err != nil {
+			return nil, err
+		}
+
+		if isOr {
+			if len(q.groups) == 0 || orPending {
+				return nil, sc.errorf(start, "OR without a preceding term")
+			}
+
+			orPending = true
+			orPos = start
+			continue
+		}
+
+		if orPending {
+			last := len(q.groups) - 1
+			q.groups[last] = append(q.groups[last], t)
+			orPending = false
+			continue
+		}
+
+		q.groups = append(q.groups, []queryTerm{t})
+	}
+
+	if orPending {
+		return nil, sc.errorf(orPos, "OR without a following term")
+	}
+
+	return q, nil
+}
+
+// escapeLike escapes the special characters for the LIKE operator.
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+// toTSPhrase converts a phrase into a query for to_tsquery(), the words must
+// be found next to each other.
+func toTSPhrase(phrase string) string {
+	var words []string
+	for _, word := range strings.Fields(phrase) {
+		words = append(words, tsQuote(word))
+	}
+
+	return strings.Join(words, " <-> ")
+}
+
+// tsquery returns the query for to_tsquery() for the term, which must not
+// have a field.
+func (t queryTerm) tsquery() string {
+	if t.Phrase {
+		return toTSPhrase(t.Value)
+	}
+
+	return toTSQuery(t.Value)
+}
+
+// sql returns the SQL condition for the term. The tables people and
+// people_search (as s) must be available in the query. Parameters are
+// appended to args, the placeholders are numbered accordingly.
+func (t queryTerm) sql(args *[]interface{}) string {
+	param := func(v interface{}) string {
+		*args = append(*args, v)
+		return fmt.Sprintf("$%d", len(*args))
+	}
+
+	var cond string
+	switch t.Field {
+	case "":
+		cond = "s.document @@ to_tsquery('simple', " + param(t.tsquery()) + ")"
+	case queryFieldEmail:
+		cond = "EXISTS (SELECT 1 FROM email_addresses e WHERE e.person_id = people.id AND e.address ILIKE " +
+			param("%"+escapeLike(t.Value)+"%") + ")"
+	case queryFieldPhone:
+		num := normalizePhoneNumber(t.Value)
+		cond = "EXISTS (SELECT 1 FROM phone_numbers n WHERE n.person_id = people.id AND normalize_phone_number(n.number) LIKE " +
+			param("%"+escapeLike(num)+"%") + ")"
+	default:
+		p := param("%" + escapeLike(t.Value) + "%")
+		var conds []string
+		for _, col := range queryColumns[t.Field] {
+			conds = append(conds, "people."+col+" ILIKE "+p)
+		}
+		cond = strings.Join(conds, " OR ")
+		if len(conds) > 1 {
+			cond = "(" + cond + ")"
+		}
+	}
+
+	if t.Negate {
+		cond = "NOT " + cond
+	}
+
+	return cond
+}
+
+// where returns the SQL condition for the query. Parameters are appended to
+// args.
+func (q *Query) where(args *[]interface{}) string {
+	var groups []string
+	for _, group := range q.groups {
+		var conds []string
+		for _, t := range group {
+			conds = append(conds, t.sql(args))
+		}
+
+		if len(conds) == 1 {
+			groups = append(groups, conds[0])
+			continue
+		}
+
+		groups = append(groups, "("+strings.Join(conds, " OR ")+")")
+	}
+
+	if len(groups) == 0 {
+		return "TRUE"
+	}
+
+	return strings.Join(groups, " AND ")
+}
+
+// rankQuery returns the query for to_tsquery() which is used to rank the
+// results and build the snippets. It contains all words and phrases without a
+// field which are not negated.
+func (q *Query) rankQuery() string {
+	var parts []string
+	for _, group := range q.groups {
+		for _, t := range group {
+			if t.Field != "" || t.Negate {
+				continue
+			}
+
+			if tsq := t.tsquery(); tsq != "" {
+				parts = append(parts, "("+tsq+")")
+			}
+		}
+	}
+
+	return strings.Join(parts, " | ")
+}
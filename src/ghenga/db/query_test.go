@@ -0,0 +1,153 @@
+package db
+
+import (
+	"reflect"
+	"testing"
+)
+
+var parseQueryTests = []struct {
+	query  string
+	groups [][]queryTerm
+}{
+	{"", nil},
+	{"  ", nil},
+	{"tamara", [][]queryTerm{
+		{{Value: "tamara"}},
+	}},
+	{"city:Köln title:CEO -department:Testers phone:+49221", [][]queryTerm{
+		{{Field: "city", Value: "Köln"}},
+		{{Field: "title", Value: "CEO"}},
+		{{Field: "department", Value: "Testers", Negate: true}},
+		{{Field: "phone", Value: "+49221"}},
+	}},
+	{`title:"Head of Sales" "Tamara Skibicki"`, [][]queryTerm{
+		{{Field: "title", Value: "Head of Sales", Phrase: true}},
+		{{Value: "Tamara Skibicki", Phrase: true}},
+	}},
+	{"city:Köln OR city:Bonn title:CEO", [][]queryTerm{
+		{{Field: "city", Value: "Köln"}, {Field: "city", Value: "Bonn"}},
+		{{Field: "title", Value: "CEO"}},
+	}},
+	{"a OR b OR -c d", [][]queryTerm{
+		{{Value: "a"}, {Value: "b"}, {Value: "c", Negate: true}},
+		{{Value: "d"}},
+	}},
+	{"or Müller-Lüdenscheidt name:a:b", [][]queryTerm{
+		{{Value: "or"}},
+		{{Value: "Müller-Lüdenscheidt"}},
+		{{Field: "name", Value: "a:b"}},
+	}},
+	{`-"foo  bar"`, [][]queryTerm{
+		{{Value: "foo  bar", Phrase: true, Negate: true}},
+	}},
+}
+
+func TestParseQuery(t *testing.T) {
+	for i, test := range parseQueryTests {
+		q, err := ParseQuery(test.query)
+		if err != nil {
+			t.Errorf("test %d: ParseQuery(%q) returned error: %v", i, test.query, err)
+			continue
+		}
+
+		if !reflect.DeepEqual(q.groups, test.groups) {
+			t.Errorf("test %d: ParseQuery(%q) returned wrong terms:\nwant: %v\n got: %v", i, test.query, test.groups, q.groups)
+		}
+	}
+}
+
+var parseQueryErrorTests = []struct {
+	query string
+	pos   int
+}{
+	{`"foo`, 1},
+	{`city:Köln "foo`, 11},
+	{`""`, 1},
+	{`-`, 1},
+	{`foo -`, 5},
+	{`city:`, 1},
+	{`Köln city: x`, 6},
+	{`foo:bar`, 1},
+	{`OR foo`, 1},
+	{`foo OR OR bar`, 8},
+	{`foo OR`, 5},
+	{`fo"o`, 3},
+	{`phone:abc`, 7},
+	{`äöü phone:"x y"`, 11},
+}
+
+func TestParseQueryErrors(t *testing.T) {
+	for i, test := range parseQueryErrorTests {
+		_, err := ParseQuery(test.query)
+		if err == nil {
+			t.Errorf("test %d: ParseQuery(%q) did not return an error", i, test.query)
+			continue
+		}
+
+		qe, ok := err.(QueryError)
+		if !ok {
+			t.Errorf("test %d: ParseQuery(%q) returned wrong error type %T: %v", i, test.query, err, err)
+			continue
+		}
+
+		if qe.Pos != test.pos {
+			t.Errorf("test %d: ParseQuery(%q) returned wrong position, want %d, got %d (%v)", i, test.query, test.pos, qe.Pos, qe)
+		}
+	}
+}
+
+var queryWhereTests = []struct {
+	query string
+	where string
+	args  []interface{}
+}{
+	{
+		"city:Köln -department:Testers",
+		"people.city ILIKE $1 AND NOT people.department ILIKE $2",
+		[]interface{}{"%Köln%", "%Testers%"},
+	},
+	{
+		"phone:+49221 OR email:foo_bar",
+		"(EXISTS (SELECT 1 FROM phone_numbers n WHERE n.person_id = people.id AND normalize_phone_number(n.number) LIKE $1)" +
+			" OR EXISTS (SELECT 1 FROM email_addresses e WHERE e.person_id = people.id AND e.address ILIKE $2))",
+		[]interface{}{"%0221%", `%foo\_bar%`},
+	},
+	{
+		`tam "head of" address:100%`,
+		"s.document @@ to_tsquery('simple', $1) AND s.document @@ to_tsquery('simple', $2) AND " +
+			"(people.street ILIKE $3 OR people.postal_code ILIKE $3 OR people.city ILIKE $3 OR people.state ILIKE $3 OR people.country ILIKE $3)",
+		[]interface{}{"'tam':*", "'head' <-> 'of'", `%100\%%`},
+	},
+}
+
+func TestQueryWhere(t *testing.T) {
+	for i, test := range queryWhereTests {
+		q, err := ParseQuery(test.query)
+		if err != nil {
+			t.Errorf("test %d: ParseQuery(%q) returned error: %v", i, test.query, err)
+			continue
+		}
+
+		var args []interface{}
+		where := q.where(&args)
+		if where != test.where {
+			t.Errorf("test %d: wrong SQL for %q:\nwant: %v\n got: %v", i, test.query, test.where, where)
+		}
+
+		if !reflect.DeepEqual(args, test.args) {
+			t.Errorf("test %d: wrong args for %q:\nwant: %v\n got: %v", i, test.query, test.args, args)
+		}
+	}
+}
+
+func TestQueryRankQuery(t *testing.T) {
+	q, err := ParseQuery(`tam -ski city:Köln "head of" OR foo`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := "('tam':*) | ('head' <-> 'of') | ('foo':*)"
+	if rq := q.rankQuery(); rq != want {
+		t.Fatalf("wrong rank query, want %q, got %q", want, rq)
+	}
+}
@@ -0,0 +1,204 @@
+package db
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"time"
+
+	"github.com/jmoiron/modl"
+)
+
+// Entities for which revisions are recorded.
+const (
+	RevisionPerson = "person"
+	RevisionUser   = "user"
+)
+
+// Revision is a stored version of a record. Each time a person or a user is
+// saved, the JSON representation of the new version is recorded together
+// with the user who made the change.
+type Revision struct {
+	Entity   string
+	EntityID int64
+	Version  int64
+
+	Data      string
+	ChangedBy sql.NullInt64
+	ChangedAt time.Time
+}
+
+// RevisionJSON is the JSON representation of a Revision.
+type RevisionJSON struct {
+	Version   int64           `json:"version"`
+	ChangedBy *int64          `json:"changed_by"`
+	ChangedAt string          `json:"changed_at"`
+	Data      json.RawMessage `json:"data"`
+}
+
+// MarshalJSON returns the JSON representation of r.
+func (r Revision) MarshalJSON() ([]byte, error) {
+	return json.Marshal(RevisionJSON{
+		Version:   r.Version,
+		ChangedBy: nullInt64JSON(r.ChangedBy),
+		ChangedAt: r.ChangedAt.Format(timeLayout),
+		Data:      json.RawMessage(r.Data),
+	})
+}
+
+func (r Revision) String() string {
+	return fmt.Sprintf("<Revision %v %v version %v>", r.Entity, r.EntityID, r.Version)
+}
+
+// Person returns the person stored in the revision.
+func (r Revision) Person() (*Person, error) {
+	if r.Entity != RevisionPerson {
+		return nil, fmt.Errorf("revision is for %v, not for person", r.Entity)
+	}
+
+	var p Person
+	if err := json.Unmarshal([]byte(r.Data), &p); err != nil {
+		return nil, err
+	}
+
+	return &p, nil
+}
+
+// saveRevision records the current state of item as a new revision.
+func saveRevision(db modl.SqlExecutor, entity string, id, version int64, changedBy sql.NullInt64, item interface{}) error {
+	buf, err := json.Marshal(item)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec(`INSERT INTO revisions (entity, entity_id, version, data, changed_by, changed_at)
+		VALUES ($1, $2, $3, $4, $5, $6)`,
+		entity, id, version, string(buf), changedBy, time.Now())
+	return err
+}
+
+// ListRevisions returns all revisions of the record, the oldest first.
+func (db *DB) ListRevisions(entity string, id int64) ([]*Revision, error) {
+	var revs []*Revision
+	err := db.ex.Select(&revs, `SELECT * FROM revisions
+		WHERE entity = $1 AND entity_id = $2 ORDER BY version`, entity, id)
+	return revs, err
+}
+
+// FindRevision returns a specific revision of a record.
+func (db *DB) FindRevision(entity string, id, version int64) (*Revision, error) {
+	var r Revision
+	err := db.ex.SelectOne(&r, `SELECT * FROM revisions
+		WHERE entity = $1 AND entity_id = $2 AND version = $3`, entity, id, version)
+	if err != nil {
+		return nil, err
+	}
+
+	return &r, nil
+}
+
+// FieldChange describes the change of a single field between two revisions.
+// Fields of nested objects are named with a dot, e.g. "address.city". The
+// values are null if the field is not set.
+type FieldChange struct {
+	Field string          `json:"field"`
+	Old   json.RawMessage `json:"old"`
+	New   json.RawMessage `json:"new"`
+}
+
+// diffIgnoreFields are fields which change with every version and are not
+// included in a diff.
+var diffIgnoreFields = map[string]bool{
+	"version":    true,
+	"changed_at": true,
+	"changed_by": true,
+}
+
+// flattenJSON adds all fields of the JSON object obj to fields, nested
+// objects are flattened with the field names joined by a dot. Lists are not
+// descended into.
+func flattenJSON(fields map[string]interface{}, prefix string, obj map[string]interface{}) {
+	for k, v := range obj {
+		if sub, ok := v.(map[string]interface{}); ok {
+			flattenJSON(fields, prefix+k+".", sub)
+			continue
+		}
+
+		fields[prefix+k] = v
+	}
+}
+
+func decodeFields(data string) (map[string]interface{}, error) {
+	var obj map[string]interface{}
+	dec := json.NewDecoder(bytes.NewReader([]byte(data)))
+	dec.UseNumber()
+	if err := dec.Decode(&obj); err != nil {
+		return nil, err
+	}
+
+	fields := make(map[string]interface{})
+	flattenJSON(fields, "", obj)
+	return fields, nil
+}
+
+func rawJSON(v interface{}, ok bool) (json.RawMessage, error) {
+	if !ok {
+		return json.RawMessage("null"), nil
+	}
+
+	return json.Marshal(v)
+}
+
+// DiffRevisions returns the list of fields which differ between the two
+// revisions, sorted by field name.
+func DiffRevisions(from, to *Revision) ([]FieldChange, error) {
+	oldFields, err := decodeFields(from.Data)
+	if err != nil {
+		return nil, err
+	}
+
+	newFields, err := decodeFields(to.Data)
+	if err != nil {
+		return nil, err
+	}
+
+	names := make(map[string]struct{})
+	for k := range oldFields {
+		names[k] = struct{}{}
+	}
+	for k := range newFields {
+		names[k] = struct{}{}
+	}
+
+	var sorted []string
+	for k := range names {
+		if !diffIgnoreFields[k] {
+			sorted = append(sorted, k)
+		}
+	}
+	sort.Strings(sorted)
+
+	changes := []FieldChange{}
+	for _, k := range sorted {
+		o, oOK := oldFields[k]
+		n, nOK := newFields[k]
+		if oOK == nOK && reflect.DeepEqual(o, n) {
+			continue
+		}
+
+		c := FieldChange{Field: k}
+		if c.Old, err = rawJSON(o, oOK); err != nil {
+			return nil, err
+		}
+		if c.New, err = rawJSON(n, nOK); err != nil {
+			return nil, err
+		}
+
+		changes = append(changes, c)
+	}
+
+	return changes, nil
+}
@@ -0,0 +1,87 @@
+package db
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+var diffRevisionsTests = []struct {
+	from, to string
+	changes  []FieldChange
+}{
+	{
+		from:    `{"name": "foo", "version": 1}`,
+		to:      `{"name": "foo", "version": 2}`,
+		changes: []FieldChange{},
+	},
+	{
+		from: `{"name": "foo", "title": "CEO", "version": 1}`,
+		to:   `{"name": "bar", "version": 2}`,
+		changes: []FieldChange{
+			{Field: "name", Old: json.RawMessage(`"foo"`), New: json.RawMessage(`"bar"`)},
+			{Field: "title", Old: json.RawMessage(`"CEO"`), New: json.RawMessage(`null`)},
+		},
+	},
+	{
+		from: `{"address": {"city": "Köln", "country": "Germany"}}`,
+		to:   `{"address": {"city": "Bonn", "country": "Germany"}}`,
+		changes: []FieldChange{
+			{Field: "address.city", Old: json.RawMessage(`"Köln"`), New: json.RawMessage(`"Bonn"`)},
+		},
+	},
+	{
+		from: `{"phone_numbers": [{"type": "work", "number": "123"}]}`,
+		to:   `{"phone_numbers": []}`,
+		changes: []FieldChange{
+			{Field: "phone_numbers", Old: json.RawMessage(`[{"number":"123","type":"work"}]`), New: json.RawMessage(`[]`)},
+		},
+	},
+}
+
+func TestDiffRevisions(t *testing.T) {
+	for i, test := range diffRevisionsTests {
+		changes, err := DiffRevisions(&Revision{Data: test.from}, &Revision{Data: test.to})
+		if err != nil {
+			t.Errorf("test %d: DiffRevisions() returned error: %v", i, err)
+			continue
+		}
+
+		want := marshal(t, test.changes)
+		got := marshal(t, changes)
+		if string(want) != string(got) {
+			t.Errorf("test %d: wrong changes returned:\nwant:\n%s\ngot:\n%s", i, want, got)
+		}
+	}
+}
+
+func TestPersonRevisions(t *testing.T) {
+	p := NewPerson("Revision Test")
+	if err := testDB.InsertPerson(p); err != nil {
+		t.Fatal(err)
+	}
+
+	p.Title = "CTO"
+	updatePerson(t, testDB, p)
+
+	revs, err := testDB.ListRevisions(RevisionPerson, p.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(revs) != 2 {
+		t.Fatalf("wrong number of revisions, want 2, got %d", len(revs))
+	}
+
+	old, err := revs[0].Person()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if old.Title != "" || old.Version != 1 {
+		t.Fatalf("wrong data in first revision: %v", revs[0].Data)
+	}
+
+	if revs[1].Version != p.Version {
+		t.Fatalf("wrong version of latest revision, want %v, got %v", p.Version, revs[1].Version)
+	}
+}
@@ -0,0 +1,272 @@
+package db
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/modl"
+)
+
+// Permissions which can be granted by a role.
+const (
+	PermPersonRead   = "person:read"
+	PermPersonWrite  = "person:write"
+	PermPersonDelete = "person:delete"
+	PermUserManage   = "user:manage"
+	PermExport       = "export"
+)
+
+// AllPermissions is the list of all permissions.
+var AllPermissions = Permissions{PermPersonRead, PermPersonWrite, PermPersonDelete, PermUserManage, PermExport}
+
+// RoleAdmin is the name of the role which grants full access. It cannot be
+// renamed or removed.
+const RoleAdmin = "admin"
+
+// Permissions is a list of permissions, e.g. "person:read".
+type Permissions []string
+
+// Value returns the permissions as a comma separated list.
+func (p Permissions) Value() (driver.Value, error) {
+	return strings.Join(p, ","), nil
+}
+
+// Scan reads a comma separated list of permissions.
+func (p *Permissions) Scan(src interface{}) error {
+	var s string
+	switch v := src.(type) {
+	case string:
+		s = v
+	case []byte:
+		s = string(v)
+	default:
+		return fmt.Errorf("unable to scan %T into Permissions", src)
+	}
+
+	*p = nil
+	for _, perm := range strings.Split(s, ",") {
+		if perm != "" {
+			*p = append(*p, perm)
+		}
+	}
+
+	return nil
+}
+
+// Has returns true if the list contains perm.
+func (p Permissions) Has(perm string) bool {
+	for _, v := range p {
+		if v == perm {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Validate returns an error if one of the permissions is unknown.
+func (p Permissions) Validate() error {
+	for _, perm := range p {
+		if !AllPermissions.Has(perm) {
+			return fmt.Errorf("unknown permission %q", perm)
+		}
+	}
+
+	return nil
+}
+
+// Role is a named set of permissions which can be assigned to users.
+type Role struct {
+	ID          int64
+	Name        string
+	Description string
+	Permissions Permissions
+
+	ChangedAt time.Time
+	CreatedAt time.Time
+	Version   int64
+}
+
+// RoleJSON is the JSON representation of a Role.
+type RoleJSON struct {
+	ID          int64    `json:"id,omitempty"`
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	Permissions []string `json:"permissions"`
+
+	ChangedAt string `json:"changed_at,omitempty"`
+	CreatedAt string `json:"created_at,omitempty"`
+
+	Version int64 `json:"version"`
+}
+
+// MarshalJSON returns the JSON representation of r.
+func (r Role) MarshalJSON() ([]byte, error) {
+	perms := []string(r.Permissions)
+	if perms == nil {
+		perms = []string{}
+	}
+
+	return json.Marshal(RoleJSON{
+		ID:          r.ID,
+		Name:        r.Name,
+		Description: r.Description,
+		Permissions: perms,
+		ChangedAt:   r.ChangedAt.Format(timeLayout),
+		CreatedAt:   r.CreatedAt.Format(timeLayout),
+		Version:     r.Version,
+	})
+}
+
+// Update updates r with the fields from other.
+func (r *Role) Update(other RoleJSON) {
+	r.Name = other.Name
+	r.Description = other.Description
+	r.Permissions = Permissions(other.Permissions)
+	r.Version = other.Version
+}
+
+// roleNamePattern is the pattern for valid role names.
+var roleNamePattern = regexp.MustCompile(`^[a-z0-9_-]+$`)
+
+// Validate checks if r is valid and returns an error if not.
+func (r *Role) Validate() error {
+	if !roleNamePattern.MatchString(r.Name) {
+		return errors.New("name must consist of lower case letters, digits, '-' and '_'")
+	}
+
+	if err := r.Permissions.Validate(); err != nil {
+		return err
+	}
+
+	if r.CreatedAt.IsZero() || r.ChangedAt.IsZero() {
+		return errors.New("invalid timestamps")
+	}
+
+	return nil
+}
+
+func (r Role) String() string {
+	return fmt.Sprintf("<Role[%v] %v>", r.ID, r.Name)
+}
+
+// FindRole returns the role with the given id.
+func (db *DB) FindRole(id int64) (*Role, error) {
+	var r Role
+
+	err := db.ex.SelectOne(&r, "SELECT * FROM roles WHERE id = $1", id)
+	if err != nil {
+		return nil, err
+	}
+
+	return &r, nil
+}
+
+// ListRoles returns the list of roles.
+func (db *DB) ListRoles() ([]*Role, error) {
+	roles := []*Role{}
+	err := db.ex.Select(&roles, "SELECT * FROM roles ORDER BY name")
+	return roles, err
+}
+
+// InsertRole creates a new role.
+func (db *DB) InsertRole(r *Role) error {
+	return db.ex.Insert(r)
+}
+
+// UpdateRole modifies an existing role. If the role was changed in the
+// meantime, ErrVersionMismatch is returned.
+func (db *DB) UpdateRole(r *Role) error {
+	_, err := db.ex.Update(r)
+	return versionError(err)
+}
+
+// DeleteRole removes a role, it is removed from all users.
+func (db *DB) DeleteRole(id int64) error {
+	res, err := db.ex.Exec("DELETE FROM roles WHERE id = $1", id)
+	if err != nil {
+		return err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if n != 1 {
+		return errors.New("role not found")
+	}
+
+	return nil
+}
+
+// UserPermissions returns the permissions the user is granted by the roles.
+// Users with the role admin are granted all permissions.
+func (db *DB) UserPermissions(u *User) (Permissions, error) {
+	if u.Admin {
+		return AllPermissions, nil
+	}
+
+	var lists []string
+	err := db.ex.Select(&lists, `SELECT r.permissions FROM roles r
+		JOIN user_roles ur ON ur.role_id = r.id WHERE ur.user_id = $1`, u.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	var perms Permissions
+	for _, list := range lists {
+		for _, perm := range strings.Split(list, ",") {
+			if perm != "" && !perms.Has(perm) {
+				perms = append(perms, perm)
+			}
+		}
+	}
+
+	return perms, nil
+}
+
+// selectUserRoles loads the names of the roles assigned to a user.
+func selectUserRoles(db modl.SqlExecutor, userID int64, roles *[]string) error {
+	*roles = []string{}
+	return db.Select(roles, `SELECT r.name FROM roles r
+		JOIN user_roles ur ON ur.role_id = r.id WHERE ur.user_id = $1 ORDER BY r.name`, userID)
+}
+
+// updateUserRoles replaces the roles assigned to a user. An error is
+// returned if one of the roles does not exist.
+func updateUserRoles(db modl.SqlExecutor, userID int64, roles []string) error {
+	if _, err := db.Exec("DELETE FROM user_roles WHERE user_id = $1", userID); err != nil {
+		return err
+	}
+
+	seen := make(map[string]bool)
+	for _, name := range roles {
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+
+		res, err := db.Exec(`INSERT INTO user_roles (user_id, role_id)
+			SELECT $1, id FROM roles WHERE name = $2`, userID, name)
+		if err != nil {
+			return err
+		}
+
+		n, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+
+		if n == 0 {
+			return fmt.Errorf("unknown role %q", name)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,188 @@
+package db
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+var permissionsScanTests = []struct {
+	src   interface{}
+	perms Permissions
+}{
+	{"", nil},
+	{"person:read", Permissions{"person:read"}},
+	{[]byte("person:read,export"), Permissions{"person:read", "export"}},
+	{"person:read,,user:manage", Permissions{"person:read", "user:manage"}},
+}
+
+func TestPermissionsScan(t *testing.T) {
+	for i, test := range permissionsScanTests {
+		var perms Permissions
+		if err := perms.Scan(test.src); err != nil {
+			t.Errorf("test %d: Scan(%q) returned error: %v", i, test.src, err)
+			continue
+		}
+
+		if !reflect.DeepEqual(perms, test.perms) {
+			t.Errorf("test %d: Scan(%q) returned %v, want %v", i, test.src, perms, test.perms)
+		}
+
+		v, err := perms.Value()
+		if err != nil {
+			t.Errorf("test %d: Value() returned error: %v", i, err)
+			continue
+		}
+
+		var perms2 Permissions
+		if err = perms2.Scan(v); err != nil || !reflect.DeepEqual(perms, perms2) {
+			t.Errorf("test %d: %v does not survive Value/Scan, got %v (%v)", i, perms, perms2, err)
+		}
+	}
+}
+
+func TestPermissionsValidate(t *testing.T) {
+	if err := AllPermissions.Validate(); err != nil {
+		t.Errorf("AllPermissions is invalid: %v", err)
+	}
+
+	if err := (Permissions{"person:read", "person:fly"}).Validate(); err == nil {
+		t.Errorf("unknown permission was accepted")
+	}
+}
+
+var userUpdateRolesTests = []struct {
+	roles []string
+	other UserJSON
+	want  []string
+	admin bool
+}{
+	{nil, UserJSON{Roles: []string{"sales"}}, []string{"sales"}, false},
+	{[]string{"sales"}, UserJSON{}, []string{"sales"}, false},
+	{[]string{"sales"}, UserJSON{Admin: true}, []string{"sales", "admin"}, true},
+	{[]string{"sales", "admin"}, UserJSON{Admin: false}, []string{"sales"}, false},
+	{[]string{"sales", "admin"}, UserJSON{Admin: true}, []string{"sales", "admin"}, true},
+	{nil, UserJSON{Roles: []string{"admin"}}, []string{"admin"}, true},
+	{[]string{"admin"}, UserJSON{Admin: false, Roles: []string{"admin", "viewer"}}, []string{"viewer"}, false},
+}
+
+func TestUserUpdateRoles(t *testing.T) {
+	for i, test := range userUpdateRolesTests {
+		var u User
+		u.SetRoles(test.roles)
+		u.Update(test.other)
+
+		if !reflect.DeepEqual(u.Roles, test.want) {
+			t.Errorf("test %d: wrong roles, want %v, got %v", i, test.want, u.Roles)
+		}
+
+		if u.Admin != test.admin {
+			t.Errorf("test %d: wrong admin flag, want %v, got %v", i, test.admin, u.Admin)
+		}
+	}
+}
+
+func TestRoleCRUD(t *testing.T) {
+	r := &Role{
+		Name:        "support",
+		Description: "Read people and export",
+		Permissions: Permissions{PermPersonRead, PermExport},
+		CreatedAt:   time.Now(),
+		ChangedAt:   time.Now(),
+	}
+
+	if err := testDB.InsertRole(r); err != nil {
+		t.Fatalf("unable to insert role: %v", err)
+	}
+
+	u, err := testDB.FindUserName("user")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(u.Roles, []string{"manager"}) {
+		t.Fatalf("wrong roles for user: %v", u.Roles)
+	}
+
+	u.SetRoles([]string{"support", "viewer"})
+	if err = testDB.UpdateUser(u); err != nil {
+		t.Fatal(err)
+	}
+
+	u, err = testDB.FindUserName("user")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	perms, err := testDB.UserPermissions(u)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(perms, Permissions{PermPersonRead, PermExport}) {
+		t.Errorf("wrong permissions: %v", perms)
+	}
+
+	if err = testDB.DeleteRole(r.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	u, err = testDB.FindUserName("user")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(u.Roles, []string{"viewer"}) {
+		t.Errorf("deleted role was not removed from user: %v", u.Roles)
+	}
+
+	u.SetRoles([]string{"manager"})
+	if err = testDB.UpdateUser(u); err != nil {
+		t.Fatal(err)
+	}
+
+	u.SetRoles([]string{"nonexistent"})
+	if err = testDB.UpdateUser(u); err == nil {
+		t.Errorf("unknown role was accepted")
+	}
+}
+
+func TestUserPermissionsAdmin(t *testing.T) {
+	u, err := testDB.FindUserName("admin")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !u.Admin {
+		t.Fatalf("user admin does not have the role admin: %v", u.Roles)
+	}
+
+	perms, err := testDB.UserPermissions(u)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(perms, AllPermissions) {
+		t.Errorf("wrong permissions for admin: %v", perms)
+	}
+}
+
+func TestListUsersRole(t *testing.T) {
+	for _, test := range []struct {
+		filter map[string]string
+		login  string
+	}{
+		{map[string]string{"role": "manager"}, "user"},
+		{map[string]string{"role": RoleAdmin}, "admin"},
+		{map[string]string{"admin": "true"}, "admin"},
+	} {
+		users, _, err := testDB.ListUsersPage(ListOptions{Limit: 10, Filter: test.filter})
+		if err != nil {
+			t.Fatalf("filter %v: %v", test.filter, err)
+		}
+
+		if len(users) != 1 || users[0].Login != test.login {
+			t.Errorf("filter %v: want user %v, got %v", test.filter, test.login, users)
+		}
+	}
+}
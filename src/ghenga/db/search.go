@@ -1,14 +1,185 @@
 package db
 
+import (
+	"encoding/json"
+	"strings"
+)
+
 // FuzzyFindPersons searches the database for persons related to the query
-// string.
+// string. The query is matched against the name and all email addresses.
 func (db *DB) FuzzyFindPersons(query string) ([]*Person, error) {
 	var result []*Person
 
-	err := db.dbmap.Select(&result, "SELECT * FROM people WHERE name ILIKE $1", "%"+query+"%")
+	args := []interface{}{"%" + query + "%"}
+	err := db.ex.Select(&result, `SELECT * FROM people WHERE (name ILIKE $1
+		OR id IN (SELECT person_id FROM email_addresses WHERE address ILIKE $1))
+		AND `+db.visiblePeople(&args), args...)
 	if err != nil {
 		return nil, err
 	}
 
 	return result, nil
 }
+
+// SearchResult is a person found by a full-text search. Snippets contains
+// the fields which matched the query, escaped for HTML, with the matching
+// words enclosed in <b> and </b>.
+type SearchResult struct {
+	Person   *Person
+	Rank     float64
+	Snippets map[string]string
+}
+
+// SearchResultJSON is the JSON representation of a SearchResult.
+type SearchResultJSON struct {
+	Person   *Person           `json:"person"`
+	Rank     float64           `json:"rank"`
+	Snippets map[string]string `json:"snippets"`
+}
+
+// MarshalJSON returns the JSON representation of r.
+func (r SearchResult) MarshalJSON() ([]byte, error) {
+	return json.Marshal(SearchResultJSON{
+		Person:   r.Person,
+		Rank:     r.Rank,
+		Snippets: r.Snippets,
+	})
+}
+
+// Markers for the matching words in search snippets.
+const (
+	highlightStart = "<b>"
+	highlightStop  = "</b>"
+)
+
+// Markers for the matching words inserted by ts_headline(). The snippets
+// contain the fields as they were entered, so the markers are replaced with
+// highlightStart and highlightStop after the snippet has been escaped.
+const (
+	headlineStart = "\x02"
+	headlineStop  = "\x03"
+)
+
+// headlineOptions are the options for ts_headline() used to build the
+// snippets.
+const headlineOptions = `StartSel="` + headlineStart + `", StopSel="` + headlineStop +
+	`", MaxWords=20, MinWords=5, MaxFragments=2`
+
+// highlighter escapes a snippet for HTML and replaces the markers.
+var highlighter = strings.NewReplacer(
+	"&", "&amp;", "<", "&lt;", ">", "&gt;", `"`, "&#34;", "'", "&#39;",
+	headlineStart, highlightStart, headlineStop, highlightStop)
+
+// toTSQuery converts a user supplied search string into a query for
+// to_tsquery(). All words must match, and each word also matches as a
+// prefix, so that "tam ski" finds "Tamara Skibicki". Each word is quoted, so
+// that operators in the search string are not interpreted.
+func toTSQuery(query string) string {
+	var words []string
+	for _, word := range strings.Fields(query) {
+		words = append(words, tsQuote(word)+":*")
+	}
+
+	return strings.Join(words, " & ")
+}
+
+// tsQuote quotes word for use in a query for to_tsquery().
+func tsQuote(word string) string {
+	word = strings.Replace(word, `\`, `\\`, -1)
+	word = strings.Replace(word, `'`, `''`, -1)
+	return "'" + word + "'"
+}
+
+// searchRow is a row returned by the full-text search query.
+type searchRow struct {
+	ID   int64
+	Rank float64
+
+	Name           string
+	Title          string
+	Department     string
+	EmailAddresses string
+	PhoneNumbers   string
+	Address        string
+	Comment        string
+}
+
+// snippets returns the highlighted fields which contain a match.
+func (r searchRow) snippets() map[string]string {
+	snippets := make(map[string]string)
+	for name, s := range map[string]string{
+		"name":            r.Name,
+		"title":           r.Title,
+		"department":      r.Department,
+		"email_addresses": r.EmailAddresses,
+		"phone_numbers":   r.PhoneNumbers,
+		"address":         r.Address,
+		"comment":         r.Comment,
+	} {
+		if strings.Contains(s, headlineStart) {
+			snippets[name] = highlighter.Replace(s)
+		}
+	}
+
+	return snippets
+}
+
+// SearchPeople searches all fields of people, including their email
+// addresses and phone numbers. The query is parsed with ParseQuery, syntax
+// errors are returned as a QueryError. The results are ordered by their rank
+// for the words and phrases of the query without a field, the best matches
+// first. At most limit results are returned, starting at offset.
+func (db *DB) SearchPeople(query string, limit, offset int) ([]*SearchResult, error) {
+	results := []*SearchResult{}
+
+	q, err := ParseQuery(query)
+	if err != nil {
+		return nil, err
+	}
+
+	if q.Empty() {
+		return results, nil
+	}
+
+	args := []interface{}{q.rankQuery(), headlineOptions, limit, offset}
+	where := q.where(&args) + " AND " + db.visiblePeople(&args)
+
+	var rows []searchRow
+	err = db.ex.Select(&rows, `WITH q AS (SELECT to_tsquery('simple', $1) AS query),
+		m AS (SELECT people.id, ts_rank(s.document, q.query) AS rank
+			FROM people JOIN people_search s ON s.person_id = people.id, q
+			WHERE `+where+`
+			ORDER BY rank DESC, people.id
+			LIMIT $3 OFFSET $4)
+		SELECT m.id, m.rank,
+			ts_headline('simple', p.name, q.query, $2) AS name,
+			ts_headline('simple', p.title, q.query, $2) AS title,
+			ts_headline('simple', p.department, q.query, $2) AS department,
+			ts_headline('simple', coalesce((SELECT string_agg(address, ', ') FROM email_addresses e
+				WHERE e.person_id = p.id), ''), q.query, $2) AS email_addresses,
+			ts_headline('simple', coalesce((SELECT string_agg(number, ', ') FROM phone_numbers n
+				WHERE n.person_id = p.id), ''), q.query, $2) AS phone_numbers,
+			ts_headline('simple', concat_ws(', ', nullif(p.street, ''), nullif(p.postal_code || ' ' || p.city, ' '),
+				nullif(p.state, ''), nullif(p.country, '')), q.query, $2) AS address,
+			ts_headline('simple', p.comment, q.query, $2) AS comment
+		FROM m JOIN people p ON p.id = m.id, q
+		ORDER BY m.rank DESC, m.id`, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, row := range rows {
+		p, err := db.FindPerson(row.ID)
+		if err != nil {
+			return nil, err
+		}
+
+		results = append(results, &SearchResult{
+			Person:   p,
+			Rank:     row.Rank,
+			Snippets: row.snippets(),
+		})
+	}
+
+	return results, nil
+}
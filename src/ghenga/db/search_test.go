@@ -1,11 +1,18 @@
 package db
 
-import "testing"
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
 
 var searchTestPersons = []Person{
 	Person{
-		Name:         "Tamara Skibicki",
-		EmailAddress: "pit@ackermannsehls.org",
+		Name: "Tamara Skibicki",
+		EmailAddresses: []EmailAddress{
+			{Type: "work", Address: "pit@ackermannsehls.org", Primary: true},
+			{Type: "private", Address: "tamara@example.com"},
+		},
 		PhoneNumbers: []PhoneNumber{
 			{Type: "work", Number: "(03867) 3074101"},
 			{Type: "mobile", Number: "+49-077-1634655"},
@@ -17,11 +24,13 @@ var searchTestPersons = []Person{
 		Version:   23,
 	},
 	Person{
-		Name:         "Mario Drees",
-		EmailAddress: "bela_freigang@herweg.com",
-		ChangedAt:    parseTime("2016-04-24T10:30:07+00:00"),
-		CreatedAt:    parseTime("2016-04-24T10:30:07+00:00"),
-		Version:      1,
+		Name: "Mario Drees",
+		EmailAddresses: []EmailAddress{
+			{Type: "work", Address: "bela_freigang@herweg.com", Primary: true},
+		},
+		ChangedAt: parseTime("2016-04-24T10:30:07+00:00"),
+		CreatedAt: parseTime("2016-04-24T10:30:07+00:00"),
+		Version:   1,
 	},
 }
 
@@ -87,6 +96,16 @@ var fuzzyFindTests = []struct {
 		query: "a",
 		in:    []Person{searchTestPersons[0], searchTestPersons[1]},
 	},
+	{
+		query: "herweg.com",
+		in:    []Person{searchTestPersons[1]},
+		out:   []Person{searchTestPersons[0]},
+	},
+	{
+		query: "tamara@example",
+		in:    []Person{searchTestPersons[0]},
+		out:   []Person{searchTestPersons[1]},
+	},
 	{
 		query: "y",
 		out:   []Person{searchTestPersons[0], searchTestPersons[1]},
@@ -105,3 +124,187 @@ func TestFuzzyFindPersons(t *testing.T) {
 		fuzzyFindPersons(t, testDB, test.query, test.in, test.out)
 	}
 }
+
+var toTSQueryTests = []struct {
+	query, tsquery string
+}{
+	{"", ""},
+	{"   ", ""},
+	{"tamara", "'tamara':*"},
+	{"Tamara  Skibicki", "'Tamara':* & 'Skibicki':*"},
+	{"foo|bar & !baz", "'foo|bar':* & '&':* & '!baz':*"},
+	{`o'neil back\slash`, `'o''neil':* & 'back\\slash':*`},
+}
+
+func TestToTSQuery(t *testing.T) {
+	for i, test := range toTSQueryTests {
+		res := toTSQuery(test.query)
+		if res != test.tsquery {
+			t.Errorf("test %d: toTSQuery(%q) returned %q, want %q", i, test.query, res, test.tsquery)
+		}
+	}
+}
+
+var fullTextTestPersons = []Person{
+	{
+		Name:       "Annegret Wurzelbach",
+		Title:      "Head of Purchasing",
+		Department: "Einkauf",
+		EmailAddresses: []EmailAddress{
+			{Type: "work", Address: "annegret@wurzelbach-gmbh.de", Primary: true},
+		},
+		PhoneNumbers: []PhoneNumber{
+			{Type: "work", Number: "+49 (221) 987-6543"},
+		},
+		City:      "Köln",
+		Country:   "Germany",
+		Comment:   "prefers contact via telephone",
+		ChangedAt: parseTime("2016-04-24T10:30:07+00:00"),
+		CreatedAt: parseTime("2016-04-24T10:30:07+00:00"),
+	},
+	{
+		Name:       "Bertold Wurzelbach",
+		Title:      "Software Developer",
+		Department: "Entwicklung",
+		City:       "Bonn",
+		Country:    "Germany",
+		Comment:    "met at the purchasing fair",
+		ChangedAt:  parseTime("2016-04-24T10:30:07+00:00"),
+		CreatedAt:  parseTime("2016-04-24T10:30:07+00:00"),
+	},
+}
+
+var searchPeopleTests = []struct {
+	query    string
+	names    []string
+	snippets []string
+}{
+	// multi-word queries require all words to match
+	{"wurzelbach köln", []string{"Annegret Wurzelbach"}, []string{"name", "address"}},
+	{"wurzelbach bonn", []string{"Bertold Wurzelbach"}, []string{"name", "address"}},
+	{"wurzelbach germany", []string{"Annegret Wurzelbach", "Bertold Wurzelbach"}, nil},
+	{"wurzelbach paris", nil, nil},
+	// prefixes match
+	{"wurz annegr", []string{"Annegret Wurzelbach"}, []string{"name"}},
+	// words may match in different fields
+	{"bertold purchasing", []string{"Bertold Wurzelbach"}, []string{"name", "comment"}},
+	{"head purchasing", []string{"Annegret Wurzelbach"}, []string{"title"}},
+	{"annegret@wurzelbach-gmbh.de", []string{"Annegret Wurzelbach"}, []string{"email_addresses"}},
+	// phone numbers are found with or without formatting
+	{"0221 987", []string{"Annegret Wurzelbach"}, []string{"phone_numbers"}},
+	{"02219876543", []string{"Annegret Wurzelbach"}, nil},
+	// structured queries
+	{"name:wurzelbach city:Köln", []string{"Annegret Wurzelbach"}, nil},
+	{"name:wurzelbach -city:köln", []string{"Bertold Wurzelbach"}, nil},
+	{"name:wurzelbach city:Bonn OR city:Köln", []string{"Annegret Wurzelbach", "Bertold Wurzelbach"}, nil},
+	{"name:wurzelbach phone:+49221", []string{"Annegret Wurzelbach"}, nil},
+	{"name:wurzelbach -phone:+49221", []string{"Bertold Wurzelbach"}, nil},
+	{"email:wurzelbach-gmbh", []string{"Annegret Wurzelbach"}, nil},
+	{`"head of purchasing"`, []string{"Annegret Wurzelbach"}, []string{"title"}},
+	{`"purchasing head"`, nil, nil},
+	{`wurzelbach title:"software developer"`, []string{"Bertold Wurzelbach"}, []string{"name"}},
+	{`wurzelbach -"purchasing fair"`, []string{"Annegret Wurzelbach"}, []string{"name"}},
+}
+
+func TestSearchPeople(t *testing.T) {
+	for _, p := range fullTextTestPersons {
+		err := testDB.InsertPerson(&p)
+		if err != nil {
+			t.Fatalf("insert test persons returned error %v", err)
+		}
+	}
+
+	for i, test := range searchPeopleTests {
+		results, err := testDB.SearchPeople(test.query, 100, 0)
+		if err != nil {
+			t.Errorf("test %d: SearchPeople(%q) returned error: %v", i, test.query, err)
+			continue
+		}
+
+		var names []string
+		for _, r := range results {
+			names = append(names, r.Person.Name)
+		}
+
+		if len(names) != len(test.names) {
+			t.Errorf("test %d: SearchPeople(%q) returned %v, want %v", i, test.query, names, test.names)
+			continue
+		}
+
+		for _, name := range test.names {
+			found := false
+			for _, n := range names {
+				if n == name {
+					found = true
+				}
+			}
+
+			if !found {
+				t.Errorf("test %d: SearchPeople(%q) did not return %v, got %v", i, test.query, name, names)
+			}
+		}
+
+		if len(results) == 0 {
+			continue
+		}
+
+		for _, field := range test.snippets {
+			s, ok := results[0].Snippets[field]
+			if !ok || !strings.Contains(s, highlightStart) {
+				t.Errorf("test %d: SearchPeople(%q) has no highlighted snippet for %v: %v",
+					i, test.query, field, results[0].Snippets)
+			}
+		}
+	}
+}
+
+func TestSearchSnippets(t *testing.T) {
+	row := searchRow{
+		Name:    "Tamara " + headlineStart + "Skibicki" + headlineStop,
+		Title:   "Head of purchasing",
+		Comment: `<img src=x onerror="alert(1)"> & ` + headlineStart + "Skibicki" + headlineStop + "'s <script>",
+	}
+
+	want := map[string]string{
+		"name":    "Tamara <b>Skibicki</b>",
+		"comment": "&lt;img src=x onerror=&#34;alert(1)&#34;&gt; &amp; <b>Skibicki</b>&#39;s &lt;script&gt;",
+	}
+
+	if got := row.snippets(); !reflect.DeepEqual(got, want) {
+		t.Errorf("wrong snippets, want:\n  %q\ngot:\n  %q", want, got)
+	}
+}
+
+func TestSearchPeopleSyntaxError(t *testing.T) {
+	_, err := testDB.SearchPeople(`city:Köln "foo`, 10, 0)
+	if _, ok := err.(QueryError); !ok {
+		t.Fatalf("expected QueryError, got %T: %v", err, err)
+	}
+}
+
+func TestSearchPeopleRank(t *testing.T) {
+	for _, p := range []*Person{NewPerson("Ranking Quaxelbeck"), NewPerson("Someone Else")} {
+		if p.Name == "Someone Else" {
+			p.Comment = "quaxelbeck knows this person"
+		}
+
+		if err := testDB.InsertPerson(p); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	results, err := testDB.SearchPeople("quaxelbeck", 10, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(results) != 2 {
+		t.Fatalf("wrong number of results, want 2, got %d", len(results))
+	}
+
+	// a match in the name is ranked higher than a match in the comment
+	if results[0].Person.Name != "Ranking Quaxelbeck" || results[0].Rank <= results[1].Rank {
+		t.Fatalf("wrong order of results: %v (%v), %v (%v)",
+			results[0].Person, results[0].Rank, results[1].Person, results[1].Rank)
+	}
+}
@@ -13,6 +13,12 @@ type Session struct {
 	Token      string
 	User       string
 	ValidUntil time.Time
+
+	// APITokenID is the ID of the personal API token the request was
+	// authenticated with, the session is not saved in the database then.
+	// ReadOnly is set for sessions of read-only tokens.
+	APITokenID int64 `db:"-"`
+	ReadOnly   bool  `db:"-"`
 }
 
 func (s Session) String() string {
@@ -46,7 +52,7 @@ func (db *DB) SaveNewSession(user string, valid time.Duration) (*Session, error)
 		return nil, err
 	}
 
-	err = db.dbmap.Insert(s)
+	err = db.ex.Insert(s)
 	if err != nil {
 		return nil, err
 	}
@@ -57,7 +63,7 @@ func (db *DB) SaveNewSession(user string, valid time.Duration) (*Session, error)
 // FindSession searches the session with the given token in the database.
 func (db *DB) FindSession(token string) (*Session, error) {
 	var s Session
-	err := db.dbmap.SelectOne(&s, "SELECT * FROM sessions WHERE token = $1", token)
+	err := db.ex.SelectOne(&s, "SELECT * FROM sessions WHERE token = $1", token)
 	if err != nil {
 		return nil, err
 	}
@@ -67,13 +73,17 @@ func (db *DB) FindSession(token string) (*Session, error) {
 
 // ExpireSessions removes expired sessions from the db.
 func (db *DB) ExpireSessions() (sessionsRemoved int64, err error) {
-	res := db.dbmap.Dbx.MustExec("DELETE FROM sessions WHERE valid_until < now()")
+	res, err := db.ex.Exec("DELETE FROM sessions WHERE valid_until < now()")
+	if err != nil {
+		return 0, err
+	}
+
 	return res.RowsAffected()
 }
 
 // Invalidate removes the session from the database.
 func (db *DB) Invalidate(s *Session) error {
-	_, err := db.dbmap.Delete(s)
+	_, err := db.ex.Delete(s)
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,258 @@
+package db
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Valid values for the status of a task.
+const (
+	TaskOpen      = "open"
+	TaskDone      = "done"
+	TaskCancelled = "cancelled"
+)
+
+// Task is something that needs to be done concerning a person. It is assigned
+// to a user.
+type Task struct {
+	ID          int64
+	Title       string
+	Description string
+
+	PersonID   int64
+	AccountID  sql.NullInt64
+	AssigneeID sql.NullInt64
+
+	DueDate  pq.NullTime
+	Priority int
+	Status   string
+
+	ChangedAt time.Time
+	CreatedAt time.Time
+	Version   int64
+}
+
+// TaskJSON is the JSON representation of a Task as returned or consumed by the
+// API.
+type TaskJSON struct {
+	ID          int64  `json:"id,omitempty"`
+	Title       string `json:"title,omitempty"`
+	Description string `json:"description,omitempty"`
+
+	PersonID   int64  `json:"person_id"`
+	AccountID  *int64 `json:"account_id,omitempty"`
+	AssigneeID *int64 `json:"assignee_id,omitempty"`
+
+	DueDate  *string `json:"due_date,omitempty"`
+	Priority int     `json:"priority"`
+	Status   string  `json:"status,omitempty"`
+
+	ChangedAt string `json:"changed_at,omitempty"`
+	CreatedAt string `json:"created_at,omitempty"`
+
+	Version int64 `json:"version"`
+}
+
+// NewTask returns a new open task for the person.
+func NewTask(title string, personID int64) *Task {
+	ts := time.Now()
+	return &Task{
+		Title:     title,
+		PersonID:  personID,
+		Status:    TaskOpen,
+		CreatedAt: ts,
+		ChangedAt: ts,
+	}
+}
+
+// MarshalJSON returns the JSON representation of t.
+func (t Task) MarshalJSON() ([]byte, error) {
+	jt := TaskJSON{
+		ID:          t.ID,
+		Title:       t.Title,
+		Description: t.Description,
+
+		PersonID:   t.PersonID,
+		AccountID:  nullInt64JSON(t.AccountID),
+		AssigneeID: nullInt64JSON(t.AssigneeID),
+
+		DueDate:  nullTimeJSON(t.DueDate),
+		Priority: t.Priority,
+		Status:   t.Status,
+
+		ChangedAt: t.ChangedAt.Format(timeLayout),
+		CreatedAt: t.CreatedAt.Format(timeLayout),
+		Version:   t.Version,
+	}
+
+	return json.Marshal(jt)
+}
+
+// UnmarshalJSON returns a task from JSON.
+func (t *Task) UnmarshalJSON(data []byte) error {
+	var jt TaskJSON
+
+	err := json.Unmarshal(data, &jt)
+	if err != nil {
+		return err
+	}
+
+	createdAt, err := time.Parse(timeLayout, jt.CreatedAt)
+	if err != nil {
+		return err
+	}
+
+	changedAt, err := time.Parse(timeLayout, jt.ChangedAt)
+	if err != nil {
+		return err
+	}
+
+	*t = Task{
+		ID:        jt.ID,
+		CreatedAt: createdAt,
+		ChangedAt: changedAt,
+	}
+
+	return t.Update(jt)
+}
+
+// Validate checks if t is valid and returns an error if not.
+func (t *Task) Validate() error {
+	if t.Title == "" {
+		return errors.New("title is empty")
+	}
+
+	if t.PersonID == 0 {
+		return errors.New("person is not set")
+	}
+
+	switch t.Status {
+	case TaskOpen, TaskDone, TaskCancelled:
+	default:
+		return fmt.Errorf("invalid status %q", t.Status)
+	}
+
+	if t.Priority < 0 {
+		return errors.New("priority must not be negative")
+	}
+
+	if t.CreatedAt.IsZero() || t.ChangedAt.IsZero() {
+		return errors.New("invalid timestamps")
+	}
+
+	return nil
+}
+
+// Update updates t with the fields from other. When other does not specify a
+// status, the task is open.
+func (t *Task) Update(other TaskJSON) error {
+	dueDate, err := jsonNullTime(other.DueDate)
+	if err != nil {
+		return err
+	}
+
+	t.Title = other.Title
+	t.Description = other.Description
+
+	t.PersonID = other.PersonID
+	t.AccountID = jsonNullInt64(other.AccountID)
+	t.AssigneeID = jsonNullInt64(other.AssigneeID)
+
+	t.DueDate = dueDate
+	t.Priority = other.Priority
+
+	t.Status = other.Status
+	if t.Status == "" {
+		t.Status = TaskOpen
+	}
+
+	t.Version = other.Version
+
+	return nil
+}
+
+func (t Task) String() string {
+	return fmt.Sprintf("<Task[%v] (%v, %v)>", t.ID, t.Title, t.Status)
+}
+
+// FindTask returns the task with the given id.
+func (db *DB) FindTask(id int64) (*Task, error) {
+	var t Task
+
+	err := db.ex.SelectOne(&t, "SELECT * FROM tasks WHERE id = $1", id)
+	if err != nil {
+		return nil, err
+	}
+
+	return &t, nil
+}
+
+// UpdateTask modifies an existing task.
+func (db *DB) UpdateTask(t *Task) error {
+	_, err := db.ex.Update(t)
+	return err
+}
+
+// InsertTask creates a new task.
+func (db *DB) InsertTask(t *Task) error {
+	return db.ex.Insert(t)
+}
+
+// ListTasks returns the list of tasks for people visible to the viewer.
+func (db *DB) ListTasks() ([]*Task, error) {
+	var tasks []*Task
+	var args []interface{}
+	err := db.ex.Select(&tasks, `SELECT tasks.* FROM tasks JOIN people ON people.id = tasks.person_id
+		WHERE `+db.visiblePeople(&args), args...)
+	return tasks, err
+}
+
+// ListUserTasks returns the tasks assigned to the user, ordered by due date.
+// When status is not empty, only tasks with that status are returned. When
+// dueBefore is not zero, only tasks due before that time are returned. Tasks
+// for people which are not visible to the viewer are left out.
+func (db *DB) ListUserTasks(userID int64, status string, dueBefore time.Time) ([]*Task, error) {
+	args := []interface{}{userID}
+	query := `SELECT tasks.* FROM tasks JOIN people ON people.id = tasks.person_id
+		WHERE tasks.assignee_id = $1 AND ` + db.visiblePeople(&args)
+
+	if status != "" {
+		args = append(args, status)
+		query += fmt.Sprintf(" AND tasks.status = $%d", len(args))
+	}
+
+	if !dueBefore.IsZero() {
+		args = append(args, dueBefore)
+		query += fmt.Sprintf(" AND tasks.due_date < $%d", len(args))
+	}
+
+	query += " ORDER BY tasks.due_date ASC NULLS LAST, tasks.priority DESC, tasks.id"
+
+	var tasks []*Task
+	err := db.ex.Select(&tasks, query, args...)
+	return tasks, err
+}
+
+// DeleteTask removes a task.
+func (db *DB) DeleteTask(id int64) error {
+	res, err := db.ex.Exec("delete from tasks where id = $1", id)
+	if err != nil {
+		return err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if n != 1 {
+		return errors.New("task not found")
+	}
+
+	return nil
+}
@@ -0,0 +1,205 @@
+package db
+
+import (
+	"bytes"
+	"database/sql"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+var testTasks = []struct {
+	name string
+	t    Task
+}{
+	{
+		name: "testtask1",
+		t: Task{
+			Title:       "Call back",
+			Description: "Ask about the new offer",
+			PersonID:    3,
+			AssigneeID:  sql.NullInt64{Int64: 1, Valid: true},
+			DueDate:     pq.NullTime{Time: parseTime("2016-05-02T12:00:00+00:00"), Valid: true},
+			Priority:    2,
+			Status:      TaskOpen,
+			ChangedAt:   parseTime("2016-04-24T10:30:07+00:00"),
+			CreatedAt:   parseTime("2016-04-24T10:30:07+00:00"),
+			Version:     3,
+		},
+	},
+	{
+		name: "testtask2",
+		t: Task{
+			Title:     "Send brochure",
+			PersonID:  5,
+			AccountID: sql.NullInt64{Int64: 7, Valid: true},
+			Status:    TaskDone,
+			ChangedAt: parseTime("2016-04-24T10:30:07+00:00"),
+			CreatedAt: parseTime("2016-04-24T10:30:07+00:00"),
+			Version:   1,
+		},
+	},
+}
+
+func TestTaskMarshal(t *testing.T) {
+	for i, test := range testTasks {
+		buf := marshal(t, test.t)
+
+		golden := filepath.Join("testdata", "TestTaskMarshal_"+test.name+".golden")
+		if *update {
+			err := ioutil.WriteFile(golden, buf, 0644)
+			if err != nil {
+				t.Fatalf("test %d: update golden file %v failed: %v", i, golden, err)
+			}
+		}
+
+		expected, err := ioutil.ReadFile(golden)
+		if err != nil {
+			t.Errorf("test %d: unable to read golden file %v", i, golden)
+			continue
+		}
+		if !bytes.Equal(buf, expected) {
+			t.Errorf("test %d (%v) wrong JSON returned:\nwant:\n%s\ngot:\n%s", i, test.name, expected, buf)
+		}
+	}
+}
+
+func TestTaskUnmarshal(t *testing.T) {
+	for i, test := range testTasks {
+		golden := filepath.Join("testdata", "TestTaskMarshal_"+test.name+".golden")
+		buf, err := ioutil.ReadFile(golden)
+		if err != nil {
+			t.Errorf("test %d: unable to read golden file %v", i, golden)
+			continue
+		}
+
+		var task Task
+		unmarshal(t, buf, &task)
+
+		buf2 := marshal(t, task)
+
+		if !bytes.Equal(buf, buf2) {
+			t.Errorf("test %d (%v) wrong JSON returned:\nwant:\n%s\ngot:\n%s", i, test.name, buf, buf2)
+		}
+	}
+}
+
+var testTaskValidate = []struct {
+	name  string
+	valid bool
+	t     Task
+}{
+	{
+		name:  "notitle",
+		valid: false,
+		t:     Task{PersonID: 1, Status: TaskOpen},
+	},
+	{
+		name:  "noperson",
+		valid: false,
+		t:     Task{Title: "foo", Status: TaskOpen},
+	},
+	{
+		name:  "invalidstatus",
+		valid: false,
+		t:     Task{Title: "foo", PersonID: 1, Status: "postponed"},
+	},
+}
+
+func TestTaskValidate(t *testing.T) {
+	for i, test := range testTasks {
+		if err := test.t.Validate(); err != nil {
+			t.Errorf("test %v (%v) failed: testTask is invalid: %v", test.name, i, err)
+		}
+	}
+
+	for i, test := range testTaskValidate {
+		err := test.t.Validate()
+		if test.valid && err != nil {
+			t.Errorf("test %v (%v) failed: testTask should be valid but is invalid: %v", test.name, i, err)
+		}
+
+		if !test.valid && err == nil {
+			t.Errorf("test %v (%v) failed: testTask should be invalid but is valid", test.name, i)
+		}
+	}
+}
+
+func TestTaskInsertUpdate(t *testing.T) {
+	task := NewTask("Meet for lunch", 4)
+	if err := testDB.InsertTask(task); err != nil {
+		t.Fatal(err)
+	}
+
+	task.Status = TaskDone
+	if err := testDB.UpdateTask(task); err != nil {
+		t.Fatalf("unable to update task: %v", err)
+	}
+
+	task2, err := testDB.FindTask(task.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if task2.Status != TaskDone {
+		t.Fatalf("wrong status loaded from db, want %q, got %q", TaskDone, task2.Status)
+	}
+
+	task.Version = 1
+	if err = testDB.UpdateTask(task); err == nil {
+		t.Fatalf("update did not fail despite wrong version field")
+	}
+
+	if err = testDB.DeleteTask(task.ID); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestListUserTasks(t *testing.T) {
+	u, err := testDB.FindUserName("user")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	tasks := []struct {
+		status string
+		due    time.Time
+	}{
+		{TaskOpen, now.Add(-time.Hour)},
+		{TaskOpen, now.Add(48 * time.Hour)},
+		{TaskDone, now.Add(-time.Hour)},
+	}
+
+	for _, test := range tasks {
+		task := NewTask("test task", 2)
+		task.AssigneeID = sql.NullInt64{Int64: u.ID, Valid: true}
+		task.Status = test.status
+		task.DueDate = pq.NullTime{Time: test.due, Valid: true}
+
+		if err = testDB.InsertTask(task); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	list, err := testDB.ListUserTasks(u.ID, "", time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(list) != 3 {
+		t.Errorf("wrong number of tasks returned, want 3, got %v", len(list))
+	}
+
+	list, err = testDB.ListUserTasks(u.ID, TaskOpen, now.Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(list) != 1 {
+		t.Errorf("wrong number of open tasks returned, want 1, got %v", len(list))
+	}
+}
@@ -0,0 +1,175 @@
+package db
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/modl"
+)
+
+// Team is a group of users. People with the visibility VisibilityTeam are
+// visible to all users which share a team with the owner.
+type Team struct {
+	ID          int64
+	Name        string
+	Description string
+
+	// Members are the IDs of the users in the team.
+	Members []int64 `db:"-"`
+
+	ChangedAt time.Time
+	CreatedAt time.Time
+	Version   int64
+}
+
+// TeamJSON is the JSON representation of a Team.
+type TeamJSON struct {
+	ID          int64   `json:"id,omitempty"`
+	Name        string  `json:"name"`
+	Description string  `json:"description"`
+	Members     []int64 `json:"members"`
+
+	ChangedAt string `json:"changed_at,omitempty"`
+	CreatedAt string `json:"created_at,omitempty"`
+
+	Version int64 `json:"version"`
+}
+
+// MarshalJSON returns the JSON representation of t.
+func (t Team) MarshalJSON() ([]byte, error) {
+	members := t.Members
+	if members == nil {
+		members = []int64{}
+	}
+
+	return json.Marshal(TeamJSON{
+		ID:          t.ID,
+		Name:        t.Name,
+		Description: t.Description,
+		Members:     members,
+		ChangedAt:   t.ChangedAt.Format(timeLayout),
+		CreatedAt:   t.CreatedAt.Format(timeLayout),
+		Version:     t.Version,
+	})
+}
+
+// Update updates t with the fields from other.
+func (t *Team) Update(other TeamJSON) {
+	t.Name = other.Name
+	t.Description = other.Description
+	t.Members = other.Members
+	t.Version = other.Version
+}
+
+// Validate checks if t is valid and returns an error if not.
+func (t *Team) Validate() error {
+	if t.Name == "" {
+		return errors.New("name is empty")
+	}
+
+	if t.CreatedAt.IsZero() || t.ChangedAt.IsZero() {
+		return errors.New("invalid timestamps")
+	}
+
+	return nil
+}
+
+func (t Team) String() string {
+	return fmt.Sprintf("<Team[%v] %v>", t.ID, t.Name)
+}
+
+// PostInsert is run after a team is saved into the database. It saves the
+// members.
+func (t *Team) PostInsert(db modl.SqlExecutor) error {
+	return updateTeamMembers(db, t.ID, t.Members)
+}
+
+// PostUpdate is run after a team has been updated. It saves the members.
+func (t *Team) PostUpdate(db modl.SqlExecutor) error {
+	return updateTeamMembers(db, t.ID, t.Members)
+}
+
+// PostGet loads the members of the team.
+func (t *Team) PostGet(db modl.SqlExecutor) error {
+	t.Members = []int64{}
+	return db.Select(&t.Members, "SELECT user_id FROM team_members WHERE team_id = $1 ORDER BY user_id", t.ID)
+}
+
+// updateTeamMembers replaces the members of a team.
+func updateTeamMembers(db modl.SqlExecutor, teamID int64, members []int64) error {
+	if _, err := db.Exec("DELETE FROM team_members WHERE team_id = $1", teamID); err != nil {
+		return err
+	}
+
+	seen := make(map[int64]bool)
+	for _, id := range members {
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+
+		_, err := db.Exec("INSERT INTO team_members (team_id, user_id) VALUES ($1, $2)", teamID, id)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// FindTeam returns the team with the given id.
+func (db *DB) FindTeam(id int64) (*Team, error) {
+	var t Team
+
+	err := db.ex.SelectOne(&t, "SELECT * FROM teams WHERE id = $1", id)
+	if err != nil {
+		return nil, err
+	}
+
+	return &t, nil
+}
+
+// ListTeams returns the list of teams.
+func (db *DB) ListTeams() ([]*Team, error) {
+	teams := []*Team{}
+	err := db.ex.Select(&teams, "SELECT * FROM teams ORDER BY name")
+	return teams, err
+}
+
+// InsertTeam creates a new team. The team and its members are saved in one
+// transaction.
+func (db *DB) InsertTeam(t *Team) error {
+	return db.atomic(func(tx *Tx) error {
+		return tx.ex.Insert(t)
+	})
+}
+
+// UpdateTeam modifies an existing team. If the team was changed in the
+// meantime, ErrVersionMismatch is returned.
+func (db *DB) UpdateTeam(t *Team) error {
+	return db.atomic(func(tx *Tx) error {
+		_, err := tx.ex.Update(t)
+		return versionError(err)
+	})
+}
+
+// DeleteTeam removes a team.
+func (db *DB) DeleteTeam(id int64) error {
+	res, err := db.ex.Exec("DELETE FROM teams WHERE id = $1", id)
+	if err != nil {
+		return err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if n != 1 {
+		return errors.New("team not found")
+	}
+
+	return nil
+}
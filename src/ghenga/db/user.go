@@ -1,9 +1,12 @@
 package db
 
 import (
+	"database/sql"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/elithrar/simple-scrypt"
@@ -15,10 +18,20 @@ type User struct {
 	ID           int64
 	Login        string
 	PasswordHash string
-	Admin        bool
+
+	// Roles are the names of the roles assigned to the user, Admin is set if
+	// the user has the role admin. Use SetRoles to change them.
+	Roles []string `db:"-"`
+	Admin bool     `db:"-"`
 
 	Password string `db:"-"`
 
+	// CreatedBy is the ID of the user who created the user. ChangedBy is the
+	// ID of the user who makes a change, it is also recorded in the revision
+	// history when the user is saved.
+	CreatedBy sql.NullInt64
+	ChangedBy sql.NullInt64
+
 	ChangedAt time.Time
 	CreatedAt time.Time
 	Version   int64
@@ -26,13 +39,16 @@ type User struct {
 
 // UserJSON is the JSON representation of a User.
 type UserJSON struct {
-	ID       int64  `json:"id,omitempty"`
-	Login    string `json:"login,omitempty"`
-	Admin    bool   `json:"admin"`
-	Password string `json:"password,omitempty"`
+	ID       int64    `json:"id,omitempty"`
+	Login    string   `json:"login,omitempty"`
+	Admin    bool     `json:"admin"`
+	Roles    []string `json:"roles"`
+	Password string   `json:"password,omitempty"`
 
 	ChangedAt string `json:"changed_at"`
 	CreatedAt string `json:"created_at"`
+	ChangedBy *int64 `json:"changed_by"`
+	CreatedBy *int64 `json:"created_by"`
 	Version   int64  `json:"version"`
 }
 
@@ -58,10 +74,33 @@ func NewAdminUser(login, password string) (*User, error) {
 		return nil, err
 	}
 
-	u.Admin = true
+	u.SetRoles([]string{RoleAdmin})
 	return u, nil
 }
 
+// NewExternalUser returns a new User for a login which is checked by an
+// external identity provider. The password is random and never shown, so the
+// user cannot log in with a password.
+func NewExternalUser(login string) (*User, error) {
+	password, err := randomHex()
+	if err != nil {
+		return nil, err
+	}
+
+	return NewUser(login, password)
+}
+
+// SetRoles replaces the roles of the user.
+func (u *User) SetRoles(roles []string) {
+	u.Roles = roles
+	u.Admin = false
+	for _, role := range roles {
+		if role == RoleAdmin {
+			u.Admin = true
+		}
+	}
+}
+
 // CheckPassword returns true iff the password matches the user's password hash.
 func (u User) CheckPassword(password string) bool {
 	err := scrypt.CompareHashAndPassword([]byte(u.PasswordHash), []byte(password))
@@ -85,13 +124,21 @@ func (u User) String() string {
 
 // MarshalJSON returns the JSON representation of u.
 func (u User) MarshalJSON() ([]byte, error) {
+	roles := u.Roles
+	if roles == nil {
+		roles = []string{}
+	}
+
 	ju := UserJSON{
 		ID:    u.ID,
 		Login: u.Login,
 		Admin: u.Admin,
+		Roles: roles,
 
 		ChangedAt: u.ChangedAt.Format(timeLayout),
 		CreatedAt: u.CreatedAt.Format(timeLayout),
+		ChangedBy: nullInt64JSON(u.ChangedBy),
+		CreatedBy: nullInt64JSON(u.CreatedBy),
 		Version:   u.Version,
 	}
 
@@ -130,10 +177,13 @@ func (u *User) UnmarshalJSON(data []byte) error {
 		ID:           ju.ID,
 		Login:        ju.Login,
 		Admin:        ju.Admin,
+		Roles:        ju.Roles,
 		PasswordHash: string(hash),
 
 		CreatedAt: createdAt,
 		ChangedAt: changedAt,
+		CreatedBy: jsonNullInt64(ju.CreatedBy),
+		ChangedBy: jsonNullInt64(ju.ChangedBy),
 		Version:   ju.Version,
 	}
 
@@ -160,6 +210,37 @@ func (u *User) PreUpdate(db modl.SqlExecutor) error {
 	return u.UpdatePasswordHash(u.Password)
 }
 
+// PostInsert is run after a user is saved into the database. It saves the
+// roles and records the first revision.
+func (u *User) PostInsert(db modl.SqlExecutor) error {
+	if err := updateUserRoles(db, u.ID, u.Roles); err != nil {
+		return err
+	}
+
+	return saveRevision(db, RevisionUser, u.ID, u.Version, u.ChangedBy, u)
+}
+
+// PostUpdate is run after a user has been updated. It saves the roles and
+// records the new revision.
+func (u *User) PostUpdate(db modl.SqlExecutor) error {
+	if err := updateUserRoles(db, u.ID, u.Roles); err != nil {
+		return err
+	}
+
+	return saveRevision(db, RevisionUser, u.ID, u.Version, u.ChangedBy, u)
+}
+
+// PostGet loads the roles of the user.
+func (u *User) PostGet(db modl.SqlExecutor) error {
+	var roles []string
+	if err := selectUserRoles(db, u.ID, &roles); err != nil {
+		return err
+	}
+
+	u.SetRoles(roles)
+	return nil
+}
+
 // Validate checks whether the user record does not contain any errors.
 func (u User) Validate() error {
 	if u.Login == "" {
@@ -177,10 +258,30 @@ func (u User) Validate() error {
 	return nil
 }
 
-// Update updates some fields from other.
+// Update updates some fields from other. If other does not contain roles,
+// the roles are kept. If the admin flag in other differs from the current
+// one, the role admin is added or removed.
 func (u *User) Update(other UserJSON) {
 	u.Login = other.Login
-	u.Admin = other.Admin
+
+	current := other.Roles
+	if current == nil {
+		current = u.Roles
+	}
+
+	roles := make([]string, 0, len(current)+1)
+	for _, role := range current {
+		if other.Admin != u.Admin && role == RoleAdmin {
+			continue
+		}
+		roles = append(roles, role)
+	}
+
+	if other.Admin && other.Admin != u.Admin {
+		roles = append(roles, RoleAdmin)
+	}
+
+	u.SetRoles(roles)
 
 	if other.Password != "" {
 		u.UpdatePasswordHash(other.Password)
@@ -190,7 +291,7 @@ func (u *User) Update(other UserJSON) {
 // FindUserName searches the database for a user based on their login name.
 func (db *DB) FindUserName(login string) (*User, error) {
 	var u User
-	err := db.dbmap.SelectOne(&u, "SELECT * FROM users WHERE login = $1", login)
+	err := db.ex.SelectOne(&u, "SELECT * FROM users WHERE login = $1", login)
 	if err != nil {
 		return nil, err
 	}
@@ -201,7 +302,7 @@ func (db *DB) FindUserName(login string) (*User, error) {
 // FindUser searches the database for a user based on their id.
 func (db *DB) FindUser(id int64) (*User, error) {
 	var u User
-	err := db.dbmap.SelectOne(&u, "SELECT * FROM users WHERE id = $1", id)
+	err := db.ex.SelectOne(&u, "SELECT * FROM users WHERE id = $1", id)
 	if err != nil {
 		return nil, err
 	}
@@ -212,32 +313,125 @@ func (db *DB) FindUser(id int64) (*User, error) {
 // ListUsers returns the list of User.
 func (db *DB) ListUsers() ([]*User, error) {
 	var user []*User
-	err := db.dbmap.Select(&user, "select * from users")
+	err := db.ex.Select(&user, "select * from users")
 	return user, err
 }
 
-// UpdateUser modifies an existing user.
-func (db *DB) UpdateUser(u *User) error {
-	_, err := db.dbmap.Update(u)
-	return err
+// userRolesColumn is an expression for the names of the roles of a user.
+const userRolesColumn = `ARRAY(SELECT r.name FROM roles r
+	JOIN user_roles ur ON ur.role_id = r.id WHERE ur.user_id = users.id)`
+
+// parseRole returns an array literal which contains only the role s.
+func parseRole(s string) (interface{}, error) {
+	s = strings.Replace(s, `\`, `\\`, -1)
+	s = strings.Replace(s, `"`, `\"`, -1)
+	return `{"` + s + `"}`, nil
 }
 
-// InsertUser creates a new user.
-func (db *DB) InsertUser(u *User) error {
-	return db.dbmap.Insert(u)
+// userList describes how the table users can be listed. Users can be filtered
+// by the name of a role with `role` and by the role admin with `admin`.
+var userList = listTable{
+	name: "users",
+	id:   func(item interface{}) int64 { return item.(*User).ID },
+	sort: map[string]listColumn{
+		"id":         {func(item interface{}) string { return strconv.FormatInt(item.(*User).ID, 10) }, parseInt},
+		"login":      {func(item interface{}) string { return item.(*User).Login }, parseString},
+		"created_at": {func(item interface{}) string { return formatTime(item.(*User).CreatedAt) }, parseTimestamp},
+		"changed_at": {func(item interface{}) string { return formatTime(item.(*User).ChangedAt) }, parseTimestamp},
+	},
+	filter: map[string]listColumn{
+		"login":      {parse: parseString},
+		"created_by": {parse: parseInt},
+		"changed_by": {parse: parseInt},
+	},
+	conditions: map[string]listCondition{
+		"role":          {userRolesColumn, "@>", parseRole},
+		"admin":         {"(" + userRolesColumn + " @> ARRAY['" + RoleAdmin + "'])", "=", parseBool},
+		"created_since": timeRangeConditions["created_since"],
+		"created_until": timeRangeConditions["created_until"],
+		"changed_since": timeRangeConditions["changed_since"],
+		"changed_until": timeRangeConditions["changed_until"],
+	},
 }
 
-// DeleteUser removes a user.
-func (db *DB) DeleteUser(id int64) error {
-	res := db.dbmap.Dbx.MustExec("delete from people where id = $1", id)
-	n, err := res.RowsAffected()
+// ListUsersPage returns a part of the list of users according to opts.
+// Invalid options are reported as a ListError.
+func (db *DB) ListUsersPage(opts ListOptions) ([]*User, Page, error) {
+	users := []*User{}
+	page, err := db.list(userList, opts, &users,
+		func() int { return len(users) },
+		func(i int) interface{} { return users[i] })
 	if err != nil {
-		return err
+		return nil, Page{}, err
 	}
 
-	if n != 1 {
-		return errors.New("user not found")
+	if len(users) > opts.Limit {
+		users = users[:opts.Limit]
 	}
 
-	return nil
+	return users, page, nil
+}
+
+// UpdateUser modifies an existing user. If the user was changed in the
+// meantime, ErrVersionMismatch is returned. All changes are saved in one
+// transaction.
+func (db *DB) UpdateUser(u *User) error {
+	return db.atomic(func(tx *Tx) error {
+		if _, err := tx.ex.Update(u); err != nil {
+			return versionError(err)
+		}
+
+		return tx.changed(ActionUpdate, RevisionUser, u.ID, u.Version, u.ChangedBy)
+	})
+}
+
+// InsertUser creates a new user. The user and the revision are saved in one
+// transaction. Unless CreatedBy is set, the user in ChangedBy is recorded as
+// the creator.
+func (db *DB) InsertUser(u *User) error {
+	if !u.CreatedBy.Valid {
+		u.CreatedBy = u.ChangedBy
+	}
+
+	return db.atomic(func(tx *Tx) error {
+		if err := tx.ex.Insert(u); err != nil {
+			return err
+		}
+
+		return tx.changed(ActionCreate, RevisionUser, u.ID, u.Version, u.ChangedBy)
+	})
+}
+
+// DeleteUser removes a user. The user who removes the user is passed in
+// changedBy.
+func (db *DB) DeleteUser(id int64, changedBy sql.NullInt64) error {
+	return db.atomic(func(tx *Tx) error {
+		var version int64
+		err := tx.ex.SelectOne(&version, "DELETE FROM users WHERE id = $1 RETURNING version", id)
+		if err == sql.ErrNoRows {
+			return errors.New("user not found")
+		}
+		if err != nil {
+			return err
+		}
+
+		return tx.changed(ActionDelete, RevisionUser, id, version, changedBy)
+	})
+}
+
+// DeleteUserVersion removes a user, but only if the version matches the
+// version in the database. Otherwise, ErrVersionMismatch is returned.
+func (db *DB) DeleteUserVersion(id, version int64, changedBy sql.NullInt64) error {
+	return db.atomic(func(tx *Tx) error {
+		res, err := tx.ex.Exec("DELETE FROM users WHERE id = $1 AND version = $2", id, version)
+		if err != nil {
+			return err
+		}
+
+		if err = tx.deletedVersion(res, "users", "user", id); err != nil {
+			return err
+		}
+
+		return tx.changed(ActionDelete, RevisionUser, id, version, changedBy)
+	})
 }
@@ -41,6 +41,7 @@ var testUsers = []struct {
 		u: User{
 			Login:        "foobar",
 			Admin:        false,
+			Roles:        []string{"sales"},
 			PasswordHash: "foobarbaz",
 			ChangedAt:    parseTime("2016-04-24T10:30:07+02:00"),
 			CreatedAt:    parseTime("2016-04-24T10:30:07+02:00"),
@@ -52,6 +53,7 @@ var testUsers = []struct {
 		u: User{
 			Login:        "x",
 			Admin:        true,
+			Roles:        []string{"admin", "viewer"},
 			PasswordHash: "xxy",
 			ChangedAt:    parseTime("2016-03-24T10:30:07+02:00"),
 			CreatedAt:    parseTime("2016-01-24T10:30:07+02:00"),
@@ -0,0 +1,75 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// Visibility levels of a person. A private person is only visible to the
+// owner, a person with the visibility team also to the users which share a
+// team with the owner. Admins can see all people.
+const (
+	VisibilityPrivate  = "private"
+	VisibilityTeam     = "team"
+	VisibilityEveryone = "everyone"
+)
+
+// validVisibility returns an error if v is not a visibility level.
+func validVisibility(v string) error {
+	switch v {
+	case VisibilityPrivate, VisibilityTeam, VisibilityEveryone:
+		return nil
+	}
+
+	return fmt.Errorf("invalid visibility %q", v)
+}
+
+// VisibleTo returns a DB which only finds and lists the people the user u may
+// see. People which are not visible are reported as not found. For admins,
+// db is returned unchanged.
+func (db *DB) VisibleTo(u *User) *DB {
+	if u.Admin {
+		return db
+	}
+
+	scoped := *db
+	scoped.viewer = u
+	return &scoped
+}
+
+// CanSee returns true if a person with the owner and the visibility is
+// visible to the viewer of db. It is used for people which do not exist any
+// more, e.g. for deleted people in a Change.
+func (db *DB) CanSee(ownerID sql.NullInt64, visibility string) (bool, error) {
+	if db.viewer == nil {
+		return true, nil
+	}
+
+	args := []interface{}{ownerID, visibility}
+	var n int64
+	err := db.ex.SelectOne(&n, `SELECT count(*)
+		FROM (SELECT $1::int AS owner_id, $2::text AS visibility) people
+		WHERE `+db.visiblePeople(&args), args...)
+	return n > 0, err
+}
+
+// visiblePeople returns the SQL condition which selects the people visible
+// to the viewer, the ID of the viewer is appended to args. Without a viewer,
+// all people are selected.
+func (db *DB) visiblePeople(args *[]interface{}) string {
+	if db.viewer == nil {
+		return "TRUE"
+	}
+
+	*args = append(*args, db.viewer.ID)
+	return visibleCondition(fmt.Sprintf("$%d", len(*args)))
+}
+
+// visibleCondition returns the SQL condition which selects the people
+// visible to the user whose ID is in the parameter viewer.
+func visibleCondition(viewer string) string {
+	return `(people.visibility = '` + VisibilityEveryone + `' OR people.owner_id = ` + viewer + `
+		OR (people.visibility = '` + VisibilityTeam + `' AND people.owner_id IN (
+			SELECT o.user_id FROM team_members o JOIN team_members v ON v.team_id = o.team_id
+			WHERE v.user_id = ` + viewer + `)))`
+}
@@ -0,0 +1,169 @@
+package db
+
+import (
+	"database/sql"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestValidVisibility(t *testing.T) {
+	for _, v := range []string{VisibilityPrivate, VisibilityTeam, VisibilityEveryone} {
+		if err := validVisibility(v); err != nil {
+			t.Errorf("visibility %q is invalid: %v", v, err)
+		}
+	}
+
+	for _, v := range []string{"", "public", "Team"} {
+		if err := validVisibility(v); err == nil {
+			t.Errorf("visibility %q was accepted", v)
+		}
+	}
+}
+
+func TestPersonUpdateOwner(t *testing.T) {
+	owner := int64(23)
+
+	p := NewPerson("foo")
+	p.OwnerID = sql.NullInt64{Int64: 5, Valid: true}
+
+	p.Update(PersonJSON{Name: "bar"})
+	if p.OwnerID.Int64 != 5 || p.Visibility != VisibilityEveryone {
+		t.Errorf("owner or visibility changed without being set: %v %v", p.OwnerID, p.Visibility)
+	}
+
+	p.Update(PersonJSON{Name: "bar", OwnerID: &owner, Visibility: VisibilityPrivate})
+	if p.OwnerID.Int64 != owner || p.Visibility != VisibilityPrivate {
+		t.Errorf("owner or visibility not updated: %v %v", p.OwnerID, p.Visibility)
+	}
+
+	p.Visibility = "public"
+	if err := p.Validate(); err == nil {
+		t.Errorf("invalid visibility was accepted")
+	}
+}
+
+func insertVisibilityUser(t *testing.T, login string) *User {
+	u, err := NewUser(login, "geheim")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = testDB.InsertUser(u); err != nil {
+		t.Fatal(err)
+	}
+
+	return u
+}
+
+func TestVisibleTo(t *testing.T) {
+	owner := insertVisibilityUser(t, "vis-owner")
+	member := insertVisibilityUser(t, "vis-member")
+	other := insertVisibilityUser(t, "vis-other")
+
+	team := &Team{
+		Name:      "vis-team",
+		Members:   []int64{owner.ID, member.ID},
+		CreatedAt: time.Now(),
+		ChangedAt: time.Now(),
+	}
+	if err := testDB.InsertTeam(team); err != nil {
+		t.Fatal(err)
+	}
+
+	ids := make(map[string]int64)
+	for _, v := range []string{VisibilityPrivate, VisibilityTeam, VisibilityEveryone} {
+		p := NewPerson("Visibility " + v)
+		p.OwnerID = sql.NullInt64{Int64: owner.ID, Valid: true}
+		p.Visibility = v
+		if err := testDB.InsertPerson(p); err != nil {
+			t.Fatal(err)
+		}
+		ids[v] = p.ID
+	}
+
+	admin, err := testDB.FindUserName("admin")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		u       *User
+		visible []string
+	}{
+		{owner, []string{VisibilityPrivate, VisibilityTeam, VisibilityEveryone}},
+		{member, []string{VisibilityTeam, VisibilityEveryone}},
+		{other, []string{VisibilityEveryone}},
+		{admin, []string{VisibilityPrivate, VisibilityTeam, VisibilityEveryone}},
+	} {
+		db := testDB.VisibleTo(test.u)
+
+		want := make(map[int64]bool)
+		for _, v := range test.visible {
+			want[ids[v]] = true
+		}
+
+		for v, id := range ids {
+			_, err := db.FindPerson(id)
+			if want[id] && err != nil {
+				t.Errorf("user %v: person with visibility %v not found: %v", test.u.Login, v, err)
+			}
+
+			if !want[id] && err == nil {
+				t.Errorf("user %v: person with visibility %v was found", test.u.Login, v)
+			}
+
+			// the same check for a deleted person
+			visible, err := db.CanSee(sql.NullInt64{Int64: owner.ID, Valid: true}, v)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if visible != want[id] {
+				t.Errorf("user %v: CanSee for visibility %v returned %v", test.u.Login, v, visible)
+			}
+		}
+
+		people, _, err := db.ListPeoplePage(ListOptions{
+			Limit:  10,
+			Filter: map[string]string{"owner_id": strconv.FormatInt(owner.ID, 10)},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(people) != len(want) {
+			t.Errorf("user %v: want %d people, got %v", test.u.Login, len(want), people)
+		}
+
+		for _, p := range people {
+			if !want[p.ID] {
+				t.Errorf("user %v: invisible person %v listed", test.u.Login, p)
+			}
+		}
+
+		found, err := db.FuzzyFindPersons("Visibility")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, p := range found {
+			if !want[p.ID] {
+				t.Errorf("user %v: invisible person %v found by search", test.u.Login, p)
+			}
+		}
+	}
+
+	team.Members = []int64{owner.ID}
+	if err = testDB.UpdateTeam(team); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = testDB.VisibleTo(member).FindPerson(ids[VisibilityTeam]); err == nil {
+		t.Errorf("person is still visible after the user was removed from the team")
+	}
+
+	if err = testDB.DeleteTeam(team.ID); err != nil {
+		t.Fatal(err)
+	}
+}
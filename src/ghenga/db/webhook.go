@@ -0,0 +1,443 @@
+package db
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Webhook is a URL which is notified when a person or a user is created,
+// updated or deleted. The requests are signed with the secret.
+type Webhook struct {
+	ID     int64
+	URL    string
+	Events WebhookEvents
+	Secret string
+	Active bool
+
+	ChangedAt time.Time
+	CreatedAt time.Time
+	Version   int64
+}
+
+// WebhookJSON is the JSON representation of a Webhook as returned or consumed
+// by the API. The secret is never returned.
+type WebhookJSON struct {
+	ID     int64    `json:"id,omitempty"`
+	URL    string   `json:"url"`
+	Events []string `json:"events"`
+	Secret string   `json:"secret,omitempty"`
+	Active bool     `json:"active"`
+
+	ChangedAt string `json:"changed_at,omitempty"`
+	CreatedAt string `json:"created_at,omitempty"`
+
+	Version int64 `json:"version"`
+}
+
+// WebhookEvents is the list of events a webhook is notified about. An event
+// is named after the entity and the action, e.g. "person.create". Either part
+// may be "*" to match all entities or actions, the event "*" matches all
+// events. An empty list matches all events as well.
+type WebhookEvents []string
+
+// Value returns the events as a comma separated list.
+func (e WebhookEvents) Value() (driver.Value, error) {
+	return strings.Join(e, ","), nil
+}
+
+// Scan reads a comma separated list of events.
+func (e *WebhookEvents) Scan(src interface{}) error {
+	var s string
+	switch v := src.(type) {
+	case string:
+		s = v
+	case []byte:
+		s = string(v)
+	default:
+		return fmt.Errorf("unable to scan %T into WebhookEvents", src)
+	}
+
+	*e = nil
+	for _, event := range strings.Split(s, ",") {
+		if event != "" {
+			*e = append(*e, event)
+		}
+	}
+
+	return nil
+}
+
+var (
+	webhookEntities = map[string]bool{RevisionPerson: true, RevisionUser: true, "*": true}
+	webhookActions  = map[string]bool{ActionCreate: true, ActionUpdate: true, ActionDelete: true, "*": true}
+)
+
+// Validate returns an error if one of the events is invalid.
+func (e WebhookEvents) Validate() error {
+	for _, event := range e {
+		if event == "*" {
+			continue
+		}
+
+		parts := strings.Split(event, ".")
+		if len(parts) != 2 || !webhookEntities[parts[0]] || !webhookActions[parts[1]] {
+			return fmt.Errorf("invalid event %q", event)
+		}
+	}
+
+	return nil
+}
+
+// Match returns true if the event (e.g. "person.update") is contained in the
+// list.
+func (e WebhookEvents) Match(event string) bool {
+	if len(e) == 0 {
+		return true
+	}
+
+	entity, action := event, ""
+	if i := strings.Index(event, "."); i >= 0 {
+		entity, action = event[:i], event[i+1:]
+	}
+
+	for _, pattern := range e {
+		if pattern == "*" || pattern == event {
+			return true
+		}
+
+		parts := strings.Split(pattern, ".")
+		if len(parts) != 2 {
+			continue
+		}
+
+		if (parts[0] == "*" || parts[0] == entity) && (parts[1] == "*" || parts[1] == action) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// MarshalJSON returns the JSON representation of w.
+func (w Webhook) MarshalJSON() ([]byte, error) {
+	events := []string(w.Events)
+	if events == nil {
+		events = []string{}
+	}
+
+	return json.Marshal(WebhookJSON{
+		ID:        w.ID,
+		URL:       w.URL,
+		Events:    events,
+		Active:    w.Active,
+		ChangedAt: w.ChangedAt.Format(timeLayout),
+		CreatedAt: w.CreatedAt.Format(timeLayout),
+		Version:   w.Version,
+	})
+}
+
+// Update updates w with the fields from other. The secret is only changed if
+// other contains a secret.
+func (w *Webhook) Update(other WebhookJSON) {
+	w.URL = other.URL
+	w.Events = WebhookEvents(other.Events)
+	w.Active = other.Active
+	w.Version = other.Version
+
+	if other.Secret != "" {
+		w.Secret = other.Secret
+	}
+}
+
+// Validate checks if w is valid and returns an error if not.
+func (w *Webhook) Validate() error {
+	u, err := url.Parse(w.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return errors.New("url must be an absolute http or https URL")
+	}
+
+	if w.Secret == "" {
+		return errors.New("secret is empty")
+	}
+
+	if err = w.Events.Validate(); err != nil {
+		return err
+	}
+
+	if w.CreatedAt.IsZero() || w.ChangedAt.IsZero() {
+		return errors.New("invalid timestamps")
+	}
+
+	return nil
+}
+
+func (w Webhook) String() string {
+	return fmt.Sprintf("<Webhook[%v] %v>", w.ID, w.URL)
+}
+
+// FindWebhook returns the webhook with the given id.
+func (db *DB) FindWebhook(id int64) (*Webhook, error) {
+	var w Webhook
+
+	err := db.ex.SelectOne(&w, "SELECT * FROM webhooks WHERE id = $1", id)
+	if err != nil {
+		return nil, err
+	}
+
+	return &w, nil
+}
+
+// ListWebhooks returns the list of webhooks.
+func (db *DB) ListWebhooks() ([]*Webhook, error) {
+	webhooks := []*Webhook{}
+	err := db.ex.Select(&webhooks, "SELECT * FROM webhooks ORDER BY id")
+	return webhooks, err
+}
+
+// InsertWebhook creates a new webhook.
+func (db *DB) InsertWebhook(w *Webhook) error {
+	return db.ex.Insert(w)
+}
+
+// UpdateWebhook modifies an existing webhook. If the webhook was changed in
+// the meantime, ErrVersionMismatch is returned.
+func (db *DB) UpdateWebhook(w *Webhook) error {
+	_, err := db.ex.Update(w)
+	return versionError(err)
+}
+
+// DeleteWebhook removes a webhook together with its deliveries.
+func (db *DB) DeleteWebhook(id int64) error {
+	res, err := db.ex.Exec("DELETE FROM webhooks WHERE id = $1", id)
+	if err != nil {
+		return err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if n != 1 {
+		return errors.New("webhook not found")
+	}
+
+	return nil
+}
+
+// States of a WebhookDelivery.
+const (
+	DeliveryPending = "pending"
+	DeliverySuccess = "success"
+	DeliveryFailed  = "failed"
+)
+
+// WebhookDelivery is a request sent to a webhook. The delivery stays pending
+// while it is retried, afterwards it has either succeeded or failed. A
+// pending delivery is sent by the ghenga instance which has claimed it, see
+// ClaimWebhookDelivery.
+type WebhookDelivery struct {
+	ID        int64
+	WebhookID int64
+	Event     string
+	EntityID  int64
+	Payload   string
+
+	Status         string
+	Attempts       int
+	ResponseStatus sql.NullInt64
+	Error          string
+
+	CreatedAt   time.Time
+	DeliveredAt pq.NullTime
+
+	ClaimedBy    string
+	ClaimedUntil pq.NullTime
+}
+
+// WebhookDeliveryJSON is the JSON representation of a WebhookDelivery.
+type WebhookDeliveryJSON struct {
+	ID        int64           `json:"id"`
+	WebhookID int64           `json:"webhook_id"`
+	Event     string          `json:"event"`
+	EntityID  int64           `json:"entity_id"`
+	Payload   json.RawMessage `json:"payload"`
+
+	Status         string `json:"status"`
+	Attempts       int    `json:"attempts"`
+	ResponseStatus *int64 `json:"response_status"`
+	Error          string `json:"error,omitempty"`
+
+	CreatedAt   string  `json:"created_at"`
+	DeliveredAt *string `json:"delivered_at"`
+}
+
+// NewWebhookDelivery returns a new pending delivery of payload to a webhook.
+func NewWebhookDelivery(webhookID int64, event string, entityID int64, payload []byte) *WebhookDelivery {
+	return &WebhookDelivery{
+		WebhookID: webhookID,
+		Event:     event,
+		EntityID:  entityID,
+		Payload:   string(payload),
+		Status:    DeliveryPending,
+		CreatedAt: time.Now(),
+	}
+}
+
+// MarshalJSON returns the JSON representation of d.
+func (d WebhookDelivery) MarshalJSON() ([]byte, error) {
+	return json.Marshal(WebhookDeliveryJSON{
+		ID:             d.ID,
+		WebhookID:      d.WebhookID,
+		Event:          d.Event,
+		EntityID:       d.EntityID,
+		Payload:        json.RawMessage(d.Payload),
+		Status:         d.Status,
+		Attempts:       d.Attempts,
+		ResponseStatus: nullInt64JSON(d.ResponseStatus),
+		Error:          d.Error,
+		CreatedAt:      d.CreatedAt.Format(timeLayout),
+		DeliveredAt:    nullTimeJSON(d.DeliveredAt),
+	})
+}
+
+// Attempted records an attempt to deliver d. status is the HTTP status code
+// of the response, it is zero if no response was received, and err is nil if
+// the delivery succeeded. After maxAttempts failed attempts, the delivery
+// has failed.
+func (d *WebhookDelivery) Attempted(status int, err error, maxAttempts int) {
+	d.Attempts++
+	d.ResponseStatus = sql.NullInt64{Int64: int64(status), Valid: status != 0}
+	d.Error = ""
+
+	switch {
+	case err == nil:
+		d.Status = DeliverySuccess
+		d.DeliveredAt = pq.NullTime{Time: time.Now(), Valid: true}
+	case d.Attempts >= maxAttempts:
+		d.Status = DeliveryFailed
+		d.Error = err.Error()
+	default:
+		d.Error = err.Error()
+	}
+}
+
+func (d WebhookDelivery) String() string {
+	return fmt.Sprintf("<WebhookDelivery[%v] %v to webhook %v: %v>", d.ID, d.Event, d.WebhookID, d.Status)
+}
+
+// FindWebhookDelivery returns the delivery with the given id.
+func (db *DB) FindWebhookDelivery(id int64) (*WebhookDelivery, error) {
+	var d WebhookDelivery
+
+	err := db.ex.SelectOne(&d, "SELECT * FROM webhook_deliveries WHERE id = $1", id)
+	if err != nil {
+		return nil, err
+	}
+
+	return &d, nil
+}
+
+// InsertWebhookDelivery saves a new delivery. The delivery is claimed by db
+// for the duration claim.
+func (db *DB) InsertWebhookDelivery(d *WebhookDelivery, claim time.Duration) error {
+	d.ClaimedBy = db.instance
+	d.ClaimedUntil = pq.NullTime{Time: time.Now().Add(claim), Valid: true}
+	return db.ex.Insert(d)
+}
+
+// ClaimWebhookDelivery claims the pending delivery d for the duration claim,
+// so that it is not sent by other ghenga instances using the same database.
+// A claim of db itself is extended. If d is not pending any more or has
+// been claimed by another instance in the meantime, false is returned.
+func (db *DB) ClaimWebhookDelivery(d *WebhookDelivery, claim time.Duration) (bool, error) {
+	now := time.Now()
+	until := now.Add(claim)
+
+	res, err := db.ex.Exec(`UPDATE webhook_deliveries SET claimed_by = $1, claimed_until = $2
+		WHERE id = $3 AND status = $4 AND (claimed_by = $1 OR claimed_until IS NULL OR claimed_until < $5)`,
+		db.instance, until, d.ID, DeliveryPending, now)
+	if err != nil {
+		return false, err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	if n != 1 {
+		return false, nil
+	}
+
+	d.ClaimedBy = db.instance
+	d.ClaimedUntil = pq.NullTime{Time: until, Valid: true}
+	return true, nil
+}
+
+// UpdateWebhookDelivery saves the state of a delivery.
+func (db *DB) UpdateWebhookDelivery(d *WebhookDelivery) error {
+	_, err := db.ex.Update(d)
+	return err
+}
+
+// ClaimPendingWebhookDeliveries claims all pending deliveries which are not
+// claimed by another ghenga instance for the duration claim, see
+// ClaimWebhookDelivery. The claimed deliveries are returned, the oldest
+// first.
+func (db *DB) ClaimPendingWebhookDeliveries(claim time.Duration) ([]*WebhookDelivery, error) {
+	now := time.Now()
+
+	deliveries := []*WebhookDelivery{}
+	err := db.ex.Select(&deliveries, `WITH claimed AS (
+			UPDATE webhook_deliveries SET claimed_by = $1, claimed_until = $2
+			WHERE status = $3 AND (claimed_by = $1 OR claimed_until IS NULL OR claimed_until < $4)
+			RETURNING *)
+		SELECT * FROM claimed ORDER BY id`,
+		db.instance, now.Add(claim), DeliveryPending, now)
+	return deliveries, err
+}
+
+// webhookDeliveryList describes how the table webhook_deliveries can be
+// listed.
+var webhookDeliveryList = listTable{
+	name: "webhook_deliveries",
+	id:   func(item interface{}) int64 { return item.(*WebhookDelivery).ID },
+	sort: map[string]listColumn{
+		"id":         {func(item interface{}) string { return strconv.FormatInt(item.(*WebhookDelivery).ID, 10) }, parseInt},
+		"created_at": {func(item interface{}) string { return formatTime(item.(*WebhookDelivery).CreatedAt) }, parseTimestamp},
+	},
+	filter: map[string]listColumn{
+		"webhook_id": {parse: parseInt},
+		"event":      {parse: parseString},
+		"entity_id":  {parse: parseInt},
+		"status":     {parse: parseString},
+	},
+}
+
+// ListWebhookDeliveriesPage returns a part of the delivery log according to
+// opts. Invalid options are reported as a ListError.
+func (db *DB) ListWebhookDeliveriesPage(opts ListOptions) ([]*WebhookDelivery, Page, error) {
+	deliveries := []*WebhookDelivery{}
+	page, err := db.list(webhookDeliveryList, opts, &deliveries,
+		func() int { return len(deliveries) },
+		func(i int) interface{} { return deliveries[i] })
+	if err != nil {
+		return nil, Page{}, err
+	}
+
+	if len(deliveries) > opts.Limit {
+		deliveries = deliveries[:opts.Limit]
+	}
+
+	return deliveries, page, nil
+}
@@ -0,0 +1,207 @@
+package db
+
+import (
+	"errors"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+)
+
+var webhookEventsMatchTests = []struct {
+	events WebhookEvents
+	event  string
+	match  bool
+}{
+	{nil, "person.create", true},
+	{WebhookEvents{"*"}, "user.delete", true},
+	{WebhookEvents{"person.create"}, "person.create", true},
+	{WebhookEvents{"person.create"}, "person.update", false},
+	{WebhookEvents{"person.*"}, "person.delete", true},
+	{WebhookEvents{"person.*"}, "user.delete", false},
+	{WebhookEvents{"*.delete"}, "user.delete", true},
+	{WebhookEvents{"user.update", "*.delete"}, "person.update", false},
+}
+
+func TestWebhookEventsMatch(t *testing.T) {
+	for i, test := range webhookEventsMatchTests {
+		if match := test.events.Match(test.event); match != test.match {
+			t.Errorf("test %d: %v.Match(%q) returned %v, want %v", i, test.events, test.event, match, test.match)
+		}
+	}
+}
+
+func TestWebhookEventsValidate(t *testing.T) {
+	for _, events := range []WebhookEvents{nil, {"*"}, {"person.create", "*.delete", "user.*"}} {
+		if err := events.Validate(); err != nil {
+			t.Errorf("%v: unexpected error %v", events, err)
+		}
+	}
+
+	for _, events := range []WebhookEvents{{"person"}, {"account.create"}, {"person.merge"}, {"person.create.x"}} {
+		if err := events.Validate(); err == nil {
+			t.Errorf("%v: expected error for invalid events", events)
+		}
+	}
+}
+
+func TestWebhookEventsScan(t *testing.T) {
+	var events WebhookEvents
+	if err := events.Scan([]byte("person.create,user.*")); err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(events, WebhookEvents{"person.create", "user.*"}) {
+		t.Fatalf("wrong events: %v", events)
+	}
+
+	v, err := events.Value()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if v != "person.create,user.*" {
+		t.Fatalf("wrong value: %v", v)
+	}
+}
+
+func TestWebhookDeliveryAttempted(t *testing.T) {
+	d := NewWebhookDelivery(1, "person.create", 23, []byte("{}"))
+
+	d.Attempted(500, errors.New("webhook returned 500"), 2)
+	if d.Status != DeliveryPending || d.Attempts != 1 || d.ResponseStatus.Int64 != 500 || d.Error == "" {
+		t.Fatalf("wrong state after first failed attempt: %+v", d)
+	}
+
+	d.Attempted(0, errors.New("connection refused"), 2)
+	if d.Status != DeliveryFailed || d.ResponseStatus.Valid {
+		t.Fatalf("wrong state after last failed attempt: %+v", d)
+	}
+
+	d = NewWebhookDelivery(1, "person.create", 23, []byte("{}"))
+	d.Attempted(200, nil, 2)
+	if d.Status != DeliverySuccess || !d.DeliveredAt.Valid || d.Error != "" {
+		t.Fatalf("wrong state after successful attempt: %+v", d)
+	}
+}
+
+func TestWebhookInsert(t *testing.T) {
+	w := &Webhook{
+		URL:    "http://localhost:2345/hook",
+		Events: WebhookEvents{"person.*", "user.delete"},
+		Secret: "secret",
+		Active: true,
+	}
+
+	if err := testDB.InsertWebhook(w); err != nil {
+		t.Fatal(err)
+	}
+
+	w2, err := testDB.FindWebhook(w.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(w2.Events, w.Events) || w2.Secret != w.Secret {
+		t.Fatalf("webhook was not saved correctly: %+v", w2)
+	}
+
+	d := NewWebhookDelivery(w.ID, "person.create", 23, []byte(`{"event": "person.create"}`))
+	if err = testDB.InsertWebhookDelivery(d, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+
+	deliveries, _, err := testDB.ListWebhookDeliveriesPage(ListOptions{
+		Limit:  10,
+		Filter: map[string]string{"webhook_id": strconv.FormatInt(w.ID, 10), "status": DeliveryPending},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(deliveries) != 1 || deliveries[0].ID != d.ID {
+		t.Fatalf("wrong deliveries returned: %v", deliveries)
+	}
+
+	if err = testDB.DeleteWebhook(w.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = testDB.FindWebhookDelivery(d.ID); err == nil {
+		t.Fatalf("delivery was not removed with the webhook")
+	}
+}
+
+func TestWebhookClaim(t *testing.T) {
+	w := &Webhook{
+		URL:    "http://localhost:2345/hook",
+		Secret: "secret",
+		Active: true,
+	}
+
+	if err := testDB.InsertWebhook(w); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := testDB.DeleteWebhook(w.ID); err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	// another ghenga instance using the same database
+	other := *testDB
+	other.instance = "other"
+
+	d := NewWebhookDelivery(w.ID, "person.create", 23, []byte("{}"))
+	if err := testDB.InsertWebhookDelivery(d, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+
+	claimDelivery := func(db *DB, want bool) {
+		claimed, err := db.ClaimWebhookDelivery(d, time.Minute)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if claimed != want {
+			t.Fatalf("instance %v: want claimed %v, got %v", db.instance, want, claimed)
+		}
+	}
+
+	claimDelivery(&other, false)
+	claimDelivery(testDB, true)
+
+	deliveries, err := other.ClaimPendingWebhookDeliveries(time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deliveries) != 0 {
+		t.Fatalf("deliveries claimed by another instance were returned: %v", deliveries)
+	}
+
+	// the claim has expired, e.g. because the instance was stopped
+	_, err = testDB.ex.Exec("UPDATE webhook_deliveries SET claimed_until = $1 WHERE id = $2",
+		time.Now().Add(-time.Second), d.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	deliveries, err = other.ClaimPendingWebhookDeliveries(time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deliveries) != 1 || deliveries[0].ID != d.ID || deliveries[0].ClaimedBy != "other" {
+		t.Fatalf("wrong deliveries claimed: %v", deliveries)
+	}
+
+	claimDelivery(testDB, false)
+	claimDelivery(&other, true)
+
+	// deliveries which are not pending are not claimed
+	d.Attempted(200, nil, 1)
+	if err = testDB.UpdateWebhookDelivery(d); err != nil {
+		t.Fatal(err)
+	}
+
+	claimDelivery(&other, false)
+}
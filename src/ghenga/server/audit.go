@@ -0,0 +1,157 @@
+package server
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"ghenga/db"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"golang.org/x/net/context"
+)
+
+// auditRecorder passes a response to the client and keeps a copy of the
+// status code and the body.
+type auditRecorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (r *auditRecorder) WriteHeader(code int) {
+	r.status = code
+	r.ResponseWriter.WriteHeader(code)
+}
+
+func (r *auditRecorder) Write(p []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+
+	r.body.Write(p)
+	return r.ResponseWriter.Write(p)
+}
+
+// newAuditEntry returns an audit entry for the request.
+func newAuditEntry(req *http.Request, action, entity string) *db.AuditEntry {
+	addr, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		addr = req.RemoteAddr
+	}
+
+	return &db.AuditEntry{
+		CreatedAt:  time.Now(),
+		Action:     action,
+		Entity:     entity,
+		RemoteAddr: addr,
+		UserAgent:  req.UserAgent(),
+	}
+}
+
+// audit saves an entry in the audit log. The change has already been made at
+// this point, so an error is only logged.
+func audit(env *Env, e *db.AuditEntry) {
+	if err := env.DB.InsertAuditEntry(e); err != nil {
+		env.Logf("unable to save audit entry %v: %v", e, err)
+	}
+}
+
+// versionJSON contains the fields of a record which are recorded in the audit
+// log.
+type versionJSON struct {
+	ID      *int64 `json:"id"`
+	Version *int64 `json:"version"`
+}
+
+func nullInt64(v *int64) sql.NullInt64 {
+	if v == nil || *v == 0 {
+		return sql.NullInt64{}
+	}
+
+	return sql.NullInt64{Int64: *v, Valid: true}
+}
+
+// requestVersion returns the version of the record the client based a change
+// on, either from the If-Match header or from the field `version` in the
+// body. The body is replaced so that it can be read again.
+func requestVersion(req *http.Request) sql.NullInt64 {
+	if header := req.Header.Get("If-Match"); header != "" {
+		// the ETag of a record ends with the version, e.g. "person-23-5"
+		tag := strings.Trim(strings.TrimSpace(header), `"`)
+		v, err := strconv.ParseInt(tag[strings.LastIndex(tag, "-")+1:], 10, 64)
+		if err != nil {
+			return sql.NullInt64{}
+		}
+
+		return sql.NullInt64{Int64: v, Valid: true}
+	}
+
+	if req.Body == nil {
+		return sql.NullInt64{}
+	}
+
+	buf, err := ioutil.ReadAll(req.Body)
+	req.Body = ioutil.NopCloser(bytes.NewReader(buf))
+	if err != nil {
+		return sql.NullInt64{}
+	}
+
+	var v versionJSON
+	if json.Unmarshal(buf, &v) != nil {
+		return sql.NullInt64{}
+	}
+
+	return nullInt64(v.Version)
+}
+
+// Audit records an entry in the audit log for each successful request
+// handled by h. The ID of the record is taken from the response or the URL,
+// the version before the change from the request and the version after the
+// change from the response. Audit must be wrapped by RequireAuth or
+// RequireAdmin, so that the current user can be recorded.
+func Audit(action, entity string, h HandleFunc) HandleFunc {
+	return func(ctx context.Context, env *Env, res http.ResponseWriter, req *http.Request) error {
+		e := newAuditEntry(req, action, entity)
+		e.BeforeVersion = requestVersion(req)
+
+		rec := &auditRecorder{ResponseWriter: res}
+		if err := h(ctx, env, rec, req); err != nil {
+			return err
+		}
+
+		if rec.status >= http.StatusBadRequest {
+			return nil
+		}
+
+		u, err := currentUser(ctx, env)
+		if err != nil {
+			env.Logf("unable to find user for audit entry %v: %v", e, err)
+			return nil
+		}
+		e.UserID = sql.NullInt64{Int64: u.ID, Valid: true}
+		e.Login = u.Login
+
+		var v versionJSON
+		if json.Unmarshal(rec.body.Bytes(), &v) == nil {
+			e.EntityID = nullInt64(v.ID)
+			if action != db.ActionDelete {
+				e.AfterVersion = nullInt64(v.Version)
+			}
+		}
+
+		if !e.EntityID.Valid {
+			if id, err := strconv.ParseInt(mux.Vars(req)["id"], 10, 64); err == nil {
+				e.EntityID = sql.NullInt64{Int64: id, Valid: true}
+			}
+		}
+
+		audit(env, e)
+		return nil
+	}
+}
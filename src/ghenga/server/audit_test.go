@@ -0,0 +1,51 @@
+package server
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"testing"
+)
+
+var requestVersionTests = []struct {
+	ifMatch string
+	body    string
+	version int64
+	valid   bool
+}{
+	{`"person-23-5"`, `{"version": 7}`, 5, true},
+	{"*", "", 0, false},
+	{"", `{"name": "foo", "version": 7}`, 7, true},
+	{"", `{"name": "foo"}`, 0, false},
+	{"", `{"version": 0}`, 0, false},
+	{"", `[1, 2]`, 0, false},
+	{"", "", 0, false},
+}
+
+func TestRequestVersion(t *testing.T) {
+	for i, test := range requestVersionTests {
+		req, err := http.NewRequest("PUT", "/api/person/23", bytes.NewReader([]byte(test.body)))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if test.ifMatch != "" {
+			req.Header.Set("If-Match", test.ifMatch)
+		}
+
+		v := requestVersion(req)
+		if v.Valid != test.valid || v.Int64 != test.version {
+			t.Errorf("test %d: want version %v (%v), got %v", i, test.version, test.valid, v)
+		}
+
+		// the body must still be readable by the handler
+		buf, err := ioutil.ReadAll(req.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if string(buf) != test.body {
+			t.Errorf("test %d: body was changed, want %q, got %q", i, test.body, buf)
+		}
+	}
+}
@@ -0,0 +1,165 @@
+package server
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"ghenga/db"
+	"time"
+)
+
+// ErrInvalidLogin is returned by an Authenticator if the login or the
+// password is wrong or the user may not log in.
+var ErrInvalidLogin = errors.New("invalid username or password")
+
+// Authenticator checks the login and password of a user at Login.
+type Authenticator interface {
+	// Authenticate returns the local user for login if password is correct.
+	// If the credentials are rejected, ErrInvalidLogin is returned, other
+	// errors mean that the check could not be done.
+	Authenticate(env *Env, login, password string) (*db.User, error)
+}
+
+// DBAuthenticator checks the password against the hash saved for the user in
+// the database. It is used unless another Authenticator is configured.
+type DBAuthenticator struct{}
+
+// Authenticate returns the user for login if password matches.
+func (DBAuthenticator) Authenticate(env *Env, login, password string) (*db.User, error) {
+	u, err := env.DB.FindUserName(login)
+	if err != nil {
+		env.Debugf("error finding user %q in database: %v", login, err)
+		return nil, ErrInvalidLogin
+	}
+
+	if !u.CheckPassword(password) {
+		return nil, ErrInvalidLogin
+	}
+
+	return u, nil
+}
+
+// authenticator returns the configured Authenticator.
+func (e Env) authenticator() Authenticator {
+	if e.Cfg.Authenticator == nil {
+		return DBAuthenticator{}
+	}
+
+	return e.Cfg.Authenticator
+}
+
+// externalLogin is a user who has been authenticated by an external identity
+// provider, e.g. OpenID Connect or LDAP.
+type externalLogin struct {
+	Login string
+
+	// Create allows creating a local user with the roles in Roles if the
+	// user does not exist yet.
+	Create bool
+	Roles  []string
+
+	// Admin is set if the provider decides whether the user has the role
+	// admin, the role is then granted or removed.
+	Admin *bool
+
+	// Issuer and Subject identify the user at an OpenID Connect provider.
+	// If they are set, the local user is found by them and Login is only
+	// used for new users. An existing local user with the login is bound to
+	// the subject only if Link is set.
+	Issuer  string
+	Subject string
+	Link    bool
+}
+
+// setAdmin grants or removes the role admin, it returns true if the roles of
+// u changed.
+func setAdmin(u *db.User, admin bool) bool {
+	if u.Admin == admin {
+		return false
+	}
+
+	var roles []string
+	for _, role := range u.Roles {
+		if role != db.RoleAdmin {
+			roles = append(roles, role)
+		}
+	}
+
+	if admin {
+		roles = append(roles, db.RoleAdmin)
+	}
+
+	u.SetRoles(roles)
+	return true
+}
+
+// find returns the existing local user for l. A user found by the login is
+// bound to the subject of l if allowed, see externalLogin.
+func (l externalLogin) find(env *Env) (*db.User, error) {
+	if l.Subject == "" {
+		return env.DB.FindUserName(l.Login)
+	}
+
+	u, err := env.DB.FindOIDCUser(l.Issuer, l.Subject)
+	if err != sql.ErrNoRows {
+		return u, err
+	}
+
+	u, err = env.DB.FindUserName(l.Login)
+	if err != nil {
+		return nil, err
+	}
+
+	if !l.Link {
+		return nil, fmt.Errorf("user %v is not bound to subject %q", u, l.Subject)
+	}
+
+	if err = env.DB.BindOIDCUser(u.ID, l.Issuer, l.Subject); err != nil {
+		return nil, err
+	}
+
+	env.Debugf("bound user %v to subject %q", u, l.Subject)
+	return u, nil
+}
+
+// user returns the local user for l, which is created or updated as needed.
+func (l externalLogin) user(env *Env) (*db.User, error) {
+	u, err := l.find(env)
+	if err != nil && (err != sql.ErrNoRows || !l.Create) {
+		env.Debugf("user %q not found: %v", l.Login, err)
+		return nil, ErrInvalidLogin
+	}
+
+	if err != nil {
+		if u, err = db.NewExternalUser(l.Login); err != nil {
+			return nil, err
+		}
+
+		u.SetRoles(l.Roles)
+		if l.Admin != nil {
+			setAdmin(u, *l.Admin)
+		}
+
+		if l.Subject != "" {
+			err = env.DB.InsertOIDCUser(u, l.Issuer, l.Subject)
+		} else {
+			err = env.DB.InsertUser(u)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		env.Debugf("created user %v for external login", u)
+		return u, nil
+	}
+
+	if l.Admin != nil && setAdmin(u, *l.Admin) {
+		u.ChangedAt = time.Now()
+		u.ChangedBy = sql.NullInt64{}
+		if err = env.DB.UpdateUser(u); err != nil {
+			return nil, err
+		}
+	}
+
+	return u, nil
+}
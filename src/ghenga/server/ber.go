@@ -0,0 +1,200 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// This file contains the small subset of the ASN.1 Basic Encoding Rules
+// needed for talking to an LDAP server (RFC 4511, section 5.1): definite
+// lengths, integers, booleans, octet strings and constructed values.
+
+// BER tags used by LDAP.
+const (
+	berBoolean     = 0x01
+	berInteger     = 0x02
+	berOctetString = 0x04
+	berEnumerated  = 0x0a
+	berSequence    = 0x30
+)
+
+// berMaxLength is the maximum length of a value read from the network.
+const berMaxLength = 1 << 20
+
+// berValue is a decoded BER value. For constructed values, Children contains
+// the decoded content.
+type berValue struct {
+	Tag      byte
+	Data     []byte
+	Children []berValue
+}
+
+// berConstructed returns true if the tag denotes a constructed value.
+func berConstructed(tag byte) bool {
+	return tag&0x20 != 0
+}
+
+// berEncode returns the encoding of a value with the tag and the content.
+func berEncode(tag byte, content []byte) []byte {
+	n := len(content)
+	buf := []byte{tag}
+
+	switch {
+	case n < 0x80:
+		buf = append(buf, byte(n))
+	case n <= 0xff:
+		buf = append(buf, 0x81, byte(n))
+	case n <= 0xffff:
+		buf = append(buf, 0x82, byte(n>>8), byte(n))
+	default:
+		buf = append(buf, 0x84, byte(n>>24), byte(n>>16), byte(n>>8), byte(n))
+	}
+
+	return append(buf, content...)
+}
+
+// berConcat returns the encoding of a constructed value containing values.
+func berConcat(tag byte, values ...[]byte) []byte {
+	var content []byte
+	for _, v := range values {
+		content = append(content, v...)
+	}
+
+	return berEncode(tag, content)
+}
+
+// berInt returns the encoding of an integer with the tag, e.g. berInteger or
+// berEnumerated.
+func berInt(tag byte, v int64) []byte {
+	var content []byte
+	for {
+		content = append([]byte{byte(v)}, content...)
+		if (v >= -0x80 && v < 0x80) || len(content) == 8 {
+			break
+		}
+		v >>= 8
+	}
+
+	return berEncode(tag, content)
+}
+
+// berBool returns the encoding of a boolean.
+func berBool(v bool) []byte {
+	if v {
+		return berEncode(berBoolean, []byte{0xff})
+	}
+
+	return berEncode(berBoolean, []byte{0})
+}
+
+// berString returns the encoding of s with the tag, e.g. berOctetString.
+func berString(tag byte, s string) []byte {
+	return berEncode(tag, []byte(s))
+}
+
+// berReadLength reads the length of a value.
+func berReadLength(rd io.ByteReader) (int, error) {
+	b, err := rd.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+
+	if b < 0x80 {
+		return int(b), nil
+	}
+
+	n := int(b & 0x7f)
+	if n == 0 || n > 4 {
+		return 0, fmt.Errorf("unsupported BER length encoding 0x%02x", b)
+	}
+
+	length := 0
+	for i := 0; i < n; i++ {
+		b, err = rd.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		length = length<<8 | int(b)
+	}
+
+	if length > berMaxLength {
+		return 0, fmt.Errorf("BER value too large (%d bytes)", length)
+	}
+
+	return length, nil
+}
+
+// berRead reads and decodes the next value from rd.
+func berRead(rd *bufio.Reader) (berValue, error) {
+	tag, err := rd.ReadByte()
+	if err != nil {
+		return berValue{}, err
+	}
+
+	length, err := berReadLength(rd)
+	if err != nil {
+		return berValue{}, err
+	}
+
+	buf := make([]byte, length)
+	if _, err = io.ReadFull(rd, buf); err != nil {
+		return berValue{}, err
+	}
+
+	return berDecode(tag, buf)
+}
+
+// berDecode decodes the content of a value with the tag. The content of
+// constructed values is decoded recursively.
+func berDecode(tag byte, data []byte) (berValue, error) {
+	v := berValue{Tag: tag, Data: data}
+	if !berConstructed(tag) {
+		return v, nil
+	}
+
+	for len(data) > 0 {
+		rd := bytes.NewReader(data[1:])
+		length, err := berReadLength(rd)
+		if err != nil {
+			return berValue{}, errors.New("truncated BER value")
+		}
+
+		start := len(data) - rd.Len()
+		if length > rd.Len() {
+			return berValue{}, errors.New("truncated BER value")
+		}
+
+		child, err := berDecode(data[0], data[start:start+length])
+		if err != nil {
+			return berValue{}, err
+		}
+
+		v.Children = append(v.Children, child)
+		data = data[start+length:]
+	}
+
+	return v, nil
+}
+
+// Int returns the value of an integer or enumerated value.
+func (v berValue) Int() (int64, error) {
+	if len(v.Data) == 0 || len(v.Data) > 8 {
+		return 0, errors.New("invalid BER integer")
+	}
+
+	// sign extension of the first byte
+	n := int64(int8(v.Data[0]))
+	for _, b := range v.Data[1:] {
+		n = n<<8 | int64(b)
+	}
+
+	return n, nil
+}
+
+// String returns the content of a primitive value as a string.
+func (v berValue) String() string {
+	return string(v.Data)
+}
@@ -0,0 +1,130 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestBEREncode(t *testing.T) {
+	for i, test := range []struct {
+		data []byte
+		want []byte
+	}{
+		{berInt(berInteger, 0), []byte{0x02, 0x01, 0x00}},
+		{berInt(berInteger, 127), []byte{0x02, 0x01, 0x7f}},
+		{berInt(berInteger, 128), []byte{0x02, 0x02, 0x00, 0x80}},
+		{berInt(berInteger, 256), []byte{0x02, 0x02, 0x01, 0x00}},
+		{berInt(berInteger, -1), []byte{0x02, 0x01, 0xff}},
+		{berInt(berInteger, -128), []byte{0x02, 0x01, 0x80}},
+		{berInt(berInteger, -129), []byte{0x02, 0x02, 0xff, 0x7f}},
+		{berInt(berEnumerated, 3), []byte{0x0a, 0x01, 0x03}},
+		{berBool(true), []byte{0x01, 0x01, 0xff}},
+		{berBool(false), []byte{0x01, 0x01, 0x00}},
+		{berString(berOctetString, ""), []byte{0x04, 0x00}},
+		{berString(berOctetString, "foo"), []byte{0x04, 0x03, 'f', 'o', 'o'}},
+		{berEncode(berOctetString, make([]byte, 200))[:3], []byte{0x04, 0x81, 200}},
+		{berEncode(berOctetString, make([]byte, 300))[:4], []byte{0x04, 0x82, 0x01, 0x2c}},
+		// bind request with message ID 1 from RFC 4511
+		{
+			berConcat(berSequence, berInt(berInteger, 1),
+				berConcat(ldapBindRequest,
+					berInt(berInteger, 3),
+					berString(berOctetString, "cn=admin"),
+					berString(ldapAuthSimple, "secret"))),
+			append(append([]byte{0x30, 0x1a, 0x02, 0x01, 0x01, 0x60, 0x15, 0x02, 0x01, 0x03, 0x04, 0x08},
+				"cn=admin"...), append([]byte{0x80, 0x06}, "secret"...)...),
+		},
+	} {
+		if !bytes.Equal(test.data, test.want) {
+			t.Errorf("test %d: want % x, got % x", i, test.want, test.data)
+		}
+	}
+}
+
+func TestBERDecode(t *testing.T) {
+	long := make([]byte, 300)
+	for i := range long {
+		long[i] = 'x'
+	}
+
+	msg := berConcat(berSequence,
+		berInt(berInteger, 23),
+		berConcat(ldapSearchDone,
+			berInt(berEnumerated, 32),
+			berString(berOctetString, "cn=group"),
+			berString(berOctetString, string(long))))
+
+	v, err := berRead(bufio.NewReader(bytes.NewReader(msg)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if v.Tag != berSequence || len(v.Children) != 2 {
+		t.Fatalf("wrong message decoded: %v", v)
+	}
+
+	if id, err := v.Children[0].Int(); err != nil || id != 23 {
+		t.Errorf("wrong message ID %v (%v)", id, err)
+	}
+
+	op := v.Children[1]
+	if op.Tag != ldapSearchDone || len(op.Children) != 3 {
+		t.Fatalf("wrong operation decoded: %v", op)
+	}
+
+	if code, err := op.Children[0].Int(); err != nil || code != 32 {
+		t.Errorf("wrong result code %v (%v)", code, err)
+	}
+
+	if op.Children[1].String() != "cn=group" || op.Children[2].String() != string(long) {
+		t.Errorf("wrong strings decoded: %v", op.Children[1:])
+	}
+
+	// truncated messages
+	for i := 1; i < len(msg); i++ {
+		if _, err := berRead(bufio.NewReader(bytes.NewReader(msg[:i]))); err == nil {
+			t.Errorf("message truncated to %d bytes accepted", i)
+		}
+	}
+
+	// content shorter than the nested values
+	if _, err := berDecode(berSequence, []byte{0x04, 0x05, 'a'}); err == nil {
+		t.Errorf("truncated nested value accepted")
+	}
+
+	// the length of a value is limited
+	if _, err := berRead(bufio.NewReader(bytes.NewReader([]byte{0x04, 0x84, 0x7f, 0xff, 0xff, 0xff}))); err == nil {
+		t.Errorf("huge value accepted")
+	}
+}
+
+func TestBERInt(t *testing.T) {
+	for _, n := range []int64{0, 1, -1, 127, 128, -128, -129, 255, 256, 65535, -65536, 1<<62 + 5, -1 << 63} {
+		v, err := berDecode(berInteger, berInt(berInteger, n)[2:])
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		got, err := v.Int()
+		if err != nil {
+			t.Errorf("%d: %v", n, err)
+			continue
+		}
+
+		if got != n {
+			t.Errorf("want %d, got %d", n, got)
+		}
+	}
+
+	for _, data := range [][]byte{nil, make([]byte, 9)} {
+		if _, err := (berValue{Tag: berInteger, Data: data}).Int(); err == nil {
+			t.Errorf("invalid integer % x accepted", data)
+		}
+	}
+
+	if !reflect.DeepEqual(berInt(berInteger, -1<<63), []byte{0x02, 0x08, 0x80, 0, 0, 0, 0, 0, 0, 0}) {
+		t.Errorf("wrong encoding for the smallest integer: % x", berInt(berInteger, -1<<63))
+	}
+}
@@ -6,4 +6,53 @@ import "time"
 type Config struct {
 	SessionDuration time.Duration
 	Debug           bool
+
+	// WebhookAttempts is the number of times a webhook delivery is tried,
+	// WebhookBackoff the time to wait before the first retry, it is doubled
+	// for each further retry. If unset, defaults are used.
+	WebhookAttempts int
+	WebhookBackoff  time.Duration
+
+	// Authenticator checks the password at Login, DBAuthenticator is used if
+	// it is nil.
+	Authenticator Authenticator
+
+	// OIDC enables the login with an OpenID Connect provider if set.
+	OIDC *OIDCConfig
+}
+
+// OIDCConfig configures the login with an OpenID Connect provider.
+type OIDCConfig struct {
+	// Issuer is the URL of the provider, the configuration is discovered
+	// below it. ClientID and ClientSecret are registered with the provider,
+	// RedirectURL is the absolute URL of /api/login/oidc/callback.
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+
+	// LoginClaim is the claim of the ID token which contains the login of
+	// the user, it defaults to preferred_username. Users are bound to the
+	// subject (the claim sub) on their first login and found by it
+	// afterwards, the login is only used to name new users.
+	LoginClaim string
+
+	// LinkUsers allows binding an existing local user to the subject if the
+	// login matches on the first login with the provider. Users can often
+	// change claims like preferred_username themselves, so this allows them
+	// to take over local users which are not bound yet. It should only be
+	// enabled while existing users are migrated, or if the login claim
+	// cannot be chosen by the users.
+	LinkUsers bool
+
+	// AutoProvision creates users which log in for the first time with the
+	// roles in DefaultRoles. Otherwise, the user must already exist.
+	AutoProvision bool
+	DefaultRoles  []string
+
+	// If AdminGroup is set, users in this group get the role admin when they
+	// log in and users not in this group lose it. The groups are read from
+	// the claim GroupsClaim, which defaults to groups.
+	AdminGroup  string
+	GroupsClaim string
 }
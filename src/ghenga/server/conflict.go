@@ -0,0 +1,153 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"ghenga/db"
+	"net/http"
+)
+
+// errVersionMismatch is the message for an update based on an outdated
+// version.
+var errVersionMismatch = errors.New("version field does not match")
+
+// parseMergeMode returns true if the client requested an automatic merge
+// with the query parameter `merge=auto`.
+func parseMergeMode(req *http.Request) (bool, error) {
+	switch req.URL.Query().Get("merge") {
+	case "":
+		return false, nil
+	case "auto":
+		return true, nil
+	}
+
+	return false, StatusError{
+		Code: http.StatusBadRequest,
+		Err:  errors.New("merge must be auto"),
+	}
+}
+
+// revisionData returns the JSON data of a version of a record from the
+// revision history, or nil if the version cannot be found.
+func revisionData(env *Env, entity string, id, version int64) []byte {
+	rev, err := env.DB.FindRevision(entity, id, version)
+	if err != nil {
+		env.Debugf("revision %v of %v %v not found: %v", version, entity, id, err)
+		return nil
+	}
+
+	return []byte(rev.Data)
+}
+
+// recordConflict returns a ConflictError for an update based on an outdated
+// version. The fields changed by the client are found by comparing the
+// client's data with the version from the revision history.
+func recordConflict(env *Env, entity string, id, version int64, current interface{}, client []byte) error {
+	server, err := json.Marshal(current)
+	if err != nil {
+		return err
+	}
+
+	base := revisionData(env, entity, id, version)
+	_, conflicts, err := db.MergeJSON(base, client, server)
+	if err != nil {
+		return err
+	}
+
+	return ConflictError{Err: errVersionMismatch, Current: current, Conflicts: conflicts}
+}
+
+// patchConflict returns a ConflictError for a JSON merge patch based on an
+// outdated version. The client's version of the record is built by applying
+// the patch to the version the client started from.
+func patchConflict(env *Env, entity string, id int64, current interface{}, patch map[string]interface{}) error {
+	server, err := json.Marshal(current)
+	if err != nil {
+		return err
+	}
+
+	version, _ := patch["version"].(float64)
+	base := revisionData(env, entity, id, int64(version))
+
+	src := base
+	if src == nil {
+		src = server
+	}
+
+	var doc interface{}
+	if err = json.Unmarshal(src, &doc); err != nil {
+		return err
+	}
+
+	client, err := json.Marshal(mergePatch(doc, patch))
+	if err != nil {
+		return err
+	}
+
+	_, conflicts, err := db.MergeJSON(base, client, server)
+	if err != nil {
+		return err
+	}
+
+	return ConflictError{Err: errVersionMismatch, Current: current, Conflicts: conflicts}
+}
+
+// canonicalPerson returns the JSON representation of jp as it would be saved
+// for a person, so that it can be compared with other versions.
+func canonicalPerson(jp db.PersonJSON) ([]byte, error) {
+	var p db.Person
+	p.Update(jp)
+	return json.Marshal(p)
+}
+
+// personConflict returns a ConflictError for an update of p with newPerson,
+// which is based on an outdated version.
+func personConflict(env *Env, p *db.Person, newPerson db.PersonJSON) error {
+	client, err := canonicalPerson(newPerson)
+	if err != nil {
+		return err
+	}
+
+	return recordConflict(env, db.RevisionPerson, p.ID, newPerson.Version, p, client)
+}
+
+// mergePerson does a three-way merge of the changes the client made to
+// original with the current person p. If the changes overlap, a
+// ConflictError is returned. The merged person has the current version.
+func mergePerson(p *db.Person, original, newPerson db.PersonJSON) (db.PersonJSON, error) {
+	base, err := canonicalPerson(original)
+	if err != nil {
+		return db.PersonJSON{}, err
+	}
+
+	client, err := canonicalPerson(newPerson)
+	if err != nil {
+		return db.PersonJSON{}, err
+	}
+
+	server, err := json.Marshal(p)
+	if err != nil {
+		return db.PersonJSON{}, err
+	}
+
+	merged, conflicts, err := db.MergeJSON(base, client, server)
+	if err != nil {
+		return db.PersonJSON{}, err
+	}
+
+	if len(conflicts) > 0 {
+		return db.PersonJSON{}, ConflictError{
+			Err:       errors.New("changes conflict with the current version"),
+			Current:   p,
+			Conflicts: conflicts,
+		}
+	}
+
+	var jp db.PersonJSON
+	if err = json.Unmarshal(merged, &jp); err != nil {
+		return db.PersonJSON{}, err
+	}
+
+	jp.Version = p.Version
+	return jp, nil
+}
@@ -1,5 +1,10 @@
 package server
 
+import (
+	"ghenga/db"
+	"net/http"
+)
+
 // Error bundles an HTTP status code and an error.
 type Error interface {
 	error
@@ -23,3 +28,28 @@ func (err StatusError) Error() string {
 	}
 	return err.Err.Error()
 }
+
+// ConflictError is returned when a record cannot be saved because it was
+// modified in the meantime. The current record and the conflicting fields are
+// returned to the client.
+type ConflictError struct {
+	Err       error
+	Current   interface{}
+	Conflicts []db.FieldConflict
+}
+
+// Status returns the HTTP status for this error.
+func (err ConflictError) Status() int {
+	return http.StatusConflict
+}
+
+func (err ConflictError) Error() string {
+	return err.Err.Error()
+}
+
+// conflictJSON is the JSON document returned for a ConflictError.
+type conflictJSON struct {
+	Message   string             `json:"message"`
+	Current   interface{}        `json:"current"`
+	Conflicts []db.FieldConflict `json:"conflicts"`
+}
@@ -0,0 +1,95 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"ghenga/db"
+	"net/http"
+	"strings"
+)
+
+// entityTag returns the ETag for a record, which is derived from the kind of
+// record, the ID and the version.
+func entityTag(entity string, id, version int64) string {
+	return fmt.Sprintf(`"%s-%d-%d"`, entity, id, version)
+}
+
+func personETag(p *db.Person) string {
+	return entityTag("person", p.ID, p.Version)
+}
+
+func userETag(u *db.User) string {
+	return entityTag("user", u.ID, u.Version)
+}
+
+// matchETag returns true if the value of an If-Match or If-None-Match header
+// contains tag or is "*". For If-None-Match, weak tags are compared as well.
+func matchETag(header, tag string, weak bool) bool {
+	for _, t := range strings.Split(header, ",") {
+		t = strings.TrimSpace(t)
+		if t == "*" {
+			return true
+		}
+
+		if weak {
+			t = strings.TrimPrefix(t, "W/")
+		}
+
+		if t == tag {
+			return true
+		}
+	}
+
+	return false
+}
+
+// checkIfMatch returns an error with the status code 412 if the request
+// contains an If-Match header which does not match tag, the ETag of the
+// current version of the record.
+func checkIfMatch(req *http.Request, tag string) error {
+	header := req.Header.Get("If-Match")
+	if header == "" || matchETag(header, tag, false) {
+		return nil
+	}
+
+	return StatusError{
+		Code: http.StatusPreconditionFailed,
+		Err:  errors.New("record has been modified"),
+	}
+}
+
+// hasIfMatch returns true if the request contains an If-Match header. The
+// header replaces the version field in the body.
+func hasIfMatch(req *http.Request) bool {
+	return req.Header.Get("If-Match") != ""
+}
+
+// notModified sets the ETag header for the response. If the request contains
+// an If-None-Match header which matches tag, the status code 304 is sent and
+// true is returned.
+func notModified(res http.ResponseWriter, req *http.Request, tag string) bool {
+	res.Header().Set("ETag", tag)
+
+	header := req.Header.Get("If-None-Match")
+	if header == "" || !matchETag(header, tag, true) {
+		return false
+	}
+
+	res.WriteHeader(http.StatusNotModified)
+	return true
+}
+
+// versionError converts db.ErrVersionMismatch into an error with the status
+// code 412 if the request contains an If-Match header, and 409 otherwise.
+func versionError(req *http.Request, err error) error {
+	if err != db.ErrVersionMismatch {
+		return err
+	}
+
+	code := http.StatusConflict
+	if hasIfMatch(req) {
+		code = http.StatusPreconditionFailed
+	}
+
+	return StatusError{Code: code, Err: errors.New("record has been modified")}
+}
@@ -0,0 +1,26 @@
+package server
+
+import "testing"
+
+var matchETagTests = []struct {
+	header string
+	weak   bool
+	match  bool
+}{
+	{`"person-1-2"`, false, true},
+	{`"person-1-3"`, false, false},
+	{`"foo", "person-1-2"`, false, true},
+	{`*`, false, true},
+	{`W/"person-1-2"`, false, false},
+	{`W/"person-1-2"`, true, true},
+	{`"person-1-2`, true, false},
+}
+
+func TestMatchETag(t *testing.T) {
+	tag := entityTag("person", 1, 2)
+	for i, test := range matchETagTests {
+		if m := matchETag(test.header, tag, test.weak); m != test.match {
+			t.Errorf("test %d: matchETag(%q, %q, %v) returned %v", i, test.header, tag, test.weak, m)
+		}
+	}
+}
@@ -3,10 +3,13 @@
 package server
 
 import (
+	"database/sql"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"ghenga/db"
 	"net/http"
+	"strconv"
 
 	"golang.org/x/net/context"
 )
@@ -74,7 +77,7 @@ func RequireAuth(h HandleFunc) HandleFunc {
 }
 
 // RequireAdmin ensures that only authenticated requests from a user which has
-// the admin flag set are passed to H, otherwise an error is returned.
+// the role admin are passed to H, otherwise an error is returned.
 func RequireAdmin(h HandleFunc) HandleFunc {
 	return func(ctx context.Context, env *Env, res http.ResponseWriter, req *http.Request) error {
 		session, err := findSession(env, req)
@@ -100,12 +103,207 @@ func RequireAdmin(h HandleFunc) HandleFunc {
 	}
 }
 
+// RequirePermission ensures that only authenticated requests from a user
+// which is granted perm by one of its roles are passed to H, otherwise an
+// error is returned.
+func RequirePermission(perm string, h HandleFunc) HandleFunc {
+	return RequirePermissions([]string{perm}, h)
+}
+
+// RequirePermissions works like RequirePermission, but the user must be
+// granted all of perms.
+func RequirePermissions(perms []string, h HandleFunc) HandleFunc {
+	return func(ctx context.Context, env *Env, res http.ResponseWriter, req *http.Request) error {
+		session, err := findSession(env, req)
+		if err != nil {
+			return err
+		}
+
+		u, err := env.DB.FindUserName(session.User)
+		if err != nil {
+			return err
+		}
+
+		granted, err := env.DB.UserPermissions(u)
+		if err != nil {
+			return err
+		}
+
+		for _, perm := range perms {
+			if !granted.Has(perm) {
+				return StatusError{
+					Code: http.StatusForbidden,
+					Err:  fmt.Errorf("permission %v denied", perm),
+				}
+			}
+		}
+
+		ctx = db.NewContextWithSession(ctx, session)
+
+		return h(ctx, env, res, req)
+	}
+}
+
+// currentUser returns the user for the session stored in ctx by RequireAuth,
+// RequireAdmin or RequirePermission.
+func currentUser(ctx context.Context, env *Env) (*db.User, error) {
+	session, ok := db.SessionFromContext(ctx)
+	if !ok {
+		return nil, StatusError{
+			Code: http.StatusUnauthorized,
+			Err:  errors.New("no session found"),
+		}
+	}
+
+	return env.DB.FindUserName(session.User)
+}
+
+// changedBy returns the ID of the current user, which is recorded as the
+// author of a change in the revision history.
+func changedBy(ctx context.Context, env *Env) (sql.NullInt64, error) {
+	u, err := currentUser(ctx, env)
+	if err != nil {
+		return sql.NullInt64{}, err
+	}
+
+	return sql.NullInt64{Int64: u.ID, Valid: true}, nil
+}
+
+const (
+	defaultPageLimit = 50
+	maxPageLimit     = 1000
+)
+
+// totalCountHeaderName is the HTTP header which contains the total number of
+// items for a paginated list.
+const totalCountHeaderName = "X-Total-Count"
+
+// parseLimit returns the value of the query parameter `limit`.
+func parseLimit(req *http.Request) (int, error) {
+	s := req.URL.Query().Get("limit")
+	if s == "" {
+		return defaultPageLimit, nil
+	}
+
+	limit, err := strconv.Atoi(s)
+	if err != nil || limit <= 0 || limit > maxPageLimit {
+		return 0, StatusError{
+			Code: http.StatusBadRequest,
+			Err:  fmt.Errorf("limit must be between 1 and %d", maxPageLimit),
+		}
+	}
+
+	return limit, nil
+}
+
+// parsePage returns the values of the query parameters `limit` and `offset`.
+func parsePage(req *http.Request) (limit, offset int, err error) {
+	limit, err = parseLimit(req)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	if s := req.URL.Query().Get("offset"); s != "" {
+		offset, err = strconv.Atoi(s)
+		if err != nil || offset < 0 {
+			return 0, 0, StatusError{
+				Code: http.StatusBadRequest,
+				Err:  errors.New("invalid offset"),
+			}
+		}
+	}
+
+	return limit, offset, nil
+}
+
+// nextCursorHeaderName is the HTTP header which contains the cursor for the
+// next part of a list.
+const nextCursorHeaderName = "X-Next-Cursor"
+
+// parseListOptions returns the list options from the query parameters `limit`,
+// `cursor` and `sort`. All other query parameters are passed as filters, the
+// ones which are not a filter of the list are ignored.
+func parseListOptions(req *http.Request) (db.ListOptions, error) {
+	limit, err := parseLimit(req)
+	if err != nil {
+		return db.ListOptions{}, err
+	}
+
+	opts := db.ListOptions{
+		Limit:  limit,
+		Cursor: req.URL.Query().Get("cursor"),
+		Sort:   db.ParseSort(req.URL.Query().Get("sort")),
+		Filter: make(map[string]string),
+	}
+
+	for name, values := range req.URL.Query() {
+		switch name {
+		case "limit", "cursor", "sort":
+			continue
+		}
+
+		opts.Filter[name] = values[0]
+	}
+
+	return opts, nil
+}
+
+// userFilters are the filters which contain the ID of a user.
+var userFilters = []string{"created_by", "changed_by", "owner_id"}
+
+// filterCurrentUser replaces the value `me` of the filters created_by,
+// changed_by and owner_id with the ID of the current user, e.g. for listing
+// the people the user has created.
+func filterCurrentUser(ctx context.Context, env *Env, opts db.ListOptions) error {
+	for _, name := range userFilters {
+		if opts.Filter[name] != "me" {
+			continue
+		}
+
+		u, err := currentUser(ctx, env)
+		if err != nil {
+			return err
+		}
+
+		opts.Filter[name] = strconv.FormatInt(u.ID, 10)
+	}
+
+	return nil
+}
+
+// writePage sets the HTTP headers with the total number of items and the
+// next cursor for a part of a list.
+func writePage(res http.ResponseWriter, page db.Page) {
+	res.Header().Set(totalCountHeaderName, strconv.FormatInt(page.Total, 10))
+	if page.NextCursor != "" {
+		res.Header().Set(nextCursorHeaderName, page.NextCursor)
+	}
+}
+
+// listError converts a db.ListError into an error with status code 400.
+func listError(err error) error {
+	if e, ok := err.(db.ListError); ok {
+		return StatusError{Code: http.StatusBadRequest, Err: e}
+	}
+
+	return err
+}
+
 // Handle takes a HandleFunc and returns an http.Handler.
 func Handle(ctx context.Context, env *Env, h HandleFunc) http.Handler {
 	return http.HandlerFunc(func(wr http.ResponseWriter, req *http.Request) {
 		err := RecoverHandler(ctx, env, wr, req, h)
 		if err != nil {
 			switch e := err.(type) {
+			case ConflictError:
+				err = httpWriteJSON(wr, e.Status(), conflictJSON{
+					Message:   e.Error(),
+					Current:   e.Current,
+					Conflicts: e.Conflicts,
+				})
+				if err != nil {
+					env.Logf("error writing error document to client: %v", err)
+				}
 			case Error:
 				// return the error to the client as a nicely formatted json document.
 				err = httpWriteJSON(wr, e.Status(), jsonError{Message: e.Error()})
@@ -0,0 +1,154 @@
+package server
+
+import (
+	"bufio"
+	"fmt"
+	"ghenga/db"
+	"io"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// icalTimeLayout is the format for UTC timestamps in iCalendar data (RFC 5545,
+// section 3.3.5).
+const icalTimeLayout = "20060102T150405Z"
+
+// icalMaxLineLength is the maximum length of a line in octets, excluding the
+// line break.
+const icalMaxLineLength = 75
+
+// icalEscape escapes s for use as a TEXT value (RFC 5545, section 3.3.11).
+func icalEscape(s string) string {
+	return strings.NewReplacer(
+		`\`, `\\`,
+		`;`, `\;`,
+		`,`, `\,`,
+		"\r\n", `\n`,
+		"\n", `\n`,
+		"\r", `\n`,
+	).Replace(s)
+}
+
+// icalParam returns s for use as a parameter value. Values which contain
+// special characters are quoted, double quotes are not allowed at all and are
+// removed.
+func icalParam(s string) string {
+	s = strings.NewReplacer(`"`, "", "\r", "", "\n", " ").Replace(s)
+	if strings.ContainsAny(s, ":;,") {
+		return `"` + s + `"`
+	}
+
+	return s
+}
+
+// icalFold splits line into several lines of at most 75 octets each, the
+// continuation lines start with a single space (RFC 5545, section 3.1). Lines
+// are never split within a multi-byte UTF-8 sequence.
+func icalFold(line string) string {
+	if len(line) <= icalMaxLineLength {
+		return line
+	}
+
+	var out []string
+	max := icalMaxLineLength
+	for len(line) > max {
+		n := max
+		for n > 0 && !utf8.RuneStart(line[n]) {
+			n--
+		}
+
+		out = append(out, line[:n])
+		line = line[n:]
+
+		// continuation lines start with a space, which counts towards the limit
+		max = icalMaxLineLength - 1
+	}
+	out = append(out, line)
+
+	return strings.Join(out, "\r\n ")
+}
+
+// icalWriter writes content lines to an iCalendar stream.
+type icalWriter struct {
+	wr  *bufio.Writer
+	err error
+}
+
+// line writes a content line consisting of the name and the value, which
+// must already be escaped.
+func (w *icalWriter) line(name, value string) {
+	if w.err != nil {
+		return
+	}
+
+	_, w.err = w.wr.WriteString(icalFold(name+":"+value) + "\r\n")
+}
+
+// text writes a content line with a TEXT value, which is omitted if it is
+// empty.
+func (w *icalWriter) text(name, value string) {
+	if value == "" {
+		return
+	}
+
+	w.line(name, icalEscape(value))
+}
+
+// time writes a content line with a UTC timestamp.
+func (w *icalWriter) time(name string, t time.Time) {
+	w.line(name, t.UTC().Format(icalTimeLayout))
+}
+
+// writeCalendar writes the events as an iCalendar object (RFC 5545) to wr.
+// The people participating in the events are included as attendees if they
+// are contained in the map people and have an email address, the primary
+// address is used.
+func writeCalendar(wr io.Writer, name string, events []*db.Event, people map[int64]*db.Person, now time.Time) error {
+	w := &icalWriter{wr: bufio.NewWriter(wr)}
+
+	w.line("BEGIN", "VCALENDAR")
+	w.line("VERSION", "2.0")
+	w.line("PRODID", "-//ghenga//ghenga CRM//EN")
+	w.line("CALSCALE", "GREGORIAN")
+	w.line("METHOD", "PUBLISH")
+	w.text("X-WR-CALNAME", name)
+
+	for _, e := range events {
+		w.line("BEGIN", "VEVENT")
+		w.line("UID", fmt.Sprintf("event-%d@ghenga", e.ID))
+		w.time("DTSTAMP", now)
+		w.time("DTSTART", e.StartAt)
+		w.time("DTEND", e.EndAt)
+		w.time("CREATED", e.CreatedAt)
+		w.time("LAST-MODIFIED", e.ChangedAt)
+		w.line("SEQUENCE", fmt.Sprintf("%d", e.Version))
+		w.text("SUMMARY", e.Title)
+		w.text("LOCATION", e.Location)
+		w.text("DESCRIPTION", e.Description)
+
+		for _, id := range e.PersonIDs {
+			p, ok := people[id]
+			if !ok {
+				continue
+			}
+
+			email := p.EmailAddresses.Primary()
+			if email == "" {
+				continue
+			}
+
+			w.line("ATTENDEE;CN="+icalParam(p.Name), "mailto:"+email)
+		}
+
+		w.line("END", "VEVENT")
+	}
+
+	w.line("END", "VCALENDAR")
+
+	if w.err != nil {
+		return w.err
+	}
+
+	return w.wr.Flush()
+}
@@ -0,0 +1,113 @@
+package server
+
+import (
+	"bytes"
+	"ghenga/db"
+	"strings"
+	"testing"
+	"time"
+)
+
+var icalEscapeTests = []struct {
+	before, after string
+}{
+	{"foo", "foo"},
+	{"foo, bar; baz", `foo\, bar\; baz`},
+	{`C:\foo`, `C:\\foo`},
+	{"line1\nline2\r\nline3", `line1\nline2\nline3`},
+}
+
+func TestICalEscape(t *testing.T) {
+	for i, test := range icalEscapeTests {
+		got := icalEscape(test.before)
+		if got != test.after {
+			t.Errorf("test %d: icalEscape(%q): want %q, got %q", i, test.before, test.after, got)
+		}
+	}
+}
+
+func TestICalFold(t *testing.T) {
+	for _, line := range []string{
+		"SUMMARY:short",
+		"DESCRIPTION:" + strings.Repeat("x", 200),
+		"LOCATION:" + strings.Repeat("Köln ", 40),
+		"SUMMARY:" + strings.Repeat("€", 100),
+	} {
+		folded := icalFold(line)
+
+		for i, l := range strings.Split(folded, "\r\n") {
+			if len(l) > icalMaxLineLength {
+				t.Errorf("line %d of folded %q is too long: %d octets", i, line, len(l))
+			}
+
+			if i > 0 && !strings.HasPrefix(l, " ") {
+				t.Errorf("continuation line %d of folded %q does not start with a space", i, line)
+			}
+		}
+
+		unfolded := strings.Replace(folded, "\r\n ", "", -1)
+		if unfolded != line {
+			t.Errorf("unfolding did not restore the original line:\nwant: %q\n got: %q", line, unfolded)
+		}
+	}
+}
+
+func TestWriteCalendar(t *testing.T) {
+	ts := time.Date(2016, 4, 24, 10, 30, 7, 0, time.UTC)
+	events := []*db.Event{
+		{
+			ID:          23,
+			Title:       "Meeting, important",
+			Description: "Discuss the offer;\nbring coffee",
+			Location:    "Köln",
+			StartAt:     time.Date(2016, 5, 2, 12, 0, 0, 0, time.UTC),
+			EndAt:       time.Date(2016, 5, 2, 13, 30, 0, 0, time.UTC),
+			PersonIDs:   []int64{1, 2},
+			CreatedAt:   ts,
+			ChangedAt:   ts,
+			Version:     3,
+		},
+	}
+
+	people := map[int64]*db.Person{
+		1: {ID: 1, Name: "Nicolai Person", EmailAddresses: db.EmailAddresses{
+			{Type: "private", Address: "nicolai@private.example.com"},
+			{Type: "work", Address: "nicolai@example.com", Primary: true},
+		}},
+		2: {ID: 2, Name: "No Mail"},
+	}
+
+	buf := bytes.NewBuffer(nil)
+	err := writeCalendar(buf, "ghenga", events, people, ts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := strings.Join([]string{
+		"BEGIN:VCALENDAR",
+		"VERSION:2.0",
+		"PRODID:-//ghenga//ghenga CRM//EN",
+		"CALSCALE:GREGORIAN",
+		"METHOD:PUBLISH",
+		"X-WR-CALNAME:ghenga",
+		"BEGIN:VEVENT",
+		"UID:event-23@ghenga",
+		"DTSTAMP:20160424T103007Z",
+		"DTSTART:20160502T120000Z",
+		"DTEND:20160502T133000Z",
+		"CREATED:20160424T103007Z",
+		"LAST-MODIFIED:20160424T103007Z",
+		"SEQUENCE:3",
+		`SUMMARY:Meeting\, important`,
+		"LOCATION:Köln",
+		`DESCRIPTION:Discuss the offer\;\nbring coffee`,
+		"ATTENDEE;CN=Nicolai Person:mailto:nicolai@example.com",
+		"END:VEVENT",
+		"END:VCALENDAR",
+		"",
+	}, "\r\n")
+
+	if buf.String() != want {
+		t.Fatalf("wrong calendar returned:\nwant:\n%s\ngot:\n%s", want, buf.String())
+	}
+}
@@ -0,0 +1,339 @@
+package server
+
+import (
+	"bufio"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"ghenga/db"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// LDAP protocol constants (RFC 4511), the protocol operations are tagged
+// APPLICATION n.
+const (
+	ldapBindRequest        = 0x60
+	ldapBindResponse       = 0x61
+	ldapUnbindRequest      = 0x42
+	ldapSearchRequest      = 0x63
+	ldapSearchEntry        = 0x64
+	ldapSearchDone         = 0x65
+	ldapSearchReference    = 0x73
+	ldapAuthSimple         = 0x80
+	ldapFilterEquality     = 0xa3
+	ldapScopeBaseObject    = 0
+	ldapNeverDerefAlias    = 0
+	ldapSuccess            = 0
+	ldapInvalidCredentials = 49
+)
+
+const defaultLDAPTimeout = 10 * time.Second
+
+// LDAPAuthenticator checks the password by binding to an LDAP server as the
+// user. On the first login, a local user is created with DefaultRoles.
+type LDAPAuthenticator struct {
+	// URL is the address of the server, e.g. ldap://ldap.example.com or
+	// ldaps://ldap.example.com:636.
+	URL string
+
+	// UserDN is the DN of a user, %s is replaced with the login, e.g.
+	// uid=%s,ou=people,dc=example,dc=com.
+	UserDN string
+
+	// If UserGroup is set, only members of the group with this DN may log
+	// in. If AdminGroup is set, members of this group get the role admin
+	// and all other users lose it. The members are found in the attribute
+	// GroupAttribute of the group, which defaults to member.
+	UserGroup      string
+	AdminGroup     string
+	GroupAttribute string
+
+	DefaultRoles []string
+
+	// Timeout limits the time for talking to the server, TLSConfig is used
+	// for ldaps URLs.
+	Timeout   time.Duration
+	TLSConfig *tls.Config
+}
+
+// Authenticate binds as the user and checks the group memberships. The local
+// user is created or updated accordingly. LDAP servers usually compare the
+// login case-insensitively, so it is converted to lower case first, which
+// makes sure that there is only one local user for it.
+func (a *LDAPAuthenticator) Authenticate(env *Env, login, password string) (*db.User, error) {
+	login = strings.ToLower(login)
+
+	admin, err := a.check(login, password)
+	if err != nil {
+		if err != ErrInvalidLogin {
+			env.Logf("LDAP login for %q failed: %v", login, err)
+		}
+		return nil, err
+	}
+
+	l := externalLogin{
+		Login:  login,
+		Create: true,
+		Roles:  a.DefaultRoles,
+	}
+
+	if a.AdminGroup != "" {
+		l.Admin = &admin
+	}
+
+	return l.user(env)
+}
+
+// check binds as the user and returns whether the user is a member of the
+// admin group. If the user may not log in, ErrInvalidLogin is returned.
+func (a *LDAPAuthenticator) check(login, password string) (admin bool, err error) {
+	// a bind with an empty password is an unauthenticated bind, which
+	// succeeds for any DN
+	if login == "" || password == "" {
+		return false, ErrInvalidLogin
+	}
+
+	c, err := a.dial()
+	if err != nil {
+		return false, err
+	}
+	defer c.close()
+
+	dn := fmt.Sprintf(a.UserDN, ldapEscapeDN(login))
+	err = c.bind(dn, password)
+	if e, ok := err.(ldapResultError); ok && e.Code == ldapInvalidCredentials {
+		return false, ErrInvalidLogin
+	}
+	if err != nil {
+		return false, err
+	}
+
+	attr := a.GroupAttribute
+	if attr == "" {
+		attr = "member"
+	}
+
+	if a.UserGroup != "" {
+		member, err := c.member(a.UserGroup, attr, dn)
+		if err != nil {
+			return false, err
+		}
+
+		if !member {
+			return false, ErrInvalidLogin
+		}
+	}
+
+	if a.AdminGroup != "" {
+		return c.member(a.AdminGroup, attr, dn)
+	}
+
+	return false, nil
+}
+
+// ldapEscapeDN escapes s for use as an attribute value in a DN (RFC 4514).
+func ldapEscapeDN(s string) string {
+	var buf []byte
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case strings.IndexByte(`"+,;<>\=`, c) >= 0,
+			c == ' ' && (i == 0 || i == len(s)-1),
+			c == '#' && i == 0:
+			buf = append(buf, '\\', c)
+		case c == 0:
+			buf = append(buf, `\00`...)
+		default:
+			buf = append(buf, c)
+		}
+	}
+
+	return string(buf)
+}
+
+// ldapResultError is returned when the server answers with a result code
+// other than success.
+type ldapResultError struct {
+	Code    int64
+	Message string
+}
+
+func (e ldapResultError) Error() string {
+	return fmt.Sprintf("LDAP result code %d: %v", e.Code, e.Message)
+}
+
+// ldapConn is a connection to an LDAP server.
+type ldapConn struct {
+	conn      net.Conn
+	rd        *bufio.Reader
+	messageID int64
+	timeout   time.Duration
+}
+
+// dial connects to the server.
+func (a *LDAPAuthenticator) dial() (*ldapConn, error) {
+	u, err := url.Parse(a.URL)
+	if err != nil {
+		return nil, err
+	}
+
+	timeout := a.Timeout
+	if timeout == 0 {
+		timeout = defaultLDAPTimeout
+	}
+
+	dialer := &net.Dialer{Timeout: timeout}
+	host := u.Host
+
+	var conn net.Conn
+	switch u.Scheme {
+	case "ldap":
+		if u.Port() == "" {
+			host = net.JoinHostPort(u.Hostname(), "389")
+		}
+		conn, err = dialer.Dial("tcp", host)
+	case "ldaps":
+		if u.Port() == "" {
+			host = net.JoinHostPort(u.Hostname(), "636")
+		}
+		cfg := a.TLSConfig
+		if cfg == nil {
+			cfg = &tls.Config{ServerName: u.Hostname()}
+		}
+		conn, err = tls.DialWithDialer(dialer, "tcp", host, cfg)
+	default:
+		return nil, fmt.Errorf("unsupported LDAP URL scheme %q", u.Scheme)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if err = conn.SetDeadline(time.Now().Add(timeout)); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return &ldapConn{conn: conn, rd: bufio.NewReader(conn), timeout: timeout}, nil
+}
+
+// send sends a request with the protocol operation op.
+func (c *ldapConn) send(op []byte) error {
+	c.messageID++
+	_, err := c.conn.Write(berConcat(berSequence, berInt(berInteger, c.messageID), op))
+	return err
+}
+
+// receive returns the protocol operation of the next response.
+func (c *ldapConn) receive() (berValue, error) {
+	msg, err := berRead(c.rd)
+	if err != nil {
+		return berValue{}, err
+	}
+
+	if msg.Tag != berSequence || len(msg.Children) < 2 {
+		return berValue{}, errors.New("invalid LDAP message")
+	}
+
+	id, err := msg.Children[0].Int()
+	if err != nil {
+		return berValue{}, err
+	}
+
+	if id != c.messageID {
+		return berValue{}, fmt.Errorf("unexpected LDAP message ID %d", id)
+	}
+
+	return msg.Children[1], nil
+}
+
+// ldapResult checks the LDAPResult in the response op.
+func ldapResult(op berValue) error {
+	if len(op.Children) < 3 {
+		return errors.New("invalid LDAP result")
+	}
+
+	code, err := op.Children[0].Int()
+	if err != nil {
+		return err
+	}
+
+	if code != ldapSuccess {
+		return ldapResultError{Code: code, Message: op.Children[2].String()}
+	}
+
+	return nil
+}
+
+// bind authenticates as dn with the password.
+func (c *ldapConn) bind(dn, password string) error {
+	err := c.send(berConcat(ldapBindRequest,
+		berInt(berInteger, 3),
+		berString(berOctetString, dn),
+		berString(ldapAuthSimple, password)))
+	if err != nil {
+		return err
+	}
+
+	op, err := c.receive()
+	if err != nil {
+		return err
+	}
+
+	if op.Tag != ldapBindResponse {
+		return fmt.Errorf("unexpected LDAP response 0x%02x to bind", op.Tag)
+	}
+
+	return ldapResult(op)
+}
+
+// member returns true if the group with the DN group lists dn in the
+// attribute attr.
+func (c *ldapConn) member(group, attr, dn string) (bool, error) {
+	err := c.send(berConcat(ldapSearchRequest,
+		berString(berOctetString, group),
+		berInt(berEnumerated, ldapScopeBaseObject),
+		berInt(berEnumerated, ldapNeverDerefAlias),
+		berInt(berInteger, 1),
+		berInt(berInteger, int64(c.timeout/time.Second)),
+		berBool(false),
+		berConcat(ldapFilterEquality,
+			berString(berOctetString, attr),
+			berString(berOctetString, dn)),
+		// request no attributes
+		berConcat(berSequence, berString(berOctetString, "1.1"))))
+	if err != nil {
+		return false, err
+	}
+
+	found := false
+	for {
+		op, err := c.receive()
+		if err != nil {
+			return false, err
+		}
+
+		switch op.Tag {
+		case ldapSearchEntry:
+			found = true
+		case ldapSearchReference:
+		case ldapSearchDone:
+			if err = ldapResult(op); err != nil {
+				return false, err
+			}
+			return found, nil
+		default:
+			return false, fmt.Errorf("unexpected LDAP response 0x%02x to search", op.Tag)
+		}
+	}
+}
+
+// close ends the session and closes the connection.
+func (c *ldapConn) close() {
+	c.messageID++
+	c.conn.Write(berConcat(berSequence, berInt(berInteger, c.messageID), berEncode(ldapUnbindRequest, nil)))
+	c.conn.Close()
+}
@@ -0,0 +1,360 @@
+package server
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+// testLDAP is a minimal LDAP server for tests. It accepts simple binds for
+// the DNs in users and answers base object searches for the equality filter
+// member=<DN> on the groups.
+type testLDAP struct {
+	net.Listener
+	t *testing.T
+
+	users  map[string]string
+	groups map[string][]string
+
+	mu    sync.Mutex
+	binds []string
+}
+
+func newTestLDAP(t *testing.T) *testLDAP {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := &testLDAP{
+		Listener: l,
+		t:        t,
+		users: map[string]string{
+			"uid=nora,ou=people,dc=example,dc=com":  "nora-pw",
+			"uid=alex,ou=people,dc=example,dc=com":  "alex-pw",
+			"uid=admin,ou=people,dc=example,dc=com": "admin-pw",
+			"uid=user,ou=people,dc=example,dc=com":  "user-pw",
+			"uid=guest,ou=people,dc=example,dc=com": "guest-pw",
+			`uid=a\,b,ou=people,dc=example,dc=com`:  "comma-pw",
+		},
+		groups: map[string][]string{
+			"cn=ghenga,ou=groups,dc=example,dc=com": {
+				"uid=nora,ou=people,dc=example,dc=com",
+				"uid=alex,ou=people,dc=example,dc=com",
+				"uid=admin,ou=people,dc=example,dc=com",
+				"uid=user,ou=people,dc=example,dc=com",
+				`uid=a\,b,ou=people,dc=example,dc=com`,
+			},
+			"cn=admins,ou=groups,dc=example,dc=com": {
+				"uid=alex,ou=people,dc=example,dc=com",
+				"uid=admin,ou=people,dc=example,dc=com",
+			},
+		},
+	}
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go srv.serve(conn)
+		}
+	}()
+
+	return srv
+}
+
+func (srv *testLDAP) url() string {
+	return "ldap://" + srv.Addr().String()
+}
+
+// tlv returns the encoding of a value with the tag and the content, written
+// out by hand so that the client code is not tested against itself.
+func tlv(tag byte, content ...[]byte) []byte {
+	var buf []byte
+	for _, c := range content {
+		buf = append(buf, c...)
+	}
+
+	if len(buf) > 0x7f {
+		panic("tlv: content too long")
+	}
+
+	return append([]byte{tag, byte(len(buf))}, buf...)
+}
+
+// result returns an LDAPResult operation with the code.
+func result(tag byte, code byte) []byte {
+	return tlv(tag, tlv(0x0a, []byte{code}), tlv(0x04), tlv(0x04, []byte("test result")))
+}
+
+func (srv *testLDAP) serve(conn net.Conn) {
+	defer conn.Close()
+
+	rd := bufio.NewReader(conn)
+	bound := false
+	for {
+		msg, err := berRead(rd)
+		if err != nil || len(msg.Children) < 2 {
+			return
+		}
+
+		id := msg.Children[0].Data
+		op := msg.Children[1]
+
+		send := func(op []byte) {
+			if _, err := conn.Write(tlv(0x30, tlv(0x02, id), op)); err != nil {
+				srv.t.Errorf("LDAP server: %v", err)
+			}
+		}
+
+		switch op.Tag {
+		case 0x60:
+			dn, password := op.Children[1].String(), op.Children[2].String()
+
+			srv.mu.Lock()
+			srv.binds = append(srv.binds, dn)
+			srv.mu.Unlock()
+
+			if pw, ok := srv.users[dn]; ok && op.Children[2].Tag == 0x80 && password == pw {
+				bound = true
+				send(result(0x61, 0))
+			} else {
+				send(result(0x61, 49))
+			}
+		case 0x63:
+			// insufficientAccessRights
+			if !bound {
+				send(result(0x65, 50))
+				continue
+			}
+
+			group := op.Children[0].String()
+			filter := op.Children[6]
+			srv.mu.Lock()
+			members, ok := srv.groups[group]
+			member := contains(members, filter.Children[1].String())
+			srv.mu.Unlock()
+
+			// noSuchObject
+			if !ok {
+				send(result(0x65, 32))
+				continue
+			}
+
+			if filter.Tag == 0xa3 && filter.Children[0].String() == "member" && member {
+				send(tlv(0x64, tlv(0x04, []byte(group)), tlv(0x30)))
+			}
+
+			send(result(0x65, 0))
+		case 0x42:
+			return
+		default:
+			srv.t.Errorf("LDAP server: unexpected operation 0x%02x", op.Tag)
+			return
+		}
+	}
+}
+
+func (srv *testLDAP) authenticator() *LDAPAuthenticator {
+	return &LDAPAuthenticator{
+		URL:        srv.url(),
+		UserDN:     "uid=%s,ou=people,dc=example,dc=com",
+		UserGroup:  "cn=ghenga,ou=groups,dc=example,dc=com",
+		AdminGroup: "cn=admins,ou=groups,dc=example,dc=com",
+	}
+}
+
+func TestLDAPCheck(t *testing.T) {
+	srv := newTestLDAP(t)
+	defer srv.Close()
+
+	a := srv.authenticator()
+
+	for _, test := range []struct {
+		login, password string
+		err             error
+		admin           bool
+	}{
+		{"nora", "nora-pw", nil, false},
+		{"alex", "alex-pw", nil, true},
+		{"a,b", "comma-pw", nil, false},
+		{"nora", "alex-pw", ErrInvalidLogin, false},
+		{"nora", "", ErrInvalidLogin, false},
+		{"", "", ErrInvalidLogin, false},
+		{"nobody", "nora-pw", ErrInvalidLogin, false},
+		{"user", "user-pw", nil, false},
+		{"admin", "admin-pw", nil, true},
+		{"guest", "guest-pw", ErrInvalidLogin, false},
+	} {
+		admin, err := a.check(test.login, test.password)
+		if err != test.err {
+			t.Errorf("%v/%v: want error %v, got %v", test.login, test.password, test.err, err)
+			continue
+		}
+
+		if admin != test.admin {
+			t.Errorf("%v: want admin %v, got %v", test.login, test.admin, admin)
+		}
+	}
+
+	// without groups, all users may log in
+	a = &LDAPAuthenticator{URL: srv.url(), UserDN: a.UserDN}
+	if admin, err := a.check("guest", "guest-pw"); err != nil || admin {
+		t.Errorf("login without groups failed: %v %v", admin, err)
+	}
+
+	// a group which does not exist is an error of the configuration
+	a.UserGroup = "cn=missing,ou=groups,dc=example,dc=com"
+	if _, err := a.check("nora", "nora-pw"); err == nil || err == ErrInvalidLogin {
+		t.Errorf("missing group not reported, error %v", err)
+	}
+
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+
+	// no bind may be attempted without a login or password
+	want := []string{
+		"uid=nora,ou=people,dc=example,dc=com",
+		"uid=alex,ou=people,dc=example,dc=com",
+		`uid=a\,b,ou=people,dc=example,dc=com`,
+		"uid=nora,ou=people,dc=example,dc=com",
+		"uid=nobody,ou=people,dc=example,dc=com",
+		"uid=user,ou=people,dc=example,dc=com",
+		"uid=admin,ou=people,dc=example,dc=com",
+		"uid=guest,ou=people,dc=example,dc=com",
+		"uid=guest,ou=people,dc=example,dc=com",
+		"uid=nora,ou=people,dc=example,dc=com",
+	}
+	if !reflect.DeepEqual(srv.binds, want) {
+		t.Errorf("wrong binds, want:\n  %q\ngot:\n  %q", want, srv.binds)
+	}
+}
+
+func TestLDAPCheckUnreachable(t *testing.T) {
+	srv := newTestLDAP(t)
+	a := srv.authenticator()
+	srv.Close()
+
+	if _, err := a.check("nora", "nora-pw"); err == nil || err == ErrInvalidLogin {
+		t.Errorf("unreachable server not reported, error %v", err)
+	}
+
+	a.URL = "http://" + srv.Addr().String()
+	if _, err := a.check("nora", "nora-pw"); err == nil || err == ErrInvalidLogin {
+		t.Errorf("invalid URL not reported, error %v", err)
+	}
+}
+
+func TestLDAPEscapeDN(t *testing.T) {
+	for _, test := range []struct {
+		s, want string
+	}{
+		{"nora", "nora"},
+		{"a,b", `a\,b`},
+		{`x+y="z"`, `x\+y\=\"z\"`},
+		{`a\b;c<d>`, `a\\b\;c\<d\>`},
+		{" x y ", `\ x y\ `},
+		{"#1#", `\#1#`},
+		{"a\x00", `a\00`},
+		{"ünïcode", "ünïcode"},
+	} {
+		if got := ldapEscapeDN(test.s); got != test.want {
+			t.Errorf("ldapEscapeDN(%q): want %q, got %q", test.s, test.want, got)
+		}
+	}
+}
+
+func TestLDAPLogin(t *testing.T) {
+	ldap := newTestLDAP(t)
+	defer ldap.Close()
+
+	srv, cleanup := TestServer(t)
+	defer cleanup()
+
+	a := ldap.authenticator()
+	a.DefaultRoles = []string{"viewer"}
+	srv.Cfg.Authenticator = a
+
+	for _, test := range []struct {
+		login, password string
+		admin           bool
+		roles           []string
+	}{
+		{"nora", "nora-pw", false, []string{"viewer"}},
+		{"alex", "alex-pw", true, []string{"viewer", "admin"}},
+		{"admin", "admin-pw", true, []string{"admin"}},
+		{"user", "user-pw", false, []string{"manager"}},
+		// alex is removed from the admin group below
+		{"alex", "alex-pw", false, []string{"viewer"}},
+	} {
+		if test.login == "alex" && !test.admin {
+			ldap.mu.Lock()
+			ldap.groups["cn=admins,ou=groups,dc=example,dc=com"] = []string{"uid=admin,ou=people,dc=example,dc=com"}
+			ldap.mu.Unlock()
+		}
+
+		status, body := loginRequest(t, srv, test.login, test.password)
+		if status != http.StatusOK {
+			t.Fatalf("%v: login failed with status %v: %s", test.login, status, body)
+		}
+
+		var lr LoginResponseJSON
+		unmarshal(t, body, &lr)
+		if lr.User != test.login || lr.Admin != test.admin || !reflect.DeepEqual(lr.Roles, test.roles) {
+			t.Errorf("%v: wrong login response: %s", test.login, body)
+		}
+
+		if status, body := request(t, lr.Token, "GET", srv.URL+"/api/login/info", nil); status != http.StatusOK {
+			t.Errorf("%v: session not accepted, status %v: %s", test.login, status, body)
+		}
+	}
+
+	u, err := srv.DB.FindUserName("nora")
+	if err != nil {
+		t.Fatalf("user nora was not created: %v", err)
+	}
+	if u.CreatedBy.Valid {
+		t.Errorf("user created on login has a creator: %v", u.CreatedBy)
+	}
+
+	// the login is not case-sensitive
+	status, body := loginRequest(t, srv, "Nora", "nora-pw")
+	if status != http.StatusOK {
+		t.Fatalf("login with upper case letters failed with status %v: %s", status, body)
+	}
+
+	var lr LoginResponseJSON
+	unmarshal(t, body, &lr)
+	if lr.User != "nora" {
+		t.Errorf("login with upper case letters returned another user: %s", body)
+	}
+
+	if _, err = srv.DB.FindUserName("Nora"); err == nil {
+		t.Errorf("user created for the login with upper case letters")
+	}
+
+	for _, test := range []struct {
+		login, password string
+	}{
+		// the local password is not used
+		{"admin", "geheim"},
+		{"nora", "wrong"},
+		// not in the user group
+		{"guest", "guest-pw"},
+		{"nobody", "nobody-pw"},
+	} {
+		if status, body := loginRequest(t, srv, test.login, test.password); status != http.StatusUnauthorized {
+			t.Errorf("%v/%v: want status 401, got %v: %s", test.login, test.password, status, body)
+		}
+	}
+
+	ldap.Close()
+	if status, body := loginRequest(t, srv, "nora", "nora-pw"); status != http.StatusInternalServerError {
+		t.Errorf("login with unreachable LDAP server: want status 500, got %v: %s", status, body)
+	}
+}
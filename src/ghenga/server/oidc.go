@@ -0,0 +1,467 @@
+package server
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	oidcTimeout = 10 * time.Second
+
+	// oidcLeeway is the allowed clock skew when checking the expiry of an ID
+	// token.
+	oidcLeeway = time.Minute
+
+	// oidcKeyRefresh is the minimum time between two requests for the keys
+	// of the provider, so that tokens with unknown key IDs cannot be used to
+	// flood the provider with requests.
+	oidcKeyRefresh = time.Minute
+)
+
+// oidcDiscovery is the part of the provider configuration used by ghenga.
+type oidcDiscovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// oidcProvider talks to an OpenID Connect provider. The provider
+// configuration and the keys are fetched when they are first needed.
+type oidcProvider struct {
+	cfg    OIDCConfig
+	client *http.Client
+
+	mu          sync.Mutex
+	discovery   *oidcDiscovery
+	keys        map[string]crypto.PublicKey
+	keysFetched time.Time
+}
+
+func newOIDCProvider(cfg OIDCConfig) *oidcProvider {
+	if cfg.LoginClaim == "" {
+		cfg.LoginClaim = "preferred_username"
+	}
+
+	if cfg.GroupsClaim == "" {
+		cfg.GroupsClaim = "groups"
+	}
+
+	return &oidcProvider{
+		cfg:    cfg,
+		client: &http.Client{Timeout: oidcTimeout},
+	}
+}
+
+// getJSON requests url and decodes the JSON response into v.
+func (p *oidcProvider) getJSON(url string, v interface{}) error {
+	res, err := p.client.Get(url)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %v returned status %v", url, res.Status)
+	}
+
+	return json.NewDecoder(res.Body).Decode(v)
+}
+
+// discover returns the configuration of the provider.
+func (p *oidcProvider) discover() (*oidcDiscovery, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.discovery != nil {
+		return p.discovery, nil
+	}
+
+	var d oidcDiscovery
+	err := p.getJSON(strings.TrimSuffix(p.cfg.Issuer, "/")+"/.well-known/openid-configuration", &d)
+	if err != nil {
+		return nil, err
+	}
+
+	if d.Issuer != p.cfg.Issuer {
+		return nil, fmt.Errorf("provider returned issuer %q, want %q", d.Issuer, p.cfg.Issuer)
+	}
+
+	if d.AuthorizationEndpoint == "" || d.TokenEndpoint == "" || d.JWKSURI == "" {
+		return nil, errors.New("incomplete provider configuration")
+	}
+
+	p.discovery = &d
+	return p.discovery, nil
+}
+
+// pkceChallenge returns the S256 code challenge for the PKCE verifier.
+func pkceChallenge(verifier string) string {
+	h := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(h[:])
+}
+
+// authURL returns the URL the browser is sent to for logging in.
+func (p *oidcProvider) authURL(state, nonce, verifier string) (string, error) {
+	d, err := p.discover()
+	if err != nil {
+		return "", err
+	}
+
+	v := url.Values{}
+	v.Set("response_type", "code")
+	v.Set("client_id", p.cfg.ClientID)
+	v.Set("redirect_uri", p.cfg.RedirectURL)
+	v.Set("scope", "openid profile email")
+	v.Set("state", state)
+	v.Set("nonce", nonce)
+	v.Set("code_challenge", pkceChallenge(verifier))
+	v.Set("code_challenge_method", "S256")
+
+	sep := "?"
+	if strings.Contains(d.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+
+	return d.AuthorizationEndpoint + sep + v.Encode(), nil
+}
+
+// exchange redeems the authorization code and returns the raw ID token.
+func (p *oidcProvider) exchange(code, verifier string) (string, error) {
+	d, err := p.discover()
+	if err != nil {
+		return "", err
+	}
+
+	v := url.Values{}
+	v.Set("grant_type", "authorization_code")
+	v.Set("code", code)
+	v.Set("redirect_uri", p.cfg.RedirectURL)
+	v.Set("client_id", p.cfg.ClientID)
+	v.Set("code_verifier", verifier)
+
+	req, err := http.NewRequest("POST", d.TokenEndpoint, strings.NewReader(v.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth(url.QueryEscape(p.cfg.ClientID), url.QueryEscape(p.cfg.ClientSecret))
+
+	res, err := p.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+
+	var tr struct {
+		IDToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if err = json.NewDecoder(res.Body).Decode(&tr); err != nil {
+		return "", fmt.Errorf("invalid token response with status %v: %v", res.Status, err)
+	}
+
+	if tr.Error != "" {
+		return "", fmt.Errorf("token request failed: %v %v", tr.Error, tr.ErrorDescription)
+	}
+
+	if res.StatusCode != http.StatusOK || tr.IDToken == "" {
+		return "", fmt.Errorf("token request returned status %v without ID token", res.Status)
+	}
+
+	return tr.IDToken, nil
+}
+
+// jwk is a JSON Web Key, only RSA and EC keys are supported.
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// decodeBigInt decodes an unsigned big-endian integer in base64url encoding.
+func decodeBigInt(s string) (*big.Int, error) {
+	buf, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(buf) == 0 {
+		return nil, errors.New("empty value")
+	}
+
+	return new(big.Int).SetBytes(buf), nil
+}
+
+// publicKey returns the public key described by k.
+func (k jwk) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+
+		e, err := decodeBigInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+
+		if !e.IsInt64() || e.Int64() > 1<<31-1 {
+			return nil, errors.New("invalid RSA exponent")
+		}
+
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		if k.Crv != "P-256" {
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+
+		if !elliptic.P256().IsOnCurve(x, y) {
+			return nil, errors.New("point is not on the curve")
+		}
+
+		return &ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}, nil
+	}
+
+	return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+}
+
+// key returns the signing key with the ID kid. The keys are fetched again if
+// the key is not known, e.g. after the provider rotated its keys.
+func (p *oidcProvider) key(kid string) (crypto.PublicKey, error) {
+	d, err := p.discover()
+	if err != nil {
+		return nil, err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if key, ok := p.keys[kid]; ok {
+		return key, nil
+	}
+
+	if time.Since(p.keysFetched) < oidcKeyRefresh {
+		return nil, fmt.Errorf("unknown key %q", kid)
+	}
+
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err = p.getJSON(d.JWKSURI, &set); err != nil {
+		return nil, err
+	}
+
+	p.keysFetched = time.Now()
+	p.keys = make(map[string]crypto.PublicKey)
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+
+		key, err := k.publicKey()
+		if err != nil {
+			continue
+		}
+		p.keys[k.Kid] = key
+	}
+
+	if key, ok := p.keys[kid]; ok {
+		return key, nil
+	}
+
+	return nil, fmt.Errorf("unknown key %q", kid)
+}
+
+// decodeSegment decodes a part of a JWT into v.
+func decodeSegment(s string, v interface{}) error {
+	buf, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return err
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(buf))
+	dec.UseNumber()
+	return dec.Decode(v)
+}
+
+// verifySignature checks the signature sig of data with the algorithm alg.
+func verifySignature(alg string, key crypto.PublicKey, data, sig []byte) error {
+	h := sha256.Sum256(data)
+
+	switch alg {
+	case "RS256":
+		pub, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return errors.New("key is not an RSA key")
+		}
+
+		return rsa.VerifyPKCS1v15(pub, crypto.SHA256, h[:], sig)
+	case "ES256":
+		pub, ok := key.(*ecdsa.PublicKey)
+		if !ok {
+			return errors.New("key is not an EC key")
+		}
+
+		if len(sig) != 64 {
+			return errors.New("invalid signature length")
+		}
+
+		r := new(big.Int).SetBytes(sig[:32])
+		s := new(big.Int).SetBytes(sig[32:])
+		if !ecdsa.Verify(pub, h[:], r, s) {
+			return errors.New("invalid signature")
+		}
+
+		return nil
+	}
+
+	return fmt.Errorf("unsupported algorithm %q", alg)
+}
+
+// oidcClaims are the claims of an ID token.
+type oidcClaims map[string]interface{}
+
+// String returns the claim name as a string, it is empty if the claim is not
+// a string.
+func (c oidcClaims) String(name string) string {
+	s, _ := c[name].(string)
+	return s
+}
+
+// Strings returns the claim name, which may be a string or a list of
+// strings.
+func (c oidcClaims) Strings(name string) []string {
+	switch v := c[name].(type) {
+	case string:
+		return []string{v}
+	case []interface{}:
+		var list []string
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				list = append(list, s)
+			}
+		}
+		return list
+	}
+
+	return nil
+}
+
+// Time returns the claim name, a number of seconds since the epoch.
+func (c oidcClaims) Time(name string) (time.Time, bool) {
+	n, ok := c[name].(json.Number)
+	if !ok {
+		return time.Time{}, false
+	}
+
+	sec, err := n.Float64()
+	if err != nil {
+		return time.Time{}, false
+	}
+
+	return time.Unix(int64(sec), 0), true
+}
+
+// contains returns true if list contains s.
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+
+	return false
+}
+
+// verify checks the signature and the claims of the raw ID token and returns
+// the claims. The nonce must match the one sent with the login.
+func (p *oidcProvider) verify(raw, nonce string, now time.Time) (oidcClaims, error) {
+	parts := strings.Split(raw, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("malformed ID token")
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("invalid ID token header: %v", err)
+	}
+
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("invalid ID token signature: %v", err)
+	}
+
+	key, err := p.key(header.Kid)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = verifySignature(header.Alg, key, []byte(parts[0]+"."+parts[1]), sig); err != nil {
+		return nil, fmt.Errorf("invalid ID token signature: %v", err)
+	}
+
+	var claims oidcClaims
+	if err = decodeSegment(parts[1], &claims); err != nil {
+		return nil, fmt.Errorf("invalid ID token claims: %v", err)
+	}
+
+	if claims.String("iss") != p.cfg.Issuer {
+		return nil, fmt.Errorf("ID token issued by %q", claims.String("iss"))
+	}
+
+	aud := claims.Strings("aud")
+	if !contains(aud, p.cfg.ClientID) {
+		return nil, fmt.Errorf("ID token issued for %v", aud)
+	}
+
+	if len(aud) > 1 && claims.String("azp") != p.cfg.ClientID {
+		return nil, errors.New("ID token issued for another party")
+	}
+
+	exp, ok := claims.Time("exp")
+	if !ok || exp.Add(oidcLeeway).Before(now) {
+		return nil, errors.New("ID token expired")
+	}
+
+	if claims.String("nonce") != nonce {
+		return nil, errors.New("ID token nonce does not match")
+	}
+
+	return claims, nil
+}
@@ -0,0 +1,318 @@
+package server
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testIdP is a minimal OpenID Connect provider for tests. It signs ID tokens
+// with an RSA key and an EC key and issues authorization codes for the claims
+// in the field claims.
+type testIdP struct {
+	*httptest.Server
+	t *testing.T
+
+	rsaKey *rsa.PrivateKey
+	ecKey  *ecdsa.PrivateKey
+
+	clientID, clientSecret string
+
+	mu     sync.Mutex
+	claims map[string]interface{}
+	codes  map[string]testIdPCode
+}
+
+type testIdPCode struct {
+	nonce, challenge, redirect string
+	claims                     map[string]interface{}
+}
+
+var (
+	testRSAKey     *rsa.PrivateKey
+	testRSAKeyOnce sync.Once
+)
+
+func newTestIdP(t *testing.T) *testIdP {
+	testRSAKeyOnce.Do(func() {
+		var err error
+		testRSAKey, err = rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			panic(err)
+		}
+	})
+
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	idp := &testIdP{
+		t:            t,
+		rsaKey:       testRSAKey,
+		ecKey:        ecKey,
+		clientID:     "ghenga",
+		clientSecret: "s3cret",
+		codes:        make(map[string]testIdPCode),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", idp.discovery)
+	mux.HandleFunc("/authorize", idp.authorize)
+	mux.HandleFunc("/token", idp.token)
+	mux.HandleFunc("/keys", idp.keys)
+	idp.Server = httptest.NewServer(mux)
+
+	return idp
+}
+
+func (idp *testIdP) config(redirect string) OIDCConfig {
+	return OIDCConfig{
+		Issuer:       idp.URL,
+		ClientID:     idp.clientID,
+		ClientSecret: idp.clientSecret,
+		RedirectURL:  redirect,
+	}
+}
+
+func (idp *testIdP) discovery(res http.ResponseWriter, req *http.Request) {
+	json.NewEncoder(res).Encode(map[string]string{
+		"issuer":                 idp.URL,
+		"authorization_endpoint": idp.URL + "/authorize",
+		"token_endpoint":         idp.URL + "/token",
+		"jwks_uri":               idp.URL + "/keys",
+	})
+}
+
+func b64(buf []byte) string {
+	return base64.RawURLEncoding.EncodeToString(buf)
+}
+
+func (idp *testIdP) keys(res http.ResponseWriter, req *http.Request) {
+	pad := func(b []byte) []byte {
+		return append(make([]byte, 32-len(b)), b...)
+	}
+
+	json.NewEncoder(res).Encode(map[string]interface{}{
+		"keys": []map[string]string{
+			{"kty": "RSA", "kid": "rsa", "use": "sig", "alg": "RS256",
+				"n": b64(idp.rsaKey.N.Bytes()),
+				"e": b64(big.NewInt(int64(idp.rsaKey.E)).Bytes())},
+			{"kty": "EC", "kid": "ec", "use": "sig", "crv": "P-256",
+				"x": b64(pad(idp.ecKey.X.Bytes())),
+				"y": b64(pad(idp.ecKey.Y.Bytes()))},
+			{"kty": "oct", "kid": "hmac", "k": "c2VjcmV0"},
+		},
+	})
+}
+
+// sign returns a JWT with the claims signed with alg and the key kid.
+func (idp *testIdP) sign(alg, kid string, claims map[string]interface{}) string {
+	header, err := json.Marshal(map[string]string{"alg": alg, "kid": kid, "typ": "JWT"})
+	if err != nil {
+		idp.t.Fatal(err)
+	}
+
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		idp.t.Fatal(err)
+	}
+
+	data := b64(header) + "." + b64(payload)
+	h := sha256.Sum256([]byte(data))
+
+	var sig []byte
+	switch alg {
+	case "RS256":
+		sig, err = rsa.SignPKCS1v15(rand.Reader, idp.rsaKey, crypto.SHA256, h[:])
+	case "ES256":
+		var r, s *big.Int
+		r, s, err = ecdsa.Sign(rand.Reader, idp.ecKey, h[:])
+		if err == nil {
+			sig = make([]byte, 64)
+			r.FillBytes(sig[:32])
+			s.FillBytes(sig[32:])
+		}
+	}
+	if err != nil {
+		idp.t.Fatal(err)
+	}
+
+	return data + "." + b64(sig)
+}
+
+// idToken returns the claims of an ID token for the subject login, which
+// are valid for the client.
+func (idp *testIdP) idToken(login, nonce string) map[string]interface{} {
+	return map[string]interface{}{
+		"iss":                idp.URL,
+		"sub":                "sub-" + login,
+		"aud":                idp.clientID,
+		"exp":                time.Now().Add(5 * time.Minute).Unix(),
+		"iat":                time.Now().Unix(),
+		"nonce":              nonce,
+		"preferred_username": login,
+	}
+}
+
+// authorize issues a code for the current claims and redirects back to the
+// client, as if the user had logged in.
+func (idp *testIdP) authorize(res http.ResponseWriter, req *http.Request) {
+	q := req.URL.Query()
+	if q.Get("client_id") != idp.clientID || q.Get("response_type") != "code" ||
+		q.Get("code_challenge_method") != "S256" || q.Get("code_challenge") == "" {
+		http.Error(res, "invalid authorization request", http.StatusBadRequest)
+		return
+	}
+
+	idp.mu.Lock()
+	code := "code-" + q.Get("state")
+	idp.codes[code] = testIdPCode{
+		nonce:     q.Get("nonce"),
+		challenge: q.Get("code_challenge"),
+		redirect:  q.Get("redirect_uri"),
+		claims:    idp.claims,
+	}
+	idp.mu.Unlock()
+
+	http.Redirect(res, req, q.Get("redirect_uri")+"?code="+code+"&state="+q.Get("state"), http.StatusFound)
+}
+
+func (idp *testIdP) token(res http.ResponseWriter, req *http.Request) {
+	id, secret, ok := req.BasicAuth()
+	if !ok || id != idp.clientID || secret != idp.clientSecret {
+		res.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(res).Encode(map[string]string{"error": "invalid_client"})
+		return
+	}
+
+	idp.mu.Lock()
+	code, ok := idp.codes[req.FormValue("code")]
+	delete(idp.codes, req.FormValue("code"))
+	idp.mu.Unlock()
+
+	if !ok || code.redirect != req.FormValue("redirect_uri") ||
+		pkceChallenge(req.FormValue("code_verifier")) != code.challenge {
+		res.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(res).Encode(map[string]string{"error": "invalid_grant"})
+		return
+	}
+
+	login, _ := code.claims["preferred_username"].(string)
+	claims := idp.idToken(login, code.nonce)
+	for k, v := range code.claims {
+		claims[k] = v
+	}
+
+	json.NewEncoder(res).Encode(map[string]string{
+		"access_token": "access",
+		"token_type":   "Bearer",
+		"id_token":     idp.sign("RS256", "rsa", claims),
+	})
+}
+
+func TestPKCEChallenge(t *testing.T) {
+	// example from RFC 7636, appendix B
+	got := pkceChallenge("dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk")
+	if want := "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM"; got != want {
+		t.Errorf("wrong challenge, want %q, got %q", want, got)
+	}
+}
+
+func TestOIDCVerify(t *testing.T) {
+	idp := newTestIdP(t)
+	defer idp.Close()
+
+	p := newOIDCProvider(idp.config("http://localhost/api/login/oidc/callback"))
+	now := time.Now()
+
+	valid := func() map[string]interface{} {
+		return idp.idToken("user", "nonce")
+	}
+
+	with := func(name string, value interface{}) map[string]interface{} {
+		claims := valid()
+		if value == nil {
+			delete(claims, name)
+		} else {
+			claims[name] = value
+		}
+		return claims
+	}
+
+	for i, test := range []struct {
+		token string
+		valid bool
+	}{
+		{idp.sign("RS256", "rsa", valid()), true},
+		{idp.sign("ES256", "ec", valid()), true},
+		{idp.sign("RS256", "rsa", with("aud", []string{"other", idp.clientID})), false},
+		{idp.sign("RS256", "rsa", with("azp", idp.clientID)), true},
+		{idp.sign("RS256", "rsa", with("iss", "https://evil.example.com")), false},
+		{idp.sign("RS256", "rsa", with("aud", "other")), false},
+		{idp.sign("RS256", "rsa", with("exp", now.Add(-time.Hour).Unix())), false},
+		{idp.sign("RS256", "rsa", with("exp", nil)), false},
+		{idp.sign("RS256", "rsa", with("nonce", "other")), false},
+		{idp.sign("RS256", "unknown", valid()), false},
+		{idp.sign("RS256", "ec", valid()), false},
+		{idp.sign("ES256", "rsa", valid()), false},
+		{"", false},
+		{"a.b", false},
+	} {
+		_, err := p.verify(test.token, "nonce", now)
+		if test.valid && err != nil {
+			t.Errorf("test %d: valid token rejected: %v", i, err)
+		}
+
+		if !test.valid && err == nil {
+			t.Errorf("test %d: invalid token accepted", i)
+		}
+	}
+
+	// a token signed with the right key, but changed afterwards
+	parts := strings.Split(idp.sign("RS256", "rsa", valid()), ".")
+	claims, err := json.Marshal(with("preferred_username", "admin"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = p.verify(parts[0]+"."+b64(claims)+"."+parts[2], "nonce", now); err == nil {
+		t.Errorf("token with modified claims accepted")
+	}
+
+	// an alg none token with a key ID must not be accepted either
+	header := b64([]byte(`{"alg":"none","kid":"rsa"}`))
+	if _, err = p.verify(header+"."+parts[1]+".", "nonce", now); err == nil {
+		t.Errorf("unsigned token accepted")
+	}
+}
+
+func TestLocalPath(t *testing.T) {
+	for _, test := range []struct {
+		path  string
+		local bool
+	}{
+		{"/", true},
+		{"/app/#/people", true},
+		{"//evil.example.com", false},
+		{"/\\evil.example.com", false},
+		{"https://evil.example.com", false},
+		{"app", false},
+	} {
+		if localPath(test.path) != test.local {
+			t.Errorf("localPath(%q) != %v", test.path, test.local)
+		}
+	}
+}
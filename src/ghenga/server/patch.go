@@ -0,0 +1,111 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"mime"
+	"net/http"
+)
+
+// mergePatchContentType is the media type for a JSON merge patch (RFC 7396).
+const mergePatchContentType = "application/merge-patch+json"
+
+// mergePatch applies the JSON merge patch to target as described in RFC 7396
+// and returns the result. Members of the patch with the value null are
+// removed from the target, objects are merged recursively, all other values
+// replace the value in the target.
+func mergePatch(target, patch interface{}) interface{} {
+	p, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+
+	t, ok := target.(map[string]interface{})
+	if !ok {
+		t = make(map[string]interface{})
+	}
+
+	for name, value := range p {
+		if value == nil {
+			delete(t, name)
+			continue
+		}
+
+		t[name] = mergePatch(t[name], value)
+	}
+
+	return t
+}
+
+// readMergePatch reads a JSON merge patch from the request body. The patch
+// must be a JSON object.
+func readMergePatch(req *http.Request) (patch map[string]interface{}, err error) {
+	if ct := req.Header.Get("Content-Type"); ct != "" {
+		mt, _, err := mime.ParseMediaType(ct)
+		if err != nil || (mt != mergePatchContentType && mt != "application/json") {
+			return nil, StatusError{
+				Code: http.StatusUnsupportedMediaType,
+				Err:  errors.New("content type must be " + mergePatchContentType),
+			}
+		}
+	}
+
+	dec := json.NewDecoder(req.Body)
+	if err = dec.Decode(&patch); err != nil || patch == nil {
+		return nil, StatusError{
+			Code: http.StatusBadRequest,
+			Err:  errors.New("patch must be a JSON object"),
+		}
+	}
+
+	return patch, nil
+}
+
+// checkPatchVersion checks that the patch contains the current version of
+// the record. The version may be omitted if the request contains an If-Match
+// header, which is checked separately. If the version does not match,
+// errVersionMismatch is returned.
+func checkPatchVersion(req *http.Request, patch map[string]interface{}, current int64) error {
+	if _, ok := patch["version"]; !ok && hasIfMatch(req) {
+		return nil
+	}
+
+	v, ok := patch["version"].(float64)
+	if !ok {
+		return StatusError{
+			Code: http.StatusBadRequest,
+			Err:  errors.New("version field is required"),
+		}
+	}
+
+	if int64(v) != current {
+		return errVersionMismatch
+	}
+
+	return nil
+}
+
+// applyMergePatch applies patch to the JSON representation of item and
+// decodes the result into result.
+func applyMergePatch(item interface{}, patch map[string]interface{}, result interface{}) error {
+	buf, err := json.Marshal(item)
+	if err != nil {
+		return err
+	}
+
+	var doc interface{}
+	if err = json.Unmarshal(buf, &doc); err != nil {
+		return err
+	}
+
+	buf, err = json.Marshal(mergePatch(doc, patch))
+	if err != nil {
+		return err
+	}
+
+	if err = json.Unmarshal(buf, result); err != nil {
+		return StatusError{Code: http.StatusBadRequest, Err: err}
+	}
+
+	return nil
+}
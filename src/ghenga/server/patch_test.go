@@ -0,0 +1,47 @@
+package server
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+// mergePatchTests are the examples from RFC 7396, appendix A.
+var mergePatchTests = []struct {
+	target, patch, result string
+}{
+	{`{"a":"b"}`, `{"a":"c"}`, `{"a":"c"}`},
+	{`{"a":"b"}`, `{"b":"c"}`, `{"a":"b","b":"c"}`},
+	{`{"a":"b"}`, `{"a":null}`, `{}`},
+	{`{"a":"b","b":"c"}`, `{"a":null}`, `{"b":"c"}`},
+	{`{"a":["b"]}`, `{"a":"c"}`, `{"a":"c"}`},
+	{`{"a":"c"}`, `{"a":["b"]}`, `{"a":["b"]}`},
+	{`{"a":{"b":"c"}}`, `{"a":{"b":"d","c":null}}`, `{"a":{"b":"d"}}`},
+	{`{"a":[{"b":"c"}]}`, `{"a":[1]}`, `{"a":[1]}`},
+	{`["a","b"]`, `["c","d"]`, `["c","d"]`},
+	{`{"a":"b"}`, `["c"]`, `["c"]`},
+	{`{"a":"foo"}`, `null`, `null`},
+	{`{"a":"foo"}`, `"bar"`, `"bar"`},
+	{`{"e":null}`, `{"a":1}`, `{"e":null,"a":1}`},
+	{`[1,2]`, `{"a":"b","c":null}`, `{"a":"b"}`},
+	{`{}`, `{"a":{"bb":{"ccc":null}}}`, `{"a":{"bb":{}}}`},
+}
+
+func TestMergePatch(t *testing.T) {
+	for i, test := range mergePatchTests {
+		var target, patch, want interface{}
+		for _, v := range []struct {
+			s    string
+			item *interface{}
+		}{{test.target, &target}, {test.patch, &patch}, {test.result, &want}} {
+			if err := json.Unmarshal([]byte(v.s), v.item); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		result := mergePatch(target, patch)
+		if !reflect.DeepEqual(result, want) {
+			t.Errorf("test %d: wrong result, want %v, got %v", i, want, result)
+		}
+	}
+}
@@ -9,8 +9,20 @@ import (
 func NewRouter(ctx context.Context, env *Env) *mux.Router {
 	router := mux.NewRouter()
 	PeopleHandler(ctx, env, router)
+	AccountHandler(ctx, env, router)
+	TaskHandler(ctx, env, router)
+	ActivityHandler(ctx, env, router)
+	EventHandler(ctx, env, router)
+	RevisionHandler(ctx, env, router)
 	LoginHandler(ctx, env, router)
 	SearchHandler(ctx, env, router)
 	UserHandler(ctx, env, router)
+	BatchHandler(ctx, env, router)
+	WebhookHandler(ctx, env, router)
+	StreamHandler(ctx, env, router)
+	AuditHandler(ctx, env, router)
+	RoleHandler(ctx, env, router)
+	TeamHandler(ctx, env, router)
+	APITokenHandler(ctx, env, router)
 	return router
 }
@@ -0,0 +1,186 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"ghenga/db"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"golang.org/x/net/context"
+)
+
+// ListAccounts handles listing account records.
+func ListAccounts(ctx context.Context, env *Env, res http.ResponseWriter, req *http.Request) error {
+	accounts, err := env.DB.ListAccounts()
+	if err != nil {
+		return err
+	}
+
+	return httpWriteJSON(res, http.StatusOK, accounts)
+}
+
+// ShowAccount returns an Account record.
+func ShowAccount(ctx context.Context, env *Env, res http.ResponseWriter, req *http.Request) error {
+	id, err := strconv.Atoi(mux.Vars(req)["id"])
+	if err != nil {
+		return StatusError{Code: http.StatusBadRequest, Err: err}
+	}
+
+	account, err := env.DB.FindAccount(int64(id))
+	if err != nil {
+		return StatusError{
+			Err:  errors.New("account not found"),
+			Code: http.StatusNotFound,
+		}
+	}
+
+	return httpWriteJSON(res, http.StatusOK, account)
+}
+
+// ListAccountPeople returns the people associated with an account.
+func ListAccountPeople(ctx context.Context, env *Env, res http.ResponseWriter, req *http.Request) error {
+	id, err := strconv.Atoi(mux.Vars(req)["id"])
+	if err != nil {
+		return StatusError{Code: http.StatusBadRequest, Err: err}
+	}
+
+	if _, err = env.DB.FindAccount(int64(id)); err != nil {
+		return StatusError{
+			Err:  errors.New("account not found"),
+			Code: http.StatusNotFound,
+		}
+	}
+
+	vdb, err := visibleDB(ctx, env)
+	if err != nil {
+		return err
+	}
+
+	people, err := vdb.ListAccountPeople(int64(id))
+	if err != nil {
+		return err
+	}
+
+	return httpWriteJSON(res, http.StatusOK, people)
+}
+
+// CreateAccount inserts a new account into the database. The request body must be valid JSON.
+func CreateAccount(ctx context.Context, env *Env, wr http.ResponseWriter, req *http.Request) (err error) {
+	defer cleanupErr(&err, req.Body.Close)
+
+	var ja db.AccountJSON
+	dec := json.NewDecoder(req.Body)
+	if err = dec.Decode(&ja); err != nil {
+		return err
+	}
+
+	var a db.Account
+	a.Update(ja)
+
+	// overwrite fields we'd like to be set
+	a.CreatedAt = time.Now()
+	a.ChangedAt = time.Now()
+
+	if err = a.Validate(); err != nil {
+		return StatusError{Code: http.StatusBadRequest, Err: err}
+	}
+
+	err = env.DB.InsertAccount(&a)
+	if err != nil {
+		return err
+	}
+
+	env.Debugf("created account %v", a)
+
+	return httpWriteJSON(wr, http.StatusCreated, a)
+}
+
+// UpdateAccount changes an existing account record. The request body must be valid JSON.
+func UpdateAccount(ctx context.Context, env *Env, wr http.ResponseWriter, req *http.Request) (err error) {
+	defer cleanupErr(&err, req.Body.Close)
+
+	id, err := strconv.Atoi(mux.Vars(req)["id"])
+	if err != nil {
+		return StatusError{Code: http.StatusBadRequest, Err: err}
+	}
+
+	var newAccount db.AccountJSON
+	dec := json.NewDecoder(req.Body)
+	if err = dec.Decode(&newAccount); err != nil {
+		return err
+	}
+
+	a, err := env.DB.FindAccount(int64(id))
+	if err != nil {
+		env.Logf("unable to find account ID %v, error: %v", id, err)
+		return err
+	}
+
+	if a.Version != newAccount.Version {
+		env.Debugf("account record is outdated, version %v != %v",
+			a.Version, newAccount.Version)
+		return StatusError{
+			Err:  errors.New("version field does not match"),
+			Code: http.StatusConflict,
+		}
+	}
+
+	a.Update(newAccount)
+	a.ChangedAt = time.Now()
+
+	if err = a.Validate(); err != nil {
+		return StatusError{Code: http.StatusBadRequest, Err: err}
+	}
+
+	err = env.DB.UpdateAccount(a)
+	if err != nil {
+		env.Logf("unable update account %v, sql error: %v", a, err)
+		return err
+	}
+
+	return httpWriteJSON(wr, http.StatusOK, a)
+}
+
+// DeleteAccount removes an account from the database.
+func DeleteAccount(ctx context.Context, env *Env, wr http.ResponseWriter, req *http.Request) (err error) {
+	id, err := strconv.Atoi(mux.Vars(req)["id"])
+	if err != nil {
+		return StatusError{Code: http.StatusBadRequest, Err: err}
+	}
+
+	if err := env.DB.DeleteAccount(int64(id)); err != nil {
+		return err
+	}
+
+	return httpWriteJSON(wr, http.StatusOK, nil)
+}
+
+// checkAccount returns an error if the account p is associated with does not
+// exist.
+func checkAccount(env *Env, p *db.Person) error {
+	if !p.AccountID.Valid {
+		return nil
+	}
+
+	if _, err := env.DB.FindAccount(p.AccountID.Int64); err != nil {
+		return StatusError{
+			Err:  errors.New("account not found"),
+			Code: http.StatusBadRequest,
+		}
+	}
+
+	return nil
+}
+
+// AccountHandler adds routes for ghenga API in the given enviroment to r.
+func AccountHandler(ctx context.Context, env *Env, r *mux.Router) {
+	r.Handle("/api/account", Handle(ctx, env, RequireAuth(ListAccounts))).Methods("GET")
+	r.Handle("/api/account", Handle(ctx, env, RequireAuth(Audit(db.ActionCreate, "account", CreateAccount)))).Methods("POST")
+	r.Handle("/api/account/{id}", Handle(ctx, env, RequireAuth(ShowAccount))).Methods("GET")
+	r.Handle("/api/account/{id}", Handle(ctx, env, RequireAuth(Audit(db.ActionUpdate, "account", UpdateAccount)))).Methods("PUT")
+	r.Handle("/api/account/{id}", Handle(ctx, env, RequireAuth(Audit(db.ActionDelete, "account", DeleteAccount)))).Methods("DELETE")
+	r.Handle("/api/account/{id}/people", Handle(ctx, env, RequireAuth(ListAccountPeople))).Methods("GET")
+}
@@ -0,0 +1,112 @@
+package server
+
+import (
+	"fmt"
+	"testing"
+)
+
+type Account struct {
+	ID      int    `json:"id"`
+	Name    string `json:"name"`
+	Version int    `json:"version"`
+}
+
+func verifyAccount(t *testing.T, name string, data []byte) Account {
+	var account Account
+
+	unmarshal(t, data, &account)
+
+	if account.ID == 0 {
+		t.Fatalf("account has ID 0")
+	}
+
+	if account.Name != name {
+		t.Fatalf("name does not match, want %q, got %q", name, account.Name)
+	}
+
+	return account
+}
+
+func TestAccountCRUD(t *testing.T) {
+	srv, cleanup := TestServer(t)
+	defer cleanup()
+
+	a := readFixture(t, "sample_account.json")
+
+	token := login(t, srv, "admin", "geheim")
+
+	status, body := request(t, token, "POST", srv.URL+"/api/account", a)
+	if status != 201 {
+		t.Fatalf("invalid status code, want 201, got %v, body:\n  %s", status, body)
+	}
+
+	account := verifyAccount(t, "Beispiel GmbH", body)
+
+	status, body = request(t, token, "GET", fmt.Sprintf("%s/api/account/%d", srv.URL, account.ID), nil)
+	if status != 200 {
+		t.Fatalf("reading account again yielded unexpected status %d: %s", status, body)
+	}
+
+	account = verifyAccount(t, account.Name, body)
+	account.Name = "Beispiel AG"
+
+	status, body = request(t, token, "PUT", fmt.Sprintf("%s/api/account/%d", srv.URL, account.ID), marshal(t, account))
+	if status != 200 {
+		t.Fatalf("updating account, invalid status %d", status)
+	}
+
+	verifyAccount(t, account.Name, body)
+
+	status, body = request(t, token, "PUT", fmt.Sprintf("%s/api/account/%d", srv.URL, account.ID), marshal(t, account))
+	if status != 409 {
+		t.Fatalf("updating account with outdated version, want status 409, got %d: %s", status, body)
+	}
+
+	status, body = request(t, token, "DELETE", fmt.Sprintf("%s/api/account/%d", srv.URL, account.ID), nil)
+	if status != 200 {
+		t.Fatalf("deleting account yielded unexpected status %d: %s", status, body)
+	}
+
+	status, _ = request(t, token, "GET", fmt.Sprintf("%s/api/account/%d", srv.URL, account.ID), nil)
+	if status != 404 {
+		t.Fatalf("reading removed account, want status 404, got %d", status)
+	}
+}
+
+func TestAccountPeople(t *testing.T) {
+	srv, cleanup := TestServer(t)
+	defer cleanup()
+
+	token := login(t, srv, "admin", "geheim")
+
+	status, body := request(t, token, "POST", srv.URL+"/api/account", readFixture(t, "sample_account.json"))
+	if status != 201 {
+		t.Fatalf("invalid status code, want 201, got %v, body:\n  %s", status, body)
+	}
+
+	account := verifyAccount(t, "Beispiel GmbH", body)
+
+	p := fmt.Sprintf(`{"name": "Account Person", "account_id": %d}`, account.ID)
+	status, body = request(t, token, "POST", srv.URL+"/api/person", []byte(p))
+	if status != 201 {
+		t.Fatalf("invalid status code, want 201, got %v, body:\n  %s", status, body)
+	}
+
+	person := verifyPerson(t, "Account Person", body)
+
+	status, body = request(t, token, "GET", fmt.Sprintf("%s/api/account/%d/people", srv.URL, account.ID), nil)
+	if status != 200 {
+		t.Fatalf("listing people for account yielded unexpected status %d: %s", status, body)
+	}
+
+	var list []Person
+	unmarshal(t, body, &list)
+	if len(list) != 1 || list[0].ID != person.ID {
+		t.Fatalf("wrong list of people returned for account, want [%v], got %v", person, list)
+	}
+
+	status, body = request(t, token, "POST", srv.URL+"/api/person", []byte(`{"name": "foo", "account_id": 999999}`))
+	if status != 400 {
+		t.Fatalf("creating person with invalid account, want status 400, got %v: %s", status, body)
+	}
+}
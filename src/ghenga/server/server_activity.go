@@ -0,0 +1,233 @@
+package server
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"ghenga/db"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"golang.org/x/net/context"
+)
+
+// ListPersonActivities returns the timeline of activities for a person. The
+// newest activities are returned first, unless the query parameter `order` is
+// set to `asc`. The list is paginated with the query parameters `limit` and
+// `offset`, the total number of activities is returned in a header.
+func ListPersonActivities(ctx context.Context, env *Env, res http.ResponseWriter, req *http.Request) error {
+	id, err := strconv.Atoi(mux.Vars(req)["id"])
+	if err != nil {
+		return StatusError{Code: http.StatusBadRequest, Err: err}
+	}
+
+	if _, err = findPerson(ctx, env, int64(id)); err != nil {
+		return err
+	}
+
+	var ascending bool
+	switch req.URL.Query().Get("order") {
+	case "", "desc":
+	case "asc":
+		ascending = true
+	default:
+		return StatusError{
+			Code: http.StatusBadRequest,
+			Err:  errors.New("order must be asc or desc"),
+		}
+	}
+
+	limit, offset, err := parsePage(req)
+	if err != nil {
+		return err
+	}
+
+	activities, total, err := env.DB.ListPersonActivities(int64(id), ascending, limit, offset)
+	if err != nil {
+		return err
+	}
+
+	if activities == nil {
+		activities = []*db.Activity{}
+	}
+
+	res.Header().Set(totalCountHeaderName, strconv.FormatInt(total, 10))
+	return httpWriteJSON(res, http.StatusOK, activities)
+}
+
+// CreatePersonActivity inserts a new activity for a person into the database.
+// The current user is recorded as the author. The request body must be valid
+// JSON.
+func CreatePersonActivity(ctx context.Context, env *Env, wr http.ResponseWriter, req *http.Request) (err error) {
+	defer cleanupErr(&err, req.Body.Close)
+
+	id, err := strconv.Atoi(mux.Vars(req)["id"])
+	if err != nil {
+		return StatusError{Code: http.StatusBadRequest, Err: err}
+	}
+
+	if _, err = findPerson(ctx, env, int64(id)); err != nil {
+		return err
+	}
+
+	u, err := currentUser(ctx, env)
+	if err != nil {
+		return err
+	}
+
+	var ja db.ActivityJSON
+	dec := json.NewDecoder(req.Body)
+	if err = dec.Decode(&ja); err != nil {
+		return err
+	}
+
+	a := db.NewActivity("", "", int64(id))
+	if err = a.Update(ja); err != nil {
+		return StatusError{Code: http.StatusBadRequest, Err: err}
+	}
+
+	a.AuthorID = sql.NullInt64{Int64: u.ID, Valid: true}
+	a.Version = 0
+
+	if err = a.Validate(); err != nil {
+		return StatusError{Code: http.StatusBadRequest, Err: err}
+	}
+
+	err = env.DB.InsertActivity(a)
+	if err != nil {
+		return err
+	}
+
+	env.Debugf("created activity %v", a)
+
+	return httpWriteJSON(wr, http.StatusCreated, a)
+}
+
+// findActivity loads the activity with the ID given in the request.
+// Activities of people which the current user may not see are reported as not
+// found.
+func findActivity(ctx context.Context, env *Env, req *http.Request) (*db.Activity, error) {
+	id, err := strconv.Atoi(mux.Vars(req)["id"])
+	if err != nil {
+		return nil, StatusError{Code: http.StatusBadRequest, Err: err}
+	}
+
+	notFound := StatusError{
+		Err:  errors.New("activity not found"),
+		Code: http.StatusNotFound,
+	}
+
+	a, err := env.DB.FindActivity(int64(id))
+	if err != nil {
+		return nil, notFound
+	}
+
+	if _, err = findPerson(ctx, env, a.PersonID); err != nil {
+		return nil, notFound
+	}
+
+	return a, nil
+}
+
+// ShowActivity returns an Activity record.
+func ShowActivity(ctx context.Context, env *Env, res http.ResponseWriter, req *http.Request) error {
+	a, err := findActivity(ctx, env, req)
+	if err != nil {
+		return err
+	}
+
+	return httpWriteJSON(res, http.StatusOK, a)
+}
+
+// findAuthoredActivity loads the activity with the ID given in the request
+// and checks that the current user is allowed to modify it. This is only the
+// case for the author of the activity and for admins.
+func findAuthoredActivity(ctx context.Context, env *Env, req *http.Request) (*db.Activity, error) {
+	a, err := findActivity(ctx, env, req)
+	if err != nil {
+		return nil, err
+	}
+
+	u, err := currentUser(ctx, env)
+	if err != nil {
+		return nil, err
+	}
+
+	if !u.Admin && (!a.AuthorID.Valid || a.AuthorID.Int64 != u.ID) {
+		return nil, StatusError{
+			Code: http.StatusForbidden,
+			Err:  errors.New("only the author may modify an activity"),
+		}
+	}
+
+	return a, nil
+}
+
+// UpdateActivity changes an existing activity record. The request body must
+// be valid JSON.
+func UpdateActivity(ctx context.Context, env *Env, wr http.ResponseWriter, req *http.Request) (err error) {
+	defer cleanupErr(&err, req.Body.Close)
+
+	a, err := findAuthoredActivity(ctx, env, req)
+	if err != nil {
+		return err
+	}
+
+	var newActivity db.ActivityJSON
+	dec := json.NewDecoder(req.Body)
+	if err = dec.Decode(&newActivity); err != nil {
+		return err
+	}
+
+	if a.Version != newActivity.Version {
+		env.Debugf("activity record is outdated, version %v != %v",
+			a.Version, newActivity.Version)
+		return StatusError{
+			Err:  errors.New("version field does not match"),
+			Code: http.StatusConflict,
+		}
+	}
+
+	if err = a.Update(newActivity); err != nil {
+		return StatusError{Code: http.StatusBadRequest, Err: err}
+	}
+
+	a.ChangedAt = time.Now()
+
+	if err = a.Validate(); err != nil {
+		return StatusError{Code: http.StatusBadRequest, Err: err}
+	}
+
+	err = env.DB.UpdateActivity(a)
+	if err != nil {
+		env.Logf("unable update activity %v, sql error: %v", a, err)
+		return err
+	}
+
+	return httpWriteJSON(wr, http.StatusOK, a)
+}
+
+// DeleteActivity removes an activity from the database.
+func DeleteActivity(ctx context.Context, env *Env, wr http.ResponseWriter, req *http.Request) (err error) {
+	a, err := findAuthoredActivity(ctx, env, req)
+	if err != nil {
+		return err
+	}
+
+	if err := env.DB.DeleteActivity(a.ID); err != nil {
+		return err
+	}
+
+	return httpWriteJSON(wr, http.StatusOK, nil)
+}
+
+// ActivityHandler adds routes for ghenga API in the given enviroment to r.
+func ActivityHandler(ctx context.Context, env *Env, r *mux.Router) {
+	r.Handle("/api/person/{id}/activities", Handle(ctx, env, RequireAuth(ListPersonActivities))).Methods("GET")
+	r.Handle("/api/person/{id}/activities", Handle(ctx, env, RequireAuth(Audit(db.ActionCreate, "activity", CreatePersonActivity)))).Methods("POST")
+	r.Handle("/api/activity/{id}", Handle(ctx, env, RequireAuth(ShowActivity))).Methods("GET")
+	r.Handle("/api/activity/{id}", Handle(ctx, env, RequireAuth(Audit(db.ActionUpdate, "activity", UpdateActivity)))).Methods("PUT")
+	r.Handle("/api/activity/{id}", Handle(ctx, env, RequireAuth(Audit(db.ActionDelete, "activity", DeleteActivity)))).Methods("DELETE")
+}
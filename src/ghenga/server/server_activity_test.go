@@ -0,0 +1,133 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+type Activity struct {
+	ID       int    `json:"id"`
+	Kind     string `json:"kind"`
+	Subject  string `json:"subject"`
+	PersonID int    `json:"person_id"`
+	AuthorID int    `json:"author_id"`
+	Version  int    `json:"version"`
+}
+
+func createActivity(t *testing.T, token, url string, personID int, data string) Activity {
+	status, body := request(t, token, "POST", fmt.Sprintf("%s/api/person/%d/activities", url, personID), []byte(data))
+	if status != 201 {
+		t.Fatalf("invalid status code, want 201, got %v, body:\n  %s", status, body)
+	}
+
+	var a Activity
+	unmarshal(t, body, &a)
+
+	if a.ID == 0 {
+		t.Fatalf("activity has ID 0")
+	}
+
+	if a.PersonID != personID {
+		t.Fatalf("activity has wrong person ID, want %v, got %v", personID, a.PersonID)
+	}
+
+	return a
+}
+
+func TestPersonActivities(t *testing.T) {
+	srv, cleanup := TestServer(t)
+	defer cleanup()
+
+	token := login(t, srv, "admin", "geheim")
+
+	for i := 1; i <= 3; i++ {
+		createActivity(t, token, srv.URL, 5, fmt.Sprintf(`{"kind": "call", "subject": "call %d", "occurred_at": "2016-04-0%dT10:00:00+00:00"}`, i, i))
+	}
+
+	req, err := http.NewRequest("GET", fmt.Sprintf("%s/api/person/5/activities?limit=2", srv.URL), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Add(authHeaderName, token)
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if total := res.Header.Get(totalCountHeaderName); total != "3" {
+		t.Errorf("wrong total count returned, want 3, got %q", total)
+	}
+
+	status, body := readBody(t, res)
+	if status != 200 {
+		t.Fatalf("listing activities yielded unexpected status %d: %s", status, body)
+	}
+
+	var list []Activity
+	unmarshal(t, body, &list)
+
+	if len(list) != 2 || list[0].Subject != "call 3" || list[1].Subject != "call 2" {
+		t.Fatalf("wrong list of activities returned: %v", list)
+	}
+
+	status, body = request(t, token, "GET", fmt.Sprintf("%s/api/person/5/activities?order=asc&offset=2", srv.URL), nil)
+	if status != 200 {
+		t.Fatalf("listing activities yielded unexpected status %d: %s", status, body)
+	}
+
+	list = nil
+	unmarshal(t, body, &list)
+
+	if len(list) != 1 || list[0].Subject != "call 3" {
+		t.Fatalf("wrong list of activities returned: %v", list)
+	}
+
+	status, _ = request(t, token, "GET", fmt.Sprintf("%s/api/person/5/activities?order=foo", srv.URL), nil)
+	if status != 400 {
+		t.Fatalf("listing activities with invalid order, want status 400, got %d", status)
+	}
+}
+
+func TestActivityAuthor(t *testing.T) {
+	srv, cleanup := TestServer(t)
+	defer cleanup()
+
+	u, err := srv.DB.FindUserName("user")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	userToken := login(t, srv, "user", "geheim")
+	adminToken := login(t, srv, "admin", "geheim")
+
+	a := createActivity(t, adminToken, srv.URL, 3, `{"kind": "meeting", "subject": "lunch"}`)
+
+	a.Subject = "dinner"
+	status, body := request(t, userToken, "PUT", fmt.Sprintf("%s/api/activity/%d", srv.URL, a.ID), marshal(t, a))
+	if status != 403 {
+		t.Fatalf("updating activity of another user, want status 403, got %d: %s", status, body)
+	}
+
+	status, _ = request(t, userToken, "DELETE", fmt.Sprintf("%s/api/activity/%d", srv.URL, a.ID), nil)
+	if status != 403 {
+		t.Fatalf("removing activity of another user, want status 403, got %d", status)
+	}
+
+	a = createActivity(t, userToken, srv.URL, 3, `{"kind": "email", "subject": "offer"}`)
+	if a.AuthorID != int(u.ID) {
+		t.Fatalf("wrong author recorded, want %v, got %v", u.ID, a.AuthorID)
+	}
+
+	a.Subject = "new offer"
+	status, body = request(t, userToken, "PUT", fmt.Sprintf("%s/api/activity/%d", srv.URL, a.ID), marshal(t, a))
+	if status != 200 {
+		t.Fatalf("updating own activity yielded unexpected status %d: %s", status, body)
+	}
+
+	status, body = request(t, adminToken, "DELETE", fmt.Sprintf("%s/api/activity/%d", srv.URL, a.ID), nil)
+	if status != 200 {
+		t.Fatalf("removing activity as admin yielded unexpected status %d: %s", status, body)
+	}
+}
@@ -0,0 +1,37 @@
+package server
+
+import (
+	"ghenga/db"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"golang.org/x/net/context"
+)
+
+// ListAudit returns the audit log, the newest entries first unless a
+// different order is requested. The list is paginated and filtered like other
+// lists, the entries can be filtered by `user` (the login), `user_id`,
+// `action`, `entity`, `entity_id` and the time range with `since` and `until`.
+func ListAudit(ctx context.Context, env *Env, res http.ResponseWriter, req *http.Request) error {
+	opts, err := parseListOptions(req)
+	if err != nil {
+		return err
+	}
+
+	if len(opts.Sort) == 0 {
+		opts.Sort = db.ParseSort("-id")
+	}
+
+	entries, page, err := env.DB.ListAuditEntriesPage(opts)
+	if err != nil {
+		return listError(err)
+	}
+
+	writePage(res, page)
+	return httpWriteJSON(res, http.StatusOK, entries)
+}
+
+// AuditHandler adds the route for the audit log to r.
+func AuditHandler(ctx context.Context, env *Env, r *mux.Router) {
+	r.Handle("/api/audit", Handle(ctx, env, RequireAdmin(ListAudit))).Methods("GET")
+}
@@ -0,0 +1,115 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+type auditEntry struct {
+	UserID        *int   `json:"user_id"`
+	Login         string `json:"login"`
+	Action        string `json:"action"`
+	Entity        string `json:"entity"`
+	EntityID      *int   `json:"entity_id"`
+	BeforeVersion *int   `json:"before_version"`
+	AfterVersion  *int   `json:"after_version"`
+	RemoteAddr    string `json:"remote_addr"`
+	UserAgent     string `json:"user_agent"`
+}
+
+func intValue(v *int) int {
+	if v == nil {
+		return 0
+	}
+
+	return *v
+}
+
+func listAudit(t *testing.T, token, url string) []auditEntry {
+	status, body := request(t, token, "GET", url, nil)
+	if status != http.StatusOK {
+		t.Fatalf("listing audit log failed with status %d: %s", status, body)
+	}
+
+	var entries []auditEntry
+	unmarshal(t, body, &entries)
+	return entries
+}
+
+func TestAudit(t *testing.T) {
+	srv, cleanup := TestServer(t)
+	defer cleanup()
+
+	if status, _ := loginRequest(t, srv, "admin", "wrong"); status != http.StatusUnauthorized {
+		t.Fatalf("login with wrong password returned status %d", status)
+	}
+
+	token := login(t, srv, "admin", "geheim")
+
+	status, body := request(t, token, "POST", srv.URL+"/api/person", []byte(`{"name": "Audit Person"}`))
+	if status != http.StatusCreated {
+		t.Fatalf("creating person failed with status %d: %s", status, body)
+	}
+	person := verifyPerson(t, "Audit Person", body)
+	personURL := fmt.Sprintf("%s/api/person/%d", srv.URL, person.ID)
+
+	person.Name = "Audit Person 2"
+	status, body = request(t, token, "PUT", personURL, marshal(t, person))
+	if status != http.StatusOK {
+		t.Fatalf("updating person failed with status %d: %s", status, body)
+	}
+
+	// requests which fail are not recorded
+	if status, _ = request(t, token, "PUT", personURL, marshal(t, person)); status != http.StatusConflict {
+		t.Fatalf("updating outdated person returned status %d", status)
+	}
+
+	deletePerson(t, token, srv.URL, person.ID)
+
+	entries := listAudit(t, token, srv.URL+"/api/audit?entity=person&user=admin")
+	if len(entries) != 3 {
+		t.Fatalf("want 3 entries, got %+v", entries)
+	}
+
+	var tests = []struct {
+		action        string
+		before, after int
+	}{
+		{"delete", 0, 0},
+		{"update", 1, 2},
+		{"create", 0, 1},
+	}
+
+	for i, test := range tests {
+		e := entries[i]
+		if e.Action != test.action || intValue(e.EntityID) != person.ID ||
+			intValue(e.BeforeVersion) != test.before || intValue(e.AfterVersion) != test.after {
+			t.Errorf("entry %d: want %v %d -> %d, got %+v", i, test.action, test.before, test.after, e)
+		}
+
+		if e.UserID == nil || e.RemoteAddr != "127.0.0.1" || e.UserAgent == "" {
+			t.Errorf("entry %d: wrong user or client: %+v", i, e)
+		}
+	}
+
+	entries = listAudit(t, token, srv.URL+"/api/audit?entity=session&sort=id")
+	if len(entries) != 2 || entries[0].Action != "login_failed" || entries[0].UserID != nil ||
+		entries[1].Action != "login" || entries[1].Login != "admin" {
+		t.Fatalf("wrong login entries: %+v", entries)
+	}
+
+	entries = listAudit(t, token, srv.URL+"/api/audit?since=2100-01-01T00:00:00Z")
+	if len(entries) != 0 {
+		t.Fatalf("entries from the future returned: %+v", entries)
+	}
+
+	if status, body = request(t, token, "GET", srv.URL+"/api/audit?until=tomorrow", nil); status != http.StatusBadRequest {
+		t.Fatalf("invalid time range returned status %d: %s", status, body)
+	}
+
+	userToken := login(t, srv, "user", "geheim")
+	if status, _ = request(t, userToken, "GET", srv.URL+"/api/audit", nil); status != http.StatusForbidden {
+		t.Fatalf("listing audit log as non-admin returned status %d", status)
+	}
+}
@@ -0,0 +1,237 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"ghenga/db"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"golang.org/x/net/context"
+)
+
+// maxBatchOperations is the maximal number of operations in a single batch.
+const maxBatchOperations = 1000
+
+// BatchJSON is the request body for a batch of operations.
+type BatchJSON struct {
+	ContinueOnError bool                 `json:"continue_on_error"`
+	Operations      []BatchOperationJSON `json:"operations"`
+}
+
+// BatchOperationJSON is a single operation within a batch. Op is one of
+// "create", "update" or "delete", Entity is "person" or "user". For update and
+// delete, the ID of the record is required. If Version is set, the operation
+// fails if the record has been modified, like with an If-Match header.
+type BatchOperationJSON struct {
+	Op      string          `json:"op"`
+	Entity  string          `json:"entity"`
+	ID      int64           `json:"id,omitempty"`
+	Version int64           `json:"version,omitempty"`
+	Data    json.RawMessage `json:"data,omitempty"`
+}
+
+// BatchResultJSON is the result of a single operation, it contains the status
+// code and the body a single request for the operation would have returned.
+type BatchResultJSON struct {
+	Status int             `json:"status"`
+	Body   json.RawMessage `json:"body"`
+}
+
+// BatchResponseJSON is returned for a batch. Committed is true if the changes
+// of the batch have been saved.
+type BatchResponseJSON struct {
+	Committed bool              `json:"committed"`
+	Results   []BatchResultJSON `json:"results"`
+}
+
+// batchEntities maps the entities which can be changed in a batch to their
+// API path.
+var batchEntities = map[string]string{
+	"person": "/api/person",
+	"user":   "/api/user",
+}
+
+// batchMethods maps the batch operations to the HTTP method of the equivalent
+// request.
+var batchMethods = map[string]string{
+	"create": "POST",
+	"update": "PUT",
+	"delete": "DELETE",
+}
+
+// request returns the HTTP method and path of the request which is equivalent
+// to the operation.
+func (op BatchOperationJSON) request() (method, path string, err error) {
+	path, ok := batchEntities[op.Entity]
+	if !ok {
+		return "", "", fmt.Errorf("unknown entity %q", op.Entity)
+	}
+
+	method, ok = batchMethods[op.Op]
+	if !ok {
+		return "", "", fmt.Errorf("unknown operation %q", op.Op)
+	}
+
+	if op.Op != "delete" && len(op.Data) == 0 {
+		return "", "", errors.New("data is required")
+	}
+
+	if op.Op == "create" {
+		return method, path, nil
+	}
+
+	if op.ID <= 0 {
+		return "", "", errors.New("id is required")
+	}
+
+	return method, fmt.Sprintf("%s/%d", path, op.ID), nil
+}
+
+// batchRecorder is an http.ResponseWriter which keeps the response for an
+// operation in memory.
+type batchRecorder struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (r *batchRecorder) Header() http.Header {
+	return r.header
+}
+
+func (r *batchRecorder) Write(buf []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+
+	return r.body.Write(buf)
+}
+
+func (r *batchRecorder) WriteHeader(status int) {
+	r.status = status
+}
+
+// batchError returns the result for an operation which failed with err.
+func batchError(status int, err error) BatchResultJSON {
+	buf, _ := json.Marshal(jsonError{Message: err.Error()})
+	return BatchResultJSON{Status: status, Body: buf}
+}
+
+// runBatchOperation runs op with the handler h as if it was sent as a single
+// request with the same authentication token as req.
+func runBatchOperation(h http.Handler, req *http.Request, op BatchOperationJSON) BatchResultJSON {
+	method, path, err := op.request()
+	if err != nil {
+		return batchError(http.StatusBadRequest, err)
+	}
+
+	r, err := http.NewRequest(method, path, bytes.NewReader(op.Data))
+	if err != nil {
+		return batchError(http.StatusBadRequest, err)
+	}
+
+	// the client's address and user agent are recorded in the audit log
+	r.RemoteAddr = req.RemoteAddr
+	r.Header.Set("User-Agent", req.UserAgent())
+	r.Header.Set(authHeaderName, requestToken(req))
+	r.Header.Set("Content-Type", "application/json")
+	if op.Version != 0 && op.Op != "create" {
+		r.Header.Set("If-Match", entityTag(op.Entity, op.ID, op.Version))
+	}
+
+	rec := &batchRecorder{header: make(http.Header)}
+	h.ServeHTTP(rec, r)
+
+	return BatchResultJSON{Status: rec.status, Body: bytes.TrimSpace(rec.body.Bytes())}
+}
+
+// errBatchFailed is returned to roll back a batch (or an operation) when an
+// operation failed.
+var errBatchFailed = errors.New("batch operation failed")
+
+// Batch runs a list of operations on people and users within a single
+// transaction. If an operation fails, the transaction is rolled back and the
+// remaining operations are not run. If `continue_on_error` is set, only the
+// changes of the failed operation are rolled back and the remaining
+// operations are run. A result is returned for each operation, operations
+// which have not been run have the status code 424 (Failed Dependency).
+func Batch(ctx context.Context, env *Env, wr http.ResponseWriter, req *http.Request) (err error) {
+	defer cleanupErr(&err, req.Body.Close)
+
+	var batch BatchJSON
+	dec := json.NewDecoder(req.Body)
+	if err = dec.Decode(&batch); err != nil {
+		return StatusError{Code: http.StatusBadRequest, Err: err}
+	}
+
+	if len(batch.Operations) == 0 || len(batch.Operations) > maxBatchOperations {
+		return StatusError{
+			Code: http.StatusBadRequest,
+			Err:  fmt.Errorf("batch must contain between 1 and %d operations", maxBatchOperations),
+		}
+	}
+
+	results := make([]BatchResultJSON, len(batch.Operations))
+	for i := range results {
+		results[i] = batchError(http.StatusFailedDependency, errors.New("not run because an earlier operation failed"))
+	}
+
+	err = env.DB.Tx(ctx, func(tx *db.Tx) error {
+		// the operations are run by the usual handlers, with an environment
+		// which uses the transaction
+		txEnv := *env
+		txEnv.DB = tx.DB
+
+		router := mux.NewRouter()
+		PeopleHandler(ctx, &txEnv, router)
+		UserHandler(ctx, &txEnv, router)
+
+		for i, op := range batch.Operations {
+			run := func() error {
+				results[i] = runBatchOperation(router, req, op)
+				if results[i].Status >= 400 {
+					return errBatchFailed
+				}
+
+				return nil
+			}
+
+			var err error
+			if batch.ContinueOnError {
+				err = tx.Savepoint(run)
+			} else {
+				err = run()
+			}
+
+			if err == errBatchFailed && batch.ContinueOnError {
+				continue
+			}
+
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+
+	if err != nil && err != errBatchFailed {
+		env.Logf("unable to run batch: %v", err)
+		return err
+	}
+
+	env.Debugf("ran batch of %d operations, committed: %v", len(batch.Operations), err == nil)
+
+	return httpWriteJSON(wr, http.StatusOK, BatchResponseJSON{
+		Committed: err == nil,
+		Results:   results,
+	})
+}
+
+// BatchHandler adds the route for batches to r.
+func BatchHandler(ctx context.Context, env *Env, r *mux.Router) {
+	r.Handle("/api/batch", Handle(ctx, env, RequireAuth(Batch))).Methods("POST")
+}
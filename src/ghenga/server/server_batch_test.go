@@ -0,0 +1,151 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+)
+
+var batchRequestTests = []struct {
+	op     BatchOperationJSON
+	method string
+	path   string
+}{
+	{BatchOperationJSON{Op: "create", Entity: "person", Data: json.RawMessage(`{}`)}, "POST", "/api/person"},
+	{BatchOperationJSON{Op: "update", Entity: "user", ID: 5, Data: json.RawMessage(`{}`)}, "PUT", "/api/user/5"},
+	{BatchOperationJSON{Op: "delete", Entity: "person", ID: 23}, "DELETE", "/api/person/23"},
+	{BatchOperationJSON{Op: "create", Entity: "account", Data: json.RawMessage(`{}`)}, "", ""},
+	{BatchOperationJSON{Op: "merge", Entity: "person", ID: 23}, "", ""},
+	{BatchOperationJSON{Op: "create", Entity: "person"}, "", ""},
+	{BatchOperationJSON{Op: "delete", Entity: "person"}, "", ""},
+}
+
+func TestBatchOperationRequest(t *testing.T) {
+	for i, test := range batchRequestTests {
+		method, path, err := test.op.request()
+		if test.method == "" {
+			if err == nil {
+				t.Errorf("test %d: expected error for invalid operation, got %v %v", i, method, path)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("test %d: unexpected error: %v", i, err)
+			continue
+		}
+
+		if method != test.method || path != test.path {
+			t.Errorf("test %d: want %v %v, got %v %v", i, test.method, test.path, method, path)
+		}
+	}
+}
+
+type batchResponse struct {
+	Committed bool `json:"committed"`
+	Results   []struct {
+		Status int             `json:"status"`
+		Body   json.RawMessage `json:"body"`
+	} `json:"results"`
+}
+
+func runBatch(t *testing.T, token, url string, batch string) batchResponse {
+	status, body := request(t, token, "POST", url+"/api/batch", []byte(batch))
+	if status != 200 {
+		t.Fatalf("batch failed with status %d: %s", status, body)
+	}
+
+	var res batchResponse
+	unmarshal(t, body, &res)
+	return res
+}
+
+func checkStatus(t *testing.T, res batchResponse, want ...int) {
+	if len(res.Results) != len(want) {
+		t.Fatalf("wrong number of results, want %d, got %d", len(want), len(res.Results))
+	}
+
+	for i, r := range res.Results {
+		if r.Status != want[i] {
+			t.Errorf("operation %d: want status %d, got %d: %s", i, want[i], r.Status, r.Body)
+		}
+	}
+}
+
+func TestBatch(t *testing.T) {
+	srv, cleanup := TestServer(t)
+	defer cleanup()
+
+	token := login(t, srv, "admin", "geheim")
+
+	res := runBatch(t, token, srv.URL, `{"operations": [
+		{"op": "create", "entity": "person", "data": {"name": "Batch Person A"}},
+		{"op": "create", "entity": "person", "data": {"name": "Batch Person B"}}
+	]}`)
+	if !res.Committed {
+		t.Fatalf("batch was not committed")
+	}
+	checkStatus(t, res, 201, 201)
+
+	a := verifyPerson(t, "Batch Person A", res.Results[0].Body)
+	b := verifyPerson(t, "Batch Person B", res.Results[1].Body)
+
+	// the failed update rolls back the whole batch
+	res = runBatch(t, token, srv.URL, fmt.Sprintf(`{"operations": [
+		{"op": "delete", "entity": "person", "id": %d},
+		{"op": "update", "entity": "person", "id": %d, "data": {"name": "Batch Person C", "version": 23}},
+		{"op": "create", "entity": "person", "data": {"name": "Batch Person D"}}
+	]}`, a.ID, b.ID))
+	if res.Committed {
+		t.Fatalf("batch was committed despite failed operation")
+	}
+	checkStatus(t, res, 200, 409, 424)
+
+	status, body := request(t, token, "GET", fmt.Sprintf("%s/api/person/%d", srv.URL, a.ID), nil)
+	if status != 200 {
+		t.Fatalf("person was deleted despite rollback, status %d: %s", status, body)
+	}
+
+	// with continue_on_error, only the failed operation is rolled back
+	res = runBatch(t, token, srv.URL, fmt.Sprintf(`{"continue_on_error": true, "operations": [
+		{"op": "delete", "entity": "person", "id": %d},
+		{"op": "update", "entity": "person", "id": %d, "data": {"name": "", "version": %d}},
+		{"op": "update", "entity": "person", "id": %d, "version": %d, "data": {"name": "Batch Person C"}},
+		{"op": "frobnicate", "entity": "person"}
+	]}`, a.ID, b.ID, b.Version, b.ID, b.Version))
+	if !res.Committed {
+		t.Fatalf("batch with continue_on_error was not committed")
+	}
+	checkStatus(t, res, 200, 400, 200, 400)
+
+	status, _ = request(t, token, "GET", fmt.Sprintf("%s/api/person/%d", srv.URL, a.ID), nil)
+	if status != 404 {
+		t.Fatalf("person was not deleted, status %d", status)
+	}
+
+	status, body = request(t, token, "GET", fmt.Sprintf("%s/api/person/%d", srv.URL, b.ID), nil)
+	if status != 200 {
+		t.Fatalf("unable to get person, status %d: %s", status, body)
+	}
+	verifyPerson(t, "Batch Person C", body)
+}
+
+func TestBatchPermissions(t *testing.T) {
+	srv, cleanup := TestServer(t)
+	defer cleanup()
+
+	token := login(t, srv, "user", "geheim")
+
+	res := runBatch(t, token, srv.URL, `{"continue_on_error": true, "operations": [
+		{"op": "create", "entity": "user", "data": {"login": "batch", "password": "secret"}},
+		{"op": "create", "entity": "person", "data": {"name": "Batch Person E"}}
+	]}`)
+	checkStatus(t, res, 403, 201)
+
+	for _, batch := range []string{`{"operations": []}`, `{"operations": {}}`} {
+		status, body := request(t, token, "POST", srv.URL+"/api/batch", []byte(batch))
+		if status != 400 {
+			t.Errorf("invalid batch %s: want status 400, got %d: %s", batch, status, body)
+		}
+	}
+}
@@ -0,0 +1,336 @@
+package server
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"ghenga/db"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"golang.org/x/net/context"
+)
+
+// visibleParticipants removes the people which are not visible in vdb from
+// the participants of the events. It returns the visible people by ID and the
+// IDs of the people which have been removed.
+func visibleParticipants(vdb *db.DB, events ...*db.Event) (map[int64]*db.Person, []int64, error) {
+	people := make(map[int64]*db.Person)
+	hidden := make(map[int64]bool)
+	var hiddenIDs []int64
+
+	for _, e := range events {
+		ids := e.PersonIDs[:0:0]
+		for _, id := range e.PersonIDs {
+			if _, ok := people[id]; !ok && !hidden[id] {
+				p, err := vdb.FindPerson(id)
+				switch {
+				case err == sql.ErrNoRows:
+					hidden[id] = true
+					hiddenIDs = append(hiddenIDs, id)
+				case err != nil:
+					return nil, nil, err
+				default:
+					people[id] = p
+				}
+			}
+
+			if !hidden[id] {
+				ids = append(ids, id)
+			}
+		}
+		e.PersonIDs = ids
+	}
+
+	return people, hiddenIDs, nil
+}
+
+// ListEvents handles listing event records. Participants which the current
+// user may not see are left out.
+func ListEvents(ctx context.Context, env *Env, res http.ResponseWriter, req *http.Request) error {
+	vdb, err := visibleDB(ctx, env)
+	if err != nil {
+		return err
+	}
+
+	events, err := env.DB.ListEvents()
+	if err != nil {
+		return err
+	}
+
+	if _, _, err = visibleParticipants(vdb, events...); err != nil {
+		return err
+	}
+
+	return httpWriteJSON(res, http.StatusOK, events)
+}
+
+// findEvent returns the event with the ID from the URL.
+func findEvent(req *http.Request, env *Env) (*db.Event, error) {
+	id, err := strconv.Atoi(mux.Vars(req)["id"])
+	if err != nil {
+		return nil, StatusError{Code: http.StatusBadRequest, Err: err}
+	}
+
+	e, err := env.DB.FindEvent(int64(id))
+	if err != nil {
+		return nil, StatusError{
+			Err:  errors.New("event not found"),
+			Code: http.StatusNotFound,
+		}
+	}
+
+	return e, nil
+}
+
+// ShowEvent returns an Event record. Participants which the current user may
+// not see are left out.
+func ShowEvent(ctx context.Context, env *Env, res http.ResponseWriter, req *http.Request) error {
+	vdb, err := visibleDB(ctx, env)
+	if err != nil {
+		return err
+	}
+
+	e, err := findEvent(req, env)
+	if err != nil {
+		return err
+	}
+
+	if _, _, err = visibleParticipants(vdb, e); err != nil {
+		return err
+	}
+
+	return httpWriteJSON(res, http.StatusOK, e)
+}
+
+// checkParticipants returns an error if a participant of e does not exist or
+// a person is not visible to the current user.
+func checkParticipants(ctx context.Context, env *Env, e *db.Event) error {
+	for _, id := range e.PersonIDs {
+		if _, err := findPerson(ctx, env, id); err != nil {
+			return StatusError{
+				Err:  fmt.Errorf("person %d not found", id),
+				Code: http.StatusBadRequest,
+			}
+		}
+	}
+
+	for _, id := range e.UserIDs {
+		if _, err := env.DB.FindUser(id); err != nil {
+			return StatusError{
+				Err:  fmt.Errorf("user %d not found", id),
+				Code: http.StatusBadRequest,
+			}
+		}
+	}
+
+	return nil
+}
+
+// CreateEvent inserts a new event into the database. The request body must be valid JSON.
+func CreateEvent(ctx context.Context, env *Env, wr http.ResponseWriter, req *http.Request) (err error) {
+	defer cleanupErr(&err, req.Body.Close)
+
+	var je db.EventJSON
+	dec := json.NewDecoder(req.Body)
+	if err = dec.Decode(&je); err != nil {
+		return err
+	}
+
+	var e db.Event
+	if err = e.Update(je); err != nil {
+		return StatusError{Code: http.StatusBadRequest, Err: err}
+	}
+
+	// overwrite fields we'd like to be set
+	e.CreatedAt = time.Now()
+	e.ChangedAt = time.Now()
+
+	if err = e.Validate(); err != nil {
+		return StatusError{Code: http.StatusBadRequest, Err: err}
+	}
+
+	if err = checkParticipants(ctx, env, &e); err != nil {
+		return err
+	}
+
+	err = env.DB.InsertEvent(&e)
+	if err != nil {
+		return err
+	}
+
+	env.Debugf("created event %v", e)
+
+	return httpWriteJSON(wr, http.StatusCreated, e)
+}
+
+// UpdateEvent changes an existing event record. The request body must be valid JSON.
+// Participants which the current user may not see are kept.
+func UpdateEvent(ctx context.Context, env *Env, wr http.ResponseWriter, req *http.Request) (err error) {
+	defer cleanupErr(&err, req.Body.Close)
+
+	vdb, err := visibleDB(ctx, env)
+	if err != nil {
+		return err
+	}
+
+	e, err := findEvent(req, env)
+	if err != nil {
+		return err
+	}
+
+	var newEvent db.EventJSON
+	dec := json.NewDecoder(req.Body)
+	if err = dec.Decode(&newEvent); err != nil {
+		return err
+	}
+
+	_, hidden, err := visibleParticipants(vdb, e)
+	if err != nil {
+		return err
+	}
+
+	if e.Version != newEvent.Version {
+		env.Debugf("event record is outdated, version %v != %v",
+			e.Version, newEvent.Version)
+		return StatusError{
+			Err:  errors.New("version field does not match"),
+			Code: http.StatusConflict,
+		}
+	}
+
+	if err = e.Update(newEvent); err != nil {
+		return StatusError{Code: http.StatusBadRequest, Err: err}
+	}
+
+	e.ChangedAt = time.Now()
+
+	if err = e.Validate(); err != nil {
+		return StatusError{Code: http.StatusBadRequest, Err: err}
+	}
+
+	if err = checkParticipants(ctx, env, e); err != nil {
+		return err
+	}
+
+	visible := e.PersonIDs
+	e.PersonIDs = append(append([]int64{}, visible...), hidden...)
+
+	err = env.DB.UpdateEvent(e)
+	if err != nil {
+		env.Logf("unable update event %v, sql error: %v", e, err)
+		return err
+	}
+
+	e.PersonIDs = visible
+	return httpWriteJSON(wr, http.StatusOK, e)
+}
+
+// DeleteEvent removes an event from the database.
+func DeleteEvent(ctx context.Context, env *Env, wr http.ResponseWriter, req *http.Request) (err error) {
+	id, err := strconv.Atoi(mux.Vars(req)["id"])
+	if err != nil {
+		return StatusError{Code: http.StatusBadRequest, Err: err}
+	}
+
+	if err := env.DB.DeleteEvent(int64(id)); err != nil {
+		return err
+	}
+
+	return httpWriteJSON(wr, http.StatusOK, nil)
+}
+
+// CalendarJSON is the structure returned for the calendar feed of a user.
+type CalendarJSON struct {
+	Token string `json:"token"`
+	Path  string `json:"path"`
+}
+
+func calendarJSON(ct *db.CalendarToken) CalendarJSON {
+	return CalendarJSON{
+		Token: ct.Token,
+		Path:  "/api/calendar/" + ct.Token + ".ics",
+	}
+}
+
+// ShowCalendarToken returns the token for the calendar feed of the current
+// user. If the user does not have a token yet, a new one is created.
+func ShowCalendarToken(ctx context.Context, env *Env, res http.ResponseWriter, req *http.Request) error {
+	u, err := currentUser(ctx, env)
+	if err != nil {
+		return err
+	}
+
+	ct, err := env.DB.CalendarToken(u.ID)
+	if err != nil {
+		return err
+	}
+
+	return httpWriteJSON(res, http.StatusOK, calendarJSON(ct))
+}
+
+// ResetCalendarToken replaces the token for the calendar feed of the current
+// user, the old token becomes invalid.
+func ResetCalendarToken(ctx context.Context, env *Env, res http.ResponseWriter, req *http.Request) error {
+	u, err := currentUser(ctx, env)
+	if err != nil {
+		return err
+	}
+
+	ct, err := env.DB.ResetCalendarToken(u.ID)
+	if err != nil {
+		return err
+	}
+
+	return httpWriteJSON(res, http.StatusOK, calendarJSON(ct))
+}
+
+// Calendar returns the events the user participates in as an iCalendar feed.
+// The user is identified by the calendar token in the URL, so that calendar
+// clients can subscribe to the feed. Participants which the user may not see
+// are left out.
+func Calendar(ctx context.Context, env *Env, res http.ResponseWriter, req *http.Request) error {
+	ct, err := env.DB.FindCalendarToken(mux.Vars(req)["token"])
+	if err != nil {
+		return StatusError{
+			Err:  errors.New("calendar not found"),
+			Code: http.StatusNotFound,
+		}
+	}
+
+	u, err := env.DB.FindUser(ct.UserID)
+	if err != nil {
+		return err
+	}
+
+	events, err := env.DB.ListUserEvents(u.ID)
+	if err != nil {
+		return err
+	}
+
+	// the feed only contains the people the user may see
+	people, _, err := visibleParticipants(env.DB.VisibleTo(u), events...)
+	if err != nil {
+		return err
+	}
+
+	res.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+	res.WriteHeader(http.StatusOK)
+
+	return writeCalendar(res, "ghenga ("+u.Login+")", events, people, time.Now())
+}
+
+// EventHandler adds routes for ghenga API in the given enviroment to r.
+func EventHandler(ctx context.Context, env *Env, r *mux.Router) {
+	r.Handle("/api/event", Handle(ctx, env, RequireAuth(ListEvents))).Methods("GET")
+	r.Handle("/api/event", Handle(ctx, env, RequireAuth(Audit(db.ActionCreate, "event", CreateEvent)))).Methods("POST")
+	r.Handle("/api/event/{id}", Handle(ctx, env, RequireAuth(ShowEvent))).Methods("GET")
+	r.Handle("/api/event/{id}", Handle(ctx, env, RequireAuth(Audit(db.ActionUpdate, "event", UpdateEvent)))).Methods("PUT")
+	r.Handle("/api/event/{id}", Handle(ctx, env, RequireAuth(Audit(db.ActionDelete, "event", DeleteEvent)))).Methods("DELETE")
+	r.Handle("/api/me/calendar", Handle(ctx, env, RequireAuth(ShowCalendarToken))).Methods("GET")
+	r.Handle("/api/me/calendar", Handle(ctx, env, RequireAuth(Audit("reset", "calendar_token", ResetCalendarToken)))).Methods("POST")
+	r.Handle("/api/calendar/{token:[0-9a-f]+}.ics", Handle(ctx, env, Calendar)).Methods("GET")
+}
@@ -0,0 +1,135 @@
+package server
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+type Event struct {
+	ID           int    `json:"id"`
+	Title        string `json:"title"`
+	Start        string `json:"start"`
+	End          string `json:"end"`
+	Participants struct {
+		People []int `json:"people"`
+		Users  []int `json:"users"`
+	} `json:"participants"`
+	Version int `json:"version"`
+}
+
+func TestEventCRUD(t *testing.T) {
+	srv, cleanup := TestServer(t)
+	defer cleanup()
+
+	token := login(t, srv, "admin", "geheim")
+
+	data := `{"title": "Lunch", "start": "2016-05-02T12:00:00+02:00", "end": "2016-05-02T13:00:00+02:00",
+		"participants": {"people": [1, 2], "users": [1]}}`
+	status, body := request(t, token, "POST", srv.URL+"/api/event", []byte(data))
+	if status != 201 {
+		t.Fatalf("invalid status code, want 201, got %v, body:\n  %s", status, body)
+	}
+
+	var event Event
+	unmarshal(t, body, &event)
+
+	if event.ID == 0 || event.Title != "Lunch" {
+		t.Fatalf("invalid event returned: %s", body)
+	}
+
+	if len(event.Participants.People) != 2 || len(event.Participants.Users) != 1 {
+		t.Fatalf("wrong participants returned: %s", body)
+	}
+
+	event.Title = "Dinner"
+	event.Participants.People = []int{3}
+	status, body = request(t, token, "PUT", fmt.Sprintf("%s/api/event/%d", srv.URL, event.ID), marshal(t, event))
+	if status != 200 {
+		t.Fatalf("updating event, invalid status %d: %s", status, body)
+	}
+
+	status, body = request(t, token, "GET", fmt.Sprintf("%s/api/event/%d", srv.URL, event.ID), nil)
+	if status != 200 {
+		t.Fatalf("reading event again yielded unexpected status %d: %s", status, body)
+	}
+
+	unmarshal(t, body, &event)
+	if event.Title != "Dinner" || len(event.Participants.People) != 1 || event.Participants.People[0] != 3 {
+		t.Fatalf("event was not updated: %s", body)
+	}
+
+	status, body = request(t, token, "DELETE", fmt.Sprintf("%s/api/event/%d", srv.URL, event.ID), nil)
+	if status != 200 {
+		t.Fatalf("deleting event yielded unexpected status %d: %s", status, body)
+	}
+}
+
+var invalidEventTests = []string{
+	`{}`,
+	`{"title": "foo"}`,
+	`{"title": "foo", "start": "2016-05-02T12:00:00+02:00", "end": "2016-05-02T11:00:00+02:00"}`,
+	`{"title": "foo", "start": "2016-05-02T12:00:00+02:00", "end": "2016-05-02T13:00:00+02:00", "participants": {"people": [999999]}}`,
+}
+
+func TestInvalidEvent(t *testing.T) {
+	srv, cleanup := TestServer(t)
+	defer cleanup()
+
+	token := login(t, srv, "admin", "geheim")
+
+	for _, test := range invalidEventTests {
+		status, body := request(t, token, "POST", srv.URL+"/api/event", []byte(test))
+		if status != 400 {
+			t.Fatalf("status code for invalid event not found, want 400, got %v, body:\n  %s", status, body)
+		}
+	}
+}
+
+func TestCalendarFeed(t *testing.T) {
+	srv, cleanup := TestServer(t)
+	defer cleanup()
+
+	u, err := srv.DB.FindUserName("user")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token := login(t, srv, "user", "geheim")
+
+	data := fmt.Sprintf(`{"title": "Lunch with the team", "start": "2016-05-02T12:00:00+02:00", "end": "2016-05-02T13:00:00+02:00",
+		"participants": {"users": [%d]}}`, u.ID)
+	status, body := request(t, token, "POST", srv.URL+"/api/event", []byte(data))
+	if status != 201 {
+		t.Fatalf("invalid status code, want 201, got %v, body:\n  %s", status, body)
+	}
+
+	status, body = request(t, token, "GET", srv.URL+"/api/me/calendar", nil)
+	if status != 200 {
+		t.Fatalf("requesting calendar token yielded unexpected status %d: %s", status, body)
+	}
+
+	var cal CalendarJSON
+	unmarshal(t, body, &cal)
+
+	status, body = request(t, "", "GET", srv.URL+cal.Path, nil)
+	if status != 200 {
+		t.Fatalf("requesting calendar feed yielded unexpected status %d: %s", status, body)
+	}
+
+	for _, s := range []string{"BEGIN:VCALENDAR\r\n", "SUMMARY:Lunch with the team\r\n", "DTSTART:20160502T100000Z\r\n"} {
+		if !strings.Contains(string(body), s) {
+			t.Errorf("calendar feed does not contain %q:\n%s", s, body)
+		}
+	}
+
+	status, body = request(t, token, "POST", srv.URL+"/api/me/calendar", nil)
+	if status != 200 {
+		t.Fatalf("resetting calendar token yielded unexpected status %d: %s", status, body)
+	}
+
+	status, _ = request(t, "", "GET", srv.URL+cal.Path, nil)
+	if status != 404 {
+		t.Fatalf("requesting calendar feed with old token, want status 404, got %d", status)
+	}
+}
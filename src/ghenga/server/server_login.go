@@ -1,9 +1,13 @@
 package server
 
 import (
+	"database/sql"
 	"errors"
+	"fmt"
 	"ghenga/db"
 	"net/http"
+	"net/url"
+	"strings"
 	"time"
 
 	"golang.org/x/net/context"
@@ -17,9 +21,41 @@ type LoginResponseJSON struct {
 	Token    string `json:"token"`
 	ValidFor uint   `json:"valid_for"`
 	Admin    bool   `json:"admin"`
+
+	Roles       []string `json:"roles"`
+	Permissions []string `json:"permissions"`
+}
+
+// newLoginResponse returns the response for a login request or a session
+// check of the user u.
+func newLoginResponse(env *Env, u *db.User, token string, validFor time.Duration) (LoginResponseJSON, error) {
+	perms, err := env.DB.UserPermissions(u)
+	if err != nil {
+		return LoginResponseJSON{}, err
+	}
+
+	res := LoginResponseJSON{
+		User:        u.Login,
+		Token:       token,
+		ValidFor:    uint(validFor / time.Second),
+		Admin:       u.Admin,
+		Roles:       u.Roles,
+		Permissions: perms,
+	}
+
+	if res.Roles == nil {
+		res.Roles = []string{}
+	}
+
+	if res.Permissions == nil {
+		res.Permissions = []string{}
+	}
+
+	return res, nil
 }
 
-// Login allows users to log in and returns a token.
+// Login allows users to log in with the HTTP basic auth and returns a token.
+// The login and the password are checked by the configured Authenticator.
 func Login(ctx context.Context, env *Env, res http.ResponseWriter, req *http.Request) error {
 	username, password, ok := req.BasicAuth()
 	if !ok {
@@ -31,36 +67,214 @@ func Login(ctx context.Context, env *Env, res http.ResponseWriter, req *http.Req
 
 	env.Debugf("login attempt for user %v", username)
 
-	u, err := env.DB.FindUserName(username)
+	u, err := env.authenticator().Authenticate(env, username, password)
+	if err == ErrInvalidLogin {
+		e := newAuditEntry(req, db.AuditLoginFailed, db.AuditSession)
+		e.Login = username
+		audit(env, e)
+
+		return StatusError{
+			Code: http.StatusUnauthorized,
+			Err:  ErrInvalidLogin,
+		}
+	}
+
 	if err != nil {
-		env.Debugf("error finding user %q in database: %v", username, err)
+		return err
+	}
+
+	session, err := env.DB.SaveNewSession(u.Login, env.Cfg.SessionDuration)
+	if err != nil {
+		return err
+	}
+
+	e := newAuditEntry(req, db.AuditLogin, db.AuditSession)
+	e.UserID = sql.NullInt64{Int64: u.ID, Valid: true}
+	e.Login = u.Login
+	audit(env, e)
+
+	lr, err := newLoginResponse(env, u, session.Token, env.Cfg.SessionDuration)
+	if err != nil {
+		return err
 	}
 
-	if err != nil || !u.CheckPassword(password) {
+	return httpWriteJSON(res, http.StatusOK, lr)
+}
+
+// oidcLoginDuration is the time a user has for logging in with the OpenID
+// Connect provider.
+const oidcLoginDuration = 10 * time.Minute
+
+// localPath returns true if path is a path on this server, so that the
+// browser can safely be redirected to it.
+func localPath(path string) bool {
+	return strings.HasPrefix(path, "/") && !strings.HasPrefix(path, "//") && !strings.HasPrefix(path, "/\\")
+}
+
+// OIDCLogin starts the login with the OpenID Connect provider by redirecting
+// the browser to it. The query parameter redirect may contain a local path
+// the browser is sent to after the login.
+func (p *oidcProvider) OIDCLogin(ctx context.Context, env *Env, res http.ResponseWriter, req *http.Request) error {
+	redirect := req.URL.Query().Get("redirect")
+	if redirect != "" && !localPath(redirect) {
+		return StatusError{
+			Code: http.StatusBadRequest,
+			Err:  errors.New("invalid redirect"),
+		}
+	}
+
+	l, err := db.NewOIDCLogin(redirect, oidcLoginDuration)
+	if err != nil {
+		return err
+	}
+
+	u, err := p.authURL(l.State, l.Nonce, l.Verifier)
+	if err != nil {
+		env.Logf("unable to reach OpenID Connect provider: %v", err)
+		return StatusError{
+			Code: http.StatusBadGateway,
+			Err:  errors.New("identity provider not available"),
+		}
+	}
+
+	if err = env.DB.SaveOIDCLogin(l); err != nil {
+		return err
+	}
+
+	http.Redirect(res, req, u, http.StatusFound)
+	return nil
+}
+
+// OIDCCallback finishes the login with the OpenID Connect provider. The
+// authorization code is exchanged for an ID token, the user is looked up (or
+// created) by the claims of the token and a new session is returned like with
+// Login. If a redirect was requested, the browser is sent there with the
+// token in the fragment of the URL instead.
+func (p *oidcProvider) OIDCCallback(ctx context.Context, env *Env, res http.ResponseWriter, req *http.Request) error {
+	query := req.URL.Query()
+
+	l, err := env.DB.TakeOIDCLogin(query.Get("state"))
+	if err != nil || l.ValidUntil.Before(time.Now()) {
 		return StatusError{
 			Code: http.StatusUnauthorized,
-			Err:  errors.New("invalid username or password"),
+			Err:  errors.New("invalid or expired login"),
 		}
 	}
 
-	session, err := env.DB.SaveNewSession(username, env.Cfg.SessionDuration)
+	if e := query.Get("error"); e != "" {
+		env.Debugf("OpenID Connect login failed: %v %v", e, query.Get("error_description"))
+		return StatusError{
+			Code: http.StatusUnauthorized,
+			Err:  errors.New("login was denied by the identity provider"),
+		}
+	}
+
+	fail := func(err error) error {
+		env.Logf("OpenID Connect login failed: %v", err)
+
+		e := newAuditEntry(req, db.AuditLoginFailed, db.AuditSession)
+		audit(env, e)
+
+		return StatusError{
+			Code: http.StatusUnauthorized,
+			Err:  errors.New("login with the identity provider failed"),
+		}
+	}
+
+	idToken, err := p.exchange(query.Get("code"), l.Verifier)
+	if err != nil {
+		return fail(err)
+	}
+
+	claims, err := p.verify(idToken, l.Nonce, time.Now())
+	if err != nil {
+		return fail(err)
+	}
+
+	u, err := p.user(env, claims)
+	if err != nil {
+		return fail(err)
+	}
+
+	session, err := env.DB.SaveNewSession(u.Login, env.Cfg.SessionDuration)
+	if err != nil {
+		return err
+	}
+
+	e := newAuditEntry(req, db.AuditLogin, db.AuditSession)
+	e.UserID = sql.NullInt64{Int64: u.ID, Valid: true}
+	e.Login = u.Login
+	audit(env, e)
+
+	if l.Redirect != "" {
+		http.Redirect(res, req, l.Redirect+"#token="+url.QueryEscape(session.Token), http.StatusFound)
+		return nil
+	}
+
+	lr, err := newLoginResponse(env, u, session.Token, env.Cfg.SessionDuration)
 	if err != nil {
 		return err
 	}
 
-	return httpWriteJSON(res, http.StatusOK, LoginResponseJSON{
-		User:     u.Login,
-		Token:    session.Token,
-		ValidFor: uint(env.Cfg.SessionDuration / time.Second),
-		Admin:    u.Admin,
-	})
+	return httpWriteJSON(res, http.StatusOK, lr)
+}
+
+// user returns the user for the claims of an ID token. The user is found by
+// the subject, the login is only used for users who log in for the first
+// time. Unknown users are created if auto-provisioning is enabled. If an
+// admin group is configured, the role admin is granted or removed according
+// to the groups of the user.
+func (p *oidcProvider) user(env *Env, claims oidcClaims) (*db.User, error) {
+	subject := claims.String("sub")
+	if subject == "" {
+		return nil, errors.New("claim sub not found in ID token")
+	}
+
+	login := claims.String(p.cfg.LoginClaim)
+	if login == "" {
+		return nil, fmt.Errorf("claim %v not found in ID token", p.cfg.LoginClaim)
+	}
+
+	l := externalLogin{
+		Login:   login,
+		Create:  p.cfg.AutoProvision,
+		Roles:   p.cfg.DefaultRoles,
+		Issuer:  p.cfg.Issuer,
+		Subject: subject,
+		Link:    p.cfg.LinkUsers,
+	}
+
+	if p.cfg.AdminGroup != "" {
+		admin := contains(claims.Strings(p.cfg.GroupsClaim), p.cfg.AdminGroup)
+		l.Admin = &admin
+	}
+
+	return l.user(env)
 }
 
 const authHeaderName = "X-Auth-Token"
 
+// requestToken returns the token from the header X-Auth-Token or, if that is
+// not set, from the header `Authorization: Bearer`.
+func requestToken(req *http.Request) string {
+	if token := req.Header.Get(authHeaderName); token != "" {
+		return token
+	}
+
+	auth := req.Header.Get("Authorization")
+	if len(auth) > 7 && strings.EqualFold(auth[:7], "Bearer ") {
+		return strings.TrimSpace(auth[7:])
+	}
+
+	return ""
+}
+
 // findSession returns a session for the request or an error if none is found.
+// Requests authenticated with a personal API token get a session which is not
+// saved in the database. Sessions of read-only tokens may only be used for
+// requests which do not change anything.
 func findSession(env *Env, req *http.Request) (*db.Session, error) {
-	token := req.Header.Get(authHeaderName)
+	token := requestToken(req)
 	if token == "" {
 		return nil, StatusError{
 			Code: http.StatusUnauthorized,
@@ -68,6 +282,10 @@ func findSession(env *Env, req *http.Request) (*db.Session, error) {
 		}
 	}
 
+	if db.IsAPIToken(token) {
+		return findAPITokenSession(env, req, token)
+	}
+
 	session, err := env.DB.FindSession(token)
 	if err != nil {
 		env.Logf("error finding session with token %q in database: %v", token, err)
@@ -83,6 +301,65 @@ func findSession(env *Env, req *http.Request) (*db.Session, error) {
 	return session, nil
 }
 
+// findAPITokenSession returns a session for the personal API token and
+// records that the token has been used.
+func findAPITokenSession(env *Env, req *http.Request, token string) (*db.Session, error) {
+	t, err := env.DB.FindAPIToken(token)
+	if err != nil {
+		env.Debugf("error finding API token in database: %v", err)
+	}
+
+	if err != nil || t.Expired(time.Now()) {
+		return nil, StatusError{
+			Code: http.StatusUnauthorized,
+			Err:  errors.New("invalid API token"),
+		}
+	}
+
+	if t.ReadOnly && !readOnlyMethod(req.Method) {
+		return nil, StatusError{
+			Code: http.StatusForbidden,
+			Err:  errors.New("API token is read-only"),
+		}
+	}
+
+	u, err := env.DB.FindUser(t.UserID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = env.DB.TouchAPIToken(t.ID); err != nil {
+		return nil, err
+	}
+
+	// a token without expiry is valid as long as a regular session, it is
+	// checked again with the next request
+	validUntil := time.Now().Add(env.Cfg.SessionDuration)
+	if t.ExpiresAt.Valid {
+		validUntil = t.ExpiresAt.Time
+	}
+
+	session := &db.Session{
+		Token:      token,
+		User:       u.Login,
+		ValidUntil: validUntil,
+		APITokenID: t.ID,
+		ReadOnly:   t.ReadOnly,
+	}
+
+	return session, nil
+}
+
+// readOnlyMethod returns true for HTTP methods which do not change anything.
+func readOnlyMethod(method string) bool {
+	switch method {
+	case "GET", "HEAD", "OPTIONS":
+		return true
+	}
+
+	return false
+}
+
 // Info allows users to check whether a token is still valid and find the
 // current username.
 func Info(ctx context.Context, env *Env, res http.ResponseWriter, req *http.Request) error {
@@ -96,22 +373,41 @@ func Info(ctx context.Context, env *Env, res http.ResponseWriter, req *http.Requ
 		return err
 	}
 
-	return httpWriteJSON(res, http.StatusOK, LoginResponseJSON{
-		User:     session.User,
-		Token:    session.Token,
-		ValidFor: uint(session.ValidUntil.Sub(time.Now()) / time.Second),
-		Admin:    u.Admin,
-	})
+	lr, err := newLoginResponse(env, u, session.Token, session.ValidUntil.Sub(time.Now()))
+	if err != nil {
+		return err
+	}
+
+	return httpWriteJSON(res, http.StatusOK, lr)
 }
 
-// Invalidate deletes a valid session token.
+// Invalidate deletes a valid session token. Personal API tokens are revoked
+// with RevokeAPIToken instead.
 func Invalidate(ctx context.Context, env *Env, res http.ResponseWriter, req *http.Request) error {
 	session, err := findSession(env, req)
 	if err != nil {
 		return err
 	}
 
-	return env.DB.Invalidate(session)
+	if session.APITokenID != 0 {
+		return StatusError{
+			Code: http.StatusBadRequest,
+			Err:  errors.New("API tokens cannot be invalidated, revoke the token instead"),
+		}
+	}
+
+	if err = env.DB.Invalidate(session); err != nil {
+		return err
+	}
+
+	e := newAuditEntry(req, db.AuditLogout, db.AuditSession)
+	e.Login = session.User
+	if u, err := env.DB.FindUserName(session.User); err == nil {
+		e.UserID = sql.NullInt64{Int64: u.ID, Valid: true}
+	}
+	audit(env, e)
+
+	return nil
 }
 
 // LoginHandler adds routes to the for ghenga API in the given enviroment to r.
@@ -119,4 +415,10 @@ func LoginHandler(ctx context.Context, env *Env, r *mux.Router) {
 	r.Handle("/api/login/token", Handle(ctx, env, Login)).Methods("GET")
 	r.Handle("/api/login/info", Handle(ctx, env, Info)).Methods("GET")
 	r.Handle("/api/login/invalidate", Handle(ctx, env, Invalidate)).Methods("GET")
+
+	if env.Cfg.OIDC != nil {
+		p := newOIDCProvider(*env.Cfg.OIDC)
+		r.Handle("/api/login/oidc", Handle(ctx, env, p.OIDCLogin)).Methods("GET")
+		r.Handle("/api/login/oidc/callback", Handle(ctx, env, p.OIDCCallback)).Methods("GET")
+	}
 }
@@ -3,7 +3,12 @@ package server
 import (
 	"io/ioutil"
 	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
 	"testing"
+
+	"golang.org/x/net/context"
 )
 
 func loginRequest(t *testing.T, srv *TestSrv, username, password string) (status int, body []byte) {
@@ -127,3 +132,228 @@ func TestInfo(t *testing.T) {
 		t.Fatalf("invalid response for check request: %v", response)
 	}
 }
+
+// oidcTestServer returns a test server with the login via idp enabled. The
+// configuration can be changed with fn.
+func oidcTestServer(t *testing.T, idp *testIdP, fn func(*OIDCConfig)) (srv *TestSrv, cleanup func()) {
+	env, envcleanup := TestEnv(t)
+	ctx, cancel := context.WithCancel(context.TODO())
+
+	// the redirect URL contains the address of the server, so the router is
+	// created after the server has been started
+	var router http.Handler
+	srv = &TestSrv{
+		Server: httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+			router.ServeHTTP(res, req)
+		})),
+		Env: env,
+	}
+
+	cfg := idp.config(srv.URL + "/api/login/oidc/callback")
+	if fn != nil {
+		fn(&cfg)
+	}
+	env.Cfg.OIDC = &cfg
+	router = NewRouter(ctx, env)
+
+	return srv, func() {
+		srv.Close()
+		envcleanup()
+		cancel()
+	}
+}
+
+var noRedirectClient = &http.Client{
+	CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	},
+}
+
+// redirect requests url and returns the location the response redirects to.
+func redirect(t *testing.T, url string) string {
+	res, err := noRedirectClient.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	status, body := readBody(t, res)
+	if status != http.StatusFound {
+		t.Fatalf("GET %v: want redirect, got status %v: %s", url, status, body)
+	}
+
+	return res.Header.Get("Location")
+}
+
+// oidcLogin logs in at srv with the idp, which returns an ID token with the
+// claims. It returns the callback URL and the response of the callback.
+func oidcLogin(t *testing.T, srv *TestSrv, idp *testIdP, path string, claims map[string]interface{}) (string, *http.Response, []byte) {
+	idp.mu.Lock()
+	idp.claims = claims
+	idp.mu.Unlock()
+
+	auth := redirect(t, srv.URL+path)
+	if !strings.HasPrefix(auth, idp.URL+"/authorize?") {
+		t.Fatalf("redirected to %v instead of the provider", auth)
+	}
+
+	callback := redirect(t, auth)
+
+	res, err := noRedirectClient.Get(callback)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, body := readBody(t, res)
+	return callback, res, body
+}
+
+func TestOIDCLogin(t *testing.T) {
+	idp := newTestIdP(t)
+	defer idp.Close()
+
+	// the local users are bound to the provider on their first login
+	srv, cleanup := oidcTestServer(t, idp, func(cfg *OIDCConfig) {
+		cfg.LinkUsers = true
+	})
+	defer cleanup()
+
+	callback, res, body := oidcLogin(t, srv, idp, "/api/login/oidc", map[string]interface{}{"preferred_username": "user"})
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("login failed with status %v: %s", res.StatusCode, body)
+	}
+
+	var lr LoginResponseJSON
+	unmarshal(t, body, &lr)
+	if lr.User != "user" || lr.Token == "" || lr.Admin {
+		t.Fatalf("wrong login response: %s", body)
+	}
+
+	if status, body := request(t, lr.Token, "GET", srv.URL+"/api/person/1", nil); status != http.StatusOK {
+		t.Errorf("session from login not accepted, status %v: %s", status, body)
+	}
+
+	// the state can only be used once
+	res, err := noRedirectClient.Get(callback)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status, body := readBody(t, res); status != http.StatusUnauthorized {
+		t.Errorf("login state was accepted again, status %v: %s", status, body)
+	}
+
+	_, res, body = oidcLogin(t, srv, idp, "/api/login/oidc", map[string]interface{}{"preferred_username": "nobody"})
+	if res.StatusCode != http.StatusUnauthorized {
+		t.Errorf("unknown user could log in, status %v: %s", res.StatusCode, body)
+	}
+
+	_, res, body = oidcLogin(t, srv, idp, "/api/login/oidc?redirect=/app/", map[string]interface{}{"preferred_username": "admin"})
+	location := res.Header.Get("Location")
+	if res.StatusCode != http.StatusFound || !strings.HasPrefix(location, "/app/#token=") {
+		t.Fatalf("no redirect after login, status %v, location %q: %s", res.StatusCode, location, body)
+	}
+
+	token := strings.TrimPrefix(location, "/app/#token=")
+	if status, body := request(t, token, "GET", srv.URL+"/api/user", nil); status != http.StatusOK {
+		t.Errorf("session from redirect not accepted, status %v: %s", status, body)
+	}
+
+	res, err = noRedirectClient.Get(srv.URL + "/api/login/oidc?redirect=//evil.example.com/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status, body := readBody(t, res); status != http.StatusBadRequest {
+		t.Errorf("redirect to another host was accepted, status %v: %s", status, body)
+	}
+}
+
+func TestOIDCLoginProvisioning(t *testing.T) {
+	idp := newTestIdP(t)
+	defer idp.Close()
+
+	srv, cleanup := oidcTestServer(t, idp, func(cfg *OIDCConfig) {
+		cfg.AutoProvision = true
+		cfg.DefaultRoles = []string{"viewer"}
+		cfg.AdminGroup = "ghenga-admins"
+		cfg.LinkUsers = true
+	})
+	defer cleanup()
+
+	for _, test := range []struct {
+		login  string
+		groups []string
+		admin  bool
+		roles  []string
+	}{
+		{"nora", nil, false, []string{"viewer"}},
+		{"alex", []string{"staff", "ghenga-admins"}, true, []string{"viewer", "admin"}},
+		{"alex", []string{"staff"}, false, []string{"viewer"}},
+		{"user", []string{"ghenga-admins"}, true, []string{"manager", "admin"}},
+	} {
+		claims := map[string]interface{}{"preferred_username": test.login}
+		if test.groups != nil {
+			claims["groups"] = test.groups
+		}
+
+		_, res, body := oidcLogin(t, srv, idp, "/api/login/oidc", claims)
+		if res.StatusCode != http.StatusOK {
+			t.Fatalf("%v: login failed with status %v: %s", test.login, res.StatusCode, body)
+		}
+
+		var lr LoginResponseJSON
+		unmarshal(t, body, &lr)
+		if lr.User != test.login || lr.Admin != test.admin || !reflect.DeepEqual(lr.Roles, test.roles) {
+			t.Errorf("%v: wrong login response: %s", test.login, body)
+		}
+	}
+
+	// users created for the provider cannot log in with a password
+	if status, body := loginRequest(t, srv, "nora", ""); status != http.StatusUnauthorized {
+		t.Errorf("provisioned user logged in without password, status %v: %s", status, body)
+	}
+}
+
+func TestOIDCLoginSubject(t *testing.T) {
+	idp := newTestIdP(t)
+	defer idp.Close()
+
+	srv, cleanup := oidcTestServer(t, idp, func(cfg *OIDCConfig) {
+		cfg.AutoProvision = true
+		cfg.DefaultRoles = []string{"viewer"}
+	})
+	defer cleanup()
+
+	for _, test := range []struct {
+		subject, login string
+		status         int
+		user           string
+	}{
+		// existing local users are not bound without LinkUsers
+		{"sub-user", "user", http.StatusUnauthorized, ""},
+		{"sub-nora", "nora", http.StatusOK, "nora"},
+		// the user is found by the subject after the login was changed
+		{"sub-nora", "nora.new", http.StatusOK, "nora"},
+		// another subject cannot take over the user by choosing the login
+		{"sub-mallory", "nora", http.StatusUnauthorized, ""},
+	} {
+		claims := map[string]interface{}{"sub": test.subject, "preferred_username": test.login}
+		_, res, body := oidcLogin(t, srv, idp, "/api/login/oidc", claims)
+		if res.StatusCode != test.status {
+			t.Errorf("%v/%v: want status %v, got %v: %s", test.subject, test.login, test.status, res.StatusCode, body)
+			continue
+		}
+
+		if test.status != http.StatusOK {
+			continue
+		}
+
+		var lr LoginResponseJSON
+		unmarshal(t, body, &lr)
+		if lr.User != test.user {
+			t.Errorf("%v/%v: want user %v, got %s", test.subject, test.login, test.user, body)
+		}
+	}
+
+	if _, err := srv.DB.FindUserName("nora.new"); err == nil {
+		t.Errorf("user created for a changed login")
+	}
+}
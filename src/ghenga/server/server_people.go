@@ -3,6 +3,7 @@ package server
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
 	"ghenga/db"
 	"net/http"
 	"strconv"
@@ -13,13 +14,124 @@ import (
 	"github.com/gorilla/mux"
 )
 
-// ListPeople handles listing person records.
+// visibleDB returns the database restricted to the people the current user
+// may see, see db.VisibleTo.
+func visibleDB(ctx context.Context, env *Env) (*db.DB, error) {
+	u, err := currentUser(ctx, env)
+	if err != nil {
+		return nil, err
+	}
+
+	return env.DB.VisibleTo(u), nil
+}
+
+// findPerson returns the person with the given ID. People which the current
+// user may not see are reported as not found.
+func findPerson(ctx context.Context, env *Env, id int64) (*db.Person, error) {
+	vdb, err := visibleDB(ctx, env)
+	if err != nil {
+		return nil, err
+	}
+
+	p, err := vdb.FindPerson(id)
+	if err != nil {
+		return nil, StatusError{
+			Err:  errors.New("person not found"),
+			Code: http.StatusNotFound,
+		}
+	}
+
+	return p, nil
+}
+
+// RequireVisiblePerson ensures that only requests for a person (with the ID
+// from the URL) which the current user may see are passed to h, otherwise 404
+// is returned. Admins may also access deleted people, e.g. their revision
+// history. RequireVisiblePerson must be wrapped by RequirePermission.
+func RequireVisiblePerson(h HandleFunc) HandleFunc {
+	return func(ctx context.Context, env *Env, res http.ResponseWriter, req *http.Request) error {
+		id, err := strconv.Atoi(mux.Vars(req)["id"])
+		if err != nil {
+			return StatusError{Code: http.StatusBadRequest, Err: err}
+		}
+
+		u, err := currentUser(ctx, env)
+		if err != nil {
+			return err
+		}
+
+		if !u.Admin {
+			if _, err = findPerson(ctx, env, int64(id)); err != nil {
+				return err
+			}
+		}
+
+		return h(ctx, env, res, req)
+	}
+}
+
+// checkOwner returns an error if the current user may not set the owner and
+// the visibility of p. Only admins may assign a new person to another user,
+// and only the owner of a person (or an admin) may change the owner or the
+// visibility. old is the person before the change, it is nil for new people.
+func checkOwner(ctx context.Context, env *Env, p *db.Person, old *db.Person) error {
+	u, err := currentUser(ctx, env)
+	if err != nil {
+		return err
+	}
+
+	if u.Admin {
+		return nil
+	}
+
+	if old == nil {
+		if p.OwnerID.Valid && p.OwnerID.Int64 != u.ID {
+			return StatusError{
+				Code: http.StatusForbidden,
+				Err:  errors.New("only admins may create people for other users"),
+			}
+		}
+
+		return nil
+	}
+
+	if p.OwnerID == old.OwnerID && p.Visibility == old.Visibility {
+		return nil
+	}
+
+	if !old.OwnerID.Valid || old.OwnerID.Int64 != u.ID {
+		return StatusError{
+			Code: http.StatusForbidden,
+			Err:  errors.New("only the owner may change the owner or the visibility"),
+		}
+	}
+
+	return nil
+}
+
+// ListPeople handles listing person records. The list is paginated, sorted
+// and filtered according to the query parameters, see parseListOptions.
 func ListPeople(ctx context.Context, env *Env, res http.ResponseWriter, req *http.Request) error {
-	people, err := env.DB.ListPeople()
+	opts, err := parseListOptions(req)
+	if err != nil {
+		return err
+	}
+
+	if err = filterCurrentUser(ctx, env, opts); err != nil {
+		return err
+	}
+
+	vdb, err := visibleDB(ctx, env)
 	if err != nil {
 		return err
 	}
 
+	people, page, err := vdb.ListPeoplePage(opts)
+	if err != nil {
+		return listError(err)
+	}
+
+	writePage(res, page)
 	return httpWriteJSON(res, http.StatusOK, people)
 }
 
@@ -30,12 +142,13 @@ func ShowPerson(ctx context.Context, env *Env, res http.ResponseWriter, req *htt
 		return StatusError{Code: http.StatusBadRequest, Err: err}
 	}
 
-	person, err := env.DB.FindPerson(int64(id))
+	person, err := findPerson(ctx, env, int64(id))
 	if err != nil {
-		return StatusError{
-			Err:  errors.New("person not found"),
-			Code: http.StatusNotFound,
-		}
+		return err
+	}
+
+	if notModified(res, req, personETag(person)) {
+		return nil
 	}
 
 	return httpWriteJSON(res, http.StatusOK, person)
@@ -62,6 +175,18 @@ func CreatePerson(ctx context.Context, env *Env, wr http.ResponseWriter, req *ht
 		return StatusError{Code: http.StatusBadRequest, Err: err}
 	}
 
+	if err = checkAccount(env, &p); err != nil {
+		return err
+	}
+
+	if err = checkOwner(ctx, env, &p, nil); err != nil {
+		return err
+	}
+
+	if p.ChangedBy, err = changedBy(ctx, env); err != nil {
+		return err
+	}
+
 	err = env.DB.InsertPerson(&p)
 	if err != nil {
 		return err
@@ -69,10 +194,24 @@ func CreatePerson(ctx context.Context, env *Env, wr http.ResponseWriter, req *ht
 
 	env.Debugf("created person %v", p)
 
+	wr.Header().Set("ETag", personETag(&p))
 	return httpWriteJSON(wr, http.StatusCreated, p)
 }
 
+// autoMergeJSON is the request body for an update with `merge=auto`. It
+// contains the original version of the person the client started from and
+// the changed version.
+type autoMergeJSON struct {
+	Original *db.PersonJSON `json:"original"`
+	Update   db.PersonJSON  `json:"update"`
+}
+
 // UpdatePerson changes an existing person record. The request body must be valid JSON.
+//
+// If the person was changed in the meantime, a ConflictError with the current
+// person is returned. With the query parameter `merge=auto`, the body must
+// contain the original version and the changed version of the person, and
+// the changes are merged with the current person if they do not overlap.
 func UpdatePerson(ctx context.Context, env *Env, wr http.ResponseWriter, req *http.Request) (err error) {
 	defer cleanupErr(&err, req.Body.Close)
 
@@ -81,27 +220,112 @@ func UpdatePerson(ctx context.Context, env *Env, wr http.ResponseWriter, req *ht
 		return StatusError{Code: http.StatusBadRequest, Err: err}
 	}
 
-	var newPerson db.PersonJSON
+	autoMerge, err := parseMergeMode(req)
+	if err != nil {
+		return err
+	}
+
+	var (
+		newPerson db.PersonJSON
+		original  *db.PersonJSON
+	)
+
 	dec := json.NewDecoder(req.Body)
-	if err = dec.Decode(&newPerson); err != nil {
+	if autoMerge {
+		var body autoMergeJSON
+		if err = dec.Decode(&body); err != nil {
+			return err
+		}
+
+		if body.Original == nil {
+			return StatusError{
+				Code: http.StatusBadRequest,
+				Err:  errors.New("original version is required for merge=auto"),
+			}
+		}
+
+		newPerson, original = body.Update, body.Original
+	} else if err = dec.Decode(&newPerson); err != nil {
 		return err
 	}
 
-	p, err := env.DB.FindPerson(int64(id))
+	p, err := findPerson(ctx, env, int64(id))
 	if err != nil {
-		env.Logf("unable to find person ID %v, error: %v", id, err)
 		return err
 	}
 
+	if err = checkIfMatch(req, personETag(p)); err != nil {
+		return err
+	}
+
+	// with If-Match, the version field may be omitted
+	if newPerson.Version == 0 && hasIfMatch(req) {
+		newPerson.Version = p.Version
+	}
+
 	if p.Version != newPerson.Version {
 		env.Debugf("person record is outdated, version %v != %v",
 			p.Version, newPerson.Version)
-		return StatusError{
-			Err:  errors.New("version field does not match"),
-			Code: http.StatusConflict,
+
+		if original == nil {
+			return personConflict(env, p, newPerson)
+		}
+
+		if newPerson, err = mergePerson(p, *original, newPerson); err != nil {
+			return err
 		}
 	}
 
+	return updatePerson(ctx, env, wr, req, p, newPerson)
+}
+
+// PatchPerson changes an existing person record with the JSON merge patch
+// (RFC 7396) in the request body. Only the fields contained in the patch are
+// changed, fields set to null are cleared. The patch must contain the current
+// version, unless the request contains an If-Match header.
+func PatchPerson(ctx context.Context, env *Env, wr http.ResponseWriter, req *http.Request) (err error) {
+	defer cleanupErr(&err, req.Body.Close)
+
+	id, err := strconv.Atoi(mux.Vars(req)["id"])
+	if err != nil {
+		return StatusError{Code: http.StatusBadRequest, Err: err}
+	}
+
+	patch, err := readMergePatch(req)
+	if err != nil {
+		return err
+	}
+
+	p, err := findPerson(ctx, env, int64(id))
+	if err != nil {
+		return err
+	}
+
+	if err = checkIfMatch(req, personETag(p)); err != nil {
+		return err
+	}
+
+	err = checkPatchVersion(req, patch, p.Version)
+	if err == errVersionMismatch {
+		return patchConflict(env, db.RevisionPerson, p.ID, p, patch)
+	}
+	if err != nil {
+		return err
+	}
+
+	var newPerson db.PersonJSON
+	if err = applyMergePatch(p, patch, &newPerson); err != nil {
+		return err
+	}
+
+	return updatePerson(ctx, env, wr, req, p, newPerson)
+}
+
+// updatePerson replaces the fields of p with newPerson, validates and saves
+// it and writes the updated record to wr.
+func updatePerson(ctx context.Context, env *Env, wr http.ResponseWriter, req *http.Request, p *db.Person, newPerson db.PersonJSON) (err error) {
+	old := *p
+
 	// update all fields except
 	p.Update(newPerson)
 
@@ -111,34 +335,176 @@ func UpdatePerson(ctx context.Context, env *Env, wr http.ResponseWriter, req *ht
 		return StatusError{Code: http.StatusBadRequest, Err: err}
 	}
 
+	if err = checkAccount(env, p); err != nil {
+		return err
+	}
+
+	if err = checkOwner(ctx, env, p, &old); err != nil {
+		return err
+	}
+
+	if p.ChangedBy, err = changedBy(ctx, env); err != nil {
+		return err
+	}
+
 	err = env.DB.UpdatePerson(p)
 	if err != nil {
 		env.Logf("unable update person %v, sql error: %v", p, err)
-		return err
+		return versionError(req, err)
 	}
 
+	wr.Header().Set("ETag", personETag(p))
 	return httpWriteJSON(wr, http.StatusOK, p)
 }
 
-// DeletePerson removes a person from the database.
+// DeletePerson removes a person from the database. If the request contains an
+// If-Match header, the person is only removed if it matches the current
+// version.
 func DeletePerson(ctx context.Context, env *Env, wr http.ResponseWriter, req *http.Request) (err error) {
 	id, err := strconv.Atoi(mux.Vars(req)["id"])
 	if err != nil {
 		return StatusError{Code: http.StatusBadRequest, Err: err}
 	}
 
-	if err := env.DB.DeletePerson(int64(id)); err != nil {
+	changedBy, err := changedBy(ctx, env)
+	if err != nil {
+		return err
+	}
+
+	p, err := findPerson(ctx, env, int64(id))
+	if err != nil {
+		return err
+	}
+
+	if !hasIfMatch(req) {
+		if err := env.DB.DeletePerson(p.ID, changedBy); err != nil {
+			return err
+		}
+
+		return httpWriteJSON(wr, http.StatusOK, nil)
+	}
+
+	if err = checkIfMatch(req, personETag(p)); err != nil {
 		return err
 	}
 
+	if err = env.DB.DeletePersonVersion(p.ID, p.Version, changedBy); err != nil {
+		return versionError(req, err)
+	}
+
 	return httpWriteJSON(wr, http.StatusOK, nil)
 }
 
+// defaultDuplicateScore is the minimal score for duplicate candidates if the
+// client does not specify one.
+const defaultDuplicateScore = 0.5
+
+// ListDuplicatePeople returns pairs of people which are probably duplicates.
+// The minimal score of the pairs can be set with the query parameter
+// `min_score`.
+func ListDuplicatePeople(ctx context.Context, env *Env, res http.ResponseWriter, req *http.Request) error {
+	minScore := defaultDuplicateScore
+	if s := req.URL.Query().Get("min_score"); s != "" {
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil || f < 0 || f > 1 {
+			return StatusError{
+				Code: http.StatusBadRequest,
+				Err:  errors.New("min_score must be a number between 0 and 1"),
+			}
+		}
+		minScore = f
+	}
+
+	vdb, err := visibleDB(ctx, env)
+	if err != nil {
+		return err
+	}
+
+	pairs, err := vdb.FindDuplicatePeople(minScore)
+	if err != nil {
+		return err
+	}
+
+	return httpWriteJSON(res, http.StatusOK, pairs)
+}
+
+// MergeJSON is the request to merge two people.
+type MergeJSON struct {
+	Keep   int64    `json:"keep"`
+	Remove int64    `json:"remove"`
+	Prefer []string `json:"prefer"`
+}
+
+// mergeFields are the field names which may be contained in MergeJSON.Prefer.
+var mergeFields = map[string]bool{
+	db.MergeName:       true,
+	db.MergeTitle:      true,
+	db.MergeDepartment: true,
+	db.MergeAddress:    true,
+	db.MergeComment:    true,
+	db.MergeAccount:    true,
+}
+
+// MergePeople merges two people, the person `remove` is deleted afterwards.
+// The request body must be valid JSON.
+func MergePeople(ctx context.Context, env *Env, wr http.ResponseWriter, req *http.Request) (err error) {
+	defer cleanupErr(&err, req.Body.Close)
+
+	var m MergeJSON
+	dec := json.NewDecoder(req.Body)
+	if err = dec.Decode(&m); err != nil {
+		return StatusError{Code: http.StatusBadRequest, Err: err}
+	}
+
+	if m.Keep == m.Remove {
+		return StatusError{
+			Code: http.StatusBadRequest,
+			Err:  errors.New("keep and remove must be different people"),
+		}
+	}
+
+	for _, f := range m.Prefer {
+		if !mergeFields[f] {
+			return StatusError{
+				Code: http.StatusBadRequest,
+				Err:  fmt.Errorf("unknown field %q", f),
+			}
+		}
+	}
+
+	for _, id := range []int64{m.Keep, m.Remove} {
+		if _, err = findPerson(ctx, env, id); err != nil {
+			return StatusError{
+				Err:  fmt.Errorf("person %d not found", id),
+				Code: http.StatusNotFound,
+			}
+		}
+	}
+
+	changedBy, err := changedBy(ctx, env)
+	if err != nil {
+		return err
+	}
+
+	p, err := env.DB.MergePeople(m.Keep, m.Remove, m.Prefer, changedBy)
+	if err != nil {
+		env.Logf("unable to merge person %v into %v: %v", m.Remove, m.Keep, err)
+		return err
+	}
+
+	env.Debugf("merged person %v into %v", m.Remove, p)
+
+	return httpWriteJSON(wr, http.StatusOK, p)
+}
+
 // PeopleHandler adds routes for ghenga API in the given enviroment to r.
 func PeopleHandler(ctx context.Context, env *Env, r *mux.Router) {
-	r.Handle("/api/person", Handle(ctx, env, RequireAuth(ListPeople))).Methods("GET")
-	r.Handle("/api/person", Handle(ctx, env, RequireAuth(CreatePerson))).Methods("POST")
-	r.Handle("/api/person/{id}", Handle(ctx, env, RequireAuth(ShowPerson))).Methods("GET")
-	r.Handle("/api/person/{id}", Handle(ctx, env, RequireAuth(UpdatePerson))).Methods("PUT")
-	r.Handle("/api/person/{id}", Handle(ctx, env, RequireAuth(DeletePerson))).Methods("DELETE")
+	r.Handle("/api/person", Handle(ctx, env, RequirePermission(db.PermPersonRead, ListPeople))).Methods("GET")
+	r.Handle("/api/person", Handle(ctx, env, RequirePermission(db.PermPersonWrite, Audit(db.ActionCreate, db.RevisionPerson, CreatePerson)))).Methods("POST")
+	r.Handle("/api/person/duplicates", Handle(ctx, env, RequirePermission(db.PermPersonRead, ListDuplicatePeople))).Methods("GET")
+	r.Handle("/api/person/merge", Handle(ctx, env, RequirePermissions([]string{db.PermPersonWrite, db.PermPersonDelete}, Audit("merge", db.RevisionPerson, MergePeople)))).Methods("POST")
+	r.Handle("/api/person/{id}", Handle(ctx, env, RequirePermission(db.PermPersonRead, ShowPerson))).Methods("GET")
+	r.Handle("/api/person/{id}", Handle(ctx, env, RequirePermission(db.PermPersonWrite, Audit(db.ActionUpdate, db.RevisionPerson, UpdatePerson)))).Methods("PUT")
+	r.Handle("/api/person/{id}", Handle(ctx, env, RequirePermission(db.PermPersonWrite, Audit(db.ActionUpdate, db.RevisionPerson, PatchPerson)))).Methods("PATCH")
+	r.Handle("/api/person/{id}", Handle(ctx, env, RequirePermission(db.PermPersonDelete, Audit(db.ActionDelete, db.RevisionPerson, DeletePerson)))).Methods("DELETE")
 }
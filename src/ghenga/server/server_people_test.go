@@ -7,7 +7,9 @@ import (
 	"io"
 	"io/ioutil"
 	"net/http"
+	"net/url"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"testing"
 )
@@ -177,10 +179,419 @@ func TestPersonList(t *testing.T) {
 	t.Logf("loaded %d person records", len(list))
 }
 
+func TestPersonListPages(t *testing.T) {
+	srv, cleanup := TestServer(t)
+	defer cleanup()
+
+	token := login(t, srv, "admin", "geheim")
+
+	seen := make(map[int]bool)
+	var lastID int
+	cursor := ""
+	for {
+		req, err := http.NewRequest("GET", srv.URL+"/api/person?limit=7&sort=-id&cursor="+url.QueryEscape(cursor), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Add(authHeaderName, token)
+
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		status, body := readBody(t, res)
+		if status != 200 {
+			t.Fatalf("listing people failed with status %d: %s", status, body)
+		}
+
+		var list []Person
+		unmarshal(t, body, &list)
+		if len(list) > 7 {
+			t.Fatalf("limit not respected, got %d people", len(list))
+		}
+
+		for _, p := range list {
+			if seen[p.ID] {
+				t.Fatalf("person %d returned twice", p.ID)
+			}
+			seen[p.ID] = true
+
+			if lastID != 0 && p.ID > lastID {
+				t.Fatalf("list is not sorted by ID: %d after %d", p.ID, lastID)
+			}
+			lastID = p.ID
+		}
+
+		total, err := strconv.Atoi(res.Header.Get(totalCountHeaderName))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		cursor = res.Header.Get(nextCursorHeaderName)
+		if cursor == "" {
+			if len(seen) != total {
+				t.Fatalf("wrong number of people returned, want %d, got %d", total, len(seen))
+			}
+			break
+		}
+	}
+}
+
+var invalidPersonListTests = []string{
+	"limit=0",
+	"sort=password",
+	"cursor=foo",
+	"created_since=foo",
+}
+
+func TestPersonListInvalid(t *testing.T) {
+	srv, cleanup := TestServer(t)
+	defer cleanup()
+
+	token := login(t, srv, "admin", "geheim")
+
+	for _, test := range invalidPersonListTests {
+		status, body := request(t, token, "GET", srv.URL+"/api/person?"+test, nil)
+		if status != http.StatusBadRequest {
+			t.Errorf("%v: want status 400, got %d: %s", test, status, body)
+		}
+	}
+
+	// parameters which are not a filter are ignored
+	for _, test := range []string{"offset=0", "_=1461493807", "comment=foo&city=K%C3%B6ln"} {
+		status, body := request(t, token, "GET", srv.URL+"/api/person?"+test, nil)
+		if status != http.StatusOK {
+			t.Errorf("%v: want status 200, got %d: %s", test, status, body)
+		}
+	}
+}
+
+func TestPersonCreatedBy(t *testing.T) {
+	srv, cleanup := TestServer(t)
+	defer cleanup()
+
+	token := login(t, srv, "admin", "geheim")
+	userToken := login(t, srv, "user", "geheim")
+
+	status, body := request(t, token, "POST", srv.URL+"/api/person", []byte(`{"name": "Created By Admin"}`))
+	if status != http.StatusCreated {
+		t.Fatalf("creating person failed with status %d: %s", status, body)
+	}
+	person := verifyPerson(t, "Created By Admin", body)
+
+	var p struct {
+		Version   int `json:"version"`
+		CreatedBy int `json:"created_by"`
+		ChangedBy int `json:"changed_by"`
+	}
+	unmarshal(t, body, &p)
+	if p.CreatedBy == 0 || p.ChangedBy != p.CreatedBy {
+		t.Fatalf("wrong users returned: %s", body)
+	}
+	adminID := p.CreatedBy
+
+	personURL := fmt.Sprintf("%s/api/person/%d", srv.URL, person.ID)
+	status, body = request(t, userToken, "PUT", personURL, []byte(fmt.Sprintf(`{"name": "Changed By User", "version": %d}`, p.Version)))
+	if status != http.StatusOK {
+		t.Fatalf("updating person failed with status %d: %s", status, body)
+	}
+
+	unmarshal(t, body, &p)
+	if p.CreatedBy != adminID || p.ChangedBy == adminID || p.ChangedBy == 0 {
+		t.Fatalf("wrong users after update: %s", body)
+	}
+
+	for _, test := range []struct {
+		token, query string
+		n            int
+	}{
+		{token, "created_by=me", 1},
+		{userToken, "created_by=me", 0},
+		{userToken, "changed_by=me", 1},
+		{token, "created_by=" + strconv.Itoa(adminID) + "&created_since=2000-01-01T00:00:00Z", 1},
+		{token, "created_by=me&created_until=2000-01-01T00:00:00Z", 0},
+	} {
+		status, body = request(t, test.token, "GET", srv.URL+"/api/person?"+test.query, nil)
+		if status != http.StatusOK {
+			t.Fatalf("%v: listing people failed with status %d: %s", test.query, status, body)
+		}
+
+		var people []Person
+		unmarshal(t, body, &people)
+		if len(people) != test.n {
+			t.Errorf("%v: want %d people, got %d: %s", test.query, test.n, len(people), body)
+		}
+	}
+}
+
+func TestPersonPatch(t *testing.T) {
+	srv, cleanup := TestServer(t)
+	defer cleanup()
+
+	token := login(t, srv, "admin", "geheim")
+
+	status, body := request(t, token, "POST", srv.URL+"/api/person", readFixture(t, "sample_person.json"))
+	if status != 201 {
+		t.Fatalf("creating person failed with status %d: %s", status, body)
+	}
+
+	person := verifyPerson(t, "Nicolai Person", body)
+	personURL := fmt.Sprintf("%s/api/person/%d", srv.URL, person.ID)
+
+	patch := fmt.Sprintf(`{"version": %d, "title": "CTO", "comment": null, "address": {"city": "Bonn"}}`, person.Version)
+	status, body = request(t, token, "PATCH", personURL, []byte(patch))
+	if status != 200 {
+		t.Fatalf("patching person failed with status %d: %s", status, body)
+	}
+
+	var p struct {
+		Name         string            `json:"name"`
+		Title        string            `json:"title"`
+		Comment      string            `json:"comment"`
+		Address      map[string]string `json:"address"`
+		PhoneNumbers []interface{}     `json:"phone_numbers"`
+		Version      int               `json:"version"`
+	}
+	unmarshal(t, body, &p)
+
+	if p.Name != "Nicolai Person" || p.Title != "CTO" || p.Comment != "" {
+		t.Errorf("person not patched correctly: %s", body)
+	}
+
+	if p.Address["city"] != "Bonn" || p.Address["street"] != "Teststraße 23" {
+		t.Errorf("address not merged correctly: %v", p.Address)
+	}
+
+	if len(p.PhoneNumbers) != 4 {
+		t.Errorf("phone numbers were changed: %v", p.PhoneNumbers)
+	}
+
+	if p.Version != person.Version+1 {
+		t.Errorf("wrong version, want %d, got %d", person.Version+1, p.Version)
+	}
+
+	// outdated version
+	status, body = request(t, token, "PATCH", personURL, []byte(patch))
+	if status != 409 {
+		t.Errorf("patch with outdated version: want status 409, got %d: %s", status, body)
+	}
+
+	// missing version
+	status, body = request(t, token, "PATCH", personURL, []byte(`{"title": "CFO"}`))
+	if status != 400 {
+		t.Errorf("patch without version: want status 400, got %d: %s", status, body)
+	}
+
+	// clearing a required field
+	status, body = request(t, token, "PATCH", personURL, []byte(fmt.Sprintf(`{"version": %d, "name": null}`, p.Version)))
+	if status != 400 {
+		t.Errorf("patch removing the name: want status 400, got %d: %s", status, body)
+	}
+
+	deletePerson(t, token, srv.URL, person.ID)
+}
+
+func requestHeader(t *testing.T, token, method, url string, header http.Header, body []byte) (*http.Response, []byte) {
+	var rd io.Reader
+	if body != nil {
+		rd = bytes.NewReader(body)
+	}
+
+	req, err := http.NewRequest(method, url, rd)
+	if err != nil {
+		t.Fatalf("NewRequest() %v", err)
+	}
+
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	req.Header.Add(authHeaderName, token)
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("%v request to %v failed: %v", method, url, err)
+	}
+
+	_, buf := readBody(t, res)
+	return res, buf
+}
+
+func TestPersonConditionalRequests(t *testing.T) {
+	srv, cleanup := TestServer(t)
+	defer cleanup()
+
+	token := login(t, srv, "admin", "geheim")
+
+	status, body := request(t, token, "POST", srv.URL+"/api/person", readFixture(t, "sample_person.json"))
+	if status != 201 {
+		t.Fatalf("creating person failed with status %d: %s", status, body)
+	}
+
+	person := verifyPerson(t, "Nicolai Person", body)
+	personURL := fmt.Sprintf("%s/api/person/%d", srv.URL, person.ID)
+
+	res, _ := requestHeader(t, token, "GET", personURL, nil, nil)
+	etag := res.Header.Get("ETag")
+	if res.StatusCode != 200 || etag == "" {
+		t.Fatalf("GET returned status %d, ETag %q", res.StatusCode, etag)
+	}
+
+	res, _ = requestHeader(t, token, "GET", personURL, http.Header{"If-None-Match": {etag}}, nil)
+	if res.StatusCode != http.StatusNotModified {
+		t.Fatalf("GET with matching If-None-Match: want status 304, got %d", res.StatusCode)
+	}
+
+	// update without version in the body
+	res, body = requestHeader(t, token, "PATCH", personURL, http.Header{"If-Match": {etag}}, []byte(`{"title": "CTO"}`))
+	if res.StatusCode != 200 {
+		t.Fatalf("PATCH with matching If-Match: want status 200, got %d: %s", res.StatusCode, body)
+	}
+
+	newETag := res.Header.Get("ETag")
+	if newETag == "" || newETag == etag {
+		t.Fatalf("ETag was not changed by update: %q", newETag)
+	}
+
+	res, _ = requestHeader(t, token, "GET", personURL, http.Header{"If-None-Match": {etag}}, nil)
+	if res.StatusCode != 200 {
+		t.Fatalf("GET with outdated If-None-Match: want status 200, got %d", res.StatusCode)
+	}
+
+	res, body = requestHeader(t, token, "PUT", personURL, http.Header{"If-Match": {etag}}, marshal(t, person))
+	if res.StatusCode != http.StatusPreconditionFailed {
+		t.Fatalf("PUT with outdated If-Match: want status 412, got %d: %s", res.StatusCode, body)
+	}
+
+	res, body = requestHeader(t, token, "DELETE", personURL, http.Header{"If-Match": {etag}}, nil)
+	if res.StatusCode != http.StatusPreconditionFailed {
+		t.Fatalf("DELETE with outdated If-Match: want status 412, got %d: %s", res.StatusCode, body)
+	}
+
+	res, body = requestHeader(t, token, "DELETE", personURL, http.Header{"If-Match": {newETag}}, nil)
+	if res.StatusCode != 200 {
+		t.Fatalf("DELETE with matching If-Match: want status 200, got %d: %s", res.StatusCode, body)
+	}
+}
+
+type conflictResponse struct {
+	Message   string `json:"message"`
+	Current   Person `json:"current"`
+	Conflicts []struct {
+		Field  string      `json:"field"`
+		Base   interface{} `json:"base"`
+		Client interface{} `json:"client"`
+		Server interface{} `json:"server"`
+	} `json:"conflicts"`
+}
+
+func TestPersonConflict(t *testing.T) {
+	srv, cleanup := TestServer(t)
+	defer cleanup()
+
+	token := login(t, srv, "admin", "geheim")
+
+	status, body := request(t, token, "POST", srv.URL+"/api/person", readFixture(t, "sample_person.json"))
+	if status != 201 {
+		t.Fatalf("creating person failed with status %d: %s", status, body)
+	}
+
+	var original map[string]interface{}
+	unmarshal(t, body, &original)
+	personURL := fmt.Sprintf("%s/api/person/%v", srv.URL, original["id"])
+
+	edit := func(changes map[string]interface{}) map[string]interface{} {
+		p := make(map[string]interface{})
+		for k, v := range original {
+			p[k] = v
+		}
+		for k, v := range changes {
+			p[k] = v
+		}
+		return p
+	}
+
+	// someone else changes the title
+	status, body = request(t, token, "PUT", personURL, marshal(t, edit(map[string]interface{}{"title": "CTO"})))
+	if status != 200 {
+		t.Fatalf("updating person failed with status %d: %s", status, body)
+	}
+
+	// the name was not changed on the server, so there are no conflicting fields
+	status, body = request(t, token, "PUT", personURL, marshal(t, edit(map[string]interface{}{"name": "Nicolai Niemand"})))
+	if status != http.StatusConflict {
+		t.Fatalf("update of outdated version: want status 409, got %d: %s", status, body)
+	}
+
+	var cr conflictResponse
+	unmarshal(t, body, &cr)
+	if cr.Current.Version != 2 || len(cr.Conflicts) != 0 {
+		t.Fatalf("wrong conflict response: %s", body)
+	}
+
+	status, body = request(t, token, "PUT", personURL, marshal(t, edit(map[string]interface{}{"title": "CFO"})))
+	if status != http.StatusConflict {
+		t.Fatalf("update of outdated version: want status 409, got %d: %s", status, body)
+	}
+
+	cr = conflictResponse{}
+	unmarshal(t, body, &cr)
+	if len(cr.Conflicts) != 1 || cr.Conflicts[0].Field != "title" ||
+		cr.Conflicts[0].Base != "CEO" || cr.Conflicts[0].Client != "CFO" || cr.Conflicts[0].Server != "CTO" {
+		t.Fatalf("wrong conflicts returned: %s", body)
+	}
+
+	// automatic merge of non-overlapping changes
+	merge := map[string]interface{}{
+		"original": original,
+		"update":   edit(map[string]interface{}{"name": "Nicolai Niemand"}),
+	}
+	status, body = request(t, token, "PUT", personURL+"?merge=auto", marshal(t, merge))
+	if status != 200 {
+		t.Fatalf("automatic merge failed with status %d: %s", status, body)
+	}
+
+	var merged struct {
+		Name    string `json:"name"`
+		Title   string `json:"title"`
+		Version int    `json:"version"`
+	}
+	unmarshal(t, body, &merged)
+	if merged.Name != "Nicolai Niemand" || merged.Title != "CTO" || merged.Version != 3 {
+		t.Fatalf("person not merged correctly: %s", body)
+	}
+
+	// overlapping changes cannot be merged
+	merge["update"] = edit(map[string]interface{}{"title": "CFO"})
+	status, body = request(t, token, "PUT", personURL+"?merge=auto", marshal(t, merge))
+	if status != http.StatusConflict {
+		t.Fatalf("merge of conflicting changes: want status 409, got %d: %s", status, body)
+	}
+
+	cr = conflictResponse{}
+	unmarshal(t, body, &cr)
+	if len(cr.Conflicts) != 1 || cr.Conflicts[0].Field != "title" || cr.Current.Version != 3 {
+		t.Fatalf("wrong conflicts returned: %s", body)
+	}
+
+	// a patch of an outdated version
+	status, body = request(t, token, "PATCH", personURL, []byte(`{"version": 1, "title": "CIO", "comment": "foo"}`))
+	if status != http.StatusConflict {
+		t.Fatalf("patch of outdated version: want status 409, got %d: %s", status, body)
+	}
+
+	cr = conflictResponse{}
+	unmarshal(t, body, &cr)
+	if len(cr.Conflicts) != 1 || cr.Conflicts[0].Field != "title" {
+		t.Fatalf("wrong conflicts returned: %s", body)
+	}
+}
+
 var invalidPersonTests = []string{
 	`{}`,
 	`{"id": 23}`,
-	`{"email_address": "foo@example.com"}`,
+	`{"email_addresses": [{"type": "work", "address": "foo@example.com"}]}`,
+	`{"name": "foo", "email_addresses": [{"type": "work", "address": "foo"}]}`,
 }
 
 func TestInvalidPerson(t *testing.T) {
@@ -196,3 +607,74 @@ func TestInvalidPerson(t *testing.T) {
 		}
 	}
 }
+
+func TestPersonDuplicatesMerge(t *testing.T) {
+	srv, cleanup := TestServer(t)
+	defer cleanup()
+
+	token := login(t, srv, "admin", "geheim")
+
+	var ids []int
+	for _, p := range []string{
+		`{"name": "Hans Meyer", "phone_numbers": [{"type": "work", "number": "+49 221 555123"}]}`,
+		`{"name": "Hans Maier", "title": "CEO", "phone_numbers": [{"type": "work", "number": "0221 555123"}]}`,
+	} {
+		status, body := request(t, token, "POST", srv.URL+"/api/person", []byte(p))
+		if status != 201 {
+			t.Fatalf("invalid status code, want 201, got %v, body:\n  %s", status, body)
+		}
+
+		var person Person
+		unmarshal(t, body, &person)
+		ids = append(ids, person.ID)
+	}
+
+	status, body := request(t, token, "GET", srv.URL+"/api/person/duplicates", nil)
+	if status != 200 {
+		t.Fatalf("listing duplicates, invalid status %d: %s", status, body)
+	}
+
+	var pairs []struct {
+		PersonIDs []int   `json:"person_ids"`
+		Score     float64 `json:"score"`
+	}
+	unmarshal(t, body, &pairs)
+
+	found := false
+	for _, pair := range pairs {
+		if pair.PersonIDs[0] == ids[0] && pair.PersonIDs[1] == ids[1] {
+			found = true
+		}
+	}
+
+	if !found {
+		t.Fatalf("duplicate pair %v not found in %s", ids, body)
+	}
+
+	merge := fmt.Sprintf(`{"keep": %d, "remove": %d, "prefer": ["name"]}`, ids[0], ids[1])
+
+	// merging requires the permissions to edit and to delete people
+	createRoleUser(t, token, srv.URL, "merge-sales", "sales")
+	salesToken := login(t, srv, "merge-sales", "geheim")
+	status, body = request(t, salesToken, "POST", srv.URL+"/api/person/merge", []byte(merge))
+	if status != http.StatusForbidden {
+		t.Fatalf("merge without permission person:delete, want status 403, got %d: %s", status, body)
+	}
+
+	status, body = request(t, token, "POST", srv.URL+"/api/person/merge", []byte(merge))
+	if status != 200 {
+		t.Fatalf("merge, invalid status %d: %s", status, body)
+	}
+
+	verifyPerson(t, "Hans Maier", body)
+
+	status, _ = request(t, token, "GET", fmt.Sprintf("%s/api/person/%d", srv.URL, ids[1]), nil)
+	if status != 404 {
+		t.Fatalf("reading merged person, want status 404, got %d", status)
+	}
+
+	status, _ = request(t, token, "POST", srv.URL+"/api/person/merge", []byte(merge))
+	if status != 404 {
+		t.Fatalf("merging removed person, want status 404, got %d", status)
+	}
+}
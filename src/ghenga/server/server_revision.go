@@ -0,0 +1,212 @@
+package server
+
+import (
+	"errors"
+	"ghenga/db"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"golang.org/x/net/context"
+)
+
+// parseVersion parses a version number from a URL.
+func parseVersion(s string) (int64, error) {
+	v, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || v <= 0 {
+		return 0, StatusError{
+			Code: http.StatusBadRequest,
+			Err:  errors.New("invalid version"),
+		}
+	}
+
+	return v, nil
+}
+
+// findRevision loads a revision, a missing revision is reported as 404.
+func findRevision(env *Env, entity string, id, version int64) (*db.Revision, error) {
+	r, err := env.DB.FindRevision(entity, id, version)
+	if err != nil {
+		return nil, StatusError{
+			Err:  errors.New("version not found"),
+			Code: http.StatusNotFound,
+		}
+	}
+
+	return r, nil
+}
+
+// ListRevisions returns a handler which lists all stored versions of a
+// record, the oldest first.
+func ListRevisions(entity string) HandleFunc {
+	return func(ctx context.Context, env *Env, res http.ResponseWriter, req *http.Request) error {
+		id, err := strconv.Atoi(mux.Vars(req)["id"])
+		if err != nil {
+			return StatusError{Code: http.StatusBadRequest, Err: err}
+		}
+
+		revs, err := env.DB.ListRevisions(entity, int64(id))
+		if err != nil {
+			return err
+		}
+
+		if len(revs) == 0 {
+			return StatusError{
+				Err:  errors.New(entity + " not found"),
+				Code: http.StatusNotFound,
+			}
+		}
+
+		return httpWriteJSON(res, http.StatusOK, revs)
+	}
+}
+
+// ShowRevision returns a handler which returns a single version of a record.
+func ShowRevision(entity string) HandleFunc {
+	return func(ctx context.Context, env *Env, res http.ResponseWriter, req *http.Request) error {
+		id, err := strconv.Atoi(mux.Vars(req)["id"])
+		if err != nil {
+			return StatusError{Code: http.StatusBadRequest, Err: err}
+		}
+
+		version, err := parseVersion(mux.Vars(req)["version"])
+		if err != nil {
+			return err
+		}
+
+		r, err := findRevision(env, entity, int64(id), version)
+		if err != nil {
+			return err
+		}
+
+		return httpWriteJSON(res, http.StatusOK, r)
+	}
+}
+
+// DiffRevisions returns a handler which returns the fields changed between
+// the versions given in the query parameters `from` and `to`. When `to` is
+// not set, the latest version is used.
+func DiffRevisions(entity string) HandleFunc {
+	return func(ctx context.Context, env *Env, res http.ResponseWriter, req *http.Request) error {
+		id, err := strconv.Atoi(mux.Vars(req)["id"])
+		if err != nil {
+			return StatusError{Code: http.StatusBadRequest, Err: err}
+		}
+
+		from, err := parseVersion(req.URL.Query().Get("from"))
+		if err != nil {
+			return err
+		}
+
+		var to *db.Revision
+		if s := req.URL.Query().Get("to"); s != "" {
+			version, err := parseVersion(s)
+			if err != nil {
+				return err
+			}
+
+			if to, err = findRevision(env, entity, int64(id), version); err != nil {
+				return err
+			}
+		} else {
+			revs, err := env.DB.ListRevisions(entity, int64(id))
+			if err != nil {
+				return err
+			}
+
+			if len(revs) == 0 {
+				return StatusError{
+					Err:  errors.New(entity + " not found"),
+					Code: http.StatusNotFound,
+				}
+			}
+
+			to = revs[len(revs)-1]
+		}
+
+		r, err := findRevision(env, entity, int64(id), from)
+		if err != nil {
+			return err
+		}
+
+		changes, err := db.DiffRevisions(r, to)
+		if err != nil {
+			return err
+		}
+
+		return httpWriteJSON(res, http.StatusOK, changes)
+	}
+}
+
+// RevertPerson creates a new version of a person with the data of an older
+// version.
+func RevertPerson(ctx context.Context, env *Env, wr http.ResponseWriter, req *http.Request) (err error) {
+	id, err := strconv.Atoi(mux.Vars(req)["id"])
+	if err != nil {
+		return StatusError{Code: http.StatusBadRequest, Err: err}
+	}
+
+	version, err := parseVersion(mux.Vars(req)["version"])
+	if err != nil {
+		return err
+	}
+
+	p, err := findPerson(ctx, env, int64(id))
+	if err != nil {
+		return err
+	}
+
+	r, err := findRevision(env, db.RevisionPerson, p.ID, version)
+	if err != nil {
+		return err
+	}
+
+	old, err := r.Person()
+	if err != nil {
+		return err
+	}
+
+	// keep the identity of the current record, the old data becomes the
+	// next version
+	old.ID = p.ID
+	old.Version = p.Version
+	old.CreatedAt = p.CreatedAt
+	old.CreatedBy = p.CreatedBy
+	old.OwnerID = p.OwnerID
+	old.Visibility = p.Visibility
+	old.ChangedAt = time.Now()
+
+	if err = old.Validate(); err != nil {
+		return StatusError{Code: http.StatusBadRequest, Err: err}
+	}
+
+	if err = checkAccount(env, old); err != nil {
+		return err
+	}
+
+	if old.ChangedBy, err = changedBy(ctx, env); err != nil {
+		return err
+	}
+
+	err = env.DB.UpdatePerson(old)
+	if err != nil {
+		env.Logf("unable to revert person %v to version %v, sql error: %v", p, version, err)
+		return err
+	}
+
+	env.Debugf("reverted person %v to version %v", old, version)
+
+	return httpWriteJSON(wr, http.StatusOK, old)
+}
+
+// RevisionHandler adds routes for the revision history to r.
+func RevisionHandler(ctx context.Context, env *Env, r *mux.Router) {
+	r.Handle("/api/person/{id}/versions", Handle(ctx, env, RequirePermission(db.PermPersonRead, RequireVisiblePerson(ListRevisions(db.RevisionPerson))))).Methods("GET")
+	r.Handle("/api/person/{id}/versions/{version}", Handle(ctx, env, RequirePermission(db.PermPersonRead, RequireVisiblePerson(ShowRevision(db.RevisionPerson))))).Methods("GET")
+	r.Handle("/api/person/{id}/diff", Handle(ctx, env, RequirePermission(db.PermPersonRead, RequireVisiblePerson(DiffRevisions(db.RevisionPerson))))).Methods("GET")
+	r.Handle("/api/person/{id}/revert/{version}", Handle(ctx, env, RequirePermission(db.PermPersonWrite, Audit("revert", db.RevisionPerson, RevertPerson)))).Methods("POST")
+	r.Handle("/api/user/{id}/versions", Handle(ctx, env, RequirePermission(db.PermUserManage, ListRevisions(db.RevisionUser)))).Methods("GET")
+	r.Handle("/api/user/{id}/versions/{version}", Handle(ctx, env, RequirePermission(db.PermUserManage, ShowRevision(db.RevisionUser)))).Methods("GET")
+	r.Handle("/api/user/{id}/diff", Handle(ctx, env, RequirePermission(db.PermUserManage, DiffRevisions(db.RevisionUser)))).Methods("GET")
+}
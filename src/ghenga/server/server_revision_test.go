@@ -0,0 +1,108 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+)
+
+type Revision struct {
+	Version   int             `json:"version"`
+	ChangedBy *int            `json:"changed_by"`
+	Data      json.RawMessage `json:"data"`
+}
+
+type FieldChange struct {
+	Field string          `json:"field"`
+	Old   json.RawMessage `json:"old"`
+	New   json.RawMessage `json:"new"`
+}
+
+func TestPersonRevisions(t *testing.T) {
+	srv, cleanup := TestServer(t)
+	defer cleanup()
+
+	token := login(t, srv, "admin", "geheim")
+
+	status, body := request(t, token, "POST", srv.URL+"/api/person", readFixture(t, "sample_person.json"))
+	if status != 201 {
+		t.Fatalf("invalid status code, want 201, got %v, body:\n  %s", status, body)
+	}
+
+	person := verifyPerson(t, "Nicolai Person", body)
+	url := fmt.Sprintf("%s/api/person/%d", srv.URL, person.ID)
+
+	person.Name = "Nicolai Renamed"
+	status, body = request(t, token, "PUT", url, marshal(t, person))
+	if status != 200 {
+		t.Fatalf("updating person, invalid status %d: %s", status, body)
+	}
+	person = verifyPerson(t, "Nicolai Renamed", body)
+
+	status, body = request(t, token, "GET", url+"/versions", nil)
+	if status != 200 {
+		t.Fatalf("listing versions, invalid status %d: %s", status, body)
+	}
+
+	var revs []Revision
+	unmarshal(t, body, &revs)
+	if len(revs) != 2 {
+		t.Fatalf("wrong number of versions, want 2, got %d: %s", len(revs), body)
+	}
+
+	for i, rev := range revs {
+		if rev.Version != i+1 {
+			t.Errorf("wrong version for revision %d: %v", i, rev.Version)
+		}
+
+		if rev.ChangedBy == nil {
+			t.Errorf("user who changed revision %d not recorded", i)
+		}
+	}
+
+	status, body = request(t, token, "GET", url+"/versions/1", nil)
+	if status != 200 {
+		t.Fatalf("reading version 1, invalid status %d: %s", status, body)
+	}
+
+	var rev Revision
+	unmarshal(t, body, &rev)
+	verifyPerson(t, "Nicolai Person", rev.Data)
+
+	status, _ = request(t, token, "GET", url+"/versions/23", nil)
+	if status != 404 {
+		t.Fatalf("reading non-existing version, want status 404, got %d", status)
+	}
+
+	status, body = request(t, token, "GET", url+"/diff?from=1&to=2", nil)
+	if status != 200 {
+		t.Fatalf("diff, invalid status %d: %s", status, body)
+	}
+
+	var changes []FieldChange
+	unmarshal(t, body, &changes)
+
+	found := false
+	for _, c := range changes {
+		if c.Field == "name" {
+			found = true
+			if string(c.Old) != `"Nicolai Person"` || string(c.New) != `"Nicolai Renamed"` {
+				t.Errorf("wrong change for name: %s -> %s", c.Old, c.New)
+			}
+		}
+	}
+
+	if !found {
+		t.Errorf("change of name not found in diff: %s", body)
+	}
+
+	status, body = request(t, token, "POST", url+"/revert/1", nil)
+	if status != 200 {
+		t.Fatalf("revert, invalid status %d: %s", status, body)
+	}
+
+	person = verifyPerson(t, "Nicolai Person", body)
+	if person.Version != 3 {
+		t.Fatalf("revert did not create a new version, got version %d", person.Version)
+	}
+}
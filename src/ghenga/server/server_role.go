@@ -0,0 +1,226 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"ghenga/db"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"golang.org/x/net/context"
+)
+
+// ListRoles handles listing roles.
+func ListRoles(ctx context.Context, env *Env, res http.ResponseWriter, req *http.Request) error {
+	roles, err := env.DB.ListRoles()
+	if err != nil {
+		return err
+	}
+
+	return httpWriteJSON(res, http.StatusOK, roles)
+}
+
+// findRole returns the role with the ID from the URL.
+func findRole(env *Env, req *http.Request) (*db.Role, error) {
+	id, err := strconv.Atoi(mux.Vars(req)["id"])
+	if err != nil {
+		return nil, StatusError{Code: http.StatusBadRequest, Err: err}
+	}
+
+	r, err := env.DB.FindRole(int64(id))
+	if err != nil {
+		return nil, StatusError{
+			Err:  errors.New("role not found"),
+			Code: http.StatusNotFound,
+		}
+	}
+
+	return r, nil
+}
+
+// ShowRole returns a role.
+func ShowRole(ctx context.Context, env *Env, res http.ResponseWriter, req *http.Request) error {
+	r, err := findRole(env, req)
+	if err != nil {
+		return err
+	}
+
+	return httpWriteJSON(res, http.StatusOK, r)
+}
+
+// CreateRole inserts a new role. The request body must be valid JSON.
+func CreateRole(ctx context.Context, env *Env, wr http.ResponseWriter, req *http.Request) (err error) {
+	defer cleanupErr(&err, req.Body.Close)
+
+	var jr db.RoleJSON
+	dec := json.NewDecoder(req.Body)
+	if err = dec.Decode(&jr); err != nil {
+		return StatusError{Code: http.StatusBadRequest, Err: err}
+	}
+
+	var r db.Role
+	r.Update(jr)
+
+	// overwrite fields we'd like to be set
+	r.CreatedAt = time.Now()
+	r.ChangedAt = time.Now()
+
+	if err = r.Validate(); err != nil {
+		return StatusError{Code: http.StatusBadRequest, Err: err}
+	}
+
+	if err = env.DB.InsertRole(&r); err != nil {
+		return err
+	}
+
+	env.Debugf("created role %v", r)
+
+	return httpWriteJSON(wr, http.StatusCreated, r)
+}
+
+// UpdateRole changes an existing role. The role admin cannot be renamed. The
+// request body must be valid JSON.
+func UpdateRole(ctx context.Context, env *Env, wr http.ResponseWriter, req *http.Request) (err error) {
+	defer cleanupErr(&err, req.Body.Close)
+
+	r, err := findRole(env, req)
+	if err != nil {
+		return err
+	}
+
+	var jr db.RoleJSON
+	dec := json.NewDecoder(req.Body)
+	if err = dec.Decode(&jr); err != nil {
+		return StatusError{Code: http.StatusBadRequest, Err: err}
+	}
+
+	if r.Version != jr.Version {
+		env.Debugf("role is outdated, version %v != %v", r.Version, jr.Version)
+		return StatusError{Code: http.StatusConflict, Err: errVersionMismatch}
+	}
+
+	if r.Name == db.RoleAdmin && jr.Name != db.RoleAdmin {
+		return StatusError{
+			Code: http.StatusBadRequest,
+			Err:  errors.New("the role admin cannot be renamed"),
+		}
+	}
+
+	r.Update(jr)
+	r.ChangedAt = time.Now()
+
+	if err = r.Validate(); err != nil {
+		return StatusError{Code: http.StatusBadRequest, Err: err}
+	}
+
+	if err = env.DB.UpdateRole(r); err != nil {
+		env.Logf("unable to update role %v: %v", r, err)
+		return versionError(req, err)
+	}
+
+	return httpWriteJSON(wr, http.StatusOK, r)
+}
+
+// DeleteRole removes a role, it is removed from all users. The role admin
+// cannot be removed.
+func DeleteRole(ctx context.Context, env *Env, wr http.ResponseWriter, req *http.Request) error {
+	r, err := findRole(env, req)
+	if err != nil {
+		return err
+	}
+
+	if r.Name == db.RoleAdmin {
+		return StatusError{
+			Code: http.StatusBadRequest,
+			Err:  errors.New("the role admin cannot be removed"),
+		}
+	}
+
+	if err = env.DB.DeleteRole(r.ID); err != nil {
+		return err
+	}
+
+	return httpWriteJSON(wr, http.StatusOK, nil)
+}
+
+// ShowUserRoles returns the names of the roles assigned to a user.
+func ShowUserRoles(ctx context.Context, env *Env, res http.ResponseWriter, req *http.Request) error {
+	id, err := strconv.Atoi(mux.Vars(req)["id"])
+	if err != nil {
+		return StatusError{Code: http.StatusBadRequest, Err: err}
+	}
+
+	u, err := env.DB.FindUser(int64(id))
+	if err != nil {
+		return StatusError{
+			Err:  errors.New("user not found"),
+			Code: http.StatusNotFound,
+		}
+	}
+
+	roles := u.Roles
+	if roles == nil {
+		roles = []string{}
+	}
+
+	return httpWriteJSON(res, http.StatusOK, roles)
+}
+
+// SetUserRoles replaces the roles assigned to a user with the list of role
+// names in the request body and returns the updated user.
+func SetUserRoles(ctx context.Context, env *Env, wr http.ResponseWriter, req *http.Request) (err error) {
+	defer cleanupErr(&err, req.Body.Close)
+
+	id, err := strconv.Atoi(mux.Vars(req)["id"])
+	if err != nil {
+		return StatusError{Code: http.StatusBadRequest, Err: err}
+	}
+
+	var roles []string
+	dec := json.NewDecoder(req.Body)
+	if err = dec.Decode(&roles); err != nil {
+		return StatusError{Code: http.StatusBadRequest, Err: err}
+	}
+
+	u, err := env.DB.FindUser(int64(id))
+	if err != nil {
+		return StatusError{
+			Err:  errors.New("user not found"),
+			Code: http.StatusNotFound,
+		}
+	}
+
+	wasAdmin := u.Admin
+	u.SetRoles(roles)
+	u.ChangedAt = time.Now()
+
+	if err = checkRoles(ctx, env, u, wasAdmin); err != nil {
+		return err
+	}
+
+	if u.ChangedBy, err = changedBy(ctx, env); err != nil {
+		return err
+	}
+
+	if err = env.DB.UpdateUser(u); err != nil {
+		env.Logf("unable to update roles of user %v: %v", u, err)
+		return versionError(req, err)
+	}
+
+	wr.Header().Set("ETag", userETag(u))
+	return httpWriteJSON(wr, http.StatusOK, u)
+}
+
+// RoleHandler adds routes for managing roles and assigning them to users to
+// r.
+func RoleHandler(ctx context.Context, env *Env, r *mux.Router) {
+	r.Handle("/api/role", Handle(ctx, env, RequireAdmin(ListRoles))).Methods("GET")
+	r.Handle("/api/role", Handle(ctx, env, RequireAdmin(Audit(db.ActionCreate, "role", CreateRole)))).Methods("POST")
+	r.Handle("/api/role/{id}", Handle(ctx, env, RequireAdmin(ShowRole))).Methods("GET")
+	r.Handle("/api/role/{id}", Handle(ctx, env, RequireAdmin(Audit(db.ActionUpdate, "role", UpdateRole)))).Methods("PUT")
+	r.Handle("/api/role/{id}", Handle(ctx, env, RequireAdmin(Audit(db.ActionDelete, "role", DeleteRole)))).Methods("DELETE")
+	r.Handle("/api/user/{id}/roles", Handle(ctx, env, RequireAdmin(ShowUserRoles))).Methods("GET")
+	r.Handle("/api/user/{id}/roles", Handle(ctx, env, RequireAdmin(Audit("assign_roles", db.RevisionUser, SetUserRoles)))).Methods("PUT")
+}
@@ -0,0 +1,180 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+type Role struct {
+	ID          int      `json:"id"`
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	Permissions []string `json:"permissions"`
+	Version     int      `json:"version"`
+}
+
+type userRoles struct {
+	ID      int      `json:"id"`
+	Login   string   `json:"login"`
+	Admin   bool     `json:"admin"`
+	Roles   []string `json:"roles"`
+	Version int      `json:"version"`
+}
+
+func createRoleUser(t *testing.T, token, url, login string, roles ...string) userRoles {
+	body := fmt.Sprintf(`{"login": %q, "password": "geheim", "roles": %s}`, login, marshal(t, roles))
+	var u userRoles
+	unmarshal(t, createUser(t, token, url+"/api/user", []byte(body)), &u)
+	return u
+}
+
+func TestRolePermissions(t *testing.T) {
+	srv, cleanup := TestServer(t)
+	defer cleanup()
+
+	token := login(t, srv, "admin", "geheim")
+	createRoleUser(t, token, srv.URL, "vera", "viewer")
+
+	status, body := loginRequest(t, srv, "vera", "geheim")
+	if status != http.StatusOK {
+		t.Fatalf("login failed with status %v: %s", status, body)
+	}
+
+	var lr LoginResponseJSON
+	unmarshal(t, body, &lr)
+	if !reflect.DeepEqual(lr.Roles, []string{"viewer"}) || !reflect.DeepEqual(lr.Permissions, []string{"person:read"}) {
+		t.Errorf("wrong roles or permissions in login response: %s", body)
+	}
+
+	viewer := lr.Token
+	for _, test := range []struct {
+		method, path, body string
+		status             int
+	}{
+		{"GET", "/api/person", "", http.StatusOK},
+		{"GET", "/api/person/1", "", http.StatusOK},
+		{"GET", "/api/search/person?query=a", "", http.StatusOK},
+		{"POST", "/api/person", `{"name": "foo"}`, http.StatusForbidden},
+		{"PATCH", "/api/person/1", `{"name": "foo"}`, http.StatusForbidden},
+		{"DELETE", "/api/person/1", "", http.StatusForbidden},
+		{"GET", "/api/user", "", http.StatusForbidden},
+		{"GET", "/api/role", "", http.StatusForbidden},
+	} {
+		var data []byte
+		if test.body != "" {
+			data = []byte(test.body)
+		}
+
+		status, body := request(t, viewer, test.method, srv.URL+test.path, data)
+		if status != test.status {
+			t.Errorf("%v %v: want status %v, got %v: %s", test.method, test.path, test.status, status, body)
+		}
+	}
+
+	// the account "user" has the role manager and may delete people, but not
+	// manage users
+	userToken := login(t, srv, "user", "geheim")
+	if status, body := request(t, userToken, "GET", srv.URL+"/api/user", nil); status != http.StatusForbidden {
+		t.Errorf("user without user:manage could list users, status %v: %s", status, body)
+	}
+
+	deletePerson(t, userToken, srv.URL, 1)
+}
+
+func TestRoleCRUD(t *testing.T) {
+	srv, cleanup := TestServer(t)
+	defer cleanup()
+
+	token := login(t, srv, "admin", "geheim")
+
+	var roles []Role
+	status, body := request(t, token, "GET", srv.URL+"/api/role", nil)
+	if status != http.StatusOK {
+		t.Fatalf("listing roles failed with status %v: %s", status, body)
+	}
+	unmarshal(t, body, &roles)
+
+	ids := make(map[string]int)
+	for _, r := range roles {
+		ids[r.Name] = r.ID
+	}
+
+	for _, name := range []string{"admin", "manager", "sales", "viewer"} {
+		if ids[name] == 0 {
+			t.Errorf("role %v not found in %s", name, body)
+		}
+	}
+
+	status, body = request(t, token, "POST", srv.URL+"/api/role", []byte(`{"name": "support", "permissions": ["person:fly"]}`))
+	if status != http.StatusBadRequest {
+		t.Errorf("role with unknown permission was accepted, status %v: %s", status, body)
+	}
+
+	status, body = request(t, token, "POST", srv.URL+"/api/role", []byte(`{"name": "support", "permissions": ["person:read", "user:manage"]}`))
+	if status != http.StatusCreated {
+		t.Fatalf("creating role failed with status %v: %s", status, body)
+	}
+
+	var support Role
+	unmarshal(t, body, &support)
+
+	status, body = request(t, token, "PUT", fmt.Sprintf("%s/api/role/%d", srv.URL, ids["admin"]),
+		[]byte(`{"name": "root", "permissions": [], "version": 1}`))
+	if status != http.StatusBadRequest {
+		t.Errorf("renaming role admin did not fail, status %v: %s", status, body)
+	}
+
+	status, body = request(t, token, "DELETE", fmt.Sprintf("%s/api/role/%d", srv.URL, ids["admin"]), nil)
+	if status != http.StatusBadRequest {
+		t.Errorf("removing role admin did not fail, status %v: %s", status, body)
+	}
+
+	u := createRoleUser(t, token, srv.URL, "sam", "sales")
+
+	status, body = request(t, token, "PUT", fmt.Sprintf("%s/api/user/%d/roles", srv.URL, u.ID), []byte(`["support", "nonexistent"]`))
+	if status != http.StatusBadRequest {
+		t.Errorf("unknown role was assigned, status %v: %s", status, body)
+	}
+
+	status, body = request(t, token, "PUT", fmt.Sprintf("%s/api/user/%d/roles", srv.URL, u.ID), []byte(`["support"]`))
+	if status != http.StatusOK {
+		t.Fatalf("assigning roles failed with status %v: %s", status, body)
+	}
+
+	unmarshal(t, body, &u)
+	if !reflect.DeepEqual(u.Roles, []string{"support"}) {
+		t.Errorf("wrong roles after assignment: %v", u.Roles)
+	}
+
+	// sam may now manage users, but not admins
+	sam := login(t, srv, "sam", "geheim")
+	createRoleUser(t, sam, srv.URL, "sally", "sales")
+
+	status, body = request(t, sam, "POST", srv.URL+"/api/user", []byte(`{"login": "eve", "password": "geheim", "roles": ["admin"]}`))
+	if status != http.StatusForbidden {
+		t.Errorf("role admin was granted by non-admin, status %v: %s", status, body)
+	}
+
+	status, body = request(t, sam, "PATCH", srv.URL+"/api/user/1", []byte(`{"password": "x"}`))
+	if status != http.StatusForbidden {
+		t.Errorf("admin was modified by non-admin, status %v: %s", status, body)
+	}
+
+	status, body = request(t, token, "DELETE", fmt.Sprintf("%s/api/role/%d", srv.URL, support.ID), nil)
+	if status != http.StatusOK {
+		t.Fatalf("removing role failed with status %v: %s", status, body)
+	}
+
+	var roleNames []string
+	status, body = request(t, token, "GET", fmt.Sprintf("%s/api/user/%d/roles", srv.URL, u.ID), nil)
+	if status != http.StatusOK {
+		t.Fatalf("listing roles of user failed with status %v: %s", status, body)
+	}
+
+	unmarshal(t, body, &roleNames)
+	if len(roleNames) != 0 {
+		t.Errorf("removed role is still assigned: %v", roleNames)
+	}
+}
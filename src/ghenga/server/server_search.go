@@ -1,27 +1,45 @@
 package server
 
 import (
+	"ghenga/db"
 	"net/http"
 
 	"github.com/gorilla/mux"
 	"golang.org/x/net/context"
 )
 
-// SearchPerson handles a search request for a person.
+// SearchPerson handles a search request for a person. The query language is
+// described at db.ParseQuery, syntax errors are returned with status 400. The
+// results are ranked, the best matches are returned first. The list is
+// paginated with the query parameters `limit` and `offset`.
 func SearchPerson(ctx context.Context, env *Env, res http.ResponseWriter, req *http.Request) error {
 	query := req.URL.Query().Get("query")
 
+	limit, offset, err := parsePage(req)
+	if err != nil {
+		return err
+	}
+
 	env.Debugf("listing people that match %v", query)
 
-	people, err := env.DB.FuzzyFindPersons(query)
+	vdb, err := visibleDB(ctx, env)
+	if err != nil {
+		return err
+	}
+
+	results, err := vdb.SearchPeople(query, limit, offset)
+	if e, ok := err.(db.QueryError); ok {
+		return StatusError{Code: http.StatusBadRequest, Err: e}
+	}
+
 	if err != nil {
 		return err
 	}
 
-	return httpWriteJSON(res, http.StatusOK, people)
+	return httpWriteJSON(res, http.StatusOK, results)
 }
 
 // SearchHandler adds routes to the for ghenga API in the given enviroment to r.
 func SearchHandler(ctx context.Context, env *Env, r *mux.Router) {
-	r.Handle("/api/search/person", Handle(ctx, env, RequireAuth(SearchPerson))).Methods("GET")
+	r.Handle("/api/search/person", Handle(ctx, env, RequirePermission(db.PermPersonRead, SearchPerson))).Methods("GET")
 }
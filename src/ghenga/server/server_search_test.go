@@ -1,9 +1,15 @@
 package server
 
-import "testing"
+import (
+	"net/url"
+	"strings"
+	"testing"
+)
 
-func search(t *testing.T, query string) []Person {
-	return nil
+type SearchResult struct {
+	Person   Person            `json:"person"`
+	Rank     float64           `json:"rank"`
+	Snippets map[string]string `json:"snippets"`
 }
 
 func TestSearchPerson(t *testing.T) {
@@ -18,4 +24,58 @@ func TestSearchPerson(t *testing.T) {
 	if status != 201 {
 		t.Fatalf("invalid status code, want 201, got %v, body:\n  %s", status, string(p))
 	}
+
+	// search for the secondary email address
+	status, body := request(t, token, "GET", srv.URL+"/api/search/person?query=nicolai@example", nil)
+	if status != 200 {
+		t.Fatalf("invalid status code, want 200, got %v, body:\n  %s", status, body)
+	}
+
+	var list []SearchResult
+	unmarshal(t, body, &list)
+	if len(list) == 0 || list[0].Person.Name != "Nicolai Person" {
+		t.Fatalf("person not found by email address, got %s", body)
+	}
+
+	// multi-word search across several fields
+	status, body = request(t, token, "GET", srv.URL+"/api/search/person?query=nicolai+k%C3%B6ln+management", nil)
+	if status != 200 {
+		t.Fatalf("invalid status code, want 200, got %v, body:\n  %s", status, body)
+	}
+
+	list = nil
+	unmarshal(t, body, &list)
+	if len(list) == 0 || list[0].Person.Name != "Nicolai Person" {
+		t.Fatalf("person not found, got %s", body)
+	}
+
+	for _, field := range []string{"name", "address", "department"} {
+		if _, ok := list[0].Snippets[field]; !ok {
+			t.Errorf("snippet for field %v not found in %v", field, list[0].Snippets)
+		}
+	}
+
+	// structured query
+	status, body = request(t, token, "GET", srv.URL+"/api/search/person?query="+
+		url.QueryEscape("city:Köln title:CEO -department:Testers phone:+49221"), nil)
+	if status != 200 {
+		t.Fatalf("invalid status code, want 200, got %v, body:\n  %s", status, body)
+	}
+
+	list = nil
+	unmarshal(t, body, &list)
+	if len(list) == 0 || list[0].Person.Name != "Nicolai Person" {
+		t.Fatalf("person not found, got %s", body)
+	}
+
+	// syntax error
+	status, body = request(t, token, "GET", srv.URL+"/api/search/person?query="+
+		url.QueryEscape(`city:Köln "foo`), nil)
+	if status != 400 {
+		t.Fatalf("invalid status code for syntax error, want 400, got %v, body:\n  %s", status, body)
+	}
+
+	if !strings.Contains(string(body), "position 11") {
+		t.Fatalf("error does not contain the position: %s", body)
+	}
 }
@@ -0,0 +1,315 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"ghenga/db"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"golang.org/x/net/context"
+)
+
+const (
+	// changeBufferSize is the number of changes kept for clients which
+	// resume the stream with Last-Event-ID.
+	changeBufferSize = 1000
+
+	// changeSubscriberBuffer is the number of changes which may be queued
+	// for a client, a client which falls further behind is disconnected.
+	changeSubscriberBuffer = 100
+
+	// streamKeepalive is the interval in which a comment is sent to idle
+	// clients, so that proxies do not close the connection.
+	streamKeepalive = 30 * time.Second
+)
+
+// changeEvent is a change with the ID of the event in the stream.
+type changeEvent struct {
+	seq    uint64
+	change db.Change
+}
+
+// changeStream distributes the changes of people and users to the connected
+// clients. The last changes are kept in a buffer, so that a client can
+// resume the stream after a reconnect.
+//
+// The ID of an event consists of the generation of the stream, which is
+// derived from the time the server was started, and a sequence number. A
+// client which sends an ID of a different generation has missed changes.
+type changeStream struct {
+	m           sync.Mutex
+	generation  string
+	seq         uint64
+	buf         []changeEvent
+	size        int
+	subscribers map[chan changeEvent]struct{}
+	closed      bool
+}
+
+func newChangeStream(size int) *changeStream {
+	return &changeStream{
+		generation:  strconv.FormatInt(time.Now().UnixNano(), 36),
+		size:        size,
+		subscribers: make(map[chan changeEvent]struct{}),
+	}
+}
+
+// eventID returns the ID of the event with the sequence number seq.
+func (s *changeStream) eventID(seq uint64) string {
+	return fmt.Sprintf("%s-%d", s.generation, seq)
+}
+
+// parseEventID returns the sequence number of an event ID, ok is false if
+// the ID is invalid or of a different generation.
+func (s *changeStream) parseEventID(id string) (seq uint64, ok bool) {
+	i := strings.LastIndex(id, "-")
+	if i < 0 || id[:i] != s.generation {
+		return 0, false
+	}
+
+	seq, err := strconv.ParseUint(id[i+1:], 10, 64)
+	if err != nil {
+		return 0, false
+	}
+
+	return seq, true
+}
+
+// publish sends a change to all clients. Clients which do not keep up are
+// disconnected. publish does not block.
+func (s *changeStream) publish(c db.Change) {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	if s.closed {
+		return
+	}
+
+	s.seq++
+	ev := changeEvent{seq: s.seq, change: c}
+
+	s.buf = append(s.buf, ev)
+	if len(s.buf) > s.size {
+		s.buf = s.buf[len(s.buf)-s.size:]
+	}
+
+	for ch := range s.subscribers {
+		select {
+		case ch <- ev:
+		default:
+			delete(s.subscribers, ch)
+			close(ch)
+		}
+	}
+}
+
+// subscribe returns a channel on which all new changes are sent. If
+// lastEventID is not empty, the changes after this event are returned as
+// well. If these changes are not available any more, complete is false.
+func (s *changeStream) subscribe(lastEventID string) (ch chan changeEvent, backlog []changeEvent, complete bool) {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	ch = make(chan changeEvent, changeSubscriberBuffer)
+	if s.closed {
+		close(ch)
+		return ch, nil, true
+	}
+	s.subscribers[ch] = struct{}{}
+
+	if lastEventID == "" {
+		return ch, nil, true
+	}
+
+	seq, ok := s.parseEventID(lastEventID)
+	if !ok || seq > s.seq {
+		return ch, nil, false
+	}
+
+	// the event after seq must still be in the buffer
+	if seq < s.seq && (len(s.buf) == 0 || s.buf[0].seq > seq+1) {
+		return ch, nil, false
+	}
+
+	for _, ev := range s.buf {
+		if ev.seq > seq {
+			backlog = append(backlog, ev)
+		}
+	}
+
+	return ch, backlog, true
+}
+
+// unsubscribe removes the channel from the list of subscribers.
+func (s *changeStream) unsubscribe(ch chan changeEvent) {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	if _, ok := s.subscribers[ch]; ok {
+		delete(s.subscribers, ch)
+		close(ch)
+	}
+}
+
+// close disconnects all clients.
+func (s *changeStream) close() {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	s.closed = true
+	for ch := range s.subscribers {
+		delete(s.subscribers, ch)
+		close(ch)
+	}
+}
+
+// writeStreamEvent writes a single Server-Sent Event.
+func writeStreamEvent(wr io.Writer, id, event string, data interface{}) error {
+	buf, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+
+	if id != "" {
+		if _, err = fmt.Fprintf(wr, "id: %s\n", id); err != nil {
+			return err
+		}
+	}
+
+	_, err = fmt.Fprintf(wr, "event: %s\ndata: %s\n\n", event, buf)
+	return err
+}
+
+// visibleChange returns true if the person of the change c is visible in vdb.
+func visibleChange(vdb *db.DB, c db.Change) bool {
+	if c.Action == db.ActionDelete {
+		visible, err := vdb.CanSee(c.OwnerID, c.Visibility)
+		return err == nil && visible
+	}
+
+	_, err := vdb.FindPerson(c.ID)
+	return err == nil
+}
+
+// streamEvents sends the stream of changes to the client. A `change` event
+// is sent for each person or user which is created, updated or deleted, a
+// client only receives the changes of people with the permission person:read
+// and the changes of users with the permission user:manage. Changes of people
+// which the user may not see are left out, deleted people are checked with
+// the owner and the visibility before the deletion. If the client resumes the
+// stream with the header Last-Event-ID (or the query parameter
+// `last_event_id`) but missed changes which are not buffered any more, a
+// `reset` event is sent first and the client must reload its data. The stream
+// ends when ctx is cancelled.
+func (s *changeStream) streamEvents(ctx context.Context, env *Env, res http.ResponseWriter, req *http.Request) error {
+	flusher, ok := res.(http.Flusher)
+	if !ok {
+		return StatusError{
+			Code: http.StatusInternalServerError,
+			Err:  errors.New("streaming is not supported"),
+		}
+	}
+
+	u, err := currentUser(ctx, env)
+	if err != nil {
+		return err
+	}
+
+	perms, err := env.DB.UserPermissions(u)
+	if err != nil {
+		return err
+	}
+
+	vdb := env.DB.VisibleTo(u)
+
+	lastEventID := req.Header.Get("Last-Event-ID")
+	if lastEventID == "" {
+		lastEventID = req.URL.Query().Get("last_event_id")
+	}
+
+	ch, backlog, complete := s.subscribe(lastEventID)
+	defer s.unsubscribe(ch)
+
+	res.Header().Set("Content-Type", "text/event-stream")
+	res.Header().Set("Cache-Control", "no-cache")
+	res.Header().Set("X-Accel-Buffering", "no")
+	res.WriteHeader(http.StatusOK)
+
+	if !complete {
+		if err = writeStreamEvent(res, "", "reset", struct{}{}); err != nil {
+			return nil
+		}
+	}
+
+	send := func(ev changeEvent) error {
+		switch {
+		case ev.change.Entity == db.RevisionPerson && !perms.Has(db.PermPersonRead):
+			return nil
+		case ev.change.Entity == db.RevisionUser && !perms.Has(db.PermUserManage):
+			return nil
+		}
+
+		if ev.change.Entity == db.RevisionPerson && !u.Admin {
+			if !visibleChange(vdb, ev.change) {
+				return nil
+			}
+		}
+
+		return writeStreamEvent(res, s.eventID(ev.seq), "change", ev.change)
+	}
+
+	for _, ev := range backlog {
+		if err = send(ev); err != nil {
+			return nil
+		}
+	}
+	flusher.Flush()
+
+	keepalive := time.NewTicker(streamKeepalive)
+	defer keepalive.Stop()
+
+	for {
+		select {
+		case ev, ok := <-ch:
+			if !ok {
+				return nil
+			}
+
+			if err = send(ev); err != nil {
+				return nil
+			}
+		case <-keepalive.C:
+			if _, err = io.WriteString(res, ": keepalive\n\n"); err != nil {
+				return nil
+			}
+		case <-req.Context().Done():
+			return nil
+		case <-ctx.Done():
+			return nil
+		}
+
+		flusher.Flush()
+	}
+}
+
+// StreamHandler adds the route for the stream of changes to r. The stream is
+// closed when ctx is cancelled.
+func StreamHandler(ctx context.Context, env *Env, r *mux.Router) {
+	s := newChangeStream(changeBufferSize)
+
+	remove := env.DB.OnChange(s.publish)
+	go func() {
+		<-ctx.Done()
+		remove()
+		s.close()
+	}()
+
+	r.Handle("/api/events", Handle(ctx, env, RequireAuth(s.streamEvents))).Methods("GET")
+}
@@ -0,0 +1,256 @@
+package server
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"ghenga/db"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+func TestChangeStreamResume(t *testing.T) {
+	s := newChangeStream(3)
+
+	ch, _, _ := s.subscribe("")
+	for id := int64(1); id <= 5; id++ {
+		s.publish(db.Change{Action: db.ActionCreate, Entity: db.RevisionPerson, ID: id})
+	}
+
+	for id := int64(1); id <= 5; id++ {
+		ev := <-ch
+		if ev.change.ID != id {
+			t.Fatalf("wrong change received, want %d, got %v", id, ev.change)
+		}
+	}
+	s.unsubscribe(ch)
+
+	var tests = []struct {
+		lastEventID string
+		complete    bool
+		backlog     []int64
+	}{
+		{s.eventID(5), true, nil},
+		{s.eventID(3), true, []int64{4, 5}},
+		{s.eventID(2), true, []int64{3, 4, 5}},
+		{s.eventID(1), false, nil},
+		{s.eventID(6), false, nil},
+		{"foo-3", false, nil},
+		{"3", false, nil},
+	}
+
+	for i, test := range tests {
+		ch, backlog, complete := s.subscribe(test.lastEventID)
+		s.unsubscribe(ch)
+
+		if complete != test.complete {
+			t.Errorf("test %d: want complete %v, got %v", i, test.complete, complete)
+			continue
+		}
+
+		var ids []int64
+		for _, ev := range backlog {
+			ids = append(ids, ev.change.ID)
+		}
+
+		if fmt.Sprint(ids) != fmt.Sprint(test.backlog) {
+			t.Errorf("test %d: wrong backlog, want %v, got %v", i, test.backlog, ids)
+		}
+	}
+}
+
+func TestChangeStreamSlowSubscriber(t *testing.T) {
+	s := newChangeStream(changeBufferSize)
+
+	ch, _, _ := s.subscribe("")
+	for i := 0; i <= changeSubscriberBuffer; i++ {
+		s.publish(db.Change{ID: int64(i)})
+	}
+
+	n := 0
+	for range ch {
+		n++
+	}
+
+	if n != changeSubscriberBuffer {
+		t.Fatalf("want %d changes before disconnect, got %d", changeSubscriberBuffer, n)
+	}
+}
+
+type streamEvent struct {
+	id, event, data string
+}
+
+// readStream sends the events read from rd to the returned channel, which is
+// closed at the end of the stream.
+func readStream(rd io.Reader) <-chan streamEvent {
+	ch := make(chan streamEvent)
+	go func() {
+		defer close(ch)
+
+		var ev streamEvent
+		sc := bufio.NewScanner(rd)
+		for sc.Scan() {
+			line := sc.Text()
+			switch {
+			case line == "":
+				ch <- ev
+				ev = streamEvent{}
+			case strings.HasPrefix(line, "id: "):
+				ev.id = line[4:]
+			case strings.HasPrefix(line, "event: "):
+				ev.event = line[7:]
+			case strings.HasPrefix(line, "data: "):
+				ev.data = line[6:]
+			}
+		}
+	}()
+
+	return ch
+}
+
+func openStream(t *testing.T, token, url, lastEventID string) (*http.Response, <-chan streamEvent) {
+	req, err := http.NewRequest("GET", url+"/api/events", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req.Header.Set("X-Auth-Token", token)
+	if lastEventID != "" {
+		req.Header.Set("Last-Event-ID", lastEventID)
+	}
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if res.StatusCode != 200 || res.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("unexpected response, status %d, content type %q", res.StatusCode, res.Header.Get("Content-Type"))
+	}
+
+	return res, readStream(res.Body)
+}
+
+func nextEvent(t *testing.T, ch <-chan streamEvent) streamEvent {
+	select {
+	case ev, ok := <-ch:
+		if !ok {
+			t.Fatalf("stream was closed")
+		}
+		return ev
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timeout waiting for event")
+	}
+
+	return streamEvent{}
+}
+
+type change struct {
+	Action    string `json:"action"`
+	Entity    string `json:"entity"`
+	ID        int    `json:"id"`
+	Version   int    `json:"version"`
+	ChangedBy int    `json:"changed_by"`
+}
+
+func nextChange(t *testing.T, ch <-chan streamEvent) (string, change) {
+	ev := nextEvent(t, ch)
+	if ev.event != "change" || ev.id == "" {
+		t.Fatalf("unexpected event %+v", ev)
+	}
+
+	var c change
+	if err := json.Unmarshal([]byte(ev.data), &c); err != nil {
+		t.Fatal(err)
+	}
+
+	return ev.id, c
+}
+
+func TestEventStream(t *testing.T) {
+	env, cleanup := TestEnv(t)
+	defer cleanup()
+
+	// the server must be closed after the context is cancelled, otherwise
+	// it waits for the stream to end.
+	ctx, cancel := context.WithCancel(context.Background())
+	srv := &TestSrv{Server: httptest.NewServer(NewRouter(ctx, env)), Env: env}
+	defer srv.Close()
+	defer cancel()
+
+	token := login(t, srv, "admin", "geheim")
+	userToken := login(t, srv, "user", "geheim")
+
+	res, events := openStream(t, token, srv.URL, "")
+	defer res.Body.Close()
+
+	userRes, userEvents := openStream(t, userToken, srv.URL, "")
+	defer userRes.Body.Close()
+
+	status, body := request(t, token, "POST", srv.URL+"/api/user", []byte(`{"login": "stream", "password": "x"}`))
+	if status != 201 {
+		t.Fatalf("creating user failed with status %d: %s", status, body)
+	}
+
+	status, body = request(t, token, "POST", srv.URL+"/api/person", []byte(`{"name": "Stream Person"}`))
+	if status != 201 {
+		t.Fatalf("creating person failed with status %d: %s", status, body)
+	}
+	person := verifyPerson(t, "Stream Person", body)
+
+	_, c := nextChange(t, events)
+	if c.Action != "create" || c.Entity != "user" || c.ChangedBy == 0 {
+		t.Fatalf("unexpected change %+v", c)
+	}
+
+	id, c := nextChange(t, events)
+	if c.Action != "create" || c.Entity != "person" || c.ID != person.ID || c.Version != 1 {
+		t.Fatalf("unexpected change %+v", c)
+	}
+
+	// users which are not admin do not see changes of users
+	if _, c = nextChange(t, userEvents); c.Entity != "person" {
+		t.Fatalf("unexpected change for non-admin %+v", c)
+	}
+
+	deletePerson(t, token, srv.URL, person.ID)
+
+	if _, c = nextChange(t, events); c.Action != "delete" || c.ID != person.ID || c.Version != 1 {
+		t.Fatalf("unexpected change %+v", c)
+	}
+
+	// resume after the creation of the person
+	res2, events2 := openStream(t, token, srv.URL, id)
+	defer res2.Body.Close()
+
+	if _, c = nextChange(t, events2); c.Action != "delete" || c.ID != person.ID {
+		t.Fatalf("unexpected change after resume %+v", c)
+	}
+
+	res3, events3 := openStream(t, token, srv.URL, "foo-23")
+	defer res3.Body.Close()
+
+	if ev := nextEvent(t, events3); ev.event != "reset" {
+		t.Fatalf("expected reset event for unknown ID, got %+v", ev)
+	}
+
+	cancel()
+
+	for _, ch := range []<-chan streamEvent{events, userEvents, events2, events3} {
+		select {
+		case ev, ok := <-ch:
+			if ok {
+				t.Fatalf("unexpected event %+v", ev)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("stream was not closed after the context was cancelled")
+		}
+	}
+}
@@ -0,0 +1,249 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"ghenga/db"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"golang.org/x/net/context"
+)
+
+// ListTasks handles listing task records. Tasks for people which the current
+// user may not see are left out.
+func ListTasks(ctx context.Context, env *Env, res http.ResponseWriter, req *http.Request) error {
+	vdb, err := visibleDB(ctx, env)
+	if err != nil {
+		return err
+	}
+
+	tasks, err := vdb.ListTasks()
+	if err != nil {
+		return err
+	}
+
+	return httpWriteJSON(res, http.StatusOK, tasks)
+}
+
+// parseDate parses a date given in a query string. Both the timestamp format
+// used in the JSON documents and a plain date are accepted.
+func parseDate(s string) (time.Time, error) {
+	t, err := time.Parse("2006-01-02T15:04:05-07:00", s)
+	if err == nil {
+		return t, nil
+	}
+
+	return time.ParseInLocation("2006-01-02", s, time.Local)
+}
+
+// ListMyTasks returns the tasks assigned to the current user. The query
+// parameter `status` selects the status of the tasks, `due_before` only
+// returns tasks which are due before the given date.
+func ListMyTasks(ctx context.Context, env *Env, res http.ResponseWriter, req *http.Request) error {
+	u, err := currentUser(ctx, env)
+	if err != nil {
+		return err
+	}
+
+	status := req.URL.Query().Get("status")
+	switch status {
+	case "", db.TaskOpen, db.TaskDone, db.TaskCancelled:
+	default:
+		return StatusError{
+			Code: http.StatusBadRequest,
+			Err:  errors.New("invalid status"),
+		}
+	}
+
+	var dueBefore time.Time
+	if s := req.URL.Query().Get("due_before"); s != "" {
+		dueBefore, err = parseDate(s)
+		if err != nil {
+			return StatusError{Code: http.StatusBadRequest, Err: err}
+		}
+	}
+
+	tasks, err := env.DB.VisibleTo(u).ListUserTasks(u.ID, status, dueBefore)
+	if err != nil {
+		return err
+	}
+
+	return httpWriteJSON(res, http.StatusOK, tasks)
+}
+
+// findTask returns the task with the ID from the URL. Tasks for people which
+// the current user may not see are reported as not found.
+func findTask(ctx context.Context, env *Env, req *http.Request) (*db.Task, error) {
+	id, err := strconv.Atoi(mux.Vars(req)["id"])
+	if err != nil {
+		return nil, StatusError{Code: http.StatusBadRequest, Err: err}
+	}
+
+	notFound := StatusError{
+		Err:  errors.New("task not found"),
+		Code: http.StatusNotFound,
+	}
+
+	t, err := env.DB.FindTask(int64(id))
+	if err != nil {
+		return nil, notFound
+	}
+
+	if _, err = findPerson(ctx, env, t.PersonID); err != nil {
+		return nil, notFound
+	}
+
+	return t, nil
+}
+
+// ShowTask returns a Task record.
+func ShowTask(ctx context.Context, env *Env, res http.ResponseWriter, req *http.Request) error {
+	task, err := findTask(ctx, env, req)
+	if err != nil {
+		return err
+	}
+
+	return httpWriteJSON(res, http.StatusOK, task)
+}
+
+// checkTaskReferences returns an error if a record referenced by t does not
+// exist or the person is not visible to the current user.
+func checkTaskReferences(ctx context.Context, env *Env, t *db.Task) error {
+	if _, err := findPerson(ctx, env, t.PersonID); err != nil {
+		return StatusError{
+			Err:  errors.New("person not found"),
+			Code: http.StatusBadRequest,
+		}
+	}
+
+	if t.AccountID.Valid {
+		if _, err := env.DB.FindAccount(t.AccountID.Int64); err != nil {
+			return StatusError{
+				Err:  errors.New("account not found"),
+				Code: http.StatusBadRequest,
+			}
+		}
+	}
+
+	if t.AssigneeID.Valid {
+		if _, err := env.DB.FindUser(t.AssigneeID.Int64); err != nil {
+			return StatusError{
+				Err:  errors.New("assignee not found"),
+				Code: http.StatusBadRequest,
+			}
+		}
+	}
+
+	return nil
+}
+
+// CreateTask inserts a new task into the database. The request body must be valid JSON.
+func CreateTask(ctx context.Context, env *Env, wr http.ResponseWriter, req *http.Request) (err error) {
+	defer cleanupErr(&err, req.Body.Close)
+
+	var jt db.TaskJSON
+	dec := json.NewDecoder(req.Body)
+	if err = dec.Decode(&jt); err != nil {
+		return err
+	}
+
+	var t db.Task
+	if err = t.Update(jt); err != nil {
+		return StatusError{Code: http.StatusBadRequest, Err: err}
+	}
+
+	// overwrite fields we'd like to be set
+	t.CreatedAt = time.Now()
+	t.ChangedAt = time.Now()
+
+	if err = t.Validate(); err != nil {
+		return StatusError{Code: http.StatusBadRequest, Err: err}
+	}
+
+	if err = checkTaskReferences(ctx, env, &t); err != nil {
+		return err
+	}
+
+	err = env.DB.InsertTask(&t)
+	if err != nil {
+		return err
+	}
+
+	env.Debugf("created task %v", t)
+
+	return httpWriteJSON(wr, http.StatusCreated, t)
+}
+
+// UpdateTask changes an existing task record. The request body must be valid JSON.
+func UpdateTask(ctx context.Context, env *Env, wr http.ResponseWriter, req *http.Request) (err error) {
+	defer cleanupErr(&err, req.Body.Close)
+
+	t, err := findTask(ctx, env, req)
+	if err != nil {
+		return err
+	}
+
+	var newTask db.TaskJSON
+	dec := json.NewDecoder(req.Body)
+	if err = dec.Decode(&newTask); err != nil {
+		return err
+	}
+
+	if t.Version != newTask.Version {
+		env.Debugf("task record is outdated, version %v != %v",
+			t.Version, newTask.Version)
+		return StatusError{
+			Err:  errors.New("version field does not match"),
+			Code: http.StatusConflict,
+		}
+	}
+
+	if err = t.Update(newTask); err != nil {
+		return StatusError{Code: http.StatusBadRequest, Err: err}
+	}
+
+	t.ChangedAt = time.Now()
+
+	if err = t.Validate(); err != nil {
+		return StatusError{Code: http.StatusBadRequest, Err: err}
+	}
+
+	if err = checkTaskReferences(ctx, env, t); err != nil {
+		return err
+	}
+
+	err = env.DB.UpdateTask(t)
+	if err != nil {
+		env.Logf("unable update task %v, sql error: %v", t, err)
+		return err
+	}
+
+	return httpWriteJSON(wr, http.StatusOK, t)
+}
+
+// DeleteTask removes a task from the database.
+func DeleteTask(ctx context.Context, env *Env, wr http.ResponseWriter, req *http.Request) (err error) {
+	t, err := findTask(ctx, env, req)
+	if err != nil {
+		return err
+	}
+
+	if err := env.DB.DeleteTask(t.ID); err != nil {
+		return err
+	}
+
+	return httpWriteJSON(wr, http.StatusOK, nil)
+}
+
+// TaskHandler adds routes for ghenga API in the given enviroment to r.
+func TaskHandler(ctx context.Context, env *Env, r *mux.Router) {
+	r.Handle("/api/task", Handle(ctx, env, RequireAuth(ListTasks))).Methods("GET")
+	r.Handle("/api/task", Handle(ctx, env, RequireAuth(Audit(db.ActionCreate, "task", CreateTask)))).Methods("POST")
+	r.Handle("/api/task/mine", Handle(ctx, env, RequireAuth(ListMyTasks))).Methods("GET")
+	r.Handle("/api/task/{id}", Handle(ctx, env, RequireAuth(ShowTask))).Methods("GET")
+	r.Handle("/api/task/{id}", Handle(ctx, env, RequireAuth(Audit(db.ActionUpdate, "task", UpdateTask)))).Methods("PUT")
+	r.Handle("/api/task/{id}", Handle(ctx, env, RequireAuth(Audit(db.ActionDelete, "task", DeleteTask)))).Methods("DELETE")
+}
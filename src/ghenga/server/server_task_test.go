@@ -0,0 +1,152 @@
+package server
+
+import (
+	"fmt"
+	"testing"
+)
+
+type Task struct {
+	ID         int    `json:"id"`
+	Title      string `json:"title"`
+	PersonID   int    `json:"person_id"`
+	AssigneeID int    `json:"assignee_id"`
+	Status     string `json:"status"`
+	Version    int    `json:"version"`
+}
+
+func verifyTask(t *testing.T, title string, data []byte) Task {
+	var task Task
+
+	unmarshal(t, data, &task)
+
+	if task.ID == 0 {
+		t.Fatalf("task has ID 0")
+	}
+
+	if task.Title != title {
+		t.Fatalf("title does not match, want %q, got %q", title, task.Title)
+	}
+
+	return task
+}
+
+func TestTaskCRUD(t *testing.T) {
+	srv, cleanup := TestServer(t)
+	defer cleanup()
+
+	token := login(t, srv, "admin", "geheim")
+
+	status, body := request(t, token, "POST", srv.URL+"/api/task", []byte(`{"title": "Call back", "person_id": 1}`))
+	if status != 201 {
+		t.Fatalf("invalid status code, want 201, got %v, body:\n  %s", status, body)
+	}
+
+	task := verifyTask(t, "Call back", body)
+	if task.Status != "open" {
+		t.Fatalf("new task has status %q, want %q", task.Status, "open")
+	}
+
+	task.Status = "done"
+	status, body = request(t, token, "PUT", fmt.Sprintf("%s/api/task/%d", srv.URL, task.ID), marshal(t, task))
+	if status != 200 {
+		t.Fatalf("updating task, invalid status %d: %s", status, body)
+	}
+
+	task = verifyTask(t, task.Title, body)
+	if task.Status != "done" {
+		t.Fatalf("updated task has status %q, want %q", task.Status, "done")
+	}
+
+	task.Version--
+	status, _ = request(t, token, "PUT", fmt.Sprintf("%s/api/task/%d", srv.URL, task.ID), marshal(t, task))
+	if status != 409 {
+		t.Fatalf("updating outdated task, want status 409, got %d", status)
+	}
+
+	status, body = request(t, token, "DELETE", fmt.Sprintf("%s/api/task/%d", srv.URL, task.ID), nil)
+	if status != 200 {
+		t.Fatalf("deleting task yielded unexpected status %d: %s", status, body)
+	}
+}
+
+var invalidTaskTests = []string{
+	`{}`,
+	`{"title": "foo"}`,
+	`{"title": "foo", "person_id": 999999}`,
+	`{"title": "foo", "person_id": 1, "status": "postponed"}`,
+	`{"title": "foo", "person_id": 1, "due_date": "tomorrow"}`,
+}
+
+func TestInvalidTask(t *testing.T) {
+	srv, cleanup := TestServer(t)
+	defer cleanup()
+
+	token := login(t, srv, "admin", "geheim")
+
+	for _, test := range invalidTaskTests {
+		status, body := request(t, token, "POST", srv.URL+"/api/task", []byte(test))
+		if status != 400 {
+			t.Fatalf("status code for invalid task not found, want 400, got %v, body:\n  %s", status, body)
+		}
+	}
+}
+
+func TestMyTasks(t *testing.T) {
+	srv, cleanup := TestServer(t)
+	defer cleanup()
+
+	u, err := srv.DB.FindUserName("user")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token := login(t, srv, "user", "geheim")
+
+	for _, test := range []string{
+		fmt.Sprintf(`{"title": "task1", "person_id": 1, "assignee_id": %d, "due_date": "2016-05-01T10:00:00+00:00"}`, u.ID),
+		fmt.Sprintf(`{"title": "task2", "person_id": 1, "assignee_id": %d, "due_date": "2016-06-01T10:00:00+00:00"}`, u.ID),
+		fmt.Sprintf(`{"title": "task3", "person_id": 1, "assignee_id": %d, "status": "done"}`, u.ID),
+		`{"title": "task4", "person_id": 1}`,
+	} {
+		status, body := request(t, token, "POST", srv.URL+"/api/task", []byte(test))
+		if status != 201 {
+			t.Fatalf("invalid status code, want 201, got %v, body:\n  %s", status, body)
+		}
+	}
+
+	var tests = []struct {
+		query  string
+		titles []string
+	}{
+		{"", []string{"task1", "task2", "task3"}},
+		{"?status=open", []string{"task1", "task2"}},
+		{"?status=open&due_before=2016-05-15", []string{"task1"}},
+		{"?status=done", []string{"task3"}},
+	}
+
+	for _, test := range tests {
+		status, body := request(t, token, "GET", srv.URL+"/api/task/mine"+test.query, nil)
+		if status != 200 {
+			t.Fatalf("listing my tasks yielded unexpected status %d: %s", status, body)
+		}
+
+		var list []Task
+		unmarshal(t, body, &list)
+
+		if len(list) != len(test.titles) {
+			t.Errorf("query %q: wrong number of tasks returned, want %v, got %v", test.query, len(test.titles), len(list))
+			continue
+		}
+
+		for i, title := range test.titles {
+			if list[i].Title != title {
+				t.Errorf("query %q: wrong task %d returned, want %q, got %q", test.query, i, title, list[i].Title)
+			}
+		}
+	}
+
+	status, _ := request(t, token, "GET", srv.URL+"/api/task/mine?status=foo", nil)
+	if status != 400 {
+		t.Fatalf("listing my tasks with invalid status, want status 400, got %d", status)
+	}
+}
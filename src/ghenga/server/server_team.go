@@ -0,0 +1,162 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"ghenga/db"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"golang.org/x/net/context"
+)
+
+// ListTeams handles listing teams.
+func ListTeams(ctx context.Context, env *Env, res http.ResponseWriter, req *http.Request) error {
+	teams, err := env.DB.ListTeams()
+	if err != nil {
+		return err
+	}
+
+	return httpWriteJSON(res, http.StatusOK, teams)
+}
+
+// findTeam returns the team with the ID from the URL.
+func findTeam(env *Env, req *http.Request) (*db.Team, error) {
+	id, err := strconv.Atoi(mux.Vars(req)["id"])
+	if err != nil {
+		return nil, StatusError{Code: http.StatusBadRequest, Err: err}
+	}
+
+	t, err := env.DB.FindTeam(int64(id))
+	if err != nil {
+		return nil, StatusError{
+			Err:  errors.New("team not found"),
+			Code: http.StatusNotFound,
+		}
+	}
+
+	return t, nil
+}
+
+// checkMembers returns an error if a member of t does not exist.
+func checkMembers(env *Env, t *db.Team) error {
+	for _, id := range t.Members {
+		if _, err := env.DB.FindUser(id); err != nil {
+			return StatusError{
+				Err:  fmt.Errorf("user %d not found", id),
+				Code: http.StatusBadRequest,
+			}
+		}
+	}
+
+	return nil
+}
+
+// ShowTeam returns a team.
+func ShowTeam(ctx context.Context, env *Env, res http.ResponseWriter, req *http.Request) error {
+	t, err := findTeam(env, req)
+	if err != nil {
+		return err
+	}
+
+	return httpWriteJSON(res, http.StatusOK, t)
+}
+
+// CreateTeam inserts a new team. The request body must be valid JSON.
+func CreateTeam(ctx context.Context, env *Env, wr http.ResponseWriter, req *http.Request) (err error) {
+	defer cleanupErr(&err, req.Body.Close)
+
+	var jt db.TeamJSON
+	dec := json.NewDecoder(req.Body)
+	if err = dec.Decode(&jt); err != nil {
+		return StatusError{Code: http.StatusBadRequest, Err: err}
+	}
+
+	var t db.Team
+	t.Update(jt)
+
+	// overwrite fields we'd like to be set
+	t.CreatedAt = time.Now()
+	t.ChangedAt = time.Now()
+
+	if err = t.Validate(); err != nil {
+		return StatusError{Code: http.StatusBadRequest, Err: err}
+	}
+
+	if err = checkMembers(env, &t); err != nil {
+		return err
+	}
+
+	if err = env.DB.InsertTeam(&t); err != nil {
+		return err
+	}
+
+	env.Debugf("created team %v", t)
+
+	return httpWriteJSON(wr, http.StatusCreated, t)
+}
+
+// UpdateTeam changes an existing team. The request body must be valid JSON.
+func UpdateTeam(ctx context.Context, env *Env, wr http.ResponseWriter, req *http.Request) (err error) {
+	defer cleanupErr(&err, req.Body.Close)
+
+	t, err := findTeam(env, req)
+	if err != nil {
+		return err
+	}
+
+	var jt db.TeamJSON
+	dec := json.NewDecoder(req.Body)
+	if err = dec.Decode(&jt); err != nil {
+		return StatusError{Code: http.StatusBadRequest, Err: err}
+	}
+
+	if t.Version != jt.Version {
+		env.Debugf("team is outdated, version %v != %v", t.Version, jt.Version)
+		return StatusError{Code: http.StatusConflict, Err: errVersionMismatch}
+	}
+
+	t.Update(jt)
+	t.ChangedAt = time.Now()
+
+	if err = t.Validate(); err != nil {
+		return StatusError{Code: http.StatusBadRequest, Err: err}
+	}
+
+	if err = checkMembers(env, t); err != nil {
+		return err
+	}
+
+	if err = env.DB.UpdateTeam(t); err != nil {
+		env.Logf("unable to update team %v: %v", t, err)
+		return versionError(req, err)
+	}
+
+	return httpWriteJSON(wr, http.StatusOK, t)
+}
+
+// DeleteTeam removes a team.
+func DeleteTeam(ctx context.Context, env *Env, wr http.ResponseWriter, req *http.Request) error {
+	t, err := findTeam(env, req)
+	if err != nil {
+		return err
+	}
+
+	if err = env.DB.DeleteTeam(t.ID); err != nil {
+		return err
+	}
+
+	return httpWriteJSON(wr, http.StatusOK, nil)
+}
+
+// TeamHandler adds routes for managing teams to r.
+func TeamHandler(ctx context.Context, env *Env, r *mux.Router) {
+	r.Handle("/api/team", Handle(ctx, env, RequireAdmin(ListTeams))).Methods("GET")
+	r.Handle("/api/team", Handle(ctx, env, RequireAdmin(Audit(db.ActionCreate, "team", CreateTeam)))).Methods("POST")
+	r.Handle("/api/team/{id}", Handle(ctx, env, RequireAdmin(ShowTeam))).Methods("GET")
+	r.Handle("/api/team/{id}", Handle(ctx, env, RequireAdmin(Audit(db.ActionUpdate, "team", UpdateTeam)))).Methods("PUT")
+	r.Handle("/api/team/{id}", Handle(ctx, env, RequireAdmin(Audit(db.ActionDelete, "team", DeleteTeam)))).Methods("DELETE")
+}
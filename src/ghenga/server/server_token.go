@@ -0,0 +1,130 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"ghenga/db"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/lib/pq"
+	"golang.org/x/net/context"
+)
+
+// APITokenRequestJSON is the structure of a request to create a personal API
+// token.
+type APITokenRequestJSON struct {
+	Name      string     `json:"name"`
+	ReadOnly  bool       `json:"read_only"`
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+// ListAPITokens returns the personal API tokens of the current user. The
+// tokens themselves are not included.
+func ListAPITokens(ctx context.Context, env *Env, res http.ResponseWriter, req *http.Request) error {
+	u, err := currentUser(ctx, env)
+	if err != nil {
+		return err
+	}
+
+	tokens, err := env.DB.ListAPITokens(u.ID)
+	if err != nil {
+		return err
+	}
+
+	return httpWriteJSON(res, http.StatusOK, tokens)
+}
+
+// CreateAPIToken creates a new personal API token for the current user. The
+// token is only contained in this response. A token cannot be used to create
+// further tokens.
+func CreateAPIToken(ctx context.Context, env *Env, res http.ResponseWriter, req *http.Request) (err error) {
+	defer cleanupErr(&err, req.Body.Close)
+
+	session, ok := db.SessionFromContext(ctx)
+	if ok && session.APITokenID != 0 {
+		return StatusError{
+			Code: http.StatusForbidden,
+			Err:  errors.New("API tokens cannot be created with an API token"),
+		}
+	}
+
+	u, err := currentUser(ctx, env)
+	if err != nil {
+		return err
+	}
+
+	var tr APITokenRequestJSON
+	dec := json.NewDecoder(req.Body)
+	if err = dec.Decode(&tr); err != nil {
+		return StatusError{Code: http.StatusBadRequest, Err: err}
+	}
+
+	var expiresAt pq.NullTime
+	if tr.ExpiresAt != nil {
+		expiresAt = pq.NullTime{Time: *tr.ExpiresAt, Valid: true}
+	}
+
+	t, err := db.NewAPIToken(u.ID, tr.Name, tr.ReadOnly, expiresAt)
+	if err != nil {
+		return err
+	}
+
+	if err = t.Validate(); err != nil {
+		return StatusError{Code: http.StatusBadRequest, Err: err}
+	}
+
+	tokens, err := env.DB.ListAPITokens(u.ID)
+	if err != nil {
+		return err
+	}
+
+	for _, other := range tokens {
+		if other.Name == t.Name {
+			return StatusError{
+				Code: http.StatusConflict,
+				Err:  errors.New("a token with this name already exists"),
+			}
+		}
+	}
+
+	if err = env.DB.InsertAPIToken(t); err != nil {
+		return err
+	}
+
+	env.Debugf("created API token %v", t)
+
+	return httpWriteJSON(res, http.StatusCreated, t)
+}
+
+// RevokeAPIToken removes a personal API token of the current user, it cannot
+// be used any more.
+func RevokeAPIToken(ctx context.Context, env *Env, res http.ResponseWriter, req *http.Request) error {
+	id, err := strconv.Atoi(mux.Vars(req)["id"])
+	if err != nil {
+		return StatusError{Code: http.StatusBadRequest, Err: err}
+	}
+
+	u, err := currentUser(ctx, env)
+	if err != nil {
+		return err
+	}
+
+	if err = env.DB.DeleteAPIToken(u.ID, int64(id)); err != nil {
+		return StatusError{
+			Err:  errors.New("token not found"),
+			Code: http.StatusNotFound,
+		}
+	}
+
+	return httpWriteJSON(res, http.StatusOK, nil)
+}
+
+// APITokenHandler adds routes for managing personal API tokens to r.
+func APITokenHandler(ctx context.Context, env *Env, r *mux.Router) {
+	r.Handle("/api/me/tokens", Handle(ctx, env, RequireAuth(ListAPITokens))).Methods("GET")
+	r.Handle("/api/me/tokens", Handle(ctx, env, RequireAuth(Audit(db.ActionCreate, "api_token", CreateAPIToken)))).Methods("POST")
+	r.Handle("/api/me/tokens/{id}", Handle(ctx, env, RequireAuth(Audit(db.ActionDelete, "api_token", RevokeAPIToken)))).Methods("DELETE")
+}
@@ -0,0 +1,183 @@
+package server
+
+import (
+	"fmt"
+	"ghenga/db"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+var requestTokenTests = []struct {
+	header http.Header
+	token  string
+}{
+	{http.Header{}, ""},
+	{http.Header{"X-Auth-Token": {"abc"}}, "abc"},
+	{http.Header{"Authorization": {"Bearer ghenga_123"}}, "ghenga_123"},
+	{http.Header{"Authorization": {"bearer  ghenga_123 "}}, "ghenga_123"},
+	{http.Header{"Authorization": {"Basic dXNlcjpnZWhlaW0="}}, ""},
+	{http.Header{"Authorization": {"Bearer "}}, ""},
+	{http.Header{"X-Auth-Token": {"abc"}, "Authorization": {"Bearer ghenga_123"}}, "abc"},
+}
+
+func TestRequestToken(t *testing.T) {
+	for i, test := range requestTokenTests {
+		req := &http.Request{Header: test.header}
+		if token := requestToken(req); token != test.token {
+			t.Errorf("test %d: want token %q, got %q", i, test.token, token)
+		}
+	}
+}
+
+type apiToken struct {
+	ID         int     `json:"id"`
+	Name       string  `json:"name"`
+	Token      string  `json:"token"`
+	ReadOnly   bool    `json:"read_only"`
+	ExpiresAt  *string `json:"expires_at"`
+	LastUsedAt *string `json:"last_used_at"`
+}
+
+func createAPIToken(t *testing.T, token, url, body string) apiToken {
+	status, buf := request(t, token, "POST", url+"/api/me/tokens", []byte(body))
+	if status != http.StatusCreated {
+		t.Fatalf("creating token failed with status %v: %s", status, buf)
+	}
+
+	var tok apiToken
+	unmarshal(t, buf, &tok)
+	if tok.Token == "" {
+		t.Fatalf("new token not returned: %s", buf)
+	}
+
+	return tok
+}
+
+func bearerRequest(t *testing.T, token, method, url string, body []byte) (int, []byte) {
+	header := http.Header{"Authorization": {"Bearer " + token}}
+	if body != nil {
+		header.Set("Content-Type", "application/json")
+	}
+
+	res, buf := requestHeader(t, "", method, url, header, body)
+	return res.StatusCode, buf
+}
+
+func TestAPITokens(t *testing.T) {
+	srv, cleanup := TestServer(t)
+	defer cleanup()
+
+	session := login(t, srv, "user", "geheim")
+
+	full := createAPIToken(t, session, srv.URL, `{"name": "sync"}`)
+	readOnly := createAPIToken(t, session, srv.URL, `{"name": "report", "read_only": true}`)
+
+	status, body := request(t, session, "POST", srv.URL+"/api/me/tokens", []byte(`{"name": "sync"}`))
+	if status != http.StatusConflict {
+		t.Errorf("token with duplicate name was created, status %v: %s", status, body)
+	}
+
+	status, body = request(t, session, "POST", srv.URL+"/api/me/tokens", []byte(`{"name": "old", "expires_at": "2000-01-01T00:00:00Z"}`))
+	if status != http.StatusBadRequest {
+		t.Errorf("token with expiry in the past was created, status %v: %s", status, body)
+	}
+
+	for _, test := range []struct {
+		token, method, path, body string
+		status                    int
+	}{
+		{full.Token, "GET", "/api/person/1", "", http.StatusOK},
+		{full.Token, "PATCH", "/api/person/1", `{"title": "CTO"}`, http.StatusOK},
+		{full.Token, "GET", "/api/login/info", "", http.StatusOK},
+		{full.Token, "GET", "/api/login/invalidate", "", http.StatusBadRequest},
+		{full.Token, "POST", "/api/me/tokens", `{"name": "more"}`, http.StatusForbidden},
+		{readOnly.Token, "GET", "/api/person/1", "", http.StatusOK},
+		{readOnly.Token, "GET", "/api/search/person?query=a", "", http.StatusOK},
+		{readOnly.Token, "PATCH", "/api/person/1", `{"title": "CEO"}`, http.StatusForbidden},
+		{readOnly.Token, "DELETE", "/api/person/1", "", http.StatusForbidden},
+		{"ghenga_0123", "GET", "/api/person/1", "", http.StatusUnauthorized},
+	} {
+		var data []byte
+		if test.body != "" {
+			data = []byte(test.body)
+		}
+
+		status, body := bearerRequest(t, test.token, test.method, srv.URL+test.path, data)
+		if status != test.status {
+			t.Errorf("%v %v: want status %v, got %v: %s", test.method, test.path, test.status, status, body)
+		}
+	}
+
+	// the header X-Auth-Token also accepts API tokens
+	if status, body := request(t, readOnly.Token, "GET", srv.URL+"/api/person/1", nil); status != http.StatusOK {
+		t.Errorf("API token in X-Auth-Token was rejected, status %v: %s", status, body)
+	}
+
+	var tokens []apiToken
+	status, body = request(t, session, "GET", srv.URL+"/api/me/tokens", nil)
+	if status != http.StatusOK {
+		t.Fatalf("listing tokens failed with status %v: %s", status, body)
+	}
+	unmarshal(t, body, &tokens)
+
+	if len(tokens) != 2 {
+		t.Fatalf("wrong number of tokens listed: %s", body)
+	}
+
+	for _, tok := range tokens {
+		if tok.Token != "" {
+			t.Errorf("token %v is shown again: %s", tok.Name, body)
+		}
+
+		if tok.LastUsedAt == nil {
+			t.Errorf("token %v has not been marked as used: %s", tok.Name, body)
+		}
+	}
+
+	// tokens of other users cannot be revoked
+	other := login(t, srv, "admin", "geheim")
+	status, body = request(t, other, "DELETE", fmt.Sprintf("%s/api/me/tokens/%d", srv.URL, full.ID), nil)
+	if status != http.StatusNotFound {
+		t.Errorf("token of another user was revoked, status %v: %s", status, body)
+	}
+
+	status, body = request(t, session, "DELETE", fmt.Sprintf("%s/api/me/tokens/%d", srv.URL, full.ID), nil)
+	if status != http.StatusOK {
+		t.Fatalf("revoking token failed with status %v: %s", status, body)
+	}
+
+	if status, body = bearerRequest(t, full.Token, "GET", srv.URL+"/api/person/1", nil); status != http.StatusUnauthorized {
+		t.Errorf("revoked token is still accepted, status %v: %s", status, body)
+	}
+
+	expires := time.Now().Add(time.Hour).UTC().Format(time.RFC3339)
+	tok := createAPIToken(t, session, srv.URL, fmt.Sprintf(`{"name": "expiring", "expires_at": %q}`, expires))
+	if tok.ExpiresAt == nil {
+		t.Errorf("expiry not returned for new token")
+	}
+
+	if status, body = bearerRequest(t, tok.Token, "GET", srv.URL+"/api/person/1", nil); status != http.StatusOK {
+		t.Errorf("token with expiry was rejected, status %v: %s", status, body)
+	}
+
+	u, err := srv.DB.FindUserName("user")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expired, err := db.NewAPIToken(u.ID, "expired", false, pq.NullTime{Time: time.Now().Add(-time.Minute), Valid: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = srv.DB.InsertAPIToken(expired); err != nil {
+		t.Fatal(err)
+	}
+
+	if status, body = bearerRequest(t, expired.Token, "GET", srv.URL+"/api/person/1", nil); status != http.StatusUnauthorized {
+		t.Errorf("expired token is still accepted, status %v: %s", status, body)
+	}
+}
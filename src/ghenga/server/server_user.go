@@ -3,6 +3,7 @@ package server
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
 	"ghenga/db"
 	"net/http"
 	"strconv"
@@ -12,13 +13,24 @@ import (
 	"golang.org/x/net/context"
 )
 
-// ListUsers handles listing users.
+// ListUsers handles listing users. The list is paginated, sorted and filtered
+// according to the query parameters, see parseListOptions.
 func ListUsers(ctx context.Context, env *Env, res http.ResponseWriter, req *http.Request) error {
-	users, err := env.DB.ListUsers()
+	opts, err := parseListOptions(req)
 	if err != nil {
 		return err
 	}
 
+	if err = filterCurrentUser(ctx, env, opts); err != nil {
+		return err
+	}
+
+	users, page, err := env.DB.ListUsersPage(opts)
+	if err != nil {
+		return listError(err)
+	}
+
+	writePage(res, page)
 	return httpWriteJSON(res, http.StatusOK, users)
 }
 
@@ -37,9 +49,58 @@ func ShowUser(ctx context.Context, env *Env, res http.ResponseWriter, req *http.
 		}
 	}
 
+	if notModified(res, req, userETag(u)) {
+		return nil
+	}
+
 	return httpWriteJSON(res, http.StatusOK, u)
 }
 
+// checkRoles returns an error if one of the roles of u does not exist. Only
+// admins may modify users with the role admin and grant or remove the role,
+// wasAdmin is set if the user had the role before the change.
+func checkRoles(ctx context.Context, env *Env, u *db.User, wasAdmin bool) error {
+	roles, err := env.DB.ListRoles()
+	if err != nil {
+		return err
+	}
+
+	for _, name := range u.Roles {
+		found := false
+		for _, r := range roles {
+			if r.Name == name {
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			return StatusError{
+				Code: http.StatusBadRequest,
+				Err:  fmt.Errorf("unknown role %q", name),
+			}
+		}
+	}
+
+	if !u.Admin && !wasAdmin {
+		return nil
+	}
+
+	cur, err := currentUser(ctx, env)
+	if err != nil {
+		return err
+	}
+
+	if !cur.Admin {
+		return StatusError{
+			Code: http.StatusForbidden,
+			Err:  errors.New("only admins may modify admins"),
+		}
+	}
+
+	return nil
+}
+
 // CreateUser inserts a new person into the database. The request body must be valid JSON.
 func CreateUser(ctx context.Context, env *Env, wr http.ResponseWriter, req *http.Request) (err error) {
 	defer cleanupErr(&err, req.Body.Close)
@@ -61,6 +122,14 @@ func CreateUser(ctx context.Context, env *Env, wr http.ResponseWriter, req *http
 		return StatusError{Code: http.StatusBadRequest, Err: err}
 	}
 
+	if err = checkRoles(ctx, env, &u, false); err != nil {
+		return err
+	}
+
+	if u.ChangedBy, err = changedBy(ctx, env); err != nil {
+		return err
+	}
+
 	err = env.DB.InsertUser(&u)
 	if err != nil {
 		return err
@@ -68,6 +137,7 @@ func CreateUser(ctx context.Context, env *Env, wr http.ResponseWriter, req *http
 
 	env.Debugf("created user %v", u)
 
+	wr.Header().Set("ETag", userETag(&u))
 	return httpWriteJSON(wr, http.StatusCreated, u)
 }
 
@@ -92,15 +162,78 @@ func UpdateUser(ctx context.Context, env *Env, wr http.ResponseWriter, req *http
 		return err
 	}
 
+	if err = checkIfMatch(req, userETag(u)); err != nil {
+		return err
+	}
+
+	// with If-Match, the version field may be omitted
+	if newUser.Version == 0 && hasIfMatch(req) {
+		newUser.Version = u.Version
+	}
+
 	if u.Version != newUser.Version {
 		env.Debugf("person record is outdated, version %v != %v",
 			u.Version, newUser.Version)
-		return StatusError{
-			Err:  errors.New("version field does not match"),
-			Code: http.StatusConflict,
+
+		client, err := json.Marshal(newUser)
+		if err != nil {
+			return err
 		}
+
+		return recordConflict(env, db.RevisionUser, u.ID, newUser.Version, u, client)
+	}
+
+	return updateUser(ctx, env, wr, req, u, newUser)
+}
+
+// PatchUser changes an existing user record with the JSON merge patch (RFC
+// 7396) in the request body. Only the fields contained in the patch are
+// changed. The patch must contain the current version, unless the request
+// contains an If-Match header.
+func PatchUser(ctx context.Context, env *Env, wr http.ResponseWriter, req *http.Request) (err error) {
+	defer cleanupErr(&err, req.Body.Close)
+
+	id, err := strconv.Atoi(mux.Vars(req)["id"])
+	if err != nil {
+		return StatusError{Code: http.StatusBadRequest, Err: err}
 	}
 
+	patch, err := readMergePatch(req)
+	if err != nil {
+		return err
+	}
+
+	u, err := env.DB.FindUser(int64(id))
+	if err != nil {
+		env.Logf("unable to find user ID %v, error: %v", id, err)
+		return err
+	}
+
+	if err = checkIfMatch(req, userETag(u)); err != nil {
+		return err
+	}
+
+	err = checkPatchVersion(req, patch, u.Version)
+	if err == errVersionMismatch {
+		return patchConflict(env, db.RevisionUser, u.ID, u, patch)
+	}
+	if err != nil {
+		return err
+	}
+
+	var newUser db.UserJSON
+	if err = applyMergePatch(u, patch, &newUser); err != nil {
+		return err
+	}
+
+	return updateUser(ctx, env, wr, req, u, newUser)
+}
+
+// updateUser replaces the fields of u with newUser, validates and saves it
+// and writes the updated record to wr.
+func updateUser(ctx context.Context, env *Env, wr http.ResponseWriter, req *http.Request, u *db.User, newUser db.UserJSON) (err error) {
+	wasAdmin := u.Admin
+
 	// update the relevant fields
 	u.Update(newUser)
 	u.ChangedAt = time.Now()
@@ -109,33 +242,73 @@ func UpdateUser(ctx context.Context, env *Env, wr http.ResponseWriter, req *http
 		return StatusError{Code: http.StatusBadRequest, Err: err}
 	}
 
+	if err = checkRoles(ctx, env, u, wasAdmin); err != nil {
+		return err
+	}
+
+	if u.ChangedBy, err = changedBy(ctx, env); err != nil {
+		return err
+	}
+
 	if err := env.DB.UpdateUser(u); err != nil {
 		env.Logf("unable update person %v, error: %v", u, err)
-		return err
+		return versionError(req, err)
 	}
 
+	wr.Header().Set("ETag", userETag(u))
 	return httpWriteJSON(wr, http.StatusOK, u)
 }
 
-// DeleteUser removes a person from the database.
+// DeleteUser removes a user from the database. If the request contains an
+// If-Match header, the user is only removed if it matches the current version.
 func DeleteUser(ctx context.Context, env *Env, wr http.ResponseWriter, req *http.Request) (err error) {
 	id, err := strconv.Atoi(mux.Vars(req)["id"])
 	if err != nil {
 		return StatusError{Code: http.StatusBadRequest, Err: err}
 	}
 
-	if err := env.DB.DeleteUser(int64(id)); err != nil {
+	changedBy, err := changedBy(ctx, env)
+	if err != nil {
+		return err
+	}
+
+	u, err := env.DB.FindUser(int64(id))
+	if err != nil {
+		return StatusError{
+			Err:  errors.New("user not found"),
+			Code: http.StatusNotFound,
+		}
+	}
+
+	if err = checkRoles(ctx, env, u, u.Admin); err != nil {
 		return err
 	}
 
+	if !hasIfMatch(req) {
+		if err := env.DB.DeleteUser(u.ID, changedBy); err != nil {
+			return err
+		}
+
+		return httpWriteJSON(wr, http.StatusOK, nil)
+	}
+
+	if err = checkIfMatch(req, userETag(u)); err != nil {
+		return err
+	}
+
+	if err = env.DB.DeleteUserVersion(u.ID, u.Version, changedBy); err != nil {
+		return versionError(req, err)
+	}
+
 	return httpWriteJSON(wr, http.StatusOK, nil)
 }
 
 // UserHandler adds routes for the ghenga API in the given environment to r.
 func UserHandler(ctx context.Context, env *Env, r *mux.Router) {
-	r.Handle("/api/user", Handle(ctx, env, RequireAdmin(ListUsers))).Methods("GET")
-	r.Handle("/api/user", Handle(ctx, env, RequireAdmin(CreateUser))).Methods("Post")
-	r.Handle("/api/user/{id}", Handle(ctx, env, RequireAdmin(ShowUser))).Methods("GET")
-	r.Handle("/api/user/{id}", Handle(ctx, env, RequireAdmin(UpdateUser))).Methods("PUT")
-	r.Handle("/api/user/{id}", Handle(ctx, env, RequireAdmin(DeleteUser))).Methods("DELETE")
+	r.Handle("/api/user", Handle(ctx, env, RequirePermission(db.PermUserManage, ListUsers))).Methods("GET")
+	r.Handle("/api/user", Handle(ctx, env, RequirePermission(db.PermUserManage, Audit(db.ActionCreate, db.RevisionUser, CreateUser)))).Methods("Post")
+	r.Handle("/api/user/{id}", Handle(ctx, env, RequirePermission(db.PermUserManage, ShowUser))).Methods("GET")
+	r.Handle("/api/user/{id}", Handle(ctx, env, RequirePermission(db.PermUserManage, Audit(db.ActionUpdate, db.RevisionUser, UpdateUser)))).Methods("PUT")
+	r.Handle("/api/user/{id}", Handle(ctx, env, RequirePermission(db.PermUserManage, Audit(db.ActionUpdate, db.RevisionUser, PatchUser)))).Methods("PATCH")
+	r.Handle("/api/user/{id}", Handle(ctx, env, RequirePermission(db.PermUserManage, Audit(db.ActionDelete, db.RevisionUser, DeleteUser)))).Methods("DELETE")
 }
@@ -0,0 +1,364 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+type Team struct {
+	ID      int    `json:"id"`
+	Name    string `json:"name"`
+	Members []int  `json:"members"`
+	Version int    `json:"version"`
+}
+
+func TestPersonVisibility(t *testing.T) {
+	srv, cleanup := TestServer(t)
+	defer cleanup()
+
+	token := login(t, srv, "admin", "geheim")
+	owner := createRoleUser(t, token, srv.URL, "olga", "sales")
+	member := createRoleUser(t, token, srv.URL, "mia", "sales")
+	createRoleUser(t, token, srv.URL, "otto", "sales")
+
+	ownerToken := login(t, srv, "olga", "geheim")
+	memberToken := login(t, srv, "mia", "geheim")
+	otherToken := login(t, srv, "otto", "geheim")
+
+	status, body := request(t, ownerToken, "POST", srv.URL+"/api/person", []byte(`{"name": "Secret Person", "visibility": "private"}`))
+	if status != http.StatusCreated {
+		t.Fatalf("creating person failed with status %v: %s", status, body)
+	}
+	person := verifyPerson(t, "Secret Person", body)
+
+	var p struct {
+		OwnerID    int    `json:"owner_id"`
+		Visibility string `json:"visibility"`
+	}
+	unmarshal(t, body, &p)
+	if p.OwnerID != owner.ID || p.Visibility != "private" {
+		t.Fatalf("wrong owner or visibility: %s", body)
+	}
+
+	personURL := fmt.Sprintf("%s/api/person/%d", srv.URL, person.ID)
+	for _, test := range []struct {
+		method, path, body string
+	}{
+		{"GET", personURL, ""},
+		{"PUT", personURL, fmt.Sprintf(`{"name": "foo", "version": %d}`, person.Version)},
+		{"PATCH", personURL, `{"name": "foo"}`},
+		{"DELETE", personURL, ""},
+		{"GET", personURL + "/activities", ""},
+		{"GET", personURL + "/versions", ""},
+	} {
+		var data []byte
+		if test.body != "" {
+			data = []byte(test.body)
+		}
+
+		status, body := request(t, memberToken, test.method, test.path, data)
+		if status != http.StatusNotFound {
+			t.Errorf("%v %v: want status 404 for invisible person, got %v: %s", test.method, test.path, status, body)
+		}
+	}
+
+	status, body = request(t, ownerToken, "PATCH", personURL, []byte(fmt.Sprintf(`{"owner_id": %d}`, member.ID)))
+	if status != http.StatusOK {
+		t.Fatalf("owner could not hand over person, status %v: %s", status, body)
+	}
+
+	status, body = request(t, ownerToken, "PATCH", personURL, []byte(`{"visibility": "everyone"}`))
+	if status != http.StatusForbidden {
+		t.Errorf("former owner changed the visibility, status %v: %s", status, body)
+	}
+
+	status, body = request(t, memberToken, "PATCH", personURL, []byte(fmt.Sprintf(`{"owner_id": %d, "visibility": "team"}`, owner.ID)))
+	if status != http.StatusOK {
+		t.Fatalf("new owner could not change the person, status %v: %s", status, body)
+	}
+
+	status, body = request(t, otherToken, "POST", srv.URL+"/api/person", []byte(fmt.Sprintf(`{"name": "foo", "owner_id": %d}`, owner.ID)))
+	if status != http.StatusForbidden {
+		t.Errorf("person was created for another user, status %v: %s", status, body)
+	}
+
+	// with visibility team, the person is invisible until the users share a team
+	if status, body = request(t, memberToken, "GET", personURL, nil); status != http.StatusNotFound {
+		t.Errorf("person is visible without a team, status %v: %s", status, body)
+	}
+
+	status, body = request(t, token, "POST", srv.URL+"/api/team", []byte(fmt.Sprintf(`{"name": "sales", "members": [%d, %d]}`, owner.ID, member.ID)))
+	if status != http.StatusCreated {
+		t.Fatalf("creating team failed with status %v: %s", status, body)
+	}
+
+	for _, test := range []struct {
+		token string
+		found bool
+	}{
+		{ownerToken, true},
+		{memberToken, true},
+		{otherToken, false},
+		{token, true},
+	} {
+		status, body := request(t, test.token, "GET", personURL, nil)
+		if test.found && status != http.StatusOK {
+			t.Errorf("person not visible, status %v: %s", status, body)
+		}
+
+		if !test.found && status != http.StatusNotFound {
+			t.Errorf("person visible, status %v: %s", status, body)
+		}
+
+		var people []Person
+		status, body = request(t, test.token, "GET", fmt.Sprintf("%s/api/person?owner_id=%d", srv.URL, owner.ID), nil)
+		if status != http.StatusOK {
+			t.Fatalf("listing people failed with status %v: %s", status, body)
+		}
+		unmarshal(t, body, &people)
+
+		if test.found != (len(people) == 1) {
+			t.Errorf("wrong people listed: %s", body)
+		}
+
+		var results []struct {
+			Person Person `json:"person"`
+		}
+		status, body = request(t, test.token, "GET", srv.URL+"/api/search/person?query=Secret", nil)
+		if status != http.StatusOK {
+			t.Fatalf("search failed with status %v: %s", status, body)
+		}
+		unmarshal(t, body, &results)
+
+		found := false
+		for _, r := range results {
+			if r.Person.ID == person.ID {
+				found = true
+			}
+		}
+
+		if found != test.found {
+			t.Errorf("search result for person is wrong, want %v: %s", test.found, body)
+		}
+	}
+}
+
+func TestPersonVisibilityReferences(t *testing.T) {
+	srv, cleanup := TestServer(t)
+	defer cleanup()
+
+	token := login(t, srv, "admin", "geheim")
+	createRoleUser(t, token, srv.URL, "olga", "sales")
+	member := createRoleUser(t, token, srv.URL, "mia", "sales")
+
+	ownerToken := login(t, srv, "olga", "geheim")
+	memberToken := login(t, srv, "mia", "geheim")
+
+	status, body := request(t, ownerToken, "POST", srv.URL+"/api/person", []byte(`{"name": "Hidden Person", "visibility": "private",
+		"email_addresses": [{"type": "work", "address": "hidden@example.com"}]}`))
+	if status != http.StatusCreated {
+		t.Fatalf("creating person failed with status %v: %s", status, body)
+	}
+	hidden := verifyPerson(t, "Hidden Person", body)
+
+	status, body = request(t, ownerToken, "POST", srv.URL+"/api/person", []byte(`{"name": "Public Person", "visibility": "everyone",
+		"email_addresses": [{"type": "work", "address": "public@example.com"}]}`))
+	if status != http.StatusCreated {
+		t.Fatalf("creating person failed with status %v: %s", status, body)
+	}
+	public := verifyPerson(t, "Public Person", body)
+
+	status, body = request(t, ownerToken, "POST", srv.URL+"/api/task", []byte(fmt.Sprintf(`{"title": "Secret task", "person_id": %d}`, hidden.ID)))
+	if status != http.StatusCreated {
+		t.Fatalf("creating task failed with status %v: %s", status, body)
+	}
+	task := verifyTask(t, "Secret task", body)
+
+	activity := createActivity(t, ownerToken, srv.URL, hidden.ID, `{"kind": "note", "subject": "Secret note"}`)
+
+	status, body = request(t, ownerToken, "POST", srv.URL+"/api/event", []byte(fmt.Sprintf(`{"title": "Meeting",
+		"start": "2016-05-02T12:00:00+02:00", "end": "2016-05-02T13:00:00+02:00",
+		"participants": {"people": [%d, %d], "users": [%d]}}`, hidden.ID, public.ID, member.ID)))
+	if status != http.StatusCreated {
+		t.Fatalf("creating event failed with status %v: %s", status, body)
+	}
+	var event Event
+	unmarshal(t, body, &event)
+
+	taskURL := fmt.Sprintf("%s/api/task/%d", srv.URL, task.ID)
+	for _, test := range []struct {
+		method, path, body string
+	}{
+		{"GET", taskURL, ""},
+		{"PUT", taskURL, fmt.Sprintf(`{"title": "foo", "person_id": %d, "version": %d}`, public.ID, task.Version)},
+		{"DELETE", taskURL, ""},
+		{"GET", fmt.Sprintf("%s/api/activity/%d", srv.URL, activity.ID), ""},
+	} {
+		var data []byte
+		if test.body != "" {
+			data = []byte(test.body)
+		}
+
+		status, body := request(t, memberToken, test.method, test.path, data)
+		if status != http.StatusNotFound {
+			t.Errorf("%v %v: want status 404 for invisible person, got %v: %s", test.method, test.path, status, body)
+		}
+	}
+
+	var tasks []Task
+	status, body = request(t, memberToken, "GET", srv.URL+"/api/task", nil)
+	if status != http.StatusOK {
+		t.Fatalf("listing tasks failed with status %v: %s", status, body)
+	}
+	unmarshal(t, body, &tasks)
+	for _, task := range tasks {
+		if task.PersonID == hidden.ID {
+			t.Errorf("task for invisible person listed: %s", body)
+		}
+	}
+
+	eventURL := fmt.Sprintf("%s/api/event/%d", srv.URL, event.ID)
+	status, body = request(t, memberToken, "GET", eventURL, nil)
+	if status != http.StatusOK {
+		t.Fatalf("reading event failed with status %v: %s", status, body)
+	}
+	unmarshal(t, body, &event)
+	if !reflect.DeepEqual(event.Participants.People, []int{public.ID}) {
+		t.Errorf("wrong participants for member: %s", body)
+	}
+
+	// the participants the member cannot see are kept on update
+	event.Title = "Lunch"
+	status, body = request(t, memberToken, "PUT", eventURL, marshal(t, event))
+	if status != http.StatusOK {
+		t.Fatalf("updating event failed with status %v: %s", status, body)
+	}
+
+	status, body = request(t, ownerToken, "GET", eventURL, nil)
+	if status != http.StatusOK {
+		t.Fatalf("reading event failed with status %v: %s", status, body)
+	}
+	unmarshal(t, body, &event)
+	if event.Title != "Lunch" || !reflect.DeepEqual(event.Participants.People, []int{hidden.ID, public.ID}) {
+		t.Errorf("wrong event for owner after update: %s", body)
+	}
+
+	status, body = request(t, memberToken, "GET", srv.URL+"/api/me/calendar", nil)
+	if status != http.StatusOK {
+		t.Fatalf("requesting calendar token failed with status %v: %s", status, body)
+	}
+	var cal CalendarJSON
+	unmarshal(t, body, &cal)
+
+	status, body = request(t, "", "GET", srv.URL+cal.Path, nil)
+	if status != http.StatusOK {
+		t.Fatalf("requesting calendar feed failed with status %v: %s", status, body)
+	}
+	if !strings.Contains(string(body), "public@example.com") || strings.Contains(string(body), "Hidden Person") {
+		t.Errorf("wrong participants in calendar feed:\n%s", body)
+	}
+}
+
+func TestPersonVisibilityStream(t *testing.T) {
+	env, cleanup := TestEnv(t)
+	defer cleanup()
+
+	// the server must be closed after the context is cancelled, otherwise
+	// it waits for the stream to end.
+	ctx, cancel := context.WithCancel(context.Background())
+	srv := &TestSrv{Server: httptest.NewServer(NewRouter(ctx, env)), Env: env}
+	defer srv.Close()
+	defer cancel()
+
+	token := login(t, srv, "admin", "geheim")
+	createRoleUser(t, token, srv.URL, "olga", "sales")
+	createRoleUser(t, token, srv.URL, "mia", "sales")
+
+	ownerToken := login(t, srv, "olga", "geheim")
+	memberToken := login(t, srv, "mia", "geheim")
+
+	res, events := openStream(t, memberToken, srv.URL, "")
+	defer res.Body.Close()
+
+	var people []Person
+	for _, data := range []string{
+		`{"name": "Hidden Person", "visibility": "private"}`,
+		`{"name": "Public Person", "visibility": "everyone"}`,
+	} {
+		status, body := request(t, ownerToken, "POST", srv.URL+"/api/person", []byte(data))
+		if status != http.StatusCreated {
+			t.Fatalf("creating person failed with status %v: %s", status, body)
+		}
+
+		var p Person
+		unmarshal(t, body, &p)
+		people = append(people, p)
+	}
+
+	for _, p := range people {
+		deletePerson(t, ownerToken, srv.URL, p.ID)
+	}
+
+	// only the changes of the public person are sent
+	for _, action := range []string{"create", "delete"} {
+		if _, c := nextChange(t, events); c.Action != action || c.ID != people[1].ID {
+			t.Errorf("unexpected change %+v, want %v of person %d", c, action, people[1].ID)
+		}
+	}
+}
+
+func TestTeamCRUD(t *testing.T) {
+	srv, cleanup := TestServer(t)
+	defer cleanup()
+
+	token := login(t, srv, "admin", "geheim")
+	userToken := login(t, srv, "user", "geheim")
+
+	if status, body := request(t, userToken, "GET", srv.URL+"/api/team", nil); status != http.StatusForbidden {
+		t.Errorf("non-admin could list teams, status %v: %s", status, body)
+	}
+
+	status, body := request(t, token, "POST", srv.URL+"/api/team", []byte(`{"name": "support", "members": [1, 12345]}`))
+	if status != http.StatusBadRequest {
+		t.Errorf("team with unknown member was created, status %v: %s", status, body)
+	}
+
+	status, body = request(t, token, "POST", srv.URL+"/api/team", []byte(`{"name": "support", "members": [1]}`))
+	if status != http.StatusCreated {
+		t.Fatalf("creating team failed with status %v: %s", status, body)
+	}
+
+	var team Team
+	unmarshal(t, body, &team)
+
+	teamURL := fmt.Sprintf("%s/api/team/%d", srv.URL, team.ID)
+	status, body = request(t, token, "PUT", teamURL, []byte(fmt.Sprintf(`{"name": "support", "members": [1, 2], "version": %d}`, team.Version)))
+	if status != http.StatusOK {
+		t.Fatalf("updating team failed with status %v: %s", status, body)
+	}
+
+	unmarshal(t, body, &team)
+	if len(team.Members) != 2 {
+		t.Errorf("wrong members after update: %s", body)
+	}
+
+	status, body = request(t, token, "PUT", teamURL, []byte(`{"name": "support", "members": [], "version": 1}`))
+	if status != http.StatusConflict {
+		t.Errorf("outdated update did not fail, status %v: %s", status, body)
+	}
+
+	status, body = request(t, token, "DELETE", teamURL, nil)
+	if status != http.StatusOK {
+		t.Fatalf("removing team failed with status %v: %s", status, body)
+	}
+
+	if status, body = request(t, token, "GET", teamURL, nil); status != http.StatusNotFound {
+		t.Errorf("removed team still found, status %v: %s", status, body)
+	}
+}
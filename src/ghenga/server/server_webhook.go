@@ -0,0 +1,216 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"ghenga/db"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"golang.org/x/net/context"
+)
+
+// ListWebhooks handles listing webhooks.
+func ListWebhooks(ctx context.Context, env *Env, res http.ResponseWriter, req *http.Request) error {
+	webhooks, err := env.DB.ListWebhooks()
+	if err != nil {
+		return err
+	}
+
+	return httpWriteJSON(res, http.StatusOK, webhooks)
+}
+
+// findWebhook returns the webhook with the ID from the URL.
+func findWebhook(env *Env, req *http.Request) (*db.Webhook, error) {
+	id, err := strconv.Atoi(mux.Vars(req)["id"])
+	if err != nil {
+		return nil, StatusError{Code: http.StatusBadRequest, Err: err}
+	}
+
+	w, err := env.DB.FindWebhook(int64(id))
+	if err != nil {
+		return nil, StatusError{
+			Err:  errors.New("webhook not found"),
+			Code: http.StatusNotFound,
+		}
+	}
+
+	return w, nil
+}
+
+// ShowWebhook returns a webhook.
+func ShowWebhook(ctx context.Context, env *Env, res http.ResponseWriter, req *http.Request) error {
+	w, err := findWebhook(env, req)
+	if err != nil {
+		return err
+	}
+
+	return httpWriteJSON(res, http.StatusOK, w)
+}
+
+// CreateWebhook registers a new webhook. The request body must be valid JSON.
+func CreateWebhook(ctx context.Context, env *Env, wr http.ResponseWriter, req *http.Request) (err error) {
+	defer cleanupErr(&err, req.Body.Close)
+
+	var jw db.WebhookJSON
+	dec := json.NewDecoder(req.Body)
+	if err = dec.Decode(&jw); err != nil {
+		return StatusError{Code: http.StatusBadRequest, Err: err}
+	}
+
+	var w db.Webhook
+	w.Update(jw)
+
+	// overwrite fields we'd like to be set
+	w.CreatedAt = time.Now()
+	w.ChangedAt = time.Now()
+
+	if err = w.Validate(); err != nil {
+		return StatusError{Code: http.StatusBadRequest, Err: err}
+	}
+
+	if err = env.DB.InsertWebhook(&w); err != nil {
+		return err
+	}
+
+	env.Debugf("created webhook %v", w)
+
+	return httpWriteJSON(wr, http.StatusCreated, w)
+}
+
+// UpdateWebhook changes an existing webhook. If the secret is omitted, the
+// secret is not changed. The request body must be valid JSON.
+func UpdateWebhook(ctx context.Context, env *Env, wr http.ResponseWriter, req *http.Request) (err error) {
+	defer cleanupErr(&err, req.Body.Close)
+
+	w, err := findWebhook(env, req)
+	if err != nil {
+		return err
+	}
+
+	var jw db.WebhookJSON
+	dec := json.NewDecoder(req.Body)
+	if err = dec.Decode(&jw); err != nil {
+		return StatusError{Code: http.StatusBadRequest, Err: err}
+	}
+
+	if w.Version != jw.Version {
+		env.Debugf("webhook is outdated, version %v != %v", w.Version, jw.Version)
+		return StatusError{Code: http.StatusConflict, Err: errVersionMismatch}
+	}
+
+	w.Update(jw)
+	w.ChangedAt = time.Now()
+
+	if err = w.Validate(); err != nil {
+		return StatusError{Code: http.StatusBadRequest, Err: err}
+	}
+
+	if err = env.DB.UpdateWebhook(w); err != nil {
+		env.Logf("unable to update webhook %v: %v", w, err)
+		return versionError(req, err)
+	}
+
+	return httpWriteJSON(wr, http.StatusOK, w)
+}
+
+// DeleteWebhook removes a webhook and its delivery log.
+func DeleteWebhook(ctx context.Context, env *Env, wr http.ResponseWriter, req *http.Request) error {
+	w, err := findWebhook(env, req)
+	if err != nil {
+		return err
+	}
+
+	if err = env.DB.DeleteWebhook(w.ID); err != nil {
+		return err
+	}
+
+	return httpWriteJSON(wr, http.StatusOK, nil)
+}
+
+// ListWebhookDeliveries returns the delivery log of a webhook, the newest
+// deliveries first unless a different order is requested. The list is
+// paginated and filtered like other lists, the deliveries can be filtered by
+// `status`, `event` and `entity_id`.
+func ListWebhookDeliveries(ctx context.Context, env *Env, res http.ResponseWriter, req *http.Request) error {
+	w, err := findWebhook(env, req)
+	if err != nil {
+		return err
+	}
+
+	opts, err := parseListOptions(req)
+	if err != nil {
+		return err
+	}
+
+	if len(opts.Sort) == 0 {
+		opts.Sort = db.ParseSort("-id")
+	}
+	opts.Filter["webhook_id"] = strconv.FormatInt(w.ID, 10)
+
+	deliveries, page, err := env.DB.ListWebhookDeliveriesPage(opts)
+	if err != nil {
+		return listError(err)
+	}
+
+	writePage(res, page)
+	return httpWriteJSON(res, http.StatusOK, deliveries)
+}
+
+// redeliver sends the payload of an earlier delivery to the webhook again.
+// A new delivery is created and returned, it is sent in the background.
+func (d *webhookDispatcher) redeliver(ctx context.Context, env *Env, wr http.ResponseWriter, req *http.Request) error {
+	w, err := findWebhook(env, req)
+	if err != nil {
+		return err
+	}
+
+	id, err := strconv.Atoi(mux.Vars(req)["delivery"])
+	if err != nil {
+		return StatusError{Code: http.StatusBadRequest, Err: err}
+	}
+
+	old, err := env.DB.FindWebhookDelivery(int64(id))
+	if err != nil || old.WebhookID != w.ID {
+		return StatusError{
+			Err:  errors.New("delivery not found"),
+			Code: http.StatusNotFound,
+		}
+	}
+
+	delivery := db.NewWebhookDelivery(w.ID, old.Event, old.EntityID, []byte(old.Payload))
+	if err = env.DB.InsertWebhookDelivery(delivery, d.claim(1)); err != nil {
+		return err
+	}
+
+	env.Debugf("redelivering %v as %v", old, delivery)
+
+	pending := *delivery
+	go d.deliver(&pending)
+
+	return httpWriteJSON(wr, http.StatusAccepted, delivery)
+}
+
+// WebhookHandler adds routes for managing webhooks to r. It starts sending
+// changes to the webhooks until ctx is cancelled.
+func WebhookHandler(ctx context.Context, env *Env, r *mux.Router) {
+	d := newWebhookDispatcher(ctx, env)
+
+	remove := env.DB.OnChange(d.changed)
+	go func() {
+		<-ctx.Done()
+		remove()
+	}()
+
+	go d.resume()
+
+	r.Handle("/api/webhook", Handle(ctx, env, RequireAdmin(ListWebhooks))).Methods("GET")
+	r.Handle("/api/webhook", Handle(ctx, env, RequireAdmin(Audit(db.ActionCreate, "webhook", CreateWebhook)))).Methods("POST")
+	r.Handle("/api/webhook/{id}", Handle(ctx, env, RequireAdmin(ShowWebhook))).Methods("GET")
+	r.Handle("/api/webhook/{id}", Handle(ctx, env, RequireAdmin(Audit(db.ActionUpdate, "webhook", UpdateWebhook)))).Methods("PUT")
+	r.Handle("/api/webhook/{id}", Handle(ctx, env, RequireAdmin(Audit(db.ActionDelete, "webhook", DeleteWebhook)))).Methods("DELETE")
+	r.Handle("/api/webhook/{id}/deliveries", Handle(ctx, env, RequireAdmin(ListWebhookDeliveries))).Methods("GET")
+	r.Handle("/api/webhook/{id}/deliveries/{delivery}/redeliver", Handle(ctx, env, RequireAdmin(Audit("redeliver", "webhook_delivery", d.redeliver)))).Methods("POST")
+}
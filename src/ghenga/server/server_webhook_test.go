@@ -0,0 +1,254 @@
+package server
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type Webhook struct {
+	ID      int      `json:"id"`
+	URL     string   `json:"url"`
+	Events  []string `json:"events"`
+	Secret  string   `json:"secret"`
+	Version int      `json:"version"`
+}
+
+type webhookDelivery struct {
+	ID       int    `json:"id"`
+	Event    string `json:"event"`
+	Status   string `json:"status"`
+	Attempts int    `json:"attempts"`
+	Error    string `json:"error"`
+}
+
+type webhookRequest struct {
+	header http.Header
+	body   []byte
+}
+
+// webhookReceiver returns a server which sends all requests to the channel.
+// The first failures requests are answered with status 500.
+func webhookReceiver(failures int32) (*httptest.Server, chan webhookRequest) {
+	ch := make(chan webhookRequest, 10)
+	srv := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		body, _ := ioutil.ReadAll(req.Body)
+		ch <- webhookRequest{header: req.Header, body: body}
+
+		if atomic.AddInt32(&failures, -1) >= 0 {
+			res.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		res.WriteHeader(http.StatusNoContent)
+	}))
+
+	return srv, ch
+}
+
+func receiveWebhook(t *testing.T, ch chan webhookRequest) webhookRequest {
+	select {
+	case r := <-ch:
+		return r
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timeout waiting for webhook request")
+	}
+
+	return webhookRequest{}
+}
+
+func TestWebhookDelivery(t *testing.T) {
+	srv, cleanup := TestServer(t)
+	defer cleanup()
+
+	srv.Cfg.WebhookAttempts = 3
+	srv.Cfg.WebhookBackoff = 10 * time.Millisecond
+
+	receiver, requests := webhookReceiver(1)
+	defer receiver.Close()
+
+	token := login(t, srv, "admin", "geheim")
+
+	status, body := request(t, token, "POST", srv.URL+"/api/webhook",
+		[]byte(fmt.Sprintf(`{"url": %q, "events": ["person.*"], "secret": "s3cr3t", "active": true}`, receiver.URL)))
+	if status != 201 {
+		t.Fatalf("creating webhook failed with status %d: %s", status, body)
+	}
+
+	var webhook Webhook
+	unmarshal(t, body, &webhook)
+	if webhook.Secret != "" {
+		t.Fatalf("secret was returned: %s", body)
+	}
+
+	// users do not match the event filter
+	status, body = request(t, token, "POST", srv.URL+"/api/user", []byte(`{"login": "webhook", "password": "x"}`))
+	if status != 201 {
+		t.Fatalf("creating user failed with status %d: %s", status, body)
+	}
+
+	status, body = request(t, token, "POST", srv.URL+"/api/person", []byte(`{"name": "Webhook Person"}`))
+	if status != 201 {
+		t.Fatalf("creating person failed with status %d: %s", status, body)
+	}
+	person := verifyPerson(t, "Webhook Person", body)
+
+	// the first attempt fails and is retried
+	var r webhookRequest
+	for i := 0; i < 2; i++ {
+		r = receiveWebhook(t, requests)
+		if sig := webhookSignature("s3cr3t", r.body); r.header.Get(webhookSignatureHeaderName) != sig {
+			t.Fatalf("wrong signature %q, want %q", r.header.Get(webhookSignatureHeaderName), sig)
+		}
+	}
+
+	if r.header.Get(webhookEventHeaderName) != "person.create" {
+		t.Fatalf("wrong event %q", r.header.Get(webhookEventHeaderName))
+	}
+
+	var payload struct {
+		Event  string `json:"event"`
+		Change struct {
+			Action  string `json:"action"`
+			ID      int    `json:"id"`
+			Version int    `json:"version"`
+		} `json:"change"`
+		Data Person `json:"data"`
+	}
+	unmarshal(t, r.body, &payload)
+
+	if payload.Event != "person.create" || payload.Change.ID != person.ID || payload.Data.Name != "Webhook Person" {
+		t.Fatalf("wrong payload: %s", r.body)
+	}
+
+	deliveriesURL := fmt.Sprintf("%s/api/webhook/%d/deliveries", srv.URL, webhook.ID)
+
+	var deliveries []webhookDelivery
+	for start := time.Now(); ; {
+		status, body = request(t, token, "GET", deliveriesURL, nil)
+		if status != 200 {
+			t.Fatalf("listing deliveries failed with status %d: %s", status, body)
+		}
+
+		unmarshal(t, body, &deliveries)
+		if len(deliveries) == 1 && deliveries[0].Status != "pending" {
+			break
+		}
+
+		if time.Since(start) > 5*time.Second {
+			t.Fatalf("delivery was not finished: %s", body)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if deliveries[0].Status != "success" || deliveries[0].Attempts != 2 {
+		t.Fatalf("wrong delivery state: %+v", deliveries[0])
+	}
+
+	status, body = request(t, token, "POST", fmt.Sprintf("%s/%d/redeliver", deliveriesURL, deliveries[0].ID), nil)
+	if status != http.StatusAccepted {
+		t.Fatalf("redeliver failed with status %d: %s", status, body)
+	}
+
+	if r2 := receiveWebhook(t, requests); string(r2.body) != string(r.body) {
+		t.Fatalf("redelivered payload differs:\n%s\n%s", r2.body, r.body)
+	}
+
+	status, body = request(t, token, "GET", deliveriesURL+"?status=failed", nil)
+	if status != 200 || string(body) != "[]\n" {
+		t.Fatalf("unexpected failed deliveries, status %d: %s", status, body)
+	}
+
+	userToken := login(t, srv, "user", "geheim")
+	if status, _ = request(t, userToken, "GET", srv.URL+"/api/webhook", nil); status != http.StatusForbidden {
+		t.Fatalf("listing webhooks as non-admin returned status %d", status)
+	}
+}
+
+func TestWebhookInactive(t *testing.T) {
+	srv, cleanup := TestServer(t)
+	defer cleanup()
+
+	srv.Cfg.WebhookAttempts = 3
+	srv.Cfg.WebhookBackoff = 200 * time.Millisecond
+
+	receiver, requests := webhookReceiver(100)
+	defer receiver.Close()
+
+	token := login(t, srv, "admin", "geheim")
+
+	status, body := request(t, token, "POST", srv.URL+"/api/webhook",
+		[]byte(fmt.Sprintf(`{"url": %q, "events": ["person.create"], "secret": "s3cr3t", "active": true}`, receiver.URL)))
+	if status != 201 {
+		t.Fatalf("creating webhook failed with status %d: %s", status, body)
+	}
+
+	var webhook Webhook
+	unmarshal(t, body, &webhook)
+
+	status, body = request(t, token, "POST", srv.URL+"/api/person", []byte(`{"name": "Inactive Webhook Person"}`))
+	if status != 201 {
+		t.Fatalf("creating person failed with status %d: %s", status, body)
+	}
+
+	// the first attempt fails, the webhook is deactivated before the retry
+	receiveWebhook(t, requests)
+
+	status, body = request(t, token, "PUT", fmt.Sprintf("%s/api/webhook/%d", srv.URL, webhook.ID),
+		[]byte(fmt.Sprintf(`{"url": %q, "events": ["person.create"], "active": false, "version": %d}`, receiver.URL, webhook.Version)))
+	if status != 200 {
+		t.Fatalf("deactivating webhook failed with status %d: %s", status, body)
+	}
+
+	deliveriesURL := fmt.Sprintf("%s/api/webhook/%d/deliveries", srv.URL, webhook.ID)
+
+	var deliveries []webhookDelivery
+	for start := time.Now(); ; {
+		status, body = request(t, token, "GET", deliveriesURL, nil)
+		if status != 200 {
+			t.Fatalf("listing deliveries failed with status %d: %s", status, body)
+		}
+
+		unmarshal(t, body, &deliveries)
+		if len(deliveries) == 1 && deliveries[0].Status != "pending" {
+			break
+		}
+
+		if time.Since(start) > 5*time.Second {
+			t.Fatalf("delivery was not finished: %s", body)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if deliveries[0].Status != "failed" || deliveries[0].Attempts != 1 || deliveries[0].Error != "webhook is inactive" {
+		t.Fatalf("wrong delivery state: %+v", deliveries[0])
+	}
+
+	select {
+	case r := <-requests:
+		t.Fatalf("inactive webhook received a request: %s", r.body)
+	default:
+	}
+}
+
+func TestWebhookInvalid(t *testing.T) {
+	srv, cleanup := TestServer(t)
+	defer cleanup()
+
+	token := login(t, srv, "admin", "geheim")
+
+	for _, data := range []string{
+		`{"url": "ftp://example.com", "secret": "x"}`,
+		`{"url": "http://example.com/hook"}`,
+		`{"url": "http://example.com/hook", "secret": "x", "events": ["account.create"]}`,
+	} {
+		status, body := request(t, token, "POST", srv.URL+"/api/webhook", []byte(data))
+		if status != http.StatusBadRequest {
+			t.Errorf("%s: want status 400, got %d: %s", data, status, body)
+		}
+	}
+}
@@ -0,0 +1,264 @@
+package server
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"ghenga/db"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+const (
+	defaultWebhookAttempts = 5
+	defaultWebhookBackoff  = 30 * time.Second
+
+	// webhookTimeout is the maximal duration of a single request to a webhook.
+	webhookTimeout = 10 * time.Second
+
+	// webhookClaimMargin is added to the duration for which a delivery is
+	// claimed, so that the claim does not expire while the delivery is saved.
+	webhookClaimMargin = time.Minute
+)
+
+// HTTP headers sent to webhooks. The signature is the HMAC-SHA256 of the body
+// with the secret of the webhook as key, encoded as hex and prefixed with
+// "sha256=".
+const (
+	webhookEventHeaderName     = "X-Ghenga-Event"
+	webhookDeliveryHeaderName  = "X-Ghenga-Delivery"
+	webhookSignatureHeaderName = "X-Ghenga-Signature"
+)
+
+// webhookSignature returns the signature of body for the header
+// X-Ghenga-Signature.
+func webhookSignature(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	_, _ = mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// webhookBackoff returns the time to wait after the attempt n (starting at
+// one) has failed.
+func webhookBackoff(base time.Duration, n int) time.Duration {
+	return base << uint(n-1)
+}
+
+// WebhookPayloadJSON is the body sent to a webhook. For created and updated
+// records, Data contains the new version of the record.
+type WebhookPayloadJSON struct {
+	Event  string          `json:"event"`
+	Change db.Change       `json:"change"`
+	Data   json.RawMessage `json:"data,omitempty"`
+}
+
+// webhookDispatcher sends changes to the webhooks in the database. Each
+// delivery is run in its own goroutine and retried until it succeeds or the
+// context is cancelled. Before each attempt, the delivery is claimed in the
+// database, so that it is sent by only one of several ghenga instances.
+type webhookDispatcher struct {
+	ctx    context.Context
+	env    *Env
+	client *http.Client
+}
+
+func newWebhookDispatcher(ctx context.Context, env *Env) *webhookDispatcher {
+	return &webhookDispatcher{
+		ctx:    ctx,
+		env:    env,
+		client: &http.Client{Timeout: webhookTimeout},
+	}
+}
+
+func (d *webhookDispatcher) attempts() int {
+	if d.env.Cfg.WebhookAttempts > 0 {
+		return d.env.Cfg.WebhookAttempts
+	}
+
+	return defaultWebhookAttempts
+}
+
+func (d *webhookDispatcher) backoff(n int) time.Duration {
+	base := d.env.Cfg.WebhookBackoff
+	if base <= 0 {
+		base = defaultWebhookBackoff
+	}
+
+	return webhookBackoff(base, n)
+}
+
+// claim returns the duration for which a delivery is claimed before the
+// attempt n (starting at one), it covers the request and the wait for the
+// next attempt.
+func (d *webhookDispatcher) claim(n int) time.Duration {
+	return webhookTimeout + d.backoff(n) + webhookClaimMargin
+}
+
+// changed is called for each committed change, it must not block.
+func (d *webhookDispatcher) changed(c db.Change) {
+	// changes of other instances are delivered by these instances
+	if c.Remote {
+		return
+	}
+
+	go d.dispatch(c)
+}
+
+// dispatch creates a delivery of the change for each matching webhook and
+// starts sending them.
+func (d *webhookDispatcher) dispatch(c db.Change) {
+	if d.ctx.Err() != nil {
+		return
+	}
+
+	webhooks, err := d.env.DB.ListWebhooks()
+	if err != nil {
+		d.env.Logf("unable to list webhooks: %v", err)
+		return
+	}
+
+	var payload []byte
+	for _, w := range webhooks {
+		if !w.Active || !w.Events.Match(c.Event()) {
+			continue
+		}
+
+		if payload == nil {
+			if payload, err = d.payload(c); err != nil {
+				d.env.Logf("unable to create webhook payload for %v: %v", c, err)
+				return
+			}
+		}
+
+		delivery := db.NewWebhookDelivery(w.ID, c.Event(), c.ID, payload)
+		if err = d.env.DB.InsertWebhookDelivery(delivery, d.claim(1)); err != nil {
+			d.env.Logf("unable to save webhook delivery for %v: %v", w, err)
+			continue
+		}
+
+		go d.deliver(delivery)
+	}
+}
+
+// payload returns the body sent to the webhooks for a change.
+func (d *webhookDispatcher) payload(c db.Change) ([]byte, error) {
+	p := WebhookPayloadJSON{Event: c.Event(), Change: c}
+
+	if c.Action != db.ActionDelete {
+		rev, err := d.env.DB.FindRevision(c.Entity, c.ID, c.Version)
+		if err != nil {
+			return nil, err
+		}
+
+		p.Data = json.RawMessage(rev.Data)
+	}
+
+	return json.Marshal(p)
+}
+
+// deliver sends the delivery to the webhook until it succeeds or the maximal
+// number of attempts is reached. The state is saved after each attempt. It
+// stops when the delivery has been claimed by another instance, and the
+// delivery fails when the webhook has been deactivated.
+func (d *webhookDispatcher) deliver(delivery *db.WebhookDelivery) {
+	for {
+		w, err := d.env.DB.FindWebhook(delivery.WebhookID)
+		if err != nil {
+			d.env.Logf("webhook for %v not found: %v", delivery, err)
+			return
+		}
+
+		claimed, err := d.env.DB.ClaimWebhookDelivery(delivery, d.claim(delivery.Attempts+1))
+		if err != nil {
+			d.env.Logf("unable to claim webhook delivery %v: %v", delivery, err)
+			return
+		}
+
+		if !claimed {
+			d.env.Debugf("%v is sent by another instance", delivery)
+			return
+		}
+
+		if !w.Active {
+			delivery.Status = db.DeliveryFailed
+			delivery.Error = "webhook is inactive"
+			if err = d.env.DB.UpdateWebhookDelivery(delivery); err != nil {
+				d.env.Logf("unable to save webhook delivery %v: %v", delivery, err)
+			}
+			return
+		}
+
+		status, err := d.send(w, delivery)
+		delivery.Attempted(status, err, d.attempts())
+
+		if err = d.env.DB.UpdateWebhookDelivery(delivery); err != nil {
+			d.env.Logf("unable to save webhook delivery %v: %v", delivery, err)
+			return
+		}
+
+		d.env.Debugf("attempt %d for %v", delivery.Attempts, delivery)
+
+		if delivery.Status != db.DeliveryPending {
+			return
+		}
+
+		select {
+		case <-time.After(d.backoff(delivery.Attempts)):
+		case <-d.ctx.Done():
+			return
+		}
+	}
+}
+
+// send posts the payload of the delivery to the webhook. The status code of
+// the response is returned, an error is returned unless the status code is
+// 2xx.
+func (d *webhookDispatcher) send(w *db.Webhook, delivery *db.WebhookDelivery) (int, error) {
+	body := []byte(delivery.Payload)
+
+	req, err := http.NewRequest("POST", w.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(webhookEventHeaderName, delivery.Event)
+	req.Header.Set(webhookDeliveryHeaderName, strconv.FormatInt(delivery.ID, 10))
+	req.Header.Set(webhookSignatureHeaderName, webhookSignature(w.Secret, body))
+
+	res, err := d.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+
+	_, _ = io.Copy(ioutil.Discard, io.LimitReader(res.Body, 64*1024))
+	_ = res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return res.StatusCode, fmt.Errorf("webhook returned %v", res.Status)
+	}
+
+	return res.StatusCode, nil
+}
+
+// resume restarts all deliveries which are still pending and not claimed by
+// another instance, e.g. because the server was stopped.
+func (d *webhookDispatcher) resume() {
+	deliveries, err := d.env.DB.ClaimPendingWebhookDeliveries(d.claim(1))
+	if err != nil {
+		d.env.Logf("unable to list pending webhook deliveries: %v", err)
+		return
+	}
+
+	for _, delivery := range deliveries {
+		go d.deliver(delivery)
+	}
+}
@@ -0,0 +1,29 @@
+package server
+
+import (
+	"testing"
+	"time"
+)
+
+func TestWebhookSignature(t *testing.T) {
+	// test case 2 from RFC 4231
+	sig := webhookSignature("Jefe", []byte("what do ya want for nothing?"))
+	want := "sha256=5bdcc146bf60754e6a042426089575c75a003f089d2739839dec58b964ec3843"
+	if sig != want {
+		t.Fatalf("wrong signature, want %v, got %v", want, sig)
+	}
+}
+
+func TestWebhookBackoff(t *testing.T) {
+	var durations []time.Duration
+	for n := 1; n <= 4; n++ {
+		durations = append(durations, webhookBackoff(time.Second, n))
+	}
+
+	want := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second}
+	for i := range want {
+		if durations[i] != want[i] {
+			t.Errorf("attempt %d: want backoff %v, got %v", i+1, want[i], durations[i])
+		}
+	}
+}